@@ -16,5 +16,4 @@ sudo chmod +x /usr/local/bin/install-openvpn
 # Move the files in files/ to a dedicated directory for the gruntwork-installer
 sudo mkdir -p /gruntwork/install-openvpn
 sudo cp -R "${script_path}/files/." /gruntwork/install-openvpn/
-sudo cp -R "${script_path}/scripts/." /gruntwork/install-openvpn/
 sudo chmod +r /gruntwork/install-openvpn/*
\ No newline at end of file
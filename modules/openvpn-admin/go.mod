@@ -1,18 +1,44 @@
 module github.com/gruntwork-io/package-openvpn/modules/openvpn-admin
 
-go 1.13
+go 1.21
 
 require (
+	github.com/ThalesIgnite/crypto11 v1.2.5
 	github.com/asaskevich/govalidator v0.0.0-20170425121227-4918b99a7cb9
 	github.com/aws/aws-sdk-go v1.6.27
-	github.com/go-errors/errors v0.0.0-20161205223245-8fa88b06e597 // indirect
-	github.com/go-ini/ini v1.11.0 // indirect
+	github.com/google/cel-go v0.17.8
 	github.com/google/uuid v0.0.0-20161128191214-064e2069ce9c
 	github.com/gruntwork-io/gruntwork-cli v0.1.0
+	github.com/prometheus/client_golang v1.17.0
+	github.com/sirupsen/logrus v1.0.1-0.20170620144510-3d4380f53a34
+	github.com/urfave/cli v1.19.1
+	golang.org/x/crypto v0.23.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/antlr/antlr4/runtime/Go/antlr/v4 v4.0.0-20230305170008-8188dc5388df // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/go-errors/errors v0.0.0-20161205223245-8fa88b06e597 // indirect
+	github.com/go-ini/ini v1.11.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/jmespath/go-jmespath v0.0.0-20160202185014-0b12d6b521d8 // indirect
 	github.com/mattn/go-zglob v0.0.0-20160607002833-2dbd7f37a45e // indirect
-	github.com/sirupsen/logrus v1.0.1-0.20170620144510-3d4380f53a34
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/miekg/pkcs11 v1.1.1 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.4.1-0.20230718164431-9a2bf3000d16 // indirect
+	github.com/prometheus/common v0.44.0 // indirect
+	github.com/prometheus/procfs v0.11.1 // indirect
 	github.com/smartystreets/goconvey v1.6.4 // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
 	github.com/stretchr/testify v1.6.1 // indirect
-	github.com/urfave/cli v1.19.1
+	github.com/thales-e-security/pool v0.0.2 // indirect
+	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20230525234035-dd9d682886f9 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230525234030-28d5490b6b19 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 )
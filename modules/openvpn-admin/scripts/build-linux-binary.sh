@@ -1,14 +1,16 @@
 #!/bin/bash
-# Build a Linux binary for ssh-iam. This script is most
+# Build a Linux binary for openvpn-admin. The binary is built with cgo, as keeping the CA key in a PKCS#11 token needs
+# it, so this has to run on Linux with a C compiler installed.
 
 set -e
 
-readonly default_dest="$script_path/../bin/openvpn-admin"
-readonly dest="${1-$default_dest}"
-
 readonly script_path="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 readonly src="$script_path/../src"
 
+readonly default_dest="$script_path/../bin/openvpn-admin"
+readonly dest="${1-$default_dest}"
+readonly version="${2-test-version}"
+
 echo "Compiling openvpn-admin Linux binary from $src into $dest"
 cd "$src"
-gox -os "linux" -arch "amd64" -output "$dest" -ldflags "-X main.VERSION=test-version"
+gox -cgo -os "linux" -arch "amd64" -output "$dest" -ldflags "-X main.VERSION=$version"
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// init and server render write the access groups in the server config here, or remove the file if there are none
+const ACCESS_GROUPS_PATH = OPENVPN_PATH + "/access-groups.json"
+
+// Client certificates name each of the user's access groups in an OU with this prefix
+const ACCESS_GROUP_OU_PREFIX = "access-group:"
+
+// The iptables chain in FORWARD that sends the traffic of each connected client to a chain of its own, named
+// ACCESS_CLIENT_CHAIN_PREFIX followed by the client's VPN address and, every other time its rules change,
+// ACCESS_CLIENT_CHAIN_SUFFIX. That's at most 25 characters, within iptables' 28.
+const ACCESS_CHAIN = "openvpn-access"
+const ACCESS_CLIENT_CHAIN_PREFIX = "openvpn-"
+const ACCESS_CLIENT_CHAIN_SUFFIX = "-b"
+
+// The access groups configured for the server, or none if the server config doesn't have any
+func readAccessGroups() ([]bootstrap.AccessGroupConfig, error) {
+	contents, err := ioutil.ReadFile(ACCESS_GROUPS_PATH)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return bootstrap.ParseAccessGroups(contents)
+}
+
+// The access groups the user is in through their IAM groups. Users who aren't IAM users, such as those who request
+// certificates with an assumed role, are in none.
+func accessGroupsForUser(awsRegion string, username string) ([]string, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	groups, err := readAccessGroups()
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+
+	iamGroups, err := aws_helpers.GetIamGroupsForUser(awsRegion, username)
+	if aws_helpers.IsNoSuchIamEntity(err) {
+		logger.Warnf("%s is not an IAM user, so their certificate gives them none of the server's access groups", username)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accessGroups := []string{}
+	for _, group := range groups {
+		for _, iamGroup := range group.IamGroups {
+			if containsString(iamGroups, iamGroup) {
+				accessGroups = append(accessGroups, group.Name)
+				break
+			}
+		}
+	}
+	logger.Infof("%s is in the access groups [%s] through the IAM groups [%s]", username, strings.Join(accessGroups, ", "), strings.Join(iamGroups, ", "))
+	return accessGroups, nil
+}
+
+// The access groups recorded in the certificate with the given serial when it was issued. They're read from the copy of
+// the certificate the CA keeps rather than from the subject in the CA database, where a / in a value can't be told
+// apart from the start of another attribute.
+func certificateAccessGroups(serial *big.Int) ([]string, error) {
+	certificate, err := pki.ReadCertificate(pkiLayout.IssuedCertPath(pki.FormatSerial(serial)))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, unit := range certificate.Subject.OrganizationalUnit {
+		if strings.HasPrefix(unit, ACCESS_GROUP_OU_PREFIX) {
+			groups = append(groups, strings.TrimPrefix(unit, ACCESS_GROUP_OU_PREFIX))
+		}
+	}
+	return groups, nil
+}
+
+// Render the client config that OpenVPN's client-connect script writes for a client, which pushes the routes of its
+// access groups
+func renderAccessClientConfig(username string, routes []*net.IPNet) []byte {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("# The routes of the access groups of %s, written by openvpn-admin hook client-connect\n", username))
+	for _, route := range routes {
+		builder.WriteString(fmt.Sprintf("push \"route %s\"\n", bootstrap.AddressAndNetmask(route)))
+	}
+	return []byte(builder.String())
+}
+
+// Set up the openvpn-access chain, with a jump to it at the top of FORWARD, and drop all traffic from the VPN subnets at
+// its end. Traffic from a client only gets past that through a goto to the client's chain at the start of
+// openvpn-access, so a client that the hooks haven't set up, or whose chain was removed, reaches no network at all. The
+// VPN subnets are read from the server configs, as whoever runs the hooks controls their environment.
+func ensureAccessFirewall() error {
+	if !iptablesSucceeds("-n", "-L", ACCESS_CHAIN) {
+		if err := iptables("-N", ACCESS_CHAIN); err != nil {
+			return err
+		}
+	}
+	if !iptablesSucceeds("-C", "FORWARD", "-j", ACCESS_CHAIN) {
+		if err := iptables("-I", "FORWARD", "1", "-j", ACCESS_CHAIN); err != nil {
+			return err
+		}
+	}
+
+	instances, err := readServerInstances()
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return errors.WithStackTrace(NoVpnSubnets(pkiLayout.KeyDir))
+	}
+	for _, instance := range instances {
+		rule := []string{"-s", instance.VpnSubnet.String(), "-j", "DROP"}
+		if !iptablesSucceeds(append([]string{"-C", ACCESS_CHAIN}, rule...)...) {
+			if err := iptables(append([]string{"-A", ACCESS_CHAIN}, rule...)...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Replace the firewall rules for the client with the given VPN address with the given rules. Traffic to a network the
+// client is allowed to reach carries on through the rest of the FORWARD chain, and traffic to one it isn't is dropped.
+// The new rules are built in a chain of their own, which takes over from the client's current chain, if it has one,
+// before that's removed, so that the client is never without rules.
+func applyClientFirewall(ip net.IP, rules []bootstrap.AccessRule) error {
+	if err := ensureAccessFirewall(); err != nil {
+		return err
+	}
+
+	// A client that got the address of one that went away without a disconnect, e.g. because OpenVPN restarted, takes
+	// over its chain
+	current, next := accessClientChains(ip)
+	if !iptablesSucceeds("-n", "-L", current) {
+		current, next = next, current
+	}
+	// Left over from a change that didn't finish. The current chain is still in force.
+	removeClientChain(ip, next)
+
+	if err := iptables("-N", next); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		target := "DROP"
+		if rule.Allow {
+			target = "RETURN"
+		}
+		if err := iptables("-A", next, "-d", rule.Network.String(), "-j", target); err != nil {
+			return err
+		}
+	}
+
+	// With a goto rather than a jump, a packet the client's chain doesn't drop returns to FORWARD rather than to the
+	// drops at the end of openvpn-access
+	if err := iptables("-I", ACCESS_CHAIN, "1", "-s", ip.String()+"/32", "-g", next); err != nil {
+		return err
+	}
+	removeClientChain(ip, current)
+	return nil
+}
+
+// Remove the firewall rules for the client with the given VPN address, if it has any. Its traffic is then dropped.
+func removeClientFirewall(ip net.IP) {
+	current, next := accessClientChains(ip)
+	removeClientChain(ip, current)
+	removeClientChain(ip, next)
+}
+
+// A client's rules are in one of two chains, named ACCESS_CLIENT_CHAIN_PREFIX followed by the client's VPN address, and
+// optionally ACCESS_CLIENT_CHAIN_SUFFIX. Changing the rules moves them from one to the other.
+func accessClientChains(ip net.IP) (string, string) {
+	chain := ACCESS_CLIENT_CHAIN_PREFIX + ip.String()
+	return chain, chain + ACCESS_CLIENT_CHAIN_SUFFIX
+}
+
+// The VPN address of the client whose chain this is, or nil if it isn't a client's chain
+func accessClientChainIp(chain string) net.IP {
+	if !strings.HasPrefix(chain, ACCESS_CLIENT_CHAIN_PREFIX) {
+		return nil
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(chain, ACCESS_CLIENT_CHAIN_PREFIX), ACCESS_CLIENT_CHAIN_SUFFIX))
+}
+
+// Remove the goto to one of the client's chains, and then the chain, if there is one
+func removeClientChain(ip net.IP, chain string) {
+	iptablesSucceeds("-D", ACCESS_CHAIN, "-s", ip.String()+"/32", "-g", chain)
+	iptablesSucceeds("-F", chain)
+	iptablesSucceeds("-X", chain)
+}
+
+// Remove every client's firewall rules and the chain in FORWARD that leads to them, for a server that no longer has
+// access groups
+func removeAccessFirewall() error {
+	output, err := exec.Command("iptables", "-w", "-S").Output()
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	iptablesSucceeds("-D", "FORWARD", "-j", ACCESS_CHAIN)
+	iptablesSucceeds("-F", ACCESS_CHAIN)
+	iptablesSucceeds("-X", ACCESS_CHAIN)
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "-N" && accessClientChainIp(fields[1]) != nil {
+			iptablesSucceeds("-F", fields[1])
+			iptablesSucceeds("-X", fields[1])
+		}
+	}
+	return nil
+}
+
+// Run iptables, waiting for any other iptables command to finish, as several OpenVPN instances may connect clients at
+// the same time
+func iptables(args ...string) error {
+	return runCommand("iptables", append([]string{"-w"}, args...)...)
+}
+
+// Run an iptables command that's expected to fail sometimes, such as a check whether a rule exists
+func iptablesSucceeds(args ...string) bool {
+	return exec.Command("iptables", append([]string{"-w"}, args...)...).Run() == nil
+}
+
+// Custom errors
+
+type NoVpnSubnets string
+
+func (dir NoVpnSubnets) Error() string {
+	return fmt.Sprintf("Found no OpenVPN server config with a VPN subnet in %s, so the firewall can't drop the traffic of clients outside their access groups. Run openvpn-admin init.", string(dir))
+}
@@ -0,0 +1,291 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// An iptables that keeps the rules of each chain in a file of their own in $FAKE_IPTABLES_DIR, one rule per line, and
+// logs every command. It only knows the commands the access firewall uses.
+const FAKE_IPTABLES = `#!/bin/sh
+state="$FAKE_IPTABLES_DIR"
+[ "$1" = "-w" ] && shift
+echo "$*" >> "$state/.log"
+command="$1"
+chain="$2"
+[ "$command" = "-n" ] && chain="$3"
+shift 2
+case "$command" in
+  -N) [ ! -e "$state/$chain" ] && : > "$state/$chain" ;;
+  -X) [ -e "$state/$chain" ] && [ ! -s "$state/$chain" ] && rm "$state/$chain" ;;
+  -F) [ -e "$state/$chain" ] && : > "$state/$chain" ;;
+  -n) [ -e "$state/$chain" ] ;;
+  -C) [ -e "$state/$chain" ] && grep -qxF -- "$*" "$state/$chain" ;;
+  -A) [ -e "$state/$chain" ] && echo "$*" >> "$state/$chain" ;;
+  -I) shift; [ -e "$state/$chain" ] && { echo "$*"; cat "$state/$chain"; } > "$state/.new" && mv "$state/.new" "$state/$chain" ;;
+  -D) [ -e "$state/$chain" ] && grep -qxF -- "$*" "$state/$chain" && grep -vxF -- "$*" "$state/$chain" > "$state/.new"; status=$?; [ $status -le 1 ] && mv "$state/.new" "$state/$chain" ;;
+  *) exit 1 ;;
+esac
+`
+
+// Put the fake iptables first in the PATH, with an empty FORWARD chain, and a server config with the VPN subnet
+// 172.16.0.0/24 in the test PKI's key dir. Returns the dir with the fake's state.
+func useFakeIptables(t *testing.T) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake iptables is a shell script")
+	}
+
+	binDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(binDir, "iptables"), []byte(FAKE_IPTABLES), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	stateDir := t.TempDir()
+	t.Setenv("FAKE_IPTABLES_DIR", stateDir)
+	writeTestFile(t, filepath.Join(stateDir, "FORWARD"), "")
+
+	writeTestFile(t, filepath.Join(pkiLayout.KeyDir, "server.conf"), "server 172.16.0.0 255.255.255.0\n")
+	return stateDir
+}
+
+// Follow a packet from the given source to the given destination through the fake's FORWARD chain, the way iptables
+// does for the rules the access firewall writes. Returns DROP, or ACCEPT if it falls off the end of FORWARD.
+func fakeIptablesVerdict(t *testing.T, stateDir string, source string, destination string) string {
+	t.Helper()
+
+	var walk func(chain string, depth int) (string, bool)
+	walk = func(chain string, depth int) (string, bool) {
+		if depth > 10 {
+			t.Fatalf("the rules loop through %s", chain)
+		}
+		for _, rule := range strings.Split(strings.TrimSpace(readTestFile(t, filepath.Join(stateDir, chain))), "\n") {
+			fields := strings.Fields(rule)
+			matches := true
+			var target string
+			isGoto := false
+			for i := 0; i+1 < len(fields); i += 2 {
+				switch fields[i] {
+				case "-s":
+					matches = matches && fakeIptablesMatches(t, fields[i+1], source)
+				case "-d":
+					matches = matches && fakeIptablesMatches(t, fields[i+1], destination)
+				case "-j":
+					target = fields[i+1]
+				case "-g":
+					target = fields[i+1]
+					isGoto = true
+				}
+			}
+			if target == "" || !matches {
+				continue
+			}
+
+			switch target {
+			case "DROP":
+				return "DROP", true
+			case "RETURN":
+				return "", false
+			}
+			verdict, decided := walk(target, depth+1)
+			if decided {
+				return verdict, true
+			}
+			if isGoto {
+				// The chain that was jumped to last carries on
+				return "", false
+			}
+		}
+		return "", false
+	}
+
+	if verdict, decided := walk("FORWARD", 0); decided {
+		return verdict
+	}
+	return "ACCEPT"
+}
+
+func fakeIptablesMatches(t *testing.T, network string, address string) bool {
+	_, parsed, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Contains(net.ParseIP(address))
+}
+
+var testAccessGroups = []bootstrap.AccessGroupConfig{
+	{Name: "dev", Routes: []string{"10.10.0.0/16"}},
+	{Name: "prod", Routes: []string{"10.20.0.0/16"}},
+}
+
+func TestAccessFirewallDropsClientsWithoutRules(t *testing.T) {
+	useTestPki(t)
+	stateDir := useFakeIptables(t)
+
+	alice := net.ParseIP("172.16.0.6")
+	if err := applyClientFirewall(alice, bootstrap.ClientAccessRules(testAccessGroups, []string{"dev"})); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		source      string
+		destination string
+		expected    string
+	}{
+		{"172.16.0.6", "10.10.0.1", "ACCEPT"},
+		{"172.16.0.6", "10.20.0.1", "DROP"},
+		{"172.16.0.6", "192.0.2.1", "ACCEPT"},
+		// A VPN address without a chain of its own reaches nothing
+		{"172.16.0.7", "10.10.0.1", "DROP"},
+		{"172.16.0.7", "10.20.0.1", "DROP"},
+		{"172.16.0.7", "192.0.2.1", "DROP"},
+		// Traffic that isn't from the VPN is left to the rest of the firewall
+		{"192.0.2.9", "10.20.0.1", "ACCEPT"},
+	}
+	for _, testCase := range testCases {
+		if verdict := fakeIptablesVerdict(t, stateDir, testCase.source, testCase.destination); verdict != testCase.expected {
+			t.Errorf("expected %s from %s to %s but got %s", testCase.expected, testCase.source, testCase.destination, verdict)
+		}
+	}
+
+	removeClientFirewall(alice)
+	if verdict := fakeIptablesVerdict(t, stateDir, "172.16.0.6", "10.10.0.1"); verdict != "DROP" {
+		t.Errorf("expected a client without rules to be dropped after disconnecting but got %s", verdict)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "openvpn-172.16.0.6")); !os.IsNotExist(err) {
+		t.Errorf("expected the client's chain to be removed but got %v", err)
+	}
+}
+
+func TestAccessFirewallReplacesClientRulesWithoutGap(t *testing.T) {
+	useTestPki(t)
+	stateDir := useFakeIptables(t)
+
+	ip := net.ParseIP("172.16.0.6")
+	if err := applyClientFirewall(ip, bootstrap.ClientAccessRules(testAccessGroups, []string{"dev"})); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(stateDir, ".log"), "")
+
+	// Another client gets the same address after the first went away without a disconnect
+	if err := applyClientFirewall(ip, bootstrap.ClientAccessRules(testAccessGroups, []string{"prod"})); err != nil {
+		t.Fatal(err)
+	}
+
+	if verdict := fakeIptablesVerdict(t, stateDir, "172.16.0.6", "10.10.0.1"); verdict != "DROP" {
+		t.Errorf("expected the new client to be kept out of dev but got %s", verdict)
+	}
+	if verdict := fakeIptablesVerdict(t, stateDir, "172.16.0.6", "10.20.0.1"); verdict != "ACCEPT" {
+		t.Errorf("expected the new client to reach prod but got %s", verdict)
+	}
+
+	// The old chain must stay in force, unflushed, until the new one has taken over
+	log := strings.Split(readTestFile(t, filepath.Join(stateDir, ".log")), "\n")
+	takeOver, firstChange := -1, -1
+	for i, command := range log {
+		if command == "-I openvpn-access 1 -s 172.16.0.6/32 -g openvpn-172.16.0.6-b" {
+			takeOver = i
+		}
+		if firstChange == -1 && (strings.HasPrefix(command, "-F openvpn-172.16.0.6") || strings.HasPrefix(command, "-D openvpn-access")) && !strings.HasSuffix(command, "-b") {
+			firstChange = i
+		}
+	}
+	if takeOver == -1 || firstChange == -1 || firstChange < takeOver {
+		t.Errorf("expected the new chain to take over before the old one was changed:\n%s", strings.Join(log, "\n"))
+	}
+
+	rules := readTestFile(t, filepath.Join(stateDir, ACCESS_CHAIN))
+	if strings.Count(rules, "172.16.0.6/32") != 1 {
+		t.Errorf("expected a single goto for the client but got:\n%s", rules)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "openvpn-172.16.0.6")); !os.IsNotExist(err) {
+		t.Errorf("expected the old chain to be removed but got %v", err)
+	}
+}
+
+func TestAccessFirewallRefusesWithoutServerConfig(t *testing.T) {
+	useTestPki(t)
+	useFakeIptables(t)
+	if err := os.Remove(filepath.Join(pkiLayout.KeyDir, "server.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := applyClientFirewall(net.ParseIP("172.16.0.6"), nil)
+	if _, ok := errors.Unwrap(err).(NoVpnSubnets); !ok {
+		t.Fatalf("expected NoVpnSubnets but got %v", err)
+	}
+}
+
+func TestAccessClientChainIp(t *testing.T) {
+	ip := net.ParseIP("172.16.0.6")
+	current, next := accessClientChains(ip)
+	for _, chain := range []string{current, next} {
+		if !accessClientChainIp(chain).Equal(ip) {
+			t.Errorf("expected %s to be the chain of %s", chain, ip)
+		}
+		if len(chain) > 28 {
+			t.Errorf("%s is longer than iptables allows", chain)
+		}
+	}
+	if accessClientChainIp(ACCESS_CHAIN) != nil {
+		t.Errorf("expected %s not to be a client's chain", ACCESS_CHAIN)
+	}
+}
+
+func TestCertificateAccessGroupsAreReadFromTheCertificate(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := issueClientCertificate("alice", 0, []string{"dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Whoever can change the CA database can't grant access groups by editing the subject
+	index := readTestFile(t, pkiLayout.IndexPath())
+	writeTestFile(t, pkiLayout.IndexPath(), strings.Replace(index, "/CN=alice", "/CN=alice/OU=access-group:prod", 1))
+
+	groups, err := certificateAccessGroups(certificate.SerialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"dev"}) {
+		t.Errorf("expected the access groups [dev], got %v", groups)
+	}
+}
+
+func TestIssueClientCertificateRefusesUsernamesThatAddAttributes(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"alice/OU=access-group:prod", "alice\nbob", "alice\tbob", "..", "../alice", ""} {
+		_, err := issueClientCertificate(username, 0, nil)
+		if _, ok := errors.Unwrap(err).(InvalidUsername); !ok {
+			t.Errorf("expected InvalidUsername for %q, got %v", username, err)
+		}
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Entries) != 0 {
+		t.Errorf("expected no certificates to be issued, got %d", len(index.Entries))
+	}
+}
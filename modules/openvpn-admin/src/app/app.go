@@ -3,7 +3,11 @@ package app
 import (
 	"fmt"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
 	"github.com/urfave/cli"
+	"strings"
+	"time"
 )
 
 const LOGGER_NAME = "openvpn-admin"
@@ -11,8 +15,46 @@ const OPTION_USERNAME = "username"
 const OPTION_TIMEOUT = "timeout"
 const OPTION_AWS_REGION = "aws-region"
 const OPTION_DEBUG = "debug"
+const OPTION_LOG_FORMAT = "log-format"
 const OPTION_REQUEST_URL = "request-url"
 const OPTION_REVOKE_URL = "revoke-url"
+const OPTION_REASON = "reason"
+const OPTION_CRL_VALIDITY = "crl-validity"
+const OPTION_CRL_REFRESH_INTERVAL = "crl-refresh-interval"
+const OPTION_OCSP_BIND_ADDRESS = "ocsp-bind-address"
+const OPTION_OCSP_PORT = "ocsp-port"
+const OPTION_OCSP_RESPONSE_VALIDITY = "ocsp-response-validity"
+const OPTION_METRICS_BIND_ADDRESS = "metrics-bind-address"
+const OPTION_METRICS_PORT = "metrics-port"
+const OPTION_FORCE = "force"
+const OPTION_STATUS = "status"
+const OPTION_WAIT_FOR_APPROVAL = "wait-for-approval"
+const OPTION_REQUIRE_APPROVAL = "require-approval"
+const OPTION_APPROVAL_SNS_TOPIC_ARN = "approval-sns-topic-arn"
+const OPTION_POLICY_FILE = "policy-file"
+const OPTION_TTL = "ttl"
+const OPTION_AUDIT_S3_BUCKET = "audit-s3-bucket"
+const OPTION_AUDIT_LOG_GROUP = "audit-log-group"
+const OPTION_NOTIFICATIONS_FILE = "notifications-file"
+const OPTION_EXPIRY_REMINDER_DAYS = "expiry-reminder-days"
+const OPTION_WITHIN = "within"
+const OPTION_CONFIG = "config"
+const OPTION_ROOT = "root"
+const OPTION_STATIC_IP = "static-ip"
+const OPTION_PUSH_ROUTE = "push-route"
+const OPTION_SINCE = "since"
+const OPTION_UNTIL = "until"
+const OPTION_FORMAT = "format"
+const OPTION_S3_BUCKET_NAME = "s3-bucket-name"
+const OPTION_KMS_KEY_ID = "kms-key-id"
+const OPTION_BACKUP_URL = "backup-url"
+const OPTION_S3_ENDPOINT = "s3-endpoint"
+const OPTION_SFTP_IDENTITY_FILE = "sftp-identity-file"
+const OPTION_AGE_RECIPIENT = "age-recipient"
+const OPTION_AGE_IDENTITY_FILE = "age-identity-file"
+const OPTION_GPG_RECIPIENT = "gpg-recipient"
+const OPTION_BACKUP_VERSION = "backup-version"
+const OPTION_LIST = "list"
 
 func CreateApp(version string) *cli.App {
 	app := cli.NewApp()
@@ -51,36 +93,514 @@ func CreateApp(version string) *cli.App {
 		Usage: "The SQS url of the certificate revocation queue. Optional.",
 	}
 
+	reasonFlag := cli.StringFlag{
+		Name:  OPTION_REASON,
+		Usage: fmt.Sprintf("The reason the certificate is being revoked, recorded in the CA database and the CRL. One of: %s. Optional.", strings.Join(pki.UserRevocationReasons, ", ")),
+	}
+
+	crlValidityFlag := cli.DurationFlag{
+		Name:  OPTION_CRL_VALIDITY,
+		Usage: "How long each newly published CRL is valid for (its nextUpdate), e.g. 72h. Defaults to default_crl_days in the easy-rsa config.",
+	}
+
+	crlRefreshIntervalFlag := cli.DurationFlag{
+		Name:  OPTION_CRL_REFRESH_INTERVAL,
+		Usage: fmt.Sprintf("How often to publish a fresh CRL, e.g. 24h. Must be shorter than --%s. Defaults to %s, or half the CRL validity if that's shorter. Set it to 0 to disable scheduled refreshes.", OPTION_CRL_VALIDITY, DEFAULT_CRL_REFRESH_INTERVAL),
+		Value: DEFAULT_CRL_REFRESH_INTERVAL,
+	}
+
+	ocspBindAddressFlag := cli.StringFlag{
+		Name:  OPTION_OCSP_BIND_ADDRESS,
+		Usage: "The local address the OCSP responder listens on. Defaults to 127.0.0.1.",
+		Value: "127.0.0.1",
+	}
+
+	ocspPortFlag := cli.IntFlag{
+		Name:  OPTION_OCSP_PORT,
+		Usage: "The port the OCSP responder listens on. Defaults to 2560.",
+		Value: 2560,
+	}
+
+	ocspResponseValidityFlag := cli.DurationFlag{
+		Name:  OPTION_OCSP_RESPONSE_VALIDITY,
+		Usage: "How long clients may cache an OCSP response (its nextUpdate). Defaults to 1h.",
+		Value: time.Hour,
+	}
+
+	metricsBindAddressFlag := cli.StringFlag{
+		Name:  OPTION_METRICS_BIND_ADDRESS,
+		Usage: "The local address to serve Prometheus metrics and health checks on. Defaults to 127.0.0.1.",
+		Value: "127.0.0.1",
+	}
+
+	metricsPortFlag := cli.IntFlag{
+		Name:  OPTION_METRICS_PORT,
+		Usage: "The port to serve Prometheus metrics on /metrics, and health checks on /healthz and /readyz. Defaults to 0, which disables them.",
+	}
+
+	statusFlag := cli.StringFlag{
+		Name:  OPTION_STATUS,
+		Usage: "The id of an earlier certificate request that was waiting for approval. Fetches the certificate if it has been approved since. Optional.",
+	}
+
+	waitForApprovalFlag := cli.DurationFlag{
+		Name:  OPTION_WAIT_FOR_APPROVAL,
+		Usage: "If the OpenVPN server requires an admin to approve new certificates, how long to keep checking whether the request was approved, e.g. 30m. Defaults to 0, which returns straight away with the request id.",
+	}
+
+	requireApprovalFlag := cli.BoolFlag{
+		Name:  OPTION_REQUIRE_APPROVAL,
+		Usage: "Park new certificate requests until an admin approves them with 'openvpn-admin approve'",
+	}
+
+	approvalSnsTopicArnFlag := cli.StringFlag{
+		Name:  OPTION_APPROVAL_SNS_TOPIC_ARN,
+		Usage: "The ARN of an SNS topic to notify approvers on when a certificate request needs approval. Optional.",
+	}
+
+	denyReasonFlag := cli.StringFlag{
+		Name:  OPTION_REASON,
+		Usage: "Why the request was denied. Sent back to the user. Optional.",
+	}
+
+	policyFileFlag := cli.StringFlag{
+		Name:  OPTION_POLICY_FILE,
+		Usage: "The path to a policy file whose rules decide who may request, revoke, release or list certificates. Optional. Without it, anyone who can send to the queues may.",
+	}
+
+	ttlFlag := cli.DurationFlag{
+		Name:  OPTION_TTL,
+		Usage: "How long the new certificate should be valid for, e.g. 720h. Can only shorten the validity configured on the OpenVPN server. Optional.",
+	}
+
+	auditS3BucketFlag := cli.StringFlag{
+		Name:  OPTION_AUDIT_S3_BUCKET,
+		Usage: "The name of an S3 bucket to ship a copy of each audit event to, under audit/. Optional.",
+	}
+
+	auditLogGroupFlag := cli.StringFlag{
+		Name:  OPTION_AUDIT_LOG_GROUP,
+		Usage: "The name of a CloudWatch Logs log group to ship a copy of each audit event to. Optional.",
+	}
+
+	notificationsFileFlag := cli.StringFlag{
+		Name:  OPTION_NOTIFICATIONS_FILE,
+		Usage: "The path to a YAML file listing the webhooks, Slack channels and SNS topics to notify of certificate events. Optional.",
+	}
+
+	expiryReminderDaysFlag := cli.StringFlag{
+		Name:  OPTION_EXPIRY_REMINDER_DAYS,
+		Usage: fmt.Sprintf("How many days before a certificate expires to send its holder a certificate.expiring notification, as a comma separated list. Only used with --%s. Defaults to %s. Set it to an empty string to disable reminders.", OPTION_NOTIFICATIONS_FILE, DEFAULT_EXPIRY_REMINDER_DAYS),
+		Value: DEFAULT_EXPIRY_REMINDER_DAYS,
+	}
+
+	withinFlag := cli.DurationFlag{
+		Name:  OPTION_WITHIN,
+		Usage: fmt.Sprintf("List the certificates that expire within this long. Defaults to %s.", CERTIFICATE_EXPIRING_WINDOW),
+		Value: CERTIFICATE_EXPIRING_WINDOW,
+	}
+
+	initConfigFlag := cli.StringFlag{
+		Name:  OPTION_CONFIG,
+		Usage: "The path to a YAML file describing the OpenVPN server's PKI, backups and network settings. Required.",
+	}
+
+	rootFlag := cli.StringFlag{
+		Name:  OPTION_ROOT,
+		Usage: "Write the server's files under this directory instead of /, e.g. to try init out in a container. Anything other than / leaves the running system's sysctl settings, firewall and services alone. Defaults to /.",
+		Value: "/",
+	}
+
+	staticIpFlag := cli.StringFlag{
+		Name:  OPTION_STATIC_IP,
+		Usage: "The address in the VPN subnet to always give the user. With several listeners, the user gets the address with the same host number in each listener's subnet.",
+	}
+
+	pushRouteFlag := cli.StringSliceFlag{
+		Name:  OPTION_PUSH_ROUTE,
+		Usage: "A network to route over the VPN for this user only, e.g. 10.200.0.0/16. May be specified multiple times.",
+	}
+
+	reportUsernameFlag := cli.StringFlag{
+		Name:  OPTION_USERNAME,
+		Usage: "Only report the sessions of this user. Defaults to every user.",
+	}
+
+	sinceFlag := cli.StringFlag{
+		Name:  OPTION_SINCE,
+		Usage: fmt.Sprintf("Report the sessions connected at any time since this time, e.g. 2026-10-13T03:00 in your time zone, or this long ago, e.g. 168h. Defaults to %s.", DEFAULT_REPORT_SINCE),
+		Value: DEFAULT_REPORT_SINCE,
+	}
+
+	untilFlag := cli.StringFlag{
+		Name:  OPTION_UNTIL,
+		Usage: fmt.Sprintf("Report the sessions connected at any time until this time, written like --%s. Defaults to now.", OPTION_SINCE),
+	}
+
+	formatFlag := cli.StringFlag{
+		Name:  OPTION_FORMAT,
+		Usage: fmt.Sprintf("The format to print the report in. One of: %s. Defaults to %s.", strings.Join(ReportFormats, ", "), REPORT_FORMAT_TABLE),
+		Value: REPORT_FORMAT_TABLE,
+	}
+
+	forceFlag := cli.BoolFlag{
+		Name:  OPTION_FORCE,
+		Usage: "Retire the previous CA even though some users only have certificates from it",
+	}
+
+	s3BucketNameFlag := cli.StringFlag{
+		Name:  OPTION_S3_BUCKET_NAME,
+		Usage: fmt.Sprintf("The name of the S3 bucket backups are kept in, under %s. Either this or --%s is required.", BACKUP_ARCHIVE_PREFIX, OPTION_BACKUP_URL),
+	}
+
+	backupUrlFlag := cli.StringFlag{
+		Name:  OPTION_BACKUP_URL,
+		Usage: fmt.Sprintf("Where backups are kept: s3://<bucket>/<prefix>, file:///<dir> or sftp://<user>@<host>:<port>/<dir>. Either this or --%s is required.", OPTION_S3_BUCKET_NAME),
+	}
+
+	s3EndpointFlag := cli.StringFlag{
+		Name:  OPTION_S3_ENDPOINT,
+		Usage: "The URL of an S3-compatible store such as MinIO to keep backups in instead of S3, e.g. https://minio.acme.internal:9000. Optional.",
+	}
+
+	sftpIdentityFileFlag := cli.StringFlag{
+		Name:  OPTION_SFTP_IDENTITY_FILE,
+		Usage: "The SSH private key to log in to an sftp:// backup URL with. Optional. Defaults to the keys in ~/.ssh.",
+	}
+
+	kmsKeyIdFlag := cli.StringFlag{
+		Name:  OPTION_KMS_KEY_ID,
+		Usage: fmt.Sprintf("The id, ARN or alias of the KMS key to encrypt the backup with. One of this, --%s or --%s is required.", OPTION_AGE_RECIPIENT, OPTION_GPG_RECIPIENT),
+	}
+
+	ageRecipientFlag := cli.StringSliceFlag{
+		Name:  OPTION_AGE_RECIPIENT,
+		Usage: "An age public key to encrypt the backup for, with the age command. May be specified multiple times.",
+	}
+
+	ageIdentityFileFlag := cli.StringFlag{
+		Name:  OPTION_AGE_IDENTITY_FILE,
+		Usage: "The age identity file to decrypt a backup encrypted with age with. Required for those backups.",
+	}
+
+	gpgRecipientFlag := cli.StringSliceFlag{
+		Name:  OPTION_GPG_RECIPIENT,
+		Usage: "The key id, fingerprint or email of a GPG public key in the keyring to encrypt the backup for, with the gpg command. May be specified multiple times.",
+	}
+
+	exportKmsKeyIdFlag := cli.StringFlag{
+		Name:  OPTION_KMS_KEY_ID,
+		Usage: "The id, ARN or alias of the KMS key to encrypt the bundle with. Optional.",
+	}
+
+	exportAgeRecipientFlag := cli.StringSliceFlag{
+		Name:  OPTION_AGE_RECIPIENT,
+		Usage: "An age public key to encrypt the bundle for, with the age command. Optional. May be specified multiple times.",
+	}
+
+	exportGpgRecipientFlag := cli.StringSliceFlag{
+		Name:  OPTION_GPG_RECIPIENT,
+		Usage: "The key id, fingerprint or email of a GPG public key in the keyring to encrypt the bundle for, with the gpg command. Optional. May be specified multiple times.",
+	}
+
+	importAgeIdentityFileFlag := cli.StringFlag{
+		Name:  OPTION_AGE_IDENTITY_FILE,
+		Usage: "The age identity file to decrypt a bundle encrypted with age with. Required for those bundles.",
+	}
+
+	backupVersionFlag := cli.StringFlag{
+		Name:  OPTION_BACKUP_VERSION,
+		Usage: fmt.Sprintf("The version of the backup to restore, as listed by --%s. Defaults to the latest.", OPTION_LIST),
+	}
+
+	listFlag := cli.BoolFlag{
+		Name:  OPTION_LIST,
+		Usage: "List the versions of the backups in the bucket, oldest first, rather than restoring one",
+	}
+
+	restoreForceFlag := cli.BoolFlag{
+		Name:  OPTION_FORCE,
+		Usage: "Restore the backup even though there is already a PKI on this server. Certificates issued since the backup was taken are forgotten.",
+	}
+
 	debugFlag := cli.BoolFlag{
 		Name:   OPTION_DEBUG,
 		Usage:  "Whether debug logging should be enabled",
 		EnvVar: "OPENVPN_ADMIN_DEBUG",
 	}
 
+	logFormatFlag := cli.StringFlag{
+		Name:   OPTION_LOG_FORMAT,
+		Usage:  fmt.Sprintf("The format to write log output in. One of: %s. Defaults to text.", strings.Join(logging.Formats, ", ")),
+		Value:  logging.FORMAT_TEXT,
+		EnvVar: "OPENVPN_ADMIN_LOG_FORMAT",
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:   "request",
 			Usage:  "Request a new certificate for a user with OpenVPN",
 			Action: errors.WithPanicHandling(requestNewCertificate),
-			Flags:  []cli.Flag{debugFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, timeoutFlag, awsRegionFlag},
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, timeoutFlag, awsRegionFlag, statusFlag, waitForApprovalFlag, ttlFlag},
+		},
+		{
+			Name:   "list",
+			Usage:  "List the certificates the OpenVPN server has issued to a user",
+			Action: errors.WithPanicHandling(listCertificates),
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, usernameFlag, timeoutFlag, awsRegionFlag},
 		},
 		{
 			Name:   "revoke",
 			Usage:  "Revoke an existing OpenVPN certificate for a user",
 			Action: errors.WithPanicHandling(requestCertificateRevocation),
-			Flags:  []cli.Flag{debugFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag},
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag, reasonFlag},
+		},
+		{
+			Name:   "release",
+			Usage:  "Release the hold on a certificate that was revoked with --reason certificateHold",
+			Action: errors.WithPanicHandling(requestCertificateRelease),
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag},
+		},
+		{
+			Name:  "client-config",
+			Usage: "Manage the per-user settings the OpenVPN server pushes to a user's client",
+			Subcommands: []cli.Command{
+				{
+					Name:   "set",
+					Usage:  "Pin a user to a static IP and push extra routes to them. Replaces the user's previous settings, and removes them if neither is given.",
+					Action: errors.WithPanicHandling(requestClientConfigChange),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag, staticIpFlag, pushRouteFlag},
+				},
+			},
+		},
+		{
+			Name:      "approve",
+			Usage:     "Approve a certificate request that is waiting for approval",
+			ArgsUsage: "<request-id>",
+			Action:    errors.WithPanicHandling(approveCertificateRequest),
+			Flags:     []cli.Flag{debugFlag, logFormatFlag, revokeUrlFlag, awsRegionFlag, timeoutFlag},
+		},
+		{
+			Name:      "deny",
+			Usage:     "Deny a certificate request that is waiting for approval",
+			ArgsUsage: "<request-id>",
+			Action:    errors.WithPanicHandling(denyCertificateRequest),
+			Flags:     []cli.Flag{debugFlag, logFormatFlag, revokeUrlFlag, awsRegionFlag, timeoutFlag, denyReasonFlag},
+		},
+		{
+			Name:  "report",
+			Usage: "Report on how the OpenVPN server is used",
+			Subcommands: []cli.Command{
+				{
+					Name:   "sessions",
+					Usage:  "List the sessions that were connected to the OpenVPN server at any time between --since and --until, with how much they transferred",
+					Action: errors.WithPanicHandling(reportSessionHistory),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, revokeUrlFlag, awsRegionFlag, timeoutFlag, reportUsernameFlag, sinceFlag, untilFlag, formatFlag},
+				},
+			},
 		},
 		{
 			Name:   "process-requests",
 			Usage:  "Listen for certificate requests and revocations and process those requests",
 			Action: errors.WithPanicHandling(processNewCertificateRequests),
-			Flags:  []cli.Flag{debugFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag},
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag, requireApprovalFlag, approvalSnsTopicArnFlag, policyFileFlag, auditS3BucketFlag, auditLogGroupFlag, metricsBindAddressFlag, metricsPortFlag, notificationsFileFlag},
 		},
 		{
 			Name:   "process-revokes",
 			Usage:  "Listen for certificate revocations and process those requests",
 			Action: errors.WithPanicHandling(processCertificateRevocationRequests),
-			Flags:  []cli.Flag{debugFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag},
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, requestUrlFlag, revokeUrlFlag, usernameFlag, awsRegionFlag, timeoutFlag, crlValidityFlag, crlRefreshIntervalFlag, policyFileFlag, auditS3BucketFlag, auditLogGroupFlag, metricsBindAddressFlag, metricsPortFlag, notificationsFileFlag, expiryReminderDaysFlag},
+		},
+		{
+			Name:   "expiring",
+			Usage:  "List the valid certificates on the OpenVPN server that expire within --within, soonest first",
+			Action: errors.WithPanicHandling(showExpiringCertificates),
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, revokeUrlFlag, awsRegionFlag, timeoutFlag, withinFlag},
+		},
+		{
+			Name:  "crl",
+			Usage: "Inspect or refresh the certificate revocation list on the OpenVPN server",
+			Subcommands: []cli.Command{
+				{
+					Name:   "status",
+					Usage:  "Show the CRL's next update time and revoked count, failing if OpenVPN can't use the CRL",
+					Action: errors.WithPanicHandling(showCrlStatus),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+				},
+				{
+					Name:   "refresh",
+					Usage:  "Publish a new CRL from the CA database",
+					Action: errors.WithPanicHandling(refreshCrlNow),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, crlValidityFlag},
+				},
+			},
+		},
+		{
+			Name:   "init",
+			Usage:  "Set up the OpenVPN server from a config file, restoring or creating its PKI. Safe to run again to apply changes to the config.",
+			Action: errors.WithPanicHandling(initOpenVpnServer),
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, initConfigFlag, rootFlag, awsRegionFlag},
+		},
+		{
+			Name:   "backup",
+			Usage:  "Back up the PKI as a single archive, encrypted with a KMS key, age or GPG, to S3, an S3-compatible store, a directory or an SFTP server",
+			Action: errors.WithPanicHandling(backUpPkiNow),
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, awsRegionFlag, s3BucketNameFlag, backupUrlFlag, s3EndpointFlag, sftpIdentityFileFlag, kmsKeyIdFlag, ageRecipientFlag, gpgRecipientFlag},
+		},
+		{
+			Name:   "restore",
+			Usage:  "Restore the PKI from a backup, after checking that the CA key matches the CA certificate and the CA database is readable",
+			Action: errors.WithPanicHandling(restorePkiNow),
+			Flags:  []cli.Flag{debugFlag, logFormatFlag, awsRegionFlag, s3BucketNameFlag, backupUrlFlag, s3EndpointFlag, sftpIdentityFileFlag, ageIdentityFileFlag, backupVersionFlag, listFlag, restoreForceFlag},
+		},
+		{
+			Name:  "server",
+			Usage: "Manage the OpenVPN server's configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:   "render",
+					Usage:  "Write server.conf and the client profile template from the server settings in a config file",
+					Action: errors.WithPanicHandling(renderServerConfig),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, initConfigFlag, rootFlag},
+				},
+			},
+		},
+		{
+			Name:  "pki",
+			Usage: "Build the PKI on the OpenVPN server using the settings in /etc/openvpn-ca/vars.local, or import or export it",
+			Subcommands: []cli.Command{
+				{
+					Name:   "build-ca",
+					Usage:  "Create the CA certificate and key",
+					Action: errors.WithPanicHandling(buildCaNow),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+				},
+				{
+					Name:   "build-server",
+					Usage:  "Issue the OpenVPN server certificate and key",
+					Action: errors.WithPanicHandling(buildServerCertificateNow),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+				},
+				{
+					Name:      "import",
+					Usage:     "Take over the CA, CA database, serial and CRL of an easy-rsa 2, easy-rsa 3 or OpenSSL CA dir, or of a bundle from pki export, after checking they agree",
+					ArgsUsage: "<dir-or-bundle>",
+					Action:    errors.WithPanicHandling(importPkiNow),
+					Flags:     []cli.Flag{debugFlag, logFormatFlag, crlValidityFlag, awsRegionFlag, importAgeIdentityFileFlag},
+				},
+				{
+					Name:      "export",
+					Usage:     "Write the CA, CA database, serial, CRL and issued certificates to a bundle in the easy-rsa 3 layout, optionally encrypted",
+					ArgsUsage: "<bundle>",
+					Action:    errors.WithPanicHandling(exportPkiNow),
+					Flags:     []cli.Flag{debugFlag, logFormatFlag, awsRegionFlag, exportKmsKeyIdFlag, exportAgeRecipientFlag, exportGpgRecipientFlag},
+				},
+			},
+		},
+		{
+			Name:  "ca",
+			Usage: "Rotate the CA on the OpenVPN server",
+			Subcommands: []cli.Command{
+				{
+					Name:   "rotate",
+					Usage:  "Create a new CA and trust both the new and the previous CA while users migrate",
+					Action: errors.WithPanicHandling(rotateCa),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, crlValidityFlag},
+				},
+				{
+					Name:   "status",
+					Usage:  "Show the CA rotation in progress and which users have migrated to the new CA",
+					Action: errors.WithPanicHandling(showCaRotationStatus),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+				},
+				{
+					Name:   "retire",
+					Usage:  "Finish the CA rotation and stop trusting the previous CA",
+					Action: errors.WithPanicHandling(retireCa),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, crlValidityFlag, forceFlag},
+				},
+			},
+		},
+		{
+			Name:  "tls-crypt-v2",
+			Usage: "Check per-client tls-crypt-v2 keys on the OpenVPN server",
+			Subcommands: []cli.Command{
+				{
+					Name:   "verify",
+					Usage:  "Used as the OpenVPN --tls-crypt-v2-verify command to refuse client keys whose certificate is no longer valid",
+					Action: errors.WithPanicHandling(verifyTlsCryptV2Client),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+				},
+			},
+		},
+		{
+			Name:  "hook",
+			Usage: "Run when clients connect to or disconnect from the OpenVPN server",
+			Subcommands: []cli.Command{
+				{
+					Name:      "client-connect",
+					Usage:     "Used as the OpenVPN --client-connect script to check the client against the connection policy, apply its access groups and record its session",
+					ArgsUsage: "<config-file>",
+					Action:    errors.WithPanicHandling(runClientConnectHook),
+					Flags:     []cli.Flag{debugFlag, logFormatFlag},
+				},
+				{
+					Name:   "client-disconnect",
+					Usage:  "Used as the OpenVPN --client-disconnect script to record the end of the client's session and remove its firewall rules",
+					Action: errors.WithPanicHandling(runClientDisconnectHook),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+				},
+				{
+					Name:   HOOK_START_SESSION,
+					Usage:  "Run as root by client-connect to start the client's session and apply its access groups",
+					Action: errors.WithPanicHandling(runStartSessionHook),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+					Hidden: true,
+				},
+				{
+					Name:   HOOK_END_SESSION,
+					Usage:  "Run as root by client-disconnect to end the client's session",
+					Action: errors.WithPanicHandling(runEndSessionHook),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+					Hidden: true,
+				},
+			},
+		},
+		{
+			Name:  "audit",
+			Usage: "Check the audit log of PKI operations on the OpenVPN server",
+			Subcommands: []cli.Command{
+				{
+					Name:   "verify",
+					Usage:  "Check that no event in the audit log was changed, removed or reordered",
+					Action: errors.WithPanicHandling(verifyAuditLog),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag},
+				},
+			},
+		},
+		{
+			Name:  "notify",
+			Usage: "Check the notifications process-requests and process-revokes send about certificate events",
+			Subcommands: []cli.Command{
+				{
+					Name:   "test",
+					Usage:  "Send a test notification to every sink in a notifications file",
+					Action: errors.WithPanicHandling(testNotifications),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, notificationsFileFlag, awsRegionFlag},
+				},
+			},
+		},
+		{
+			Name:  "ocsp",
+			Usage: "Check the status of issued certificates over OCSP",
+			Subcommands: []cli.Command{
+				{
+					Name:   "serve",
+					Usage:  "Run an OCSP responder that answers from the CA database on the OpenVPN server",
+					Action: errors.WithPanicHandling(serveOcspResponses),
+					Flags:  []cli.Flag{debugFlag, logFormatFlag, ocspBindAddressFlag, ocspPortFlag, ocspResponseValidityFlag},
+				},
+			},
 		},
 	}
 
@@ -98,3 +618,7 @@ var MissingUsername = fmt.Errorf("--%s cannot be empty", OPTION_USERNAME)
 var MissingAwsRegion = fmt.Errorf("--%s cannot be empty", OPTION_AWS_REGION)
 var MissingRequestUrl = fmt.Errorf("--%s cannot be empty", OPTION_REQUEST_URL)
 var MissingRevokeUrl = fmt.Errorf("--%s cannot be empty", OPTION_REVOKE_URL)
+var MissingInitConfig = fmt.Errorf("--%s cannot be empty", OPTION_CONFIG)
+var MissingApprovalRequestId = fmt.Errorf("expected exactly one argument: the id of the certificate request")
+var MissingPkiImportSource = fmt.Errorf("expected exactly one argument: the directory or bundle to import the PKI from")
+var MissingPkiExportPath = fmt.Errorf("expected exactly one argument: the file to write the bundle to")
@@ -0,0 +1,224 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Certificate requests waiting for, or decided by, an admin are kept as one JSON file each in this directory, so that
+// process-requests and process-revokes can both see them and they're backed up with the rest of the PKI
+const APPROVALS_PATH = OPENVPN_PATH + "/approvals"
+
+// The states of a certificate request that needs approval
+const APPROVAL_STATUS_PENDING = "pending"
+const APPROVAL_STATUS_APPROVED = "approved"
+const APPROVAL_STATUS_DENIED = "denied"
+const APPROVAL_STATUS_ISSUED = "issued"
+
+// How process-requests handles certificate requests when --require-approval is set
+type approvalSettings struct {
+	Required    bool
+	SnsTopicArn string
+}
+
+type approvalRequest struct {
+	Id       string
+	Username string
+	// The SQS SenderId of the IAM principal that submitted the request. Only they may check on it and fetch the
+	// certificate once it's approved.
+	SenderId    string `json:",omitempty"`
+	Status      string
+	RequestedAt time.Time
+	DecidedAt   time.Time     `json:",omitempty"`
+	Reason      string        `json:",omitempty"`
+	Ttl         time.Duration `json:",omitempty"`
+	PolicyRule  string        `json:",omitempty"`
+}
+
+func approvalRequestPath(id string) string {
+	return filepath.Join(APPROVALS_PATH, id+".json")
+}
+
+func readApprovalRequest(id string) (*approvalRequest, error) {
+	// The id comes from the client, so make sure it can't point outside of the approvals dir
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.WithStackTrace(UnknownApprovalRequest(id))
+	}
+
+	bytes, err := ioutil.ReadFile(approvalRequestPath(id))
+	if os.IsNotExist(err) {
+		return nil, errors.WithStackTrace(UnknownApprovalRequest(id))
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	request := &approvalRequest{}
+	if err := json.Unmarshal(bytes, request); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return request, nil
+}
+
+// Write the request to a temp file first, as the other process may read it at any time
+func (request *approvalRequest) write() error {
+	bytes, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	if err := os.MkdirAll(APPROVALS_PATH, 0700); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	path := approvalRequestPath(request.Id)
+	if err := ioutil.WriteFile(path+".tmp", append(bytes, '\n'), 0600); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(os.Rename(path+".tmp", path))
+}
+
+// Find the most recent request the given sender made for the given user that hasn't resulted in a certificate yet, or nil
+// if there is none
+func findOpenApprovalRequest(senderId string, username string) (*approvalRequest, error) {
+	paths, err := filepath.Glob(filepath.Join(APPROVALS_PATH, "*.json"))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	requests := []*approvalRequest{}
+	for _, path := range paths {
+		request, err := readApprovalRequest(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if request.SenderId == senderId && request.Username == username && (request.Status == APPROVAL_STATUS_PENDING || request.Status == APPROVAL_STATUS_APPROVED) {
+			requests = append(requests, request)
+		}
+	}
+
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestedAt.After(requests[j].RequestedAt) })
+	return requests[0], nil
+}
+
+// Park a certificate request until an admin approves or denies it, and let the approvers know about it. policyRule
+// explains why the policy requires approval, if it was the policy rather than --require-approval.
+func createApprovalRequest(awsRegion string, senderId string, username string, ttl time.Duration, policyRule string, settings approvalSettings) (*approvalRequest, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	request := &approvalRequest{
+		Id:          id.String(),
+		Username:    username,
+		SenderId:    senderId,
+		Status:      APPROVAL_STATUS_PENDING,
+		RequestedAt: time.Now().UTC(),
+		Ttl:         ttl,
+		PolicyRule:  policyRule,
+	}
+	if err := request.write(); err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Certificate request %s for %s is waiting for approval. Approve it with 'openvpn-admin approve %s' or deny it with 'openvpn-admin deny %s'.", request.Id, username, request.Id, request.Id)
+
+	// The request is already recorded, so a failure to notify shouldn't fail it. Admins can still find it in the logs.
+	if settings.SnsTopicArn != "" {
+		subject := fmt.Sprintf("OpenVPN certificate request from %s", username)
+		message := fmt.Sprintf("%s requested an OpenVPN certificate at %s.\n\n", username, request.RequestedAt.Format(time.RFC3339))
+		if policyRule != "" {
+			message += fmt.Sprintf("Approval is required by %s.\n\n", policyRule)
+		}
+		message += fmt.Sprintf("Approve it with:\n\n    openvpn-admin approve %s\n\nor deny it with:\n\n    openvpn-admin deny %s --reason \"...\"\n", request.Id, request.Id)
+		if err := aws_helpers.PublishToTopic(awsRegion, settings.SnsTopicArn, subject, message); err != nil {
+			logger.Warnf("Failed to notify approvers of certificate request %s: %s", request.Id, err)
+		}
+	}
+
+	return request, nil
+}
+
+// Record an admin's decision on a pending certificate request
+func decideApprovalRequest(id string, approve bool, reason string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	request, err := readApprovalRequest(id)
+	if err != nil {
+		return err
+	}
+
+	if request.Status != APPROVAL_STATUS_PENDING {
+		return errors.WithStackTrace(ApprovalRequestAlreadyDecided{Id: id, Status: request.Status})
+	}
+
+	request.Status = APPROVAL_STATUS_DENIED
+	if approve {
+		request.Status = APPROVAL_STATUS_APPROVED
+	}
+	request.DecidedAt = time.Now().UTC()
+	request.Reason = reason
+
+	logger.Infof("Certificate request %s for %s was %s", id, request.Username, request.Status)
+	return request.write()
+}
+
+// Custom errors
+
+// Sent back to the client instead of a certificate while an admin hasn't approved the request yet
+type CertificateRequestPendingApproval struct {
+	Id         string
+	Username   string
+	PolicyRule string
+}
+
+func (err CertificateRequestPendingApproval) Error() string {
+	if err.PolicyRule != "" {
+		return fmt.Sprintf("The certificate request for %s is waiting for an admin to approve it, as required by %s. Check on it with 'openvpn-admin request --status %s'.", err.Username, err.PolicyRule, err.Id)
+	}
+	return fmt.Sprintf("The certificate request for %s is waiting for an admin to approve it. Check on it with 'openvpn-admin request --status %s'.", err.Username, err.Id)
+}
+
+type CertificateRequestDenied struct {
+	Id     string
+	Reason string
+}
+
+func (err CertificateRequestDenied) Error() string {
+	if err.Reason == "" {
+		return fmt.Sprintf("Certificate request %s was denied", err.Id)
+	}
+	return fmt.Sprintf("Certificate request %s was denied: %s", err.Id, err.Reason)
+}
+
+type UnknownApprovalRequest string
+
+func (err UnknownApprovalRequest) Error() string {
+	return fmt.Sprintf("There is no certificate request with id '%s'", string(err))
+}
+
+type ApprovalRequestAlreadyDecided struct {
+	Id     string
+	Status string
+}
+
+func (err ApprovalRequestAlreadyDecided) Error() string {
+	return fmt.Sprintf("Certificate request %s is already %s", err.Id, err.Status)
+}
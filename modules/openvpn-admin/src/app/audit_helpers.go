@@ -0,0 +1,213 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/audit"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"os"
+	"os/user"
+)
+
+// Every PKI operation on the server is recorded in this file, which is backed up with the rest of the PKI
+const AUDIT_LOG_PATH = OPENVPN_PATH + "/audit.log"
+
+// The actions recorded in the audit log, besides the ones requested over the queues
+const AUDIT_ACTION_REQUEST = "request"
+const AUDIT_ACTION_BUILD_CA = "build-ca"
+const AUDIT_ACTION_BUILD_SERVER = "build-server"
+const AUDIT_ACTION_ROTATE_CA = "ca-rotate"
+const AUDIT_ACTION_RETIRE_CA = "ca-retire"
+const AUDIT_ACTION_REFRESH_CRL = "crl-refresh"
+const AUDIT_ACTION_INIT = "init"
+const AUDIT_ACTION_RESTORE = "restore"
+const AUDIT_ACTION_IMPORT_PKI = "pki-import"
+const AUDIT_ACTION_EXPORT_PKI = "pki-export"
+
+// Where process-requests and process-revokes ship a copy of each audit event to
+type auditSettings struct {
+	S3Bucket string
+	LogGroup string
+}
+
+// Open the audit log, shipping each event to S3 and CloudWatch Logs if configured. Each process writes to a CloudWatch
+// Logs stream of its own, named after the host and the given command.
+func openAuditLog(awsRegion string, settings auditSettings, command string) *audit.Log {
+	auditLog := &audit.Log{Path: AUDIT_LOG_PATH}
+
+	if settings.S3Bucket != "" {
+		auditLog.Shippers = append(auditLog.Shippers, s3AuditShipper{AwsRegion: awsRegion, Bucket: settings.S3Bucket})
+	}
+
+	if settings.LogGroup != "" {
+		hostname, _ := os.Hostname()
+		stream := &aws_helpers.LogStream{AwsRegion: awsRegion, LogGroup: settings.LogGroup, Name: fmt.Sprintf("%s/%s", hostname, command)}
+		auditLog.Shippers = append(auditLog.Shippers, cloudWatchAuditShipper{Stream: stream})
+	}
+
+	return auditLog
+}
+
+// Write each event to its own object, named after its hash, so that events never overwrite each other. Nothing stops the
+// server from overwriting or deleting them later, unless the bucket has Object Lock and the server may only put objects.
+type s3AuditShipper struct {
+	AwsRegion string
+	Bucket    string
+}
+
+func (shipper s3AuditShipper) Ship(event *audit.Event, line []byte) error {
+	key := fmt.Sprintf("audit/%s/%s.json", event.Time.Format("2006/01/02"), event.Hash)
+	return aws_helpers.PutS3Object(shipper.AwsRegion, shipper.Bucket, key, line)
+}
+
+type cloudWatchAuditShipper struct {
+	Stream *aws_helpers.LogStream
+}
+
+func (shipper cloudWatchAuditShipper) Ship(event *audit.Event, line []byte) error {
+	return shipper.Stream.Put(event.Time, string(line))
+}
+
+// The operation has already happened by the time it's audited, so a failure to audit it is logged rather than returned
+func recordAuditEvent(auditLog *audit.Log, event *audit.Event) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if err := auditLog.Append(event); err != nil {
+		logger.Errorf("Failed to record %s of %s in the audit log: %s", event.Action, event.Target, err)
+	}
+}
+
+// Record a message from the request queue and its outcome. Returns the event, which is also used for metrics.
+func auditCertificateRequest(awsRegion string, auditLog *audit.Log, message *aws_helpers.QueueMessage, result error) *audit.Event {
+	request := CertificateRequest{}
+	json.Unmarshal([]byte(message.Body), &request)
+
+	event := newQueueAuditEvent(awsRegion, message, request.Username, result)
+	event.RequestId = request.RequestId
+
+	switch request.Action {
+	case NEW_CERTIFICATE_ACTION:
+		event.Action = AUDIT_ACTION_REQUEST
+	default:
+		event.Action = request.Action
+	}
+
+	if pending, ok := errors.Unwrap(result).(CertificateRequestPendingApproval); ok {
+		event.RequestId = pending.Id
+	}
+
+	if result == nil && request.Action != LIST_ACTION {
+		event.Serial = latestSerial(request.Username)
+	}
+
+	recordAuditEvent(auditLog, event)
+	return event
+}
+
+// Record a message from the revocation queue and its outcome. Returns the event, which is also used for metrics.
+func auditRevokeRequest(awsRegion string, auditLog *audit.Log, message *aws_helpers.QueueMessage, result error) *audit.Event {
+	revokeRequest := CertificateRevokeRequest{}
+	json.Unmarshal([]byte(message.Body), &revokeRequest)
+
+	event := newQueueAuditEvent(awsRegion, message, revokeRequest.Username, result)
+	event.Action = revokeRequest.Action
+	event.RequestId = revokeRequest.RequestId
+
+	switch revokeRequest.Action {
+	case REVOKE_ACTION, "":
+		event.Action = REVOKE_ACTION
+	case APPROVE_ACTION, DENY_ACTION:
+		// Admins only send the id of the certificate request they decide on
+		if approval, err := readApprovalRequest(revokeRequest.RequestId); err == nil {
+			event.Target = approval.Username
+		}
+	}
+
+	if result == nil && (event.Action == REVOKE_ACTION || event.Action == RELEASE_ACTION) {
+		event.Serial = latestSerial(revokeRequest.Username)
+	}
+
+	recordAuditEvent(auditLog, event)
+	return event
+}
+
+func newQueueAuditEvent(awsRegion string, message *aws_helpers.QueueMessage, target string, result error) *audit.Event {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	// Record who sent the message even if IAM can't tell us their name
+	requester, err := resolvePrincipal(awsRegion, message.SenderId)
+	if err != nil {
+		logger.Debugf("Failed to look up the sender %s of message %s: %s", message.SenderId, message.MessageId, err)
+	}
+
+	event := &audit.Event{
+		RequesterId: message.SenderId,
+		Requester:   requester.String(),
+		Target:      target,
+		MessageId:   message.MessageId,
+	}
+	event.Result, event.Message = auditResult(result)
+	return event
+}
+
+// Record an operation run by hand on the server, such as rotating the CA. These events are only written to the local
+// audit log.
+func auditLocalCommand(action string, target string, result error) {
+	recordAuditEvent(&audit.Log{Path: AUDIT_LOG_PATH}, newLocalAuditEvent(action, target, result))
+}
+
+func newLocalAuditEvent(action string, target string, result error) *audit.Event {
+	event := &audit.Event{
+		Action:      action,
+		RequesterId: "local",
+		Requester:   localUsername(),
+		Target:      target,
+	}
+	event.Result, event.Message = auditResult(result)
+	return event
+}
+
+// The user who ran sudo, if any, is more useful than root
+func localUsername() string {
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		return sudoUser
+	}
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return ""
+}
+
+// Work out the result of an operation, and the message to record with it, from the error it returned
+func auditResult(err error) (string, string) {
+	if err == nil {
+		return audit.RESULT_SUCCESS, ""
+	}
+
+	switch errors.Unwrap(err).(type) {
+	case PolicyDenied, CertificateRequestDenied:
+		return audit.RESULT_DENIED, err.Error()
+	case CertificateRequestPendingApproval:
+		return audit.RESULT_PENDING, err.Error()
+	default:
+		return audit.RESULT_FAILURE, err.Error()
+	}
+}
+
+// The serial of the most recent certificate issued to the user, which is the one an operation on the user's
+// certificate just issued, revoked or released
+func latestSerial(username string) string {
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return ""
+	}
+
+	entries := index.FindByCommonName(username, "")
+	if len(entries) == 0 {
+		return ""
+	}
+	return entries[len(entries)-1].Serial
+}
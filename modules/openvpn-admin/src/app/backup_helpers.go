@@ -0,0 +1,738 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/backup"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backup stores each backup as <version><suffix>, under this prefix in an S3 bucket unless the backup URL names
+// another. Versions are the UTC time the backup was taken, so they sort in the order they were taken.
+const BACKUP_ARCHIVE_PREFIX = "backups/"
+const BACKUP_ARCHIVE_SUFFIX = ".tar.gz.enc"
+const BACKUP_VERSION_LAYOUT = "20060102T150405Z"
+
+// The prefixes of the key wrapper descriptions in the headers of backups, which say what to unwrap the data key with
+const KEY_WRAPPER_KMS = "kms"
+const KEY_WRAPPER_AGE = "age"
+const KEY_WRAPPER_GPG = "gpg"
+
+// Where the key dir and the easy-rsa dir go in the archive
+const BACKUP_KEY_DIR = "openvpn"
+const BACKUP_EASY_RSA_DIR = "openvpn-ca"
+
+// Restoring a backup keeps each file of the PKI it replaces or removes under its name with this suffix until it's done
+const PRE_RESTORE_SUFFIX = ".pre-restore"
+
+// Where backups are kept, either an S3 bucket or a backup URL, and what encrypts them, either a KMS key, age recipients
+// or GPG recipients
+type backupSettings struct {
+	AwsRegion        string
+	S3BucketName     string
+	Url              string
+	S3Endpoint       string
+	SftpIdentityFile string
+	KmsKeyId         string
+	AgeRecipients    []string
+	AgeIdentityFile  string
+	GpgRecipients    []string
+}
+
+func (settings backupSettings) storage() (backup.Storage, error) {
+	if settings.S3BucketName != "" && settings.Url != "" {
+		return nil, errors.WithStackTrace(ConflictingBackupStorage{})
+	}
+	if settings.S3BucketName != "" {
+		return settings.s3Storage(settings.S3BucketName, BACKUP_ARCHIVE_PREFIX)
+	}
+	if settings.Url == "" {
+		return nil, errors.WithStackTrace(MissingBackupStorage{})
+	}
+
+	location, err := backup.ParseUrl(settings.Url)
+	if err != nil {
+		return nil, err
+	}
+	switch location.Scheme {
+	case backup.SCHEME_S3:
+		prefix := BACKUP_ARCHIVE_PREFIX
+		if location.Path != "" {
+			prefix = location.Path + "/"
+		}
+		return settings.s3Storage(location.Host, prefix)
+	case backup.SCHEME_SFTP:
+		return backup.SftpStorage{User: location.User, Host: location.Host, Port: location.Port, Dir: location.Path, IdentityFile: settings.SftpIdentityFile}, nil
+	default:
+		return backup.LocalStorage{Dir: location.Path}, nil
+	}
+}
+
+// S3 needs the region the bucket is in, but S3-compatible stores don't have regions
+func (settings backupSettings) s3Storage(bucket string, prefix string) (backup.Storage, error) {
+	if settings.AwsRegion == "" && settings.S3Endpoint == "" {
+		return nil, errors.WithStackTrace(MissingAwsRegion)
+	}
+	return aws_helpers.S3Storage{AwsRegion: settings.AwsRegion, Bucket: bucket, Prefix: prefix, Endpoint: settings.S3Endpoint}, nil
+}
+
+// What encrypts new backups. Exactly one way must be configured.
+func (settings backupSettings) keyWrapper() (backup.KeyWrapper, error) {
+	wrappers := []backup.KeyWrapper{}
+	if settings.KmsKeyId != "" {
+		wrappers = append(wrappers, aws_helpers.KmsKeyWrapper{AwsRegion: settings.AwsRegion, KeyId: settings.KmsKeyId})
+	}
+	if len(settings.AgeRecipients) > 0 {
+		wrappers = append(wrappers, backup.AgeKeyWrapper{Recipients: settings.AgeRecipients})
+	}
+	if len(settings.GpgRecipients) > 0 {
+		wrappers = append(wrappers, backup.GpgKeyWrapper{Recipients: settings.GpgRecipients})
+	}
+
+	switch {
+	case len(wrappers) == 0:
+		return nil, errors.WithStackTrace(MissingBackupKey{})
+	case len(wrappers) > 1:
+		return nil, errors.WithStackTrace(ConflictingBackupKeys{})
+	case settings.KmsKeyId != "" && settings.AwsRegion == "":
+		return nil, errors.WithStackTrace(MissingAwsRegion)
+	}
+	return wrappers[0], nil
+}
+
+// What unwraps the data key of a backup, going by what its header says wrapped it. That needn't be what encrypts new
+// backups, so that backups taken before switching keys can still be restored.
+func (settings backupSettings) unwrapperFor(header *backup.Header) (backup.KeyWrapper, error) {
+	switch strings.SplitN(header.KeyWrapper, ":", 2)[0] {
+	case KEY_WRAPPER_KMS:
+		if settings.AwsRegion == "" {
+			return nil, errors.WithStackTrace(MissingAwsRegion)
+		}
+		return aws_helpers.KmsKeyWrapper{AwsRegion: settings.AwsRegion}, nil
+	case KEY_WRAPPER_AGE:
+		if settings.AgeIdentityFile == "" {
+			return nil, errors.WithStackTrace(MissingAgeIdentityFile(header.KeyWrapper))
+		}
+		return backup.AgeKeyWrapper{IdentityFile: settings.AgeIdentityFile}, nil
+	case KEY_WRAPPER_GPG:
+		return backup.GpgKeyWrapper{}, nil
+	default:
+		return nil, errors.WithStackTrace(UnknownKeyWrapper(header.KeyWrapper))
+	}
+}
+
+func backupArchiveName(version string) string {
+	return version + BACKUP_ARCHIVE_SUFFIX
+}
+
+func describeBackup(storage backup.Storage, version string) string {
+	return fmt.Sprintf("%s in %s", backupArchiveName(version), storage.Description())
+}
+
+// Take a snapshot of the PKI, encrypt it and write it to the backup storage. Returns the version of the new backup.
+func createBackup(settings backupSettings) (string, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	now := time.Now().UTC()
+	version := now.Format(BACKUP_VERSION_LAYOUT)
+
+	storage, err := settings.storage()
+	if err != nil {
+		return "", err
+	}
+	keyWrapper, err := settings.keyWrapper()
+	if err != nil {
+		return "", err
+	}
+
+	snapshot, err := snapshotPki()
+	if err != nil {
+		return "", err
+	}
+
+	host, _ := os.Hostname()
+	archive, manifest, err := backup.Pack(version, now, host, snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := backup.Seal(keyWrapper, archive)
+	if err != nil {
+		return "", err
+	}
+
+	if err := storage.Put(backupArchiveName(version), sealed); err != nil {
+		return "", err
+	}
+
+	logger.Infof("Backed up %d files of the PKI to %s", len(manifest.Files), describeBackup(storage, version))
+	return version, nil
+}
+
+// Read the files to back up from the key dir and the easy-rsa dir. The PKI is locked while they're read, so that the
+// CA database, serial and CRL in the snapshot agree with each other.
+func snapshotPki() ([]backup.File, error) {
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	if !files.FileExists(pkiLayout.CaCertPath()) {
+		return nil, errors.WithStackTrace(NoPkiToBackUp(pkiLayout.KeyDir))
+	}
+
+	snapshot, err := snapshotDir(pkiLayout.KeyDir, BACKUP_KEY_DIR, isBackedUpPkiFile)
+	if err != nil {
+		return nil, err
+	}
+
+	varsSnapshot, err := snapshotDir(easyRsaDir, BACKUP_EASY_RSA_DIR, func(name string) bool { return name == EASY_RSA_VARS_FILE })
+	if err != nil {
+		return nil, err
+	}
+	return append(snapshot, varsSnapshot...), nil
+}
+
+// The files under dir that include picks, along with dir and the directories between it and them, under archiveDir
+func snapshotDir(dir string, archiveDir string, include func(name string) bool) ([]backup.File, error) {
+	dirs := []backup.File{}
+	regularFiles := []backup.File{}
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		name := path.Join(archiveDir, filepath.ToSlash(relPath))
+
+		switch {
+		case info.IsDir():
+			dirs = append(dirs, backup.File{Path: name, Mode: info.Mode()})
+		case info.Mode().IsRegular() && include(filepath.ToSlash(relPath)):
+			contents, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				return errors.WithStackTrace(err)
+			}
+			regularFiles = append(regularFiles, backup.File{Path: name, Mode: info.Mode(), Contents: contents})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave out the directories with nothing to back up in them, e.g. the ccd dir of an instance without client configs
+	snapshot := []backup.File{}
+	for _, candidate := range dirs {
+		for _, file := range regularFiles {
+			if candidate.Path == archiveDir || strings.HasPrefix(file.Path, candidate.Path+"/") {
+				snapshot = append(snapshot, candidate)
+				break
+			}
+		}
+	}
+	return append(snapshot, regularFiles...), nil
+}
+
+// The versions of the backups in the backup storage, oldest first
+func listBackupVersions(settings backupSettings) ([]string, error) {
+	storage, err := settings.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := storage.List()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []string{}
+	for _, name := range names {
+		version := strings.TrimSuffix(name, BACKUP_ARCHIVE_SUFFIX)
+		if _, err := time.Parse(BACKUP_VERSION_LAYOUT, version); err == nil && name == backupArchiveName(version) {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+func latestBackupVersion(settings backupSettings) (string, error) {
+	versions, err := listBackupVersions(settings)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		storage, err := settings.storage()
+		if err != nil {
+			return "", err
+		}
+		return "", errors.WithStackTrace(NoBackupsFound(storage.Description()))
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Fetch, decrypt and unpack a backup. The archive is checked against its manifest, and the manifest against the
+// version it was fetched as.
+func fetchBackup(settings backupSettings, version string) (*backup.Manifest, []backup.File, error) {
+	storage, err := settings.storage()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sealed, err := storage.Get(backupArchiveName(version))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, _, err := backup.ReadHeader(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	unwrapper, err := settings.unwrapperFor(header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	archive, _, err := backup.Open(unwrapper, sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest, backupFiles, err := backup.Unpack(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	if manifest.Version != version {
+		return nil, nil, errors.WithStackTrace(backup.MalformedArchive(fmt.Sprintf("%s holds version %s", describeBackup(storage, version), manifest.Version)))
+	}
+	return manifest, backupFiles, nil
+}
+
+// Restore the PKI from the given version of the backups. The backup is checked to be a working PKI before anything in
+// the key dir is replaced. Replacing a PKI that's already there takes force, as certificates issued since the backup
+// was taken would be forgotten, and so would everything else of the PKI the backup doesn't have.
+func restoreBackup(settings backupSettings, version string, force bool) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if files.FileExists(pkiLayout.CaCertPath()) && !force {
+		return errors.WithStackTrace(RestoreWouldReplacePki(pkiLayout.KeyDir))
+	}
+
+	manifest, backupFiles, err := fetchBackup(settings, version)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Restoring the PKI from version %s, which was taken on %s at %s", version, manifest.Host, manifest.Created.Format(time.RFC3339))
+
+	if err := verifyBackup(backupFiles); err != nil {
+		return err
+	}
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	restored, err := restoreFiles(backupFiles)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Restored %d files of the PKI. Restart OpenVPN for it to use them.", restored)
+	return nil
+}
+
+// Put the files of a backup in place of the PKI, and return how many were restored. Every file is written next to where
+// it goes before any is replaced, so that failing to write one, e.g. on a full disk, leaves the PKI as it was. Then the
+// files a backup would have but this one doesn't are removed, such as a newer certificate or the previous CA of a
+// rotation that finished after the backup was taken, so that nothing of the replaced PKI is left mixed in with it. Each
+// file that's replaced or removed is kept as <file>.pre-restore until all of them are done, so that if one fails, the
+// others are put back as they were.
+func restoreFiles(backupFiles []backup.File) (int, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	staged := map[string]string{}
+	targets := []string{}
+	kept := map[string]string{}
+	cleanUp := func() {
+		for _, tmpPath := range staged {
+			os.Remove(tmpPath)
+		}
+		for _, keptPath := range kept {
+			os.Remove(keptPath)
+		}
+	}
+
+	restored := map[string]bool{}
+	for _, file := range backupFiles {
+		target, ok := restoreTarget(file.Path)
+		if !ok {
+			logger.Warnf("Not restoring %s from the backup, as it isn't part of the PKI", file.Path)
+			continue
+		}
+		restored[target] = true
+
+		if file.Mode.IsDir() {
+			if err := restoreFile(target, file); err != nil {
+				cleanUp()
+				return 0, err
+			}
+			continue
+		}
+
+		tmpPath, err := stageFile(target, file)
+		if err != nil {
+			cleanUp()
+			return 0, err
+		}
+		staged[target] = tmpPath
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	stale, err := staleBackedUpFiles(restored)
+	if err != nil {
+		cleanUp()
+		return 0, err
+	}
+
+	for _, target := range targets {
+		keptPath, err := keepForRollback(target)
+		if err != nil {
+			cleanUp()
+			return 0, err
+		}
+		if keptPath != "" {
+			kept[target] = keptPath
+		}
+	}
+
+	// Put back what was replaced or removed so far, and return the files that couldn't be
+	replaced := []string{}
+	removed := []string{}
+	rollBack := func(cause error) error {
+		notRolledBack := []string{}
+		for _, target := range replaced {
+			var err error
+			if keptPath, ok := kept[target]; ok {
+				err = os.Rename(keptPath, target)
+			} else {
+				err = os.Remove(target)
+			}
+			if err != nil {
+				logger.Errorf("Failed to put %s back as it was: %s", target, err)
+				notRolledBack = append(notRolledBack, target)
+				delete(kept, target)
+			}
+		}
+		for _, stalePath := range removed {
+			if err := os.Rename(kept[stalePath], stalePath); err != nil {
+				logger.Errorf("Failed to put %s back: %s", stalePath, err)
+				notRolledBack = append(notRolledBack, stalePath)
+				delete(kept, stalePath)
+			}
+		}
+		cleanUp()
+		return errors.WithStackTrace(RestoreFailed{Replaced: append(replaced, removed...), NotRolledBack: notRolledBack, Cause: cause})
+	}
+
+	for _, target := range targets {
+		if err := os.Rename(staged[target], target); err != nil {
+			return 0, rollBack(err)
+		}
+		delete(staged, target)
+		replaced = append(replaced, target)
+	}
+	for _, stalePath := range stale {
+		logger.Infof("Removing %s, as it isn't in the backup", stalePath)
+		keptPath := stalePath + PRE_RESTORE_SUFFIX
+		if err := os.Rename(stalePath, keptPath); err != nil {
+			return 0, rollBack(err)
+		}
+		kept[stalePath] = keptPath
+		removed = append(removed, stalePath)
+	}
+
+	cleanUp()
+	return len(targets), nil
+}
+
+// Keep the file that's about to be replaced as <file>.pre-restore, with a hard link, or a copy where links aren't
+// supported. Returns the path it's kept at, or an empty string if there's no file to keep. Anything else in the way,
+// such as a directory, can't be replaced either, which the restore finds out before it changes anything else.
+func keepForRollback(target string) (string, error) {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	keptPath := target + PRE_RESTORE_SUFFIX
+	if err := os.Remove(keptPath); err != nil && !os.IsNotExist(err) {
+		return "", errors.WithStackTrace(err)
+	}
+	if err := os.Link(target, keptPath); err == nil {
+		return keptPath, nil
+	}
+
+	contents, err := ioutil.ReadFile(target)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	if err := ioutil.WriteFile(keptPath, contents, info.Mode().Perm()); err != nil {
+		os.Remove(keptPath)
+		return "", errors.WithStackTrace(err)
+	}
+	return keptPath, nil
+}
+
+// The files in the key dir that a backup would have, but that aren't among the given restored ones. The audit log is
+// kept, as it records what happened to the PKI, including this restore.
+func staleBackedUpFiles(restored map[string]bool) ([]string, error) {
+	stale := []string{}
+	err := filepath.Walk(pkiLayout.KeyDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+
+		relPath, err := filepath.Rel(pkiLayout.KeyDir, filePath)
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		name := filepath.ToSlash(relPath)
+
+		if info.Mode().IsRegular() && isBackedUpPkiFile(name) && name != path.Base(AUDIT_LOG_PATH) && !restored[filePath] {
+			stale = append(stale, filePath)
+		}
+		return nil
+	})
+	return stale, err
+}
+
+// Where a file in a backup goes, or false if it's not one restore writes
+func restoreTarget(name string) (string, bool) {
+	switch {
+	case name == BACKUP_KEY_DIR:
+		return pkiLayout.KeyDir, true
+	case name == BACKUP_EASY_RSA_DIR:
+		return easyRsaDir, true
+	case name == path.Join(BACKUP_EASY_RSA_DIR, EASY_RSA_VARS_FILE):
+		return filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE), true
+	case strings.HasPrefix(name, BACKUP_KEY_DIR+"/"):
+		relPath := strings.TrimPrefix(name, BACKUP_KEY_DIR+"/")
+		if isBackedUpPkiFile(relPath) || isBackedUpPkiDir(relPath) {
+			return filepath.Join(pkiLayout.KeyDir, filepath.FromSlash(relPath)), true
+		}
+	}
+	return "", false
+}
+
+// The directories files isBackedUpPkiFile picks can be in
+func isBackedUpPkiDir(name string) bool {
+	switch {
+	case name == path.Base(APPROVALS_PATH), name == "tls-crypt-v2", name == "ccd":
+		return true
+	case strings.HasPrefix(name, "ccd/"):
+		return !strings.Contains(strings.TrimPrefix(name, "ccd/"), "/")
+	}
+	return false
+}
+
+// Write the file through a temp file in the same directory, so that OpenVPN and the other commands never see it half
+// written
+func restoreFile(target string, file backup.File) error {
+	if file.Mode.IsDir() {
+		if err := os.MkdirAll(target, file.Mode.Perm()); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		return errors.WithStackTrace(os.Chmod(target, file.Mode.Perm()))
+	}
+
+	tmpPath, err := stageFile(target, file)
+	if err != nil {
+		return err
+	}
+	return errors.WithStackTrace(os.Rename(tmpPath, target))
+}
+
+// Write the file to a temp file next to the target, to be renamed over it. Returns the path of the temp file.
+func stageFile(target string, file backup.File) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	tmpPath := target + ".restore"
+	if err := ioutil.WriteFile(tmpPath, file.Contents, file.Mode.Perm()); err != nil {
+		os.Remove(tmpPath)
+		return "", errors.WithStackTrace(err)
+	}
+	if err := os.Chmod(tmpPath, file.Mode.Perm()); err != nil {
+		os.Remove(tmpPath)
+		return "", errors.WithStackTrace(err)
+	}
+	return tmpPath, nil
+}
+
+// Check that the backup holds a working PKI: the CA key, or the PKCS#11 token or KMS key its vars.local names, must
+// match ca.crt, and the CA database, serial and CRL must be readable. The files are written to a temp dir next to the
+// key dir to be checked, as the PKI reads them from files.
+func verifyBackup(backupFiles []backup.File) error {
+	stagingDir, err := ioutil.TempDir(filepath.Dir(pkiLayout.KeyDir), ".openvpn-restore-")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	for _, file := range backupFiles {
+		if err := restoreFile(filepath.Join(stagingDir, filepath.FromSlash(file.Path)), file); err != nil {
+			return err
+		}
+	}
+
+	staged := pki.Layout{KeyDir: filepath.Join(stagingDir, BACKUP_KEY_DIR)}
+	varsPath := filepath.Join(stagingDir, BACKUP_EASY_RSA_DIR, EASY_RSA_VARS_FILE)
+
+	if !files.FileExists(staged.CaCertPath()) {
+		return errors.WithStackTrace(BackupVerificationFailed{File: "ca.crt", Cause: fmt.Errorf("the backup has no CA certificate")})
+	}
+	if err := verifyBackedUpCa(staged.CaCertPath(), staged.CaKeyPath(), varsPath); err != nil {
+		return errors.WithStackTrace(BackupVerificationFailed{File: "ca.crt", Cause: errors.Unwrap(err)})
+	}
+
+	// During a CA rotation, the previous CA still signs the CRL for the certificates it issued
+	if files.FileExists(staged.PreviousCaCertPath()) && files.FileExists(staged.PreviousCaKeyPath()) {
+		if _, err := pki.LoadCertificateAuthority(staged.PreviousCaCertPath(), staged.PreviousCaKeyPath()); err != nil {
+			return errors.WithStackTrace(BackupVerificationFailed{File: "ca-previous.crt", Cause: errors.Unwrap(err)})
+		}
+	}
+
+	if _, err := pki.ReadIndex(staged.IndexPath()); err != nil {
+		return errors.WithStackTrace(BackupVerificationFailed{File: "index.txt", Cause: errors.Unwrap(err)})
+	}
+	if _, err := pki.ReadSerial(staged.SerialPath()); err != nil {
+		return errors.WithStackTrace(BackupVerificationFailed{File: "serial", Cause: errors.Unwrap(err)})
+	}
+	if files.FileExists(staged.CrlPath()) {
+		if _, err := pki.ReadCrl(staged.CrlPath()); err != nil {
+			return errors.WithStackTrace(BackupVerificationFailed{File: "crl.pem", Cause: errors.Unwrap(err)})
+		}
+	}
+	return nil
+}
+
+// The CA key is in the backup unless vars.local says it's in a PKCS#11 token or KMS, in which case it must still be
+// reachable from this server
+func verifyBackedUpCa(certPath string, keyPath string, varsPath string) error {
+	if files.FileExists(varsPath) {
+		vars, err := pki.ReadEasyRsaVars(varsPath)
+		if err != nil {
+			return err
+		}
+		signer, err := findExternalCaSigner(vars)
+		if err != nil {
+			return err
+		}
+		if signer != nil {
+			certificate, err := pki.ReadCertificate(certPath)
+			if err != nil {
+				return err
+			}
+			_, err = pki.NewCertificateAuthority(certificate, signer)
+			return err
+		}
+	}
+
+	_, err := pki.LoadCertificateAuthority(certPath, keyPath)
+	return err
+}
+
+// Custom errors
+
+type RestoreFailed struct {
+	Replaced      []string
+	NotRolledBack []string
+	Cause         error
+}
+
+func (err RestoreFailed) Error() string {
+	message := fmt.Sprintf("Failed to restore the PKI: %s. The files replaced or removed before that were [%s].", err.Cause, strings.Join(err.Replaced, ", "))
+	if len(err.NotRolledBack) == 0 {
+		return message + " All of them were put back as they were."
+	}
+	return fmt.Sprintf("%s These couldn't be put back, and hold the backup's version or are missing: [%s]. Any previous version of them is next to them, with the suffix %s.", message, strings.Join(err.NotRolledBack, ", "), PRE_RESTORE_SUFFIX)
+}
+
+type NoPkiToBackUp string
+
+func (keyDir NoPkiToBackUp) Error() string {
+	return fmt.Sprintf("There is no PKI in %s to back up.", string(keyDir))
+}
+
+type NoBackupsFound string
+
+func (location NoBackupsFound) Error() string {
+	return fmt.Sprintf("There are no backups in %s.", string(location))
+}
+
+type MissingBackupStorage struct{}
+
+func (err MissingBackupStorage) Error() string {
+	return fmt.Sprintf("Say where backups are kept with --%s or --%s.", OPTION_S3_BUCKET_NAME, OPTION_BACKUP_URL)
+}
+
+type ConflictingBackupStorage struct{}
+
+func (err ConflictingBackupStorage) Error() string {
+	return fmt.Sprintf("Only one of --%s and --%s may be set.", OPTION_S3_BUCKET_NAME, OPTION_BACKUP_URL)
+}
+
+type MissingBackupKey struct{}
+
+func (err MissingBackupKey) Error() string {
+	return fmt.Sprintf("Say what to encrypt backups with: --%s, --%s or --%s.", OPTION_KMS_KEY_ID, OPTION_AGE_RECIPIENT, OPTION_GPG_RECIPIENT)
+}
+
+type ConflictingBackupKeys struct{}
+
+func (err ConflictingBackupKeys) Error() string {
+	return fmt.Sprintf("Only one of --%s, --%s and --%s may be set.", OPTION_KMS_KEY_ID, OPTION_AGE_RECIPIENT, OPTION_GPG_RECIPIENT)
+}
+
+type MissingAgeIdentityFile string
+
+func (keyWrapper MissingAgeIdentityFile) Error() string {
+	return fmt.Sprintf("The backup was encrypted for %s. Pass the age identity file to decrypt it with in --%s.", string(keyWrapper), OPTION_AGE_IDENTITY_FILE)
+}
+
+type UnknownKeyWrapper string
+
+func (keyWrapper UnknownKeyWrapper) Error() string {
+	return fmt.Sprintf("The backup was encrypted with '%s', which this version of openvpn-admin can't decrypt.", string(keyWrapper))
+}
+
+type RestoreWouldReplacePki string
+
+func (keyDir RestoreWouldReplacePki) Error() string {
+	return fmt.Sprintf("There is already a PKI in %s. Certificates it issued since the backup was taken would be forgotten. Pass --%s to restore over it anyway.", string(keyDir), OPTION_FORCE)
+}
+
+type BackupVerificationFailed struct {
+	File  string
+	Cause error
+}
+
+func (err BackupVerificationFailed) Error() string {
+	return fmt.Sprintf("Not restoring the backup, as its %s doesn't check out: %s", err.File, err.Cause)
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/backup"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRestoreFilesRemovesFilesOfThePkiNotInTheBackup(t *testing.T) {
+	useTestPki(t)
+
+	writeTestFile(t, pkiLayout.CaCertPath(), "current CA")
+	writeTestFile(t, pkiLayout.PreviousCaCertPath(), "previous CA")
+	writeTestFile(t, pkiLayout.CaRotationPath(), "{}")
+	writeTestFile(t, pkiLayout.IssuedCertPath("05"), "newer certificate")
+	if err := os.MkdirAll(filepath.Dir(pkiLayout.TlsCryptV2ClientKeyPath("bob")), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, pkiLayout.TlsCryptV2ClientKeyPath("bob"), "bob's key")
+	writeTestFile(t, filepath.Join(pkiLayout.KeyDir, "audit.log"), "events since the backup")
+	writeTestFile(t, filepath.Join(pkiLayout.KeyDir, "server.conf"), "port 1194")
+
+	restored, err := restoreFiles([]backup.File{
+		{Path: BACKUP_KEY_DIR, Mode: os.ModeDir | 0700},
+		{Path: BACKUP_KEY_DIR + "/ca.crt", Mode: 0644, Contents: []byte("backed up CA")},
+		{Path: BACKUP_KEY_DIR + "/index.txt", Mode: 0644, Contents: []byte("")},
+		{Path: BACKUP_KEY_DIR + "/serial", Mode: 0644, Contents: []byte("05\n")},
+		{Path: BACKUP_KEY_DIR + "/tls-crypt-v2", Mode: os.ModeDir | 0700},
+		{Path: BACKUP_KEY_DIR + "/tls-crypt-v2/alice.key", Mode: 0600, Contents: []byte("alice's key")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 4 {
+		t.Errorf("expected 4 files to be restored, got %d", restored)
+	}
+
+	if contents := readTestFile(t, pkiLayout.CaCertPath()); contents != "backed up CA" {
+		t.Errorf("expected ca.crt to be restored, got %q", contents)
+	}
+	if contents := readTestFile(t, pkiLayout.TlsCryptV2ClientKeyPath("alice")); contents != "alice's key" {
+		t.Errorf("expected alice's key to be restored, got %q", contents)
+	}
+
+	for _, stale := range []string{pkiLayout.PreviousCaCertPath(), pkiLayout.CaRotationPath(), pkiLayout.IssuedCertPath("05"), pkiLayout.TlsCryptV2ClientKeyPath("bob")} {
+		if files.FileExists(stale) {
+			t.Errorf("expected %s to be removed, as it isn't in the backup", stale)
+		}
+	}
+	for _, kept := range []string{"audit.log", "server.conf"} {
+		if !files.FileExists(filepath.Join(pkiLayout.KeyDir, kept)) {
+			t.Errorf("expected %s to be kept", kept)
+		}
+	}
+}
+
+func TestRestoreFilesLeavesThePkiAsItWasIfAFileCantBeWritten(t *testing.T) {
+	useTestPki(t)
+
+	writeTestFile(t, pkiLayout.CaCertPath(), "current CA")
+	writeTestFile(t, pkiLayout.PreviousCaCertPath(), "previous CA")
+	// The backup has a client config dir where there's a file, so the client config can't be written
+	writeTestFile(t, filepath.Join(pkiLayout.KeyDir, "ccd"), "")
+
+	_, err := restoreFiles([]backup.File{
+		{Path: BACKUP_KEY_DIR + "/ca.crt", Mode: 0644, Contents: []byte("backed up CA")},
+		{Path: BACKUP_KEY_DIR + "/ccd/server/alice", Mode: 0644, Contents: []byte("ifconfig-push 172.16.0.10 255.255.255.0")},
+	})
+	if err == nil {
+		t.Fatal("expected the restore to fail")
+	}
+
+	if contents := readTestFile(t, pkiLayout.CaCertPath()); contents != "current CA" {
+		t.Errorf("expected ca.crt to be left alone, got %q", contents)
+	}
+	if !files.FileExists(pkiLayout.PreviousCaCertPath()) {
+		t.Errorf("expected ca-previous.crt to be left alone")
+	}
+	if files.FileExists(pkiLayout.CaCertPath() + ".restore") {
+		t.Errorf("expected the staged ca.crt to be removed")
+	}
+}
+
+func TestRestoreFilesRollsBackIfAFileCantBeReplaced(t *testing.T) {
+	useTestPki(t)
+
+	writeTestFile(t, pkiLayout.CaCertPath(), "current CA")
+	writeTestFile(t, pkiLayout.IndexPath(), "current database")
+	writeTestFile(t, pkiLayout.PreviousCaCertPath(), "previous CA")
+	// The serial file is a directory, so the backup's serial can be written next to it but can't replace it. That's
+	// after ca.crt and index.txt have been replaced.
+	if err := os.Remove(pkiLayout.SerialPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(pkiLayout.SerialPath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := restoreFiles([]backup.File{
+		{Path: BACKUP_KEY_DIR + "/ca.crt", Mode: 0644, Contents: []byte("backed up CA")},
+		{Path: BACKUP_KEY_DIR + "/dh.pem", Mode: 0644, Contents: []byte("backed up DH parameters")},
+		{Path: BACKUP_KEY_DIR + "/index.txt", Mode: 0644, Contents: []byte("backed up database")},
+		{Path: BACKUP_KEY_DIR + "/serial", Mode: 0644, Contents: []byte("05\n")},
+	})
+	restoreFailed, ok := errors.Unwrap(err).(RestoreFailed)
+	if !ok {
+		t.Fatalf("expected RestoreFailed but got %v", err)
+	}
+	expected := []string{pkiLayout.CaCertPath(), filepath.Join(pkiLayout.KeyDir, "dh.pem"), pkiLayout.IndexPath()}
+	if !reflect.DeepEqual(restoreFailed.Replaced, expected) || len(restoreFailed.NotRolledBack) != 0 {
+		t.Errorf("expected %v to be replaced and rolled back but got %+v", expected, restoreFailed)
+	}
+
+	if contents := readTestFile(t, pkiLayout.CaCertPath()); contents != "current CA" {
+		t.Errorf("expected ca.crt to be put back, got %q", contents)
+	}
+	if contents := readTestFile(t, pkiLayout.IndexPath()); contents != "current database" {
+		t.Errorf("expected index.txt to be put back, got %q", contents)
+	}
+	if files.FileExists(filepath.Join(pkiLayout.KeyDir, "dh.pem")) {
+		t.Error("expected dh.pem, which the PKI didn't have, to be removed again")
+	}
+	if !files.FileExists(pkiLayout.PreviousCaCertPath()) {
+		t.Error("expected ca-previous.crt to be left alone")
+	}
+
+	entries, err := ioutil.ReadDir(pkiLayout.KeyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".restore") || strings.HasSuffix(entry.Name(), PRE_RESTORE_SUFFIX) {
+			t.Errorf("expected %s to be cleaned up", entry.Name())
+		}
+	}
+}
+
+func TestRestoreFilesCleansUpAfterSuccess(t *testing.T) {
+	useTestPki(t)
+	writeTestFile(t, pkiLayout.CaCertPath(), "current CA")
+
+	if _, err := restoreFiles([]backup.File{{Path: BACKUP_KEY_DIR + "/ca.crt", Mode: 0644, Contents: []byte("backed up CA")}}); err != nil {
+		t.Fatal(err)
+	}
+	if files.FileExists(pkiLayout.CaCertPath() + PRE_RESTORE_SUFFIX) {
+		t.Error("expected the previous ca.crt to be removed once the restore was done")
+	}
+}
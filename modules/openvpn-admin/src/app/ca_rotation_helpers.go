@@ -0,0 +1,384 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// caMigrationStatus lists the users with a valid certificate, split by whether they have a certificate from the new CA
+// yet. The server and OCSP responder certificates are left out, as they aren't users.
+type caMigrationStatus struct {
+	Migrated []string
+	Pending  []string
+}
+
+// Publish the CRL for the current CA, plus one for the previous CA while a CA rotation is in progress
+func publishCrl(ca *pki.CertificateAuthority, index *pki.Index, crlValidity time.Duration) error {
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil {
+		return err
+	}
+
+	if rotation == nil {
+		return pki.UpdateCrl(pkiLayout, ca, index, crlValidity)
+	}
+
+	previousCa, err := pki.LoadCertificateAuthority(pkiLayout.PreviousCaCertPath(), pkiLayout.PreviousCaKeyPath())
+	if err != nil {
+		return err
+	}
+
+	previousIndex, currentIndex, err := rotation.SplitIndex(index)
+	if err != nil {
+		return err
+	}
+
+	scopes := []pki.CrlScope{
+		{CA: ca, Index: currentIndex},
+		{CA: previousCa, Index: previousIndex},
+	}
+	return pki.UpdateCrls(pkiLayout, scopes, crlValidity)
+}
+
+// Start a CA rotation: keep the current CA as the previous CA, create a new CA to issue all certificates from now on,
+// and publish a ca.crt that trusts both, so that existing client profiles keep working while users migrate
+func startCaRotation(crlValidity time.Duration) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil {
+		return err
+	}
+	if rotation != nil {
+		return errors.WithStackTrace(CaRotationInProgress(rotation.StartedAt))
+	}
+
+	vars, err := readEasyRsaVars()
+	if err != nil {
+		return err
+	}
+
+	externalSigner, err := findExternalCaSigner(vars)
+	if err != nil {
+		return err
+	}
+	if externalSigner != nil {
+		return errors.WithStackTrace(CaRotationRequiresCaKeyFile{})
+	}
+
+	previousCa, err := pki.LoadCertificateAuthority(pkiLayout.CaCertPath(), pkiLayout.CaKeyPath())
+	if err != nil {
+		return err
+	}
+
+	spec, err := vars.KeySpec()
+	if err != nil {
+		return err
+	}
+
+	validity, err := vars.CaValidity()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	logger.Infof("Generating a new %s CA key", spec)
+	key, err := spec.Generate()
+	if err != nil {
+		return err
+	}
+
+	// The new CA gets a different name so that OpenSSL never confuses the two when building chains
+	commonName := fmt.Sprintf("%s CA %s", vars["KEY_ORG"], now.UTC().Format("2006-01-02"))
+	certificate, err := pki.SelfSign(pki.CaTemplate(vars.Subject(commonName, vars["KEY_NAME"]), validity), key)
+	if err != nil {
+		return err
+	}
+
+	rotation, err = pki.NewCaRotation(pkiLayout, now)
+	if err != nil {
+		return err
+	}
+
+	// Save the previous CA before replacing it, so that a failure part way through never loses it
+	if err := pki.WriteCertificateAndKey(pkiLayout.PreviousCaCertPath(), pkiLayout.PreviousCaKeyPath(), previousCa.Certificate, previousCa.Signer); err != nil {
+		return err
+	}
+	if err := pki.WriteCertificateAndKey(pkiLayout.CaCertPath(), pkiLayout.CaKeyPath(), certificate, key); err != nil {
+		return err
+	}
+	if err := rotation.Write(pkiLayout); err != nil {
+		return err
+	}
+
+	// The new CA comes first, as everything that reads ca.crt for signing uses the first certificate in it
+	if err := pki.WriteCertificate(pkiLayout.CaCertPath(), certificate, previousCa.Certificate); err != nil {
+		return err
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+
+	ca, err := pki.NewCertificateAuthority(certificate, key)
+	if err != nil {
+		return err
+	}
+
+	return publishCrl(ca, index, crlValidity)
+}
+
+// Work out which users already have a certificate from the new CA
+func getCaMigrationStatus(rotation *pki.CaRotation, index *pki.Index) (*caMigrationStatus, error) {
+	migrated := map[string]bool{}
+
+	for _, entry := range index.Entries {
+		commonName := entry.CommonName()
+		if entry.Status != pki.STATUS_VALID || commonName == SERVER_COMMON_NAME || commonName == pki.OCSP_RESPONDER_COMMON_NAME {
+			continue
+		}
+
+		fromPrevious, err := rotation.IssuedByPreviousCa(entry)
+		if err != nil {
+			return nil, err
+		}
+		migrated[commonName] = migrated[commonName] || !fromPrevious
+	}
+
+	status := &caMigrationStatus{}
+	for commonName, hasNewCertificate := range migrated {
+		if hasNewCertificate {
+			status.Migrated = append(status.Migrated, commonName)
+		} else {
+			status.Pending = append(status.Pending, commonName)
+		}
+	}
+	sort.Strings(status.Migrated)
+	sort.Strings(status.Pending)
+
+	return status, nil
+}
+
+// During a CA rotation, a user whose valid certificates all come from the previous CA may request a certificate from
+// the new CA even though they already have a valid certificate
+func needsCaMigration(username string) (bool, error) {
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil || rotation == nil {
+		return false, err
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return false, err
+	}
+
+	entries := index.FindByCommonName(username, pki.STATUS_VALID)
+	for _, entry := range entries {
+		fromPrevious, err := rotation.IssuedByPreviousCa(entry)
+		if err != nil {
+			return false, err
+		}
+		if !fromPrevious {
+			return false, nil
+		}
+	}
+
+	return len(entries) > 0, nil
+}
+
+// Once a user has a certificate from the new CA, revoke the ones they had from the previous CA as superseded
+func supersedePreviousCaCertificates(username string, crlValidity time.Duration) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil || rotation == nil {
+		return err
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, entry := range index.FindByCommonName(username, pki.STATUS_VALID) {
+		fromPrevious, err := rotation.IssuedByPreviousCa(entry)
+		if err != nil {
+			return err
+		}
+		if !fromPrevious {
+			continue
+		}
+
+		logger.Infof("Revoking certificate %s for %s, which was issued by the previous CA", entry.Serial, username)
+		if err := entry.Revoke(now, pki.REASON_SUPERSEDED); err != nil {
+			return err
+		}
+	}
+
+	return writeIndexAndCrl(index, crlValidity)
+}
+
+// Finish a CA rotation: reissue the server certificate from the new CA, revoke whatever the previous CA issued that is
+// still valid and stop trusting the previous CA. Unless force is set, this refuses to run while users still only have
+// certificates from the previous CA, as they would lose access.
+func retirePreviousCa(force bool, crlValidity time.Duration) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	pkiLock.Lock()
+	_, _, err := readRetirableCaRotation(force)
+	pkiLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// The server and OCSP responder certificates come from the previous CA too, so replace them before revoking it all.
+	// Otherwise the published CRL would revoke the certificates they're still using.
+	if err := buildServerCertificate(); err != nil {
+		return err
+	}
+	if err := reissueOcspResponderCertIfNeeded(); err != nil {
+		return err
+	}
+
+	rotation, err := revokePreviousCaCertificates(force, crlValidity)
+	if err != nil {
+		return err
+	}
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		return err
+	}
+
+	// Keep the retired CA around, named after the day the rotation started, in case it's ever needed for forensics
+	suffix := rotation.StartedAt.Format("20060102")
+	retiredCertPath := strings.Replace(pkiLayout.PreviousCaCertPath(), "ca-previous", "ca-retired-"+suffix, 1)
+	retiredKeyPath := strings.Replace(pkiLayout.PreviousCaKeyPath(), "ca-previous", "ca-retired-"+suffix, 1)
+	if err := os.Rename(pkiLayout.PreviousCaCertPath(), retiredCertPath); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := os.Rename(pkiLayout.PreviousCaKeyPath(), retiredKeyPath); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	logger.Infof("Moved the previous CA to %s and %s", retiredCertPath, retiredKeyPath)
+
+	if err := pki.WriteCertificate(pkiLayout.CaCertPath(), ca.Certificate); err != nil {
+		return err
+	}
+	if err := os.Remove(pkiLayout.CaRotationPath()); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+
+	return publishCrl(ca, index, crlValidity)
+}
+
+// Read the CA rotation in progress along with the CA database, making sure the previous CA may be retired. The caller
+// must hold the PKI lock.
+func readRetirableCaRotation(force bool) (*pki.CaRotation, *pki.Index, error) {
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rotation == nil {
+		return nil, nil, errors.WithStackTrace(NoCaRotationInProgress{})
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status, err := getCaMigrationStatus(rotation, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(status.Pending) > 0 && !force {
+		return nil, nil, errors.WithStackTrace(UsersPendingCaMigration(status.Pending))
+	}
+
+	return rotation, index, nil
+}
+
+func revokePreviousCaCertificates(force bool, crlValidity time.Duration) (*pki.CaRotation, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	// Check again, as a user may have been revoked while the server certificate was being issued
+	rotation, index, err := readRetirableCaRotation(force)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, entry := range index.Entries {
+		if entry.Status != pki.STATUS_VALID {
+			continue
+		}
+
+		fromPrevious, err := rotation.IssuedByPreviousCa(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !fromPrevious {
+			continue
+		}
+
+		logger.Infof("Revoking certificate %s for %s, which was issued by the retired CA", entry.Serial, entry.CommonName())
+		if err := entry.Revoke(now, pki.REASON_CESSATION_OF_OPERATION); err != nil {
+			return nil, err
+		}
+	}
+
+	return rotation, writeIndexAndCrl(index, crlValidity)
+}
+
+// Custom errors
+
+type CaRotationInProgress time.Time
+
+func (err CaRotationInProgress) Error() string {
+	return fmt.Sprintf("A CA rotation started at %s is already in progress. Retire the previous CA with 'openvpn-admin ca retire' first.", time.Time(err).Format(time.RFC3339))
+}
+
+type NoCaRotationInProgress struct{}
+
+func (err NoCaRotationInProgress) Error() string {
+	return "There is no CA rotation in progress. Start one with 'openvpn-admin ca rotate'."
+}
+
+type CaRotationRequiresCaKeyFile struct{}
+
+func (err CaRotationRequiresCaKeyFile) Error() string {
+	return "CA rotation is only supported for CAs whose key is in ca.key, not in a PKCS#11 token or KMS"
+}
+
+type UsersPendingCaMigration []string
+
+func (err UsersPendingCaMigration) Error() string {
+	return fmt.Sprintf("These users only have certificates from the previous CA and would lose access: %s. Ask them to request a new certificate, or use --force.", strings.Join(err, ", "))
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"testing"
+	"time"
+)
+
+func TestRetirePreviousCaKeepsServerAndResponderCertificatesValid(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+	if err := buildServerCertificate(); err != nil {
+		t.Fatal(err)
+	}
+	previousCa, err := loadCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := issueOcspResponderCert(previousCa); err != nil {
+		t.Fatal(err)
+	}
+	previousServerCert, err := pki.ReadCertificate(pkiLayout.CertPath(SERVER_COMMON_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := startCaRotation(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := retirePreviousCa(false, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{SERVER_COMMON_NAME, OCSP_RESPONDER_NAME} {
+		certificate, err := pki.ReadCertificate(pkiLayout.CertPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := certificate.CheckSignatureFrom(ca.Certificate); err != nil {
+			t.Errorf("expected %s to be issued by the new CA: %s", name, err)
+		}
+		if entry := index.FindBySerial(certificate.SerialNumber); entry == nil || entry.Status != pki.STATUS_VALID {
+			t.Errorf("expected %s to be valid in the CA database, got %v", name, entry)
+		}
+	}
+
+	if entry := index.FindBySerial(previousServerCert.SerialNumber); entry == nil || entry.Status != pki.STATUS_REVOKED {
+		t.Errorf("expected the previous server certificate to be revoked, got %v", entry)
+	}
+
+	crl, err := pki.ReadCrl(pkiLayout.CrlPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Certificate); err != nil {
+		t.Errorf("expected the CRL to be signed by the new CA: %s", err)
+	}
+}
+
+func TestRetirePreviousCaRefusesWhileUsersArePending(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issueClientCertificate("alice", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := startCaRotation(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	err := retirePreviousCa(false, time.Hour)
+	if _, ok := errors.Unwrap(err).(UsersPendingCaMigration); !ok {
+		t.Fatalf("expected UsersPendingCaMigration, got %v", err)
+	}
+	if files.FileExists(pkiLayout.CertPath(SERVER_COMMON_NAME)) {
+		t.Errorf("expected nothing to be issued when the retirement is refused")
+	}
+}
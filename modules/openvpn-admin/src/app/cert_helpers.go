@@ -4,27 +4,46 @@ import (
 	"fmt"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
 	"github.com/gruntwork-io/gruntwork-cli/files"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
-	"os/exec"
-	"regexp"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
 	"strings"
+	"time"
 )
 
+const OPENVPN_PATH = "/etc/openvpn"
+const CA_PATH = "/etc/openvpn-ca"
+
+var pkiLayout = pki.Layout{KeyDir: OPENVPN_PATH}
+
+// The easy-rsa dir holding vars.local. `openvpn-admin init --root` points this and pkiLayout at an alternate root.
+var easyRsaDir = CA_PATH
+
 type certificatePartData struct {
 	IpAddress       string
 	CaCertificate   string
 	UserCertificate string
 	UserKey         string
+	TlsCryptV2Key   string
 	Error           error
 }
 
-func generateCertificate(username string) (string, error) {
-	command := exec.Command("./generate-wrapper.sh", username)
-	command.Dir = "/etc/openvpn-ca"
+// A ttl of 0 issues the certificate with the validity configured for the PKI
+func generateCertificate(awsRegion string, username string, ttl time.Duration) (string, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
 
-	_, err := command.CombinedOutput()
+	accessGroups, err := accessGroupsForUser(awsRegion, username)
 	if err != nil {
-		return "", errors.WithStackTrace(err)
+		return "", err
+	}
+
+	certificate, err := issueClientCertificate(username, ttl, accessGroups)
+	if err != nil {
+		return "", err
+	}
+	logger.Debugf("Issued certificate %s for %s", certificate.SerialNumber.Text(16), username)
+
+	if err := writeTlsCryptV2ClientKey(username, certificate.SerialNumber); err != nil {
+		return "", err
 	}
 
 	content, err := generateCertificateTemplate(username)
@@ -50,6 +69,7 @@ func generateCertificateTemplate(username string) (string, error) {
 	template = strings.Replace(template, "__CA_CERTIFICATE__", data.CaCertificate, -1)
 	template = strings.Replace(template, "__CLIENT_CERTIFICATE__", data.UserCertificate, -1)
 	template = strings.Replace(template, "__CLIENT_KEY__", data.UserKey, -1)
+	template = strings.Replace(template, "__TLS_CRYPT_V2_KEY__\n", data.TlsCryptV2Key, -1)
 
 	return template, nil
 }
@@ -75,36 +95,77 @@ func getCertificatePartData(username string) certificatePartData {
 		return certificatePartData{Error: err}
 	}
 
+	tlsCryptV2Key, err := readTlsCryptV2ClientKeyBlock(username)
+	if err != nil {
+		return certificatePartData{Error: err}
+	}
+
 	return certificatePartData{
 		IpAddress:       ipAddress,
 		CaCertificate:   caCert,
 		UserCertificate: userCert,
 		UserKey:         userKey,
+		TlsCryptV2Key:   tlsCryptV2Key,
 	}
 }
 
-func revokeCertificate(username string) error {
+func revokeCertificate(username string, reason string, crlValidity time.Duration) error {
 	logger := logging.GetLogger(LOGGER_NAME)
-	logger.Debugf("Running revoke script ./revoke-wrapper.sh %s", username)
-	command := exec.Command("./revoke-wrapper.sh", username)
-	command.Dir = "/etc/openvpn-ca"
 
-	output, err := command.CombinedOutput()
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
 	if err != nil {
-		return errors.WithStackTrace(err)
+		return err
+	}
+
+	entries := index.FindByCommonName(username, pki.STATUS_VALID)
+	if len(entries) == 0 {
+		return errors.WithStackTrace(fmt.Errorf("a valid certificate for %s does not exist", username))
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		logger.Debugf("Revoking certificate %s for %s (reason: '%s')", entry.Serial, username, reason)
+		if err := entry.Revoke(now, reason); err != nil {
+			return err
+		}
 	}
 
-	//fmt.Printf("%s\n", output)
-	match, err := regexp.MatchString("Already revoked", string(output))
+	return writeIndexAndCrl(index, crlValidity)
+}
+
+// Lift the certificateHold on a user's suspended certificate so that it's valid again
+func releaseCertificate(username string, crlValidity time.Duration) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
 	if err != nil {
 		return err
 	}
 
-	if match {
-		return fmt.Errorf("Certificate for %s already revoked", username)
+	released := 0
+	for _, entry := range index.FindByCommonName(username, pki.STATUS_REVOKED) {
+		if entry.RevocationReason != pki.REASON_CERTIFICATE_HOLD {
+			continue
+		}
+
+		logger.Debugf("Releasing hold on certificate %s for %s", entry.Serial, username)
+		if err := entry.Release(); err != nil {
+			return err
+		}
+		released++
+	}
+
+	if released == 0 {
+		return errors.WithStackTrace(fmt.Errorf("no certificate on hold was found for %s", username))
 	}
 
-	return nil
+	return writeIndexAndCrl(index, crlValidity)
 }
 
 func readTemplateFile() (string, error) {
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// OpenVPN looks client config files up by the common name of the client's certificate, so usernames end up in file
+// names. These are the characters IAM allows in user names, other than a comma.
+var clientConfigUsernameRegex = regexp.MustCompile(`^[A-Za-z0-9_+=@-][A-Za-z0-9_+=.@-]*$`)
+
+// Write the client config files that pin a user to a static IP and push extra routes to them, one for each of the
+// server's OpenVPN instances. A static IP is given as an address in any instance's VPN subnet, and the user gets the
+// address with the same host number in every instance's subnet. With neither a static IP nor routes, the user's client
+// config files are removed.
+func setClientConfig(username string, staticIp string, pushRoutes []string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if !clientConfigUsernameRegex.MatchString(username) {
+		return errors.WithStackTrace(InvalidClientConfig(fmt.Sprintf("'%s' can't be used as a client config file name", username)))
+	}
+
+	if staticIp == "" && len(pushRoutes) == 0 {
+		return removeClientConfig(username)
+	}
+
+	routes := []*net.IPNet{}
+	for _, route := range pushRoutes {
+		network, err := bootstrap.ParseNetwork(route)
+		if err != nil {
+			return errors.WithStackTrace(InvalidClientConfig(err.Error()))
+		}
+		routes = append(routes, network)
+	}
+
+	instances, err := readServerInstances()
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return errors.WithStackTrace(NoServerInstances(pkiLayout.KeyDir))
+	}
+
+	var hostNumber uint32
+	if staticIp != "" {
+		ip := net.ParseIP(staticIp).To4()
+		if ip == nil {
+			return errors.WithStackTrace(InvalidClientConfig(fmt.Sprintf("'%s' is not an IPv4 address", staticIp)))
+		}
+
+		found := false
+		for _, instance := range instances {
+			if hostNumber, found = instance.HostNumber(ip); found {
+				break
+			}
+		}
+		if !found {
+			return errors.WithStackTrace(InvalidClientConfig(fmt.Sprintf("%s is not an address the server can give a client in any of its VPN subnets", staticIp)))
+		}
+	}
+
+	configs := map[string]*bootstrap.ClientConfig{}
+	for _, instance := range instances {
+		config := &bootstrap.ClientConfig{PushRoutes: routes}
+
+		if staticIp != "" {
+			config.StaticIp = instance.Address(hostNumber)
+			config.Netmask = instance.VpnSubnet.Mask
+			if _, ok := instance.HostNumber(config.StaticIp); !ok {
+				return errors.WithStackTrace(InvalidClientConfig(fmt.Sprintf("%s has no address with the same host number as %s", instance.VpnSubnet, staticIp)))
+			}
+
+			owner, err := staticIpOwner(instance, config.StaticIp)
+			if err != nil {
+				return err
+			}
+			if owner != "" && owner != username {
+				return errors.WithStackTrace(InvalidClientConfig(fmt.Sprintf("%s is already the static IP of %s", config.StaticIp, owner)))
+			}
+
+			if !instance.IsReservedForStaticIps(config.StaticIp) {
+				logger.Warnf("%s is in the dynamic pool of the OpenVPN instance %s, so it may already be in use by another client. Set static_ip_count in the server config to keep addresses for static IPs.", config.StaticIp, instance.Name)
+			}
+		}
+
+		if instance.ClientConfigDir == "" {
+			logger.Warnf("The OpenVPN instance %s doesn't use client config files. Run openvpn-admin server render to enable them.", instance.Name)
+		}
+
+		configs[instance.Name] = config
+	}
+
+	for name, config := range configs {
+		path := pkiLayout.ClientConfigPath(name, username)
+		if _, err := writeFileIfChanged(path, bootstrap.RenderClientConfig(username, config), 0644); err != nil {
+			return err
+		}
+		logger.Infof("Wrote the client config file %s", path)
+	}
+	return nil
+}
+
+// Remove a user's client config files from every instance's client config dir
+func removeClientConfig(username string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	paths, err := filepath.Glob(pkiLayout.ClientConfigPath("*", username))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		logger.Infof("Removed the client config file %s", path)
+	}
+	return nil
+}
+
+// The OpenVPN server instances configured in the key dir, as read back from their config files
+func readServerInstances() ([]*bootstrap.Instance, error) {
+	paths, err := filepath.Glob(filepath.Join(pkiLayout.KeyDir, "*.conf"))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	instances := []*bootstrap.Instance{}
+	for _, path := range paths {
+		contents, err := files.ReadFileAsString(path)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		if instance, ok := bootstrap.ParseInstance(strings.TrimSuffix(filepath.Base(path), ".conf"), contents); ok {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+// The user whose client config file pins them to the given address on the instance, if any
+func staticIpOwner(instance *bootstrap.Instance, ip net.IP) (string, error) {
+	dir := pkiLayout.ClientConfigDir(instance.Name)
+	if !files.IsDir(dir) {
+		return "", nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	for _, entry := range entries {
+		contents, err := files.ReadFileAsString(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return "", errors.WithStackTrace(err)
+		}
+		if ip.Equal(bootstrap.ParseClientConfigStaticIp(contents)) {
+			return entry.Name(), nil
+		}
+	}
+	return "", nil
+}
+
+// Custom errors
+
+type InvalidClientConfig string
+
+func (err InvalidClientConfig) Error() string {
+	return fmt.Sprintf("Invalid client config: %s", string(err))
+}
+
+type NoServerInstances string
+
+func (dir NoServerInstances) Error() string {
+	return fmt.Sprintf("Found no OpenVPN server configs in %s. Run openvpn-admin init first.", string(dir))
+}
@@ -0,0 +1,27 @@
+package app
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+)
+
+// Let a user's pending certificate request through. The user gets their certificate the next time their client asks.
+func approveCertificateRequest(cliContext *cli.Context) error {
+	return submitApprovalDecision(cliContext, APPROVE_ACTION)
+}
+
+func denyCertificateRequest(cliContext *cli.Context) error {
+	return submitApprovalDecision(cliContext, DENY_ACTION)
+}
+
+func submitApprovalDecision(cliContext *cli.Context, action string) error {
+	setLoggerLevel(cliContext)
+
+	requestId, err := getApprovalRequestId(cliContext)
+	if err != nil {
+		return err
+	}
+
+	request := &CertificateRevokeRequest{Action: action, RequestId: requestId, Reason: cliContext.String(OPTION_REASON)}
+	return submitAdminQueueRequest(cliContext, request, fmt.Sprintf("%s of certificate request %s", action, requestId))
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/audit"
+	"github.com/urfave/cli"
+)
+
+func verifyAuditLog(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	auditLog := &audit.Log{Path: AUDIT_LOG_PATH}
+	count, err := auditLog.Verify()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cliContext.App.Writer, "Verified %d events in %s\n", count, AUDIT_LOG_PATH)
+	return nil
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"os"
+)
+
+// Back up the PKI and print the version of the backup, e.g. from the hourly cron job init sets up
+func backUpPkiNow(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	version, err := createBackup(getBackupSettings(cliContext))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cliContext.App.Writer, version)
+	return nil
+}
+
+// Restore the PKI from the latest backup, or the --backup-version given. With --list, print the versions to choose from
+// instead.
+func restorePkiNow(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	settings := getBackupSettings(cliContext)
+
+	if cliContext.Bool(OPTION_LIST) {
+		versions, err := listBackupVersions(settings)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			fmt.Fprintln(cliContext.App.Writer, version)
+		}
+		return nil
+	}
+
+	version := cliContext.String(OPTION_BACKUP_VERSION)
+	if version == "" {
+		var err error
+		version, err = latestBackupVersion(settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := restoreBackup(settings, version, cliContext.Bool(OPTION_FORCE))
+	auditLocalCommand(AUDIT_ACTION_RESTORE, version, err)
+	if err != nil {
+		return err
+	}
+
+	if os.Geteuid() == 0 {
+		return restrictToUserAndGroup(pkiLayout.KeyDir, OPENVPN_USER, OPENVPN_GROUP)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/urfave/cli"
+	"strings"
+	"time"
+)
+
+// OpenVPN only reads ca.crt and the server certificate when it starts
+const OPENVPN_RESTART_REMINDER = "Restart OpenVPN (systemctl restart 'openvpn@*') to pick up the new CA bundle"
+
+func rotateCa(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	crlValidity, err := getCrlValidity(cliContext)
+	if err != nil {
+		return err
+	}
+
+	err = startCaRotation(crlValidity)
+	auditLocalCommand(AUDIT_ACTION_ROTATE_CA, "", err)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Started the CA rotation. New certificates are issued by the new CA, and ca.crt trusts both CAs.")
+	logger.Infof(OPENVPN_RESTART_REMINDER)
+	logger.Infof("Users can now request a new certificate. Once 'openvpn-admin ca status' shows everyone has migrated, run 'openvpn-admin ca retire'.")
+	return nil
+}
+
+func showCaRotationStatus(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	out := cliContext.App.Writer
+
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil {
+		return err
+	}
+	if rotation == nil {
+		fmt.Fprintln(out, "No CA rotation in progress")
+		return nil
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+
+	status, err := getCaMigrationStatus(rotation, index)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Rotation started:  %s\n", rotation.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(out, "First new serial:  %s\n", rotation.FirstSerial)
+	fmt.Fprintf(out, "Migrated (%d):      %s\n", len(status.Migrated), strings.Join(status.Migrated, ", "))
+	fmt.Fprintf(out, "Pending (%d):       %s\n", len(status.Pending), strings.Join(status.Pending, ", "))
+	return nil
+}
+
+func retireCa(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	crlValidity, err := getCrlValidity(cliContext)
+	if err != nil {
+		return err
+	}
+
+	err = retirePreviousCa(cliContext.Bool(OPTION_FORCE), crlValidity)
+	auditLocalCommand(AUDIT_ACTION_RETIRE_CA, "", err)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Retired the previous CA. The server certificate was reissued by the new CA.")
+	logger.Infof(OPENVPN_RESTART_REMINDER)
+	return nil
+}
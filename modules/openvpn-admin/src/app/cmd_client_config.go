@@ -0,0 +1,24 @@
+package app
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+)
+
+// Ask the server to pin a user to a static IP and push extra routes to them, by writing their client config files
+func requestClientConfigChange(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	username, err := getUsername(cliContext, false)
+	if err != nil {
+		return err
+	}
+
+	request := &CertificateRevokeRequest{
+		Username:   username,
+		Action:     CLIENT_CONFIG_ACTION,
+		StaticIp:   cliContext.String(OPTION_STATIC_IP),
+		PushRoutes: cliContext.StringSlice(OPTION_PUSH_ROUTE),
+	}
+	return submitAdminQueueRequest(cliContext, request, fmt.Sprintf("client config change for %s", username))
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/urfave/cli"
+	"time"
+)
+
+// Print the state of the published CRL. Returns an error if OpenVPN wouldn't be able to use it, so that this command
+// can be used directly as a monitoring check.
+func showCrlStatus(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	status, healthErr := checkCrlHealth()
+	if status != nil {
+		out := cliContext.App.Writer
+		fmt.Fprintf(out, "CRL:          %s\n", pkiLayout.CrlPath())
+		fmt.Fprintf(out, "CRL number:   %s\n", status.Number.String())
+		fmt.Fprintf(out, "Last update:  %s\n", status.ThisUpdate.Format(time.RFC3339))
+		fmt.Fprintf(out, "Next update:  %s\n", status.NextUpdate.Format(time.RFC3339))
+		fmt.Fprintf(out, "Revoked:      %d\n", status.RevokedCount)
+	}
+
+	return healthErr
+}
+
+// Publish a new CRL immediately, e.g. from cron on servers that don't run the scheduled refresh in process-revokes
+func refreshCrlNow(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	crlValidity, err := getCrlValidity(cliContext)
+	if err != nil {
+		return err
+	}
+
+	err = refreshCrl(crlValidity)
+	auditLocalCommand(AUDIT_ACTION_REFRESH_CRL, "", err)
+	if err != nil {
+		return err
+	}
+
+	status, err := checkCrlHealth()
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Published CRL %s with %d revoked certificates, next update at %s", status.Number.String(), status.RevokedCount, status.NextUpdate.Format(time.RFC3339))
+	return nil
+}
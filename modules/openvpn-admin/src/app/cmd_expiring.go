@@ -0,0 +1,42 @@
+package app
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"text/tabwriter"
+	"time"
+)
+
+// Ask the OpenVPN server which valid certificates expire within --within, and print them soonest first
+func showExpiringCertificates(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	within := cliContext.Duration(OPTION_WITHIN)
+	until := time.Now().Add(within)
+	request := &CertificateRevokeRequest{Action: EXPIRING_ACTION, Until: &until}
+
+	body, err := queryAdminQueue(cliContext, request, fmt.Sprintf("the certificates that expire within %s", within))
+	if err != nil {
+		return err
+	}
+	expiring := []expiringCertificate{}
+	if err := decodeReport(body, &expiring); err != nil {
+		return err
+	}
+
+	if len(expiring) == 0 {
+		fmt.Fprintf(cliContext.App.Writer, "No certificates expire within %s\n", within)
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(cliContext.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "USERNAME\tSERIAL\tEXPIRES\tDAYS LEFT\tRENEWED")
+	for _, certificate := range expiring {
+		renewed := "no"
+		if certificate.Renewed {
+			renewed = "yes"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\n", certificate.Username, certificate.Serial, certificate.Expires.Format(time.RFC3339), certificate.DaysLeft, renewed)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,327 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/sessions"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The hooks run these commands as root with sudo to start and end sessions. They take no arguments, so that the sudoers
+// rule allows nothing else, and read the client from the environment variables OpenVPN sets.
+const HOOK_START_SESSION = "start-session"
+const HOOK_END_SESSION = "end-session"
+
+// OpenVPN creates the files it passes client-connect in its --tmp-dir, which init creates for the OpenVPN user alone
+const HOOK_TMP_DIR = OPENVPN_PATH + "/tmp"
+
+// hookEnvironment is what OpenVPN tells its client-connect and client-disconnect scripts about a client, in environment
+// variables
+type hookEnvironment struct {
+	Session *sessions.Session
+	VpnIp   net.IP
+	Serial  *big.Int
+
+	// Only set for client-disconnect
+	BytesReceived int64
+	BytesSent     int64
+}
+
+// Run by OpenVPN through --client-connect after a client authenticates, as the user OpenVPN runs as. OpenVPN passes the
+// path of a file to write config for the client to, such as routes to push, and refuses the client if this exits with a
+// non-zero status. Starting the session changes the firewall, so that's left to hook start-session, run with sudo, and
+// the config it prints is written here without root.
+func runClientConnectHook(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	configPath := cliContext.Args().First()
+	if configPath == "" {
+		return errors.WithStackTrace(MissingClientConfigFile{})
+	}
+	if err := checkClientConfigFile(configPath, HOOK_TMP_DIR); err != nil {
+		return err
+	}
+
+	clientConfig, err := runPrivilegedHook(HOOK_START_SESSION)
+	if err != nil || len(clientConfig) == 0 {
+		return err
+	}
+	return errors.WithStackTrace(ioutil.WriteFile(configPath, clientConfig, 0600))
+}
+
+// Run by OpenVPN through --client-disconnect when a client disconnects, as the user OpenVPN runs as. Ending the session
+// is left to hook end-session, run with sudo.
+func runClientDisconnectHook(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	_, err := runPrivilegedHook(HOOK_END_SESSION)
+	return err
+}
+
+// Run as root by hook client-connect to check the client against the connection policy, record its session and apply
+// its access groups. Prints the config OpenVPN should read for the client.
+func runStartSessionHook(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	environment, err := readHookEnvironment()
+	if err != nil {
+		return err
+	}
+	session := environment.Session
+	tracker := newSessionTracker(session)
+
+	connectionPolicy, err := readConnectionPolicy()
+	if err != nil {
+		return err
+	}
+
+	reason, err := checkConnection(environment, connectionPolicy, time.Now())
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		err := tracker.Start(session, connectionPolicy.MaxSessionsPerUser)
+		if tooMany, ok := errors.Unwrap(err).(sessions.TooManySessions); ok {
+			reason = tooMany.Error()
+		} else if err != nil {
+			return err
+		}
+	}
+	if reason != "" {
+		if err := tracker.Refuse(session, reason); err != nil {
+			logger.Errorf("Failed to record that %s was refused: %s", session.Username, err)
+		}
+		return errors.WithStackTrace(ConnectionRefused{Username: session.Username, Reason: reason})
+	}
+
+	clientConfig, memberOf, err := applyAccessGroups(environment)
+	if err != nil {
+		// OpenVPN refuses the client, and doesn't run client-disconnect for it
+		if _, endErr := tracker.End(session, 0, 0); endErr != nil {
+			logger.Errorf("Failed to end the session of %s: %s", session.Username, endErr)
+		}
+		return err
+	}
+
+	logger.Infof("%s connected to %s from %s with the address %s in the access groups [%s]", session.Username, session.Instance, session.TrustedIp, session.VpnIp, strings.Join(memberOf, ", "))
+	_, err = os.Stdout.Write(clientConfig)
+	return errors.WithStackTrace(err)
+}
+
+// Run as root by hook client-disconnect to end the client's session and remove what hook start-session set up for it.
+// Anyone who can run commands as the OpenVPN user can run this with any environment, so only the session recorded for
+// the client's address, common name and certificate is ended, and nothing is removed if there's no such session.
+func runEndSessionHook(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	environment, err := readHookEnvironment()
+	if err != nil {
+		return err
+	}
+	session := environment.Session
+
+	ended, err := newSessionTracker(session).End(session, environment.BytesReceived, environment.BytesSent)
+	if err != nil {
+		return err
+	}
+	if ended == nil {
+		logger.Warnf("%s disconnected from %s, but had no active session with the address %s. Leaving its firewall rules alone.", session.Username, session.Instance, session.VpnIp)
+		return nil
+	}
+
+	groups, err := readAccessGroups()
+	if err != nil {
+		return err
+	}
+	if len(groups) > 0 {
+		removeClientFirewall(environment.VpnIp)
+	}
+
+	logger.Infof("%s disconnected from %s after %s, having sent %d bytes and received %d", session.Username, session.Instance, time.Since(ended.Start).Round(time.Second), environment.BytesReceived, environment.BytesSent)
+	return nil
+}
+
+// Check the client against the CA database and the connection policy. Returns why the client should be refused, or an
+// empty string if it may connect. The limit on sessions is checked when the session starts.
+func checkConnection(environment *hookEnvironment, connectionPolicy *bootstrap.ConnectionPolicyConfig, now time.Time) (string, error) {
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return "", err
+	}
+
+	// OpenVPN only checks the CRL, which doesn't have the revocations since it was last published
+	entry := index.FindBySerial(environment.Serial)
+	switch {
+	case entry == nil:
+		return fmt.Sprintf("certificate %s is not in the CA database", environment.Session.Serial), nil
+	case entry.Status == pki.STATUS_REVOKED:
+		return fmt.Sprintf("certificate %s was revoked", environment.Session.Serial), nil
+	case entry.Status != pki.STATUS_VALID:
+		return fmt.Sprintf("certificate %s is no longer valid", environment.Session.Serial), nil
+	}
+
+	// Anyone who can run commands as the OpenVPN user can run hook start-session with any environment, so check that
+	// the common name is the one in the certificate
+	certificate, err := pki.ReadCertificate(pkiLayout.IssuedCertPath(environment.Session.Serial))
+	if err != nil {
+		return "", err
+	}
+	if certificate.Subject.CommonName != environment.Session.Username {
+		return fmt.Sprintf("certificate %s was issued to %s", environment.Session.Serial, certificate.Subject.CommonName), nil
+	}
+
+	if !connectionPolicy.AllowsConnectingAt(now) {
+		return "connections aren't allowed at this time", nil
+	}
+
+	return "", nil
+}
+
+// Limit the client to the networks of its access groups in the firewall. Returns the client config that pushes their
+// routes to it, and the access groups it's in.
+func applyAccessGroups(environment *hookEnvironment) ([]byte, []string, error) {
+	groups, err := readAccessGroups()
+	if err != nil || len(groups) == 0 {
+		return nil, nil, err
+	}
+
+	memberOf, err := certificateAccessGroups(environment.Serial)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := applyClientFirewall(environment.VpnIp, bootstrap.ClientAccessRules(groups, memberOf)); err != nil {
+		return nil, nil, err
+	}
+
+	routes := bootstrap.AccessRoutes(groups, memberOf)
+	return renderAccessClientConfig(environment.Session.Username, routes), memberOf, nil
+}
+
+// OpenVPN creates the client config file in its --tmp-dir before it runs client-connect. Refuse to write anywhere else,
+// or to a file OpenVPN didn't create, such as a link to another file.
+func checkClientConfigFile(path string, tmpDir string) error {
+	if !filepath.IsAbs(path) || filepath.Dir(filepath.Clean(path)) != filepath.Clean(tmpDir) {
+		return errors.WithStackTrace(UnexpectedClientConfigFile{Path: path, Reason: fmt.Sprintf("it is not in %s", tmpDir)})
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.WithStackTrace(UnexpectedClientConfigFile{Path: path, Reason: "it is not a regular file"})
+	}
+	if !isOwnedByCurrentUser(info) {
+		return errors.WithStackTrace(UnexpectedClientConfigFile{Path: path, Reason: "it is not owned by the user running the hook"})
+	}
+	return nil
+}
+
+// Run the given hook command as root with sudo, passing on what it logs, and return what it prints. OpenVPN runs the
+// hooks with the path in the server config, which is the one the sudoers file allows.
+func runPrivilegedHook(command string) ([]byte, error) {
+	sudo := exec.Command(SUDO_PATH, "-n", os.Args[0], "hook", command)
+	sudo.Stderr = os.Stderr
+	output, err := sudo.Output()
+	if err != nil {
+		return nil, errors.WithStackTrace(PrivilegedHookFailed{Command: command, Cause: err})
+	}
+	return output, nil
+}
+
+func readHookEnvironment() (*hookEnvironment, error) {
+	session := &sessions.Session{
+		Username:  os.Getenv("common_name"),
+		TrustedIp: os.Getenv("trusted_ip"),
+		// OpenVPN runs in /etc/openvpn, so config is the name of the instance's config file
+		Instance: strings.TrimSuffix(filepath.Base(os.Getenv("config")), ".conf"),
+	}
+	// The common name ends up in the session log and the client config, so it must be a username we'd issue a
+	// certificate for
+	if !clientConfigUsernameRegex.MatchString(session.Username) {
+		return nil, errors.WithStackTrace(InvalidHookEnvironment("common_name"))
+	}
+	if session.TrustedIp == "" {
+		session.TrustedIp = os.Getenv("trusted_ip6")
+	}
+	session.TrustedPort, _ = strconv.Atoi(os.Getenv("trusted_port"))
+	session.DaemonPid, _ = strconv.Atoi(os.Getenv("daemon_pid"))
+	session.DaemonStartTime, _ = strconv.ParseInt(os.Getenv("daemon_start_time"), 10, 64)
+
+	environment := &hookEnvironment{Session: session}
+
+	environment.VpnIp = net.ParseIP(os.Getenv("ifconfig_pool_remote_ip")).To4()
+	if environment.VpnIp == nil {
+		return nil, errors.WithStackTrace(InvalidHookEnvironment("ifconfig_pool_remote_ip"))
+	}
+	session.VpnIp = environment.VpnIp.String()
+
+	// The serial of the client's certificate, in decimal
+	serial, ok := new(big.Int).SetString(os.Getenv("tls_serial_0"), 10)
+	if !ok {
+		return nil, errors.WithStackTrace(InvalidHookEnvironment("tls_serial_0"))
+	}
+	environment.Serial = serial
+	session.Serial = pki.FormatSerial(serial)
+
+	environment.BytesReceived, _ = strconv.ParseInt(os.Getenv("bytes_received"), 10, 64)
+	environment.BytesSent, _ = strconv.ParseInt(os.Getenv("bytes_sent"), 10, 64)
+
+	return environment, nil
+}
+
+// Custom errors
+
+type MissingClientConfigFile struct{}
+
+func (err MissingClientConfigFile) Error() string {
+	return "OpenVPN passes client-connect the file to write the client's config to as its only argument, but there was none."
+}
+
+type UnexpectedClientConfigFile struct {
+	Path   string
+	Reason string
+}
+
+func (err UnexpectedClientConfigFile) Error() string {
+	return fmt.Sprintf("Refusing to write the client config to %s, as %s. OpenVPN creates the file in its --tmp-dir before it runs client-connect.", err.Path, err.Reason)
+}
+
+type PrivilegedHookFailed struct {
+	Command string
+	Cause   error
+}
+
+func (err PrivilegedHookFailed) Error() string {
+	return fmt.Sprintf("openvpn-admin hook %s failed: %s", err.Command, err.Cause)
+}
+
+type InvalidHookEnvironment string
+
+func (name InvalidHookEnvironment) Error() string {
+	return fmt.Sprintf("The environment variable %s that OpenVPN sets for client-connect and client-disconnect is missing or invalid: %q", string(name), os.Getenv(string(name)))
+}
+
+type ConnectionRefused struct {
+	Username string
+	Reason   string
+}
+
+func (err ConnectionRefused) Error() string {
+	return fmt.Sprintf("Refused %s: %s", err.Username, err.Reason)
+}
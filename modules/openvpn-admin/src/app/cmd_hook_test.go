@@ -0,0 +1,108 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/sessions"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckClientConfigFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "openvpn_cc_1234.tmp")
+	writeTestFile(t, configPath, "")
+
+	if err := checkClientConfigFile(configPath, tmpDir); err != nil {
+		t.Errorf("expected the file OpenVPN created to be accepted, got %v", err)
+	}
+
+	otherDir := t.TempDir()
+	elsewhere := filepath.Join(otherDir, "sudoers")
+	writeTestFile(t, elsewhere, "")
+	link := filepath.Join(tmpDir, "openvpn_cc_5678.tmp")
+	if err := os.Symlink(elsewhere, link); err != nil {
+		t.Fatal(err)
+	}
+	subDir := filepath.Join(tmpDir, "sub")
+	if err := os.Mkdir(subDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	refused := []string{
+		elsewhere,
+		link,
+		subDir,
+		filepath.Join(tmpDir, "..", filepath.Base(otherDir), "sudoers"),
+		"openvpn_cc_1234.tmp",
+		filepath.Join(tmpDir, "missing.tmp"),
+	}
+	for _, path := range refused {
+		if err := checkClientConfigFile(path, tmpDir); err == nil {
+			t.Errorf("expected %s to be refused", path)
+		}
+	}
+}
+
+func TestReadHookEnvironmentRefusesInvalidCommonNames(t *testing.T) {
+	t.Setenv("ifconfig_pool_remote_ip", "172.16.0.6")
+	t.Setenv("tls_serial_0", "10")
+
+	for _, commonName := range []string{"alice\nbob", "../alice", "alice/OU=access-group:prod", ""} {
+		t.Setenv("common_name", commonName)
+		_, err := readHookEnvironment()
+		if name, ok := errors.Unwrap(err).(InvalidHookEnvironment); !ok || name != "common_name" {
+			t.Errorf("expected %q to be refused, got %v", commonName, err)
+		}
+	}
+
+	t.Setenv("common_name", "alice")
+	environment, err := readHookEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if environment.Session.Username != "alice" || environment.Session.Serial != pki.FormatSerial(environment.Serial) {
+		t.Errorf("unexpected environment %+v", environment.Session)
+	}
+}
+
+func TestCheckConnectionRefusesCommonNameOfAnotherCertificate(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := issueClientCertificate("alice", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environment := &hookEnvironment{
+		Session: &sessions.Session{Username: "alice", Serial: pki.FormatSerial(certificate.SerialNumber)},
+		VpnIp:   net.ParseIP("172.16.0.6").To4(),
+		Serial:  certificate.SerialNumber,
+	}
+	policy := &bootstrap.ConnectionPolicyConfig{}
+
+	reason, err := checkConnection(environment, policy, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "" {
+		t.Errorf("expected alice to be allowed to connect, got %s", reason)
+	}
+
+	environment.Session.Username = "bob"
+	reason, err = checkConnection(environment, policy, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reason, "was issued to alice") {
+		t.Errorf("expected bob to be refused with alice's certificate, got %q", reason)
+	}
+}
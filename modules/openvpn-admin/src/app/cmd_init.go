@@ -0,0 +1,34 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/urfave/cli"
+)
+
+// Set up the OpenVPN server described by the --config file: restore or create the PKI, write server.conf and the
+// client profile template, configure the firewall and start OpenVPN. Running it again converges the server on the
+// config rather than starting over.
+func initOpenVpnServer(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	config, err := getInitConfig(cliContext)
+	if err != nil {
+		return err
+	}
+
+	root, err := getRoot(cliContext)
+	if err != nil {
+		return err
+	}
+
+	initializer := &serverInitializer{
+		Config:    config,
+		Root:      root,
+		AwsRegion: cliContext.String(OPTION_AWS_REGION),
+	}
+	err = initializer.run()
+	if files.IsDir(initializer.path(OPENVPN_PATH)) {
+		initializer.audit(AUDIT_ACTION_INIT, SERVER_COMMON_NAME, err)
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/urfave/cli"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Ask the OpenVPN server which certificates it has issued to a user, and print them
+func listCertificates(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	awsRegion, err := getAwsRegion(cliContext)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("Using AWS Region: %s", awsRegion)
+
+	username, err := getUsername(cliContext, true)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("Using Username: %s", username)
+
+	requestUrl, err := getRequestUrl(cliContext)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("Using Request URL: %s", requestUrl)
+
+	timeout, err := getTimeout(cliContext)
+	if err != nil {
+		return err
+	}
+
+	responseQueue, err := createResponseQueue(awsRegion)
+	if err != nil {
+		return err
+	}
+	defer deleteResponseQueue(awsRegion, responseQueue)
+
+	logger.Infof("Requesting the certificates of %s from %s", username, requestUrl)
+	err = sendRequest(awsRegion, requestUrl, &CertificateRequest{Username: username, ResponseQueue: responseQueue, Action: LIST_ACTION})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Waiting for response from OpenVPN server")
+	receipt, message, err := waitForMessage(awsRegion, responseQueue, timeout)
+	if err != nil {
+		return err
+	}
+	aws_helpers.DeleteMessageFromQueue(awsRegion, responseQueue, receipt)
+
+	response := CertificateResponse{}
+	json.Unmarshal([]byte(message), &response)
+	if !response.Success {
+		return errors.WithStackTrace(fmt.Errorf(response.ErrorMessage))
+	}
+
+	fmt.Fprint(cliContext.App.Writer, response.Body)
+	return nil
+}
+
+// Describe the certificates in the CA database that were issued to the given user, one per line
+func describeCertificates(username string) (string, error) {
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return "", err
+	}
+
+	entries := index.FindByCommonName(username, "")
+	if len(entries) == 0 {
+		return fmt.Sprintf("No certificates have been issued to %s\n", username), nil
+	}
+
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERIAL\tSTATUS\tEXPIRES\tREVOKED")
+	for _, entry := range entries {
+		revoked := ""
+		if entry.Status == pki.STATUS_REVOKED {
+			revoked = entry.RevocationTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", entry.Serial, describeCertificateStatus(entry), entry.ExpirationTime.Format(time.RFC3339), revoked)
+	}
+	writer.Flush()
+
+	return builder.String(), nil
+}
+
+func describeCertificateStatus(entry *pki.IndexEntry) string {
+	switch {
+	case entry.Status == pki.STATUS_REVOKED && entry.RevocationReason == pki.REASON_CERTIFICATE_HOLD:
+		return "on hold"
+	case entry.Status == pki.STATUS_REVOKED:
+		return "revoked"
+	case entry.Status == pki.STATUS_EXPIRED || entry.ExpirationTime.Before(time.Now()):
+		return "expired"
+	default:
+		return "valid"
+	}
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/notify"
+	"github.com/urfave/cli"
+	"os"
+	"time"
+)
+
+// Send a test notification to every sink in the notifications file, whatever events it subscribes to, and report
+// whether each one accepted it
+func testNotifications(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	path := cliContext.String(OPTION_NOTIFICATIONS_FILE)
+	if path == "" {
+		return errors.WithStackTrace(fmt.Errorf("--%s is required", OPTION_NOTIFICATIONS_FILE))
+	}
+
+	// Only SNS sinks need a region, so don't insist on one
+	notifier, err := openNotifier(cliContext.String(OPTION_AWS_REGION), path)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	notification := &notify.Notification{Event: notify.EVENT_TEST, Time: time.Now().UTC(), Host: hostname}
+
+	sent, failures := notifier.Notify(notification)
+	for _, name := range sent {
+		fmt.Fprintf(cliContext.App.Writer, "%s: OK\n", name)
+	}
+	for _, err := range failures {
+		fmt.Fprintln(cliContext.App.Writer, err)
+	}
+
+	if len(failures) > 0 {
+		return errors.WithStackTrace(NotificationTestFailed{Failed: len(failures), Total: len(notifier.Routes)})
+	}
+	return nil
+}
+
+// Custom errors
+
+type NotificationTestFailed struct {
+	Failed int
+	Total  int
+}
+
+func (err NotificationTestFailed) Error() string {
+	return fmt.Sprintf("%d of %d sinks failed to accept the test notification", err.Failed, err.Total)
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/urfave/cli"
+	"net/http"
+)
+
+// NOTE: This method runs until the HTTP server fails
+func serveOcspResponses(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	address, err := getOcspListenAddress(cliContext)
+	if err != nil {
+		return err
+	}
+
+	responseValidity, err := getOcspResponseValidity(cliContext)
+	if err != nil {
+		return err
+	}
+
+	server := &ocspServer{responseValidity: responseValidity}
+	if err := server.renewResponderIfNeeded(); err != nil {
+		return err
+	}
+	go server.runScheduledResponderRenewal()
+
+	logger.Infof("Serving OCSP responses on %s", address)
+	return errors.WithStackTrace(http.ListenAndServe(address, server))
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"github.com/urfave/cli"
+	"os"
+)
+
+// Create the CA for a new OpenVPN server. init-openvpn calls this in place of easy-rsa's build-ca so that the CA can
+// use an elliptic curve key.
+func buildCaNow(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	err := buildCa()
+	auditLocalCommand(AUDIT_ACTION_BUILD_CA, "", err)
+	return err
+}
+
+// Issue the OpenVPN server's certificate. init-openvpn calls this in place of easy-rsa's build-key-server.
+func buildServerCertificateNow(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	err := buildServerCertificate()
+	auditLocalCommand(AUDIT_ACTION_BUILD_SERVER, SERVER_COMMON_NAME, err)
+	return err
+}
+
+// Take over the PKI of another OpenVPN server, or of easy-rsa or an OpenSSL CA, e.g. when moving to openvpn-admin
+func importPkiNow(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	source, err := getPkiImportSource(cliContext)
+	if err != nil {
+		return err
+	}
+
+	crlValidity, err := getCrlValidity(cliContext)
+	if err != nil {
+		return err
+	}
+
+	err = importPki(getBackupSettings(cliContext), source, crlValidity)
+	auditLocalCommand(AUDIT_ACTION_IMPORT_PKI, source, err)
+	if err != nil {
+		return err
+	}
+
+	if os.Geteuid() == 0 {
+		return restrictToUserAndGroup(pkiLayout.KeyDir, OPENVPN_USER, OPENVPN_GROUP)
+	}
+	return nil
+}
+
+// Write the PKI to a bundle that pki import, or easy-rsa 3, can take over on another machine
+func exportPkiNow(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	bundlePath, err := getPkiExportPath(cliContext)
+	if err != nil {
+		return err
+	}
+
+	err = exportPki(getBackupSettings(cliContext), bundlePath)
+	auditLocalCommand(AUDIT_ACTION_EXPORT_PKI, bundlePath, err)
+	return err
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
 	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/policy"
 	"github.com/urfave/cli"
+	"time"
 )
 
 // NOTE: This method runs in an infinite loop
@@ -31,9 +33,41 @@ func processNewCertificateRequests(cliContext *cli.Context) error {
 		return err
 	}
 
+	approval := getApprovalSettings(cliContext)
+	if approval.Required {
+		logger.Infof("New certificate requests must be approved by an admin before a certificate is issued")
+	}
+
+	accessPolicy, err := getPolicy(cliContext)
+	if err != nil {
+		return err
+	}
+	if accessPolicy != nil {
+		logger.Infof("Authorizing requests with the policy in %s", accessPolicy.Path)
+	}
+
+	auditLog := openAuditLog(awsRegion, getAuditSettings(cliContext), "process-requests")
+
+	notifier, err := getNotifier(cliContext, awsRegion)
+	if err != nil {
+		return err
+	}
+
+	metricsAddress, err := getMetricsListenAddress(cliContext)
+	if err != nil {
+		return err
+	}
+	monitor := newDaemonMonitor(METRICS_QUEUE_REQUESTS, timeout)
+	if metricsAddress != "" {
+		if err := monitor.serve(metricsAddress); err != nil {
+			return err
+		}
+	}
+
 	for {
 		// Wait for a request to come in from a client on the requestQueue
-		receipt, request, err := waitForRequestMessage(awsRegion, requestUrl, timeout)
+		message, err := waitForRequestMessage(awsRegion, requestUrl, timeout)
+		monitor.recordPoll(err)
 		if err != nil {
 			if sleepOnFailedToReceiveMessages(err) {
 				continue
@@ -44,52 +78,111 @@ func processNewCertificateRequests(cliContext *cli.Context) error {
 
 		//Here if we encounter an error, we don't want to stop processing, we want to return the error to the caller
 		//via the SQS queue
-		responseQueue, certificate, err := processNewCertificateRequestMessage(awsRegion, receipt, request)
-		if err != nil {
-			logger.WithError(err)
-		}
+		startTime := time.Now()
+		previousSerial := latestSerial(certificateRequestUsername(message))
+		responseQueue, certificate, err := processNewCertificateRequestMessage(awsRegion, message, approval, accessPolicy)
+		event := auditCertificateRequest(awsRegion, auditLog, message, err)
+		monitor.recordRequest(event, time.Since(startTime))
+		sendNotification(notifier, event, event.Serial != "" && event.Serial != previousSerial)
+
+		requestLogger := logger.WithFields(requestLogFields(monitor.Queue, event))
+		logRequestResult(requestLogger, event, err)
 
 		err = sendCertificateReply(awsRegion, responseQueue, certificate, err)
 		if err != nil {
 			return err
 		}
 
-		err = aws_helpers.DeleteMessageFromQueue(awsRegion, requestUrl, receipt)
+		err = aws_helpers.DeleteMessageFromQueue(awsRegion, requestUrl, message.Receipt)
 		if err != nil {
 			return err
 		}
 
-		logger.Info("DONE")
+		requestLogger.Debug("Replied and deleted the message")
 	}
-
-	return nil
 }
 
-func waitForRequestMessage(awsRegion string, responseQueue string, timeout int) (receipt string, message string, error error) {
-	receipt, message, err := aws_helpers.WaitForQueueMessage(awsRegion, responseQueue, timeout)
+// Unlike waitForMessage, this also returns who sent the request, so that the policy can be evaluated for them
+func waitForRequestMessage(awsRegion string, requestQueue string, timeout int) (*aws_helpers.QueueMessage, error) {
+	message, err := aws_helpers.ReceiveQueueMessage(awsRegion, requestQueue, timeout)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	return receipt, message, nil
+	return message, nil
 }
 
-func processNewCertificateRequestMessage(awsRegion string, receipt string, message string) (string, string, error) {
+func processNewCertificateRequestMessage(awsRegion string, message *aws_helpers.QueueMessage, approval approvalSettings, accessPolicy *policy.Policy) (string, string, error) {
 
 	request := CertificateRequest{}
-	json.Unmarshal([]byte(message), &request)
+	json.Unmarshal([]byte(message.Body), &request)
+
+	// Listing changes nothing, so users whose certificate is on hold may still see it
+	if request.Action == LIST_ACTION {
+		if _, err := authorize(awsRegion, accessPolicy, message.SenderId, policy.OPERATION_LIST, request.Username, 0); err != nil {
+			return request.ResponseQueue, "", err
+		}
+		certificates, err := describeCertificates(request.Username)
+		return request.ResponseQueue, certificates, err
+	}
 
 	certificateAlreadyExists, err := indexContainsValidCertificate(request.Username)
 	if err != nil {
 		return "", "", err
 	}
 
-	if !certificateAlreadyExists {
-		certificate, err := generateCertificate(request.Username)
+	// A suspended user must have the hold on their existing certificate released rather than getting a new one
+	certificateOnHold, err := indexContainsCertificateOnHold(request.Username)
+	if err != nil {
+		return request.ResponseQueue, "", err
+	}
+	if certificateOnHold {
+		var onHoldError = fmt.Errorf("the certificate for %s is on hold and must be released by an administrator", request.Username)
+		return request.ResponseQueue, "", errors.WithStackTrace(onHoldError)
+	}
+
+	switch request.Action {
+	case NEW_CERTIFICATE_ACTION:
+	case STATUS_ACTION:
+		certificate, err := processCertificateRequestStatus(awsRegion, message.SenderId, request, accessPolicy)
+		return request.ResponseQueue, certificate, err
+	default:
+		return request.ResponseQueue, "", errors.WithStackTrace(UnknownRequestAction(request.Action))
+	}
+
+	decision, err := authorize(awsRegion, accessPolicy, message.SenderId, policy.OPERATION_REQUEST, request.Username, request.Ttl)
+	if err != nil {
+		return request.ResponseQueue, "", err
+	}
+
+	// Users who already have a valid certificate have been approved before, e.g. when migrating to a new CA
+	if !certificateAlreadyExists && (approval.Required || decision.Effect == policy.REQUIRE_APPROVAL) {
+		policyRule := ""
+		if decision.Effect == policy.REQUIRE_APPROVAL {
+			policyRule = decision.Explain()
+		}
+		certificate, err := processCertificateRequestNeedingApproval(awsRegion, message.SenderId, request, policyRule, approval)
+		return request.ResponseQueue, certificate, err
+	}
+
+	// During a CA rotation, users who only have certificates from the previous CA get one from the new CA
+	migratingToNewCa, err := needsCaMigration(request.Username)
+	if err != nil {
+		return request.ResponseQueue, "", err
+	}
+
+	if !certificateAlreadyExists || migratingToNewCa {
+		certificate, err := generateCertificate(awsRegion, request.Username, request.Ttl)
 		if err != nil {
 			return request.ResponseQueue, "", err
 		}
 
+		if migratingToNewCa {
+			if err := supersedePreviousCaCertificates(request.Username, defaultCrlValidity()); err != nil {
+				return request.ResponseQueue, "", err
+			}
+		}
+
 		return request.ResponseQueue, certificate, nil
 	} else {
 		var alreadyExistsError = fmt.Errorf("a valid certificate for %s already exists", request.Username)
@@ -98,6 +191,71 @@ func processNewCertificateRequestMessage(awsRegion string, receipt string, messa
 
 }
 
+// Park a new certificate request until an admin approves it. If the sender already has a request open for the user,
+// carry on with that one instead, so that requesting again after an approval issues the certificate.
+func processCertificateRequestNeedingApproval(awsRegion string, senderId string, certificateRequest CertificateRequest, policyRule string, approval approvalSettings) (string, error) {
+	request, err := findOpenApprovalRequest(senderId, certificateRequest.Username)
+	if err != nil {
+		return "", err
+	}
+
+	if request == nil {
+		request, err = createApprovalRequest(awsRegion, senderId, certificateRequest.Username, certificateRequest.Ttl, policyRule, approval)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return issueApprovedCertificate(awsRegion, request)
+}
+
+// Tell the client what happened to the request they submitted earlier, and issue the certificate if it was approved.
+// Only the IAM principal that submitted the request may check on it, as the reply carries the new private key, and the
+// policy gets to decide again, as it may have changed since.
+func processCertificateRequestStatus(awsRegion string, senderId string, request CertificateRequest, accessPolicy *policy.Policy) (string, error) {
+	approval, err := readApprovalRequest(request.RequestId)
+	if err != nil {
+		return "", err
+	}
+
+	// Don't let anyone find out about other users' requests. The username comes from the client, so it's the sender
+	// recorded with the request that counts.
+	if approval.SenderId == "" || approval.SenderId != senderId || approval.Username != request.Username {
+		return "", errors.WithStackTrace(UnknownApprovalRequest(request.RequestId))
+	}
+
+	if _, err := authorize(awsRegion, accessPolicy, senderId, policy.OPERATION_REQUEST, approval.Username, approval.Ttl); err != nil {
+		return "", err
+	}
+
+	return issueApprovedCertificate(awsRegion, approval)
+}
+
+func issueApprovedCertificate(awsRegion string, request *approvalRequest) (string, error) {
+	switch request.Status {
+	case APPROVAL_STATUS_PENDING:
+		return "", errors.WithStackTrace(CertificateRequestPendingApproval{Id: request.Id, Username: request.Username, PolicyRule: request.PolicyRule})
+	case APPROVAL_STATUS_DENIED:
+		return "", errors.WithStackTrace(CertificateRequestDenied{Id: request.Id, Reason: request.Reason})
+	case APPROVAL_STATUS_ISSUED:
+		// The configuration holds the private key, so it's only ever handed out once. A client that lost it must
+		// request a new certificate.
+		return "", errors.WithStackTrace(ApprovalRequestAlreadyDecided{Id: request.Id, Status: request.Status})
+	}
+
+	certificate, err := generateCertificate(awsRegion, request.Username, request.Ttl)
+	if err != nil {
+		return "", err
+	}
+
+	request.Status = APPROVAL_STATUS_ISSUED
+	if err := request.write(); err != nil {
+		return "", err
+	}
+
+	return certificate, nil
+}
+
 func sendCertificateReply(awsRegion string, responseQueue string, certificate string, error error) error {
 
 	responseMessage := &CertificateResponse{}
@@ -107,6 +265,16 @@ func sendCertificateReply(awsRegion string, responseQueue string, certificate st
 		responseMessage.Body = certificate
 	} else {
 		responseMessage.ErrorMessage = error.Error()
+
+		// Let the client know it may get a certificate later, or never
+		switch cause := errors.Unwrap(error).(type) {
+		case CertificateRequestPendingApproval:
+			responseMessage.Status = APPROVAL_STATUS_PENDING
+			responseMessage.RequestId = cause.Id
+		case CertificateRequestDenied:
+			responseMessage.Status = APPROVAL_STATUS_DENIED
+			responseMessage.RequestId = cause.Id
+		}
 	}
 
 	requestJson, err := json.Marshal(responseMessage)
@@ -120,3 +288,11 @@ func sendCertificateReply(awsRegion string, responseQueue string, certificate st
 	}
 	return nil
 }
+
+// Custom errors
+
+type UnknownRequestAction string
+
+func (err UnknownRequestAction) Error() string {
+	return fmt.Sprintf("Unknown action '%s' on the request queue", string(err))
+}
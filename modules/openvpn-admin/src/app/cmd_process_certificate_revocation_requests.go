@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
 	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/policy"
 	"github.com/urfave/cli"
+	"time"
 )
 
 // NOTE: This method runs in an infinite loop
@@ -31,9 +34,66 @@ func processCertificateRevocationRequests(cliContext *cli.Context) error {
 		return err
 	}
 
+	crlValidity, err := getCrlValidity(cliContext)
+	if err != nil {
+		return err
+	}
+
+	crlRefreshInterval, err := getCrlRefreshInterval(cliContext, crlValidity)
+	if err != nil {
+		return err
+	}
+
+	// OpenVPN locks every user out once the CRL expires, so refuse to run rather than silently leave it that way
+	if err := assertCrlHealthyOnStartup(crlRefreshInterval, crlValidity); err != nil {
+		return err
+	}
+
+	accessPolicy, err := getPolicy(cliContext)
+	if err != nil {
+		return err
+	}
+	if accessPolicy != nil {
+		logger.Infof("Authorizing revocations with the policy in %s", accessPolicy.Path)
+	}
+
+	auditLog := openAuditLog(awsRegion, getAuditSettings(cliContext), "process-revokes")
+
+	notifier, err := getNotifier(cliContext, awsRegion)
+	if err != nil {
+		return err
+	}
+
+	reminderDays, err := getExpiryReminderDays(cliContext)
+	if err != nil {
+		return err
+	}
+
+	metricsAddress, err := getMetricsListenAddress(cliContext)
+	if err != nil {
+		return err
+	}
+	monitor := newDaemonMonitor(METRICS_QUEUE_REVOCATIONS, timeout)
+	if metricsAddress != "" {
+		if err := monitor.serve(metricsAddress); err != nil {
+			return err
+		}
+	}
+
+	if crlRefreshInterval > 0 {
+		logger.Infof("Refreshing the CRL every %s with a validity of %s", crlRefreshInterval, crlValidity)
+		go runScheduledCrlRefresh(crlRefreshInterval, crlValidity)
+	}
+
+	if notifier != nil && len(reminderDays) > 0 {
+		logger.Infof("Reminding users %v days before their certificates expire", reminderDays)
+		go runScheduledExpiryReminders(notifier, reminderDays)
+	}
+
 	for {
 		// Wait for a request to come in from a client on the revokeQueue
-		receipt, revokeRequest, err := waitForMessage(awsRegion, revokeUrl, timeout)
+		message, err := waitForRequestMessage(awsRegion, revokeUrl, timeout)
+		monitor.recordPoll(err)
 		if err != nil {
 			if sleepOnFailedToReceiveMessages(err) {
 				continue
@@ -43,57 +103,123 @@ func processCertificateRevocationRequests(cliContext *cli.Context) error {
 
 		//Here if we encounter an error, we don't want to stop processing, we want to return the error to the caller
 		//via the SQS queue
-		responseQueue, err := processRevokeRequest(awsRegion, receipt, revokeRequest)
-		if err != nil {
-			logger.WithError(err)
-		}
+		startTime := time.Now()
+		responseQueue, body, err := processRevokeRequest(awsRegion, message, crlValidity, accessPolicy)
+		event := auditRevokeRequest(awsRegion, auditLog, message, err)
+		monitor.recordRequest(event, time.Since(startTime))
+		sendNotification(notifier, event, false)
+
+		requestLogger := logger.WithFields(requestLogFields(monitor.Queue, event))
+		logRequestResult(requestLogger, event, err)
 
-		err = sendRevokeReply(awsRegion, responseQueue, err)
+		err = sendRevokeReply(awsRegion, responseQueue, body, err)
 		if err != nil {
 			return err
 		}
 
-		err = aws_helpers.DeleteMessageFromQueue(awsRegion, revokeUrl, receipt)
+		err = aws_helpers.DeleteMessageFromQueue(awsRegion, revokeUrl, message.Receipt)
 		if err != nil {
 			return err
 		}
 
-		logger.Info("DONE")
+		requestLogger.Debug("Replied and deleted the message")
 	}
-	return nil
 }
 
-func processRevokeRequest(awsRegion string, receipt string, message string) (string, error) {
+func processRevokeRequest(awsRegion string, message *aws_helpers.QueueMessage, crlValidity time.Duration, accessPolicy *policy.Policy) (string, string, error) {
 
 	revokeRequest := CertificateRevokeRequest{}
-	json.Unmarshal([]byte(message), &revokeRequest)
+	json.Unmarshal([]byte(message.Body), &revokeRequest)
 
-	certificateAlreadyExists, err := indexContainsValidCertificate(revokeRequest.Username)
+	switch revokeRequest.Action {
+	case REVOKE_ACTION, "":
+		if _, err := authorize(awsRegion, accessPolicy, message.SenderId, policy.OPERATION_REVOKE, revokeRequest.Username, 0); err != nil {
+			return revokeRequest.ResponseQueue, "", err
+		}
+		return revokeRequest.ResponseQueue, "", processCertificateRevocation(revokeRequest, crlValidity)
+	case RELEASE_ACTION:
+		if _, err := authorize(awsRegion, accessPolicy, message.SenderId, policy.OPERATION_RELEASE, revokeRequest.Username, 0); err != nil {
+			return revokeRequest.ResponseQueue, "", err
+		}
+		return revokeRequest.ResponseQueue, "", releaseCertificate(revokeRequest.Username, crlValidity)
+	case CLIENT_CONFIG_ACTION:
+		if _, err := authorize(awsRegion, accessPolicy, message.SenderId, policy.OPERATION_CLIENT_CONFIG, revokeRequest.Username, 0); err != nil {
+			return revokeRequest.ResponseQueue, "", err
+		}
+		return revokeRequest.ResponseQueue, "", setClientConfig(revokeRequest.Username, revokeRequest.StaticIp, revokeRequest.PushRoutes)
+	case REPORT_ACTION:
+		if _, err := authorize(awsRegion, accessPolicy, message.SenderId, policy.OPERATION_REPORT, revokeRequest.Username, 0); err != nil {
+			return revokeRequest.ResponseQueue, "", err
+		}
+		report, err := reportSessions(revokeRequest)
+		return revokeRequest.ResponseQueue, report, err
+	case EXPIRING_ACTION:
+		if _, err := authorize(awsRegion, accessPolicy, message.SenderId, policy.OPERATION_REPORT, "", 0); err != nil {
+			return revokeRequest.ResponseQueue, "", err
+		}
+		report, err := reportExpiringCertificates(revokeRequest, time.Now())
+		return revokeRequest.ResponseQueue, report, err
+	case APPROVE_ACTION, DENY_ACTION:
+		return revokeRequest.ResponseQueue, "", processApprovalDecision(awsRegion, message.SenderId, revokeRequest, accessPolicy)
+	default:
+		return revokeRequest.ResponseQueue, "", errors.WithStackTrace(UnknownRevokeAction(revokeRequest.Action))
+	}
+}
+
+// Approve or deny a certificate request, if the policy lets the sender decide on requests for the user it's for
+func processApprovalDecision(awsRegion string, senderId string, revokeRequest CertificateRevokeRequest, accessPolicy *policy.Policy) error {
+	request, err := readApprovalRequest(revokeRequest.RequestId)
 	if err != nil {
-		return revokeRequest.ResponseQueue, err
+		return err
 	}
 
-	if certificateAlreadyExists {
-		err := revokeCertificate(revokeRequest.Username)
+	if _, err := authorize(awsRegion, accessPolicy, senderId, policy.OPERATION_APPROVE, request.Username, request.Ttl); err != nil {
+		return err
+	}
+
+	return decideApprovalRequest(request.Id, revokeRequest.Action == APPROVE_ACTION, revokeRequest.Reason)
+}
+
+func processCertificateRevocation(revokeRequest CertificateRevokeRequest, crlValidity time.Duration) error {
+	reason := ""
+	if revokeRequest.Reason != "" {
+		var err error
+		reason, err = pki.NormalizeUserRevocationReason(revokeRequest.Reason)
 		if err != nil {
-			return revokeRequest.ResponseQueue, err
+			return err
 		}
+	}
 
-		return revokeRequest.ResponseQueue, nil
-	} else {
+	certificateAlreadyExists, err := indexContainsValidCertificate(revokeRequest.Username)
+	if err != nil {
+		return err
+	}
+
+	if !certificateAlreadyExists {
 		var doesNotExistError = fmt.Errorf("a valid certificate for %s does not exist", revokeRequest.Username)
-		return revokeRequest.ResponseQueue, errors.WithStackTrace(doesNotExistError)
+		return errors.WithStackTrace(doesNotExistError)
 	}
 
+	if err := revokeCertificate(revokeRequest.Username, reason, crlValidity); err != nil {
+		return err
+	}
+
+	// A user on hold keeps their static IP and routes for when they're released
+	if reason == pki.REASON_CERTIFICATE_HOLD || !clientConfigUsernameRegex.MatchString(revokeRequest.Username) {
+		return nil
+	}
+	return removeClientConfig(revokeRequest.Username)
 }
 
-func sendRevokeReply(awsRegion string, responseQueue string, error error) error {
+func sendRevokeReply(awsRegion string, responseQueue string, body string, error error) error {
 	logger := logging.GetLogger(LOGGER_NAME)
 
 	responseMessage := &CertificateRevokeResponse{}
 	responseMessage.Success = (error == nil)
 
-	if !responseMessage.Success {
+	if responseMessage.Success {
+		responseMessage.Body = body
+	} else {
 		responseMessage.ErrorMessage = error.Error()
 	}
 
@@ -110,3 +236,11 @@ func sendRevokeReply(awsRegion string, responseQueue string, error error) error
 	}
 	return nil
 }
+
+// Custom errors
+
+type UnknownRevokeAction string
+
+func (err UnknownRevokeAction) Error() string {
+	return fmt.Sprintf("Unknown action '%s' on the revocation queue", string(err))
+}
@@ -0,0 +1,10 @@
+package app
+
+import (
+	"github.com/urfave/cli"
+)
+
+// Lift the certificateHold on a certificate that was previously revoked with --reason certificateHold
+func requestCertificateRelease(cliContext *cli.Context) error {
+	return submitRevokeQueueRequest(cliContext, RELEASE_ACTION, "")
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/sessions"
+	"github.com/urfave/cli"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Ask the OpenVPN server which sessions were connected between --since and --until, and print them
+func reportSessionHistory(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+
+	now := time.Now()
+	since, until, err := getReportWindow(cliContext, now)
+	if err != nil {
+		return err
+	}
+
+	format, err := getReportFormat(cliContext)
+	if err != nil {
+		return err
+	}
+
+	username := cliContext.String(OPTION_USERNAME)
+	request := &CertificateRevokeRequest{Username: username, Action: REPORT_ACTION, Since: &since, Until: &until}
+
+	description := fmt.Sprintf("the sessions from %s to %s", since.Format(time.RFC3339), until.Format(time.RFC3339))
+	if username != "" {
+		description = fmt.Sprintf("%s of %s", description, username)
+	}
+
+	body, err := queryAdminQueue(cliContext, request, description)
+	if err != nil {
+		return err
+	}
+	records, err := decodeSessionRecords(body)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case REPORT_FORMAT_CSV:
+		return writeSessionsCsv(cliContext.App.Writer, records, now)
+	case REPORT_FORMAT_JSON:
+		return writeSessionsJson(cliContext.App.Writer, records)
+	default:
+		if len(records) == 0 {
+			fmt.Fprintf(cliContext.App.Writer, "No sessions from %s to %s\n", since.Format(time.RFC3339), until.Format(time.RFC3339))
+			return nil
+		}
+		return writeSessionsTable(cliContext.App.Writer, records, now)
+	}
+}
+
+func writeSessionsTable(out io.Writer, records []*sessions.Record, now time.Time) error {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "USERNAME\tINSTANCE\tVPN IP\tFROM\tSTART\tEND\tDURATION\tRECEIVED\tSENT")
+	for _, record := range records {
+		received, sent := "", ""
+		if record.End != nil {
+			received = strconv.FormatInt(record.BytesReceived, 10)
+			sent = strconv.FormatInt(record.BytesSent, 10)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.Username, record.Instance, record.VpnIp, record.TrustedIp, record.Start.Local().Format(time.RFC3339), describeSessionEnd(record), sessionDuration(record, now).Round(time.Second), received, sent)
+	}
+	return errors.WithStackTrace(writer.Flush())
+}
+
+func writeSessionsCsv(out io.Writer, records []*sessions.Record, now time.Time) error {
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"username", "serial", "instance", "vpn_ip", "trusted_ip", "trusted_port", "start", "end", "duration_seconds", "bytes_received", "bytes_sent", "end_reason"})
+	for _, record := range records {
+		port, end, received, sent := "", "", "", ""
+		if record.TrustedPort > 0 {
+			port = strconv.Itoa(record.TrustedPort)
+		}
+		if record.End != nil {
+			end = record.End.Local().Format(time.RFC3339)
+			received = strconv.FormatInt(record.BytesReceived, 10)
+			sent = strconv.FormatInt(record.BytesSent, 10)
+		}
+		writer.Write([]string{
+			record.Username,
+			record.Serial,
+			record.Instance,
+			record.VpnIp,
+			record.TrustedIp,
+			port,
+			record.Start.Local().Format(time.RFC3339),
+			end,
+			strconv.FormatInt(int64(sessionDuration(record, now)/time.Second), 10),
+			received,
+			sent,
+			record.EndReason,
+		})
+	}
+	writer.Flush()
+	return errors.WithStackTrace(writer.Error())
+}
+
+func writeSessionsJson(out io.Writer, records []*sessions.Record) error {
+	recordsJson, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	_, err = fmt.Fprintln(out, string(recordsJson))
+	return errors.WithStackTrace(err)
+}
+
+// When the session ended, or why that isn't known
+func describeSessionEnd(record *sessions.Record) string {
+	switch {
+	case record.End != nil:
+		return record.End.Local().Format(time.RFC3339)
+	case record.EndReason != "":
+		return "unknown"
+	default:
+		return "connected"
+	}
+}
+
+// How long the session lasted, or has lasted so far if it's still connected
+func sessionDuration(record *sessions.Record, now time.Time) time.Duration {
+	switch {
+	case record.End != nil:
+		return record.End.Sub(record.Start)
+	case record.EndReason != "":
+		return 0
+	default:
+		return now.Sub(record.Start)
+	}
+}
@@ -4,21 +4,37 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
 	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
 	"github.com/urfave/cli"
 	"io/ioutil"
+	"time"
 )
 
+// The actions that can be requested over the request queue. Requests from older clients have no action, and ask for a
+// new certificate.
+const NEW_CERTIFICATE_ACTION = ""
+const STATUS_ACTION = "status"
+const LIST_ACTION = "list"
+
+// How often a client waiting for its request to be approved asks the server about it
+const APPROVAL_POLL_INTERVAL = 30 * time.Second
+
 type CertificateRequest struct {
 	Username      string
 	ResponseQueue string
+	Action        string        `json:",omitempty"`
+	RequestId     string        `json:",omitempty"`
+	Ttl           time.Duration `json:",omitempty"`
 }
 
+// Status and RequestId are only set when the server requires approval for new certificates and hasn't issued one
 type CertificateResponse struct {
 	Success      bool
 	Body         string
 	ErrorMessage string
+	Status       string `json:",omitempty"`
+	RequestId    string `json:",omitempty"`
 }
 
 func requestNewCertificate(cliContext *cli.Context) error {
@@ -50,43 +66,81 @@ func requestNewCertificate(cliContext *cli.Context) error {
 		return err
 	}
 
-	//Create a new response queue
-	responseQueue, err := createResponseQueue(awsRegion)
+	approvalWait, err := getApprovalWait(cliContext)
 	if err != nil {
 		return err
 	}
-	defer deleteResponseQueue(awsRegion, responseQueue)
 
-	logger.Infof("Submitting request for new certificate to %s", responseQueue)
-	//Put a request for a new certificate on the requestQueue
-	err = sendRequest(awsRegion, requestUrl, username, responseQueue)
+	ttl, err := getTtl(cliContext)
 	if err != nil {
 		return err
 	}
 
-	// Wait for a reply from OpenVPN server on the responseQueue
-	logger.Info("Waiting for response from OpenVPN server")
-	receipt, response, err := waitForMessage(awsRegion, responseQueue, timeout)
+	//Create a new response queue
+	responseQueue, err := createResponseQueue(awsRegion)
 	if err != nil {
 		return err
 	}
+	defer deleteResponseQueue(awsRegion, responseQueue)
 
-	// Process the response
-	logger.Info("Response received from OpenVPN server")
-	err = processNewCertificateResponse(awsRegion, responseQueue, receipt, response, username)
-	if err != nil {
-		return err
+	request := &CertificateRequest{
+		Username:      username,
+		ResponseQueue: responseQueue,
+		Action:        NEW_CERTIFICATE_ACTION,
+		RequestId:     cliContext.String(OPTION_STATUS),
+		Ttl:           ttl,
+	}
+	if request.RequestId != "" {
+		request.Action = STATUS_ACTION
+	}
+
+	waitUntil := time.Now().Add(approvalWait)
+	for {
+		if request.Action == STATUS_ACTION {
+			logger.Infof("Checking on certificate request %s", request.RequestId)
+		} else {
+			logger.Infof("Submitting request for new certificate to %s", responseQueue)
+		}
+
+		//Put a request for a new certificate on the requestQueue
+		err = sendRequest(awsRegion, requestUrl, request)
+		if err != nil {
+			return err
+		}
+
+		// Wait for a reply from OpenVPN server on the responseQueue
+		logger.Info("Waiting for response from OpenVPN server")
+		receipt, response, err := waitForMessage(awsRegion, responseQueue, timeout)
+		if err != nil {
+			return err
+		}
+
+		// Process the response
+		logger.Info("Response received from OpenVPN server")
+		pendingRequestId, err := processNewCertificateResponse(awsRegion, responseQueue, receipt, response, username)
+		if err != nil {
+			return err
+		}
+		if pendingRequestId == "" {
+			break
+		}
+
+		if time.Now().Add(APPROVAL_POLL_INTERVAL).After(waitUntil) {
+			logger.Infof("Certificate request %s is waiting for an admin to approve it. Once it's approved, run 'openvpn-admin request --status %s' to get your configuration.", pendingRequestId, pendingRequestId)
+			return nil
+		}
+
+		logger.Infof("Certificate request %s is waiting for an admin to approve it. Checking again in %s.", pendingRequestId, APPROVAL_POLL_INTERVAL)
+		time.Sleep(APPROVAL_POLL_INTERVAL)
+		request.Action = STATUS_ACTION
+		request.RequestId = pendingRequestId
 	}
 
 	logger.Info("DONE")
 	return nil
 }
 
-func sendRequest(awsRegion string, requestUrl string, username string, responseQueue string) error {
-	req := &CertificateRequest{
-		Username:      username,
-		ResponseQueue: responseQueue,
-	}
+func sendRequest(awsRegion string, requestUrl string, req *CertificateRequest) error {
 	requestJson, _ := json.Marshal(req)
 
 	err := aws_helpers.SendMessageToQueue(awsRegion, requestUrl, string(requestJson))
@@ -96,22 +150,26 @@ func sendRequest(awsRegion string, requestUrl string, username string, responseQ
 	return nil
 }
 
-func processNewCertificateResponse(awsRegion string, resonseQueue string, receipt string, message string, username string) error {
+// Returns the id of the request if the server is waiting for an admin to approve it
+func processNewCertificateResponse(awsRegion string, resonseQueue string, receipt string, message string, username string) (string, error) {
 	response := CertificateResponse{}
 	json.Unmarshal([]byte(message), &response)
 
 	if !response.Success {
 		aws_helpers.DeleteMessageFromQueue(awsRegion, resonseQueue, receipt)
-		return errors.WithStackTrace(fmt.Errorf(response.ErrorMessage))
+		if response.Status == APPROVAL_STATUS_PENDING {
+			return response.RequestId, nil
+		}
+		return "", errors.WithStackTrace(fmt.Errorf(response.ErrorMessage))
 	} else {
 		err := createOvpnFile(username, response.Body)
 		if err != nil {
-			return err
+			return "", err
 		}
 		aws_helpers.DeleteMessageFromQueue(awsRegion, resonseQueue, receipt)
 	}
 
-	return nil
+	return "", nil
 }
 
 func createOvpnFile(username string, contents string) error {
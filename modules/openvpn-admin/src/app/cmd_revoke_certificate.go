@@ -4,30 +4,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
 	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
 	"github.com/urfave/cli"
+	"time"
 )
 
+// The actions that can be requested over the revocation queue, which only admins may send to
+const REVOKE_ACTION = "revoke"
+const RELEASE_ACTION = "release"
+const APPROVE_ACTION = "approve"
+const DENY_ACTION = "deny"
+const CLIENT_CONFIG_ACTION = "client-config"
+const REPORT_ACTION = "report"
+const EXPIRING_ACTION = "expiring"
+
 type CertificateRevokeRequest struct {
 	Username      string
 	ResponseQueue string
+	Action        string
+	Reason        string
+	RequestId     string     `json:",omitempty"`
+	StaticIp      string     `json:",omitempty"`
+	PushRoutes    []string   `json:",omitempty"`
+	Since         *time.Time `json:",omitempty"`
+	Until         *time.Time `json:",omitempty"`
 }
 
 type CertificateRevokeResponse struct {
 	Success      bool
 	ErrorMessage string
+	Body         string `json:",omitempty"`
 }
 
 func requestCertificateRevocation(cliContext *cli.Context) error {
-	setLoggerLevel(cliContext)
-	logger := logging.GetLogger(LOGGER_NAME)
-
-	awsRegion, err := getAwsRegion(cliContext)
+	reason, err := getRevocationReason(cliContext)
 	if err != nil {
 		return err
 	}
-	logger.Debugf("Using AWS Region: %s", awsRegion)
+
+	return submitRevokeQueueRequest(cliContext, REVOKE_ACTION, reason)
+}
+
+// Send a request about a user's certificate to the OpenVPN server over the revocation queue
+func submitRevokeQueueRequest(cliContext *cli.Context, action string, reason string) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
 
 	username, err := getUsername(cliContext, false)
 	if err != nil {
@@ -35,56 +57,73 @@ func requestCertificateRevocation(cliContext *cli.Context) error {
 	}
 	logger.Debugf("Using Username: %s", username)
 
+	request := &CertificateRevokeRequest{Username: username, Action: action, Reason: reason}
+	return submitAdminQueueRequest(cliContext, request, fmt.Sprintf("certificate %s for %s", action, username))
+}
+
+// Send a request to the OpenVPN server over the revocation queue and wait for the server's reply
+func submitAdminQueueRequest(cliContext *cli.Context, request *CertificateRevokeRequest, description string) error {
+	_, err := queryAdminQueue(cliContext, request, description)
+	return err
+}
+
+// Send a request to the OpenVPN server over the revocation queue, wait for the server's reply and return its body
+func queryAdminQueue(cliContext *cli.Context, request *CertificateRevokeRequest, description string) (string, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	awsRegion, err := getAwsRegion(cliContext)
+	if err != nil {
+		return "", err
+	}
+	logger.Debugf("Using AWS Region: %s", awsRegion)
+
 	logger.Info("Looking up SQS queue")
 	revokeUrl, err := getRevokeUrl(cliContext)
 	if err != nil {
-		return err
+		return "", err
 	}
 	logger.Debugf("Using Revoke URL: %s", revokeUrl)
 
 	timeout, err := getTimeout(cliContext)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	//Create a new response queue
 	logger.Info("Creating temporary SQS response queue")
 	responseQueue, err := createResponseQueue(awsRegion)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer deleteResponseQueue(awsRegion, responseQueue)
 
 	//Put a request for a new certificate revocation on the revokeQueue
-	logger.Infof("Requesting certificate revocation for %s on %s", username, revokeUrl)
-	err = sendRevoke(awsRegion, revokeUrl, username, responseQueue)
+	logger.Infof("Requesting %s on %s", description, revokeUrl)
+	request.ResponseQueue = responseQueue
+	err = sendRevoke(awsRegion, revokeUrl, request)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Wait for a reply from OpenVPN server on the responseQueue
 	logger.Info("Waiting for response from OpenVPN server")
 	receipt, response, err := waitForMessage(awsRegion, responseQueue, timeout)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Process the response
 	logger.Info("Response received from OpenVPN server")
-	err = processRevokeResponse(awsRegion, responseQueue, receipt, response, username)
+	body, err := processRevokeResponse(awsRegion, responseQueue, receipt, response)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	logger.Info("DONE")
-	return nil
+	return body, nil
 }
 
-func sendRevoke(awsRegion string, revokeQueue string, username string, responseQueue string) error {
-	req := &CertificateRevokeRequest{
-		Username:      username,
-		ResponseQueue: responseQueue,
-	}
+func sendRevoke(awsRegion string, revokeQueue string, req *CertificateRevokeRequest) error {
 	requestJson, _ := json.Marshal(req)
 
 	err := aws_helpers.SendMessageToQueue(awsRegion, revokeQueue, string(requestJson))
@@ -94,15 +133,15 @@ func sendRevoke(awsRegion string, revokeQueue string, username string, responseQ
 	return nil
 }
 
-func processRevokeResponse(awsRegion string, responseQueue string, receipt string, message string, username string) error {
+func processRevokeResponse(awsRegion string, responseQueue string, receipt string, message string) (string, error) {
 	response := CertificateRevokeResponse{}
 	json.Unmarshal([]byte(message), &response)
 
 	if !response.Success {
 		aws_helpers.DeleteMessageFromQueue(awsRegion, responseQueue, receipt)
-		return errors.WithStackTrace(fmt.Errorf(response.ErrorMessage))
+		return "", errors.WithStackTrace(fmt.Errorf(response.ErrorMessage))
 	}
 
 	aws_helpers.DeleteMessageFromQueue(awsRegion, responseQueue, receipt)
-	return nil
+	return response.Body, nil
 }
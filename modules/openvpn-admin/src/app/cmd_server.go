@@ -0,0 +1,54 @@
+package app
+
+import (
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/urfave/cli"
+	"strings"
+)
+
+// Write the config of each OpenVPN instance and the client profile template from the server settings in the --config
+// file, for a server whose PKI is already set up. Unlike init, this leaves the PKI, firewall and services alone.
+func renderServerConfig(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	config, err := getServerConfig(cliContext)
+	if err != nil {
+		return err
+	}
+
+	root, err := getRoot(cliContext)
+	if err != nil {
+		return err
+	}
+
+	initializer := &serverInitializer{Config: config, Root: root}
+	pkiLayout = pki.Layout{KeyDir: initializer.path(OPENVPN_PATH)}
+	easyRsaDir = initializer.path(CA_PATH)
+
+	spec, err := readKeySpec()
+	if err != nil {
+		return err
+	}
+
+	if err := initializer.writeServerConfig(spec); err != nil {
+		return err
+	}
+
+	for _, name := range initializer.removedInstances {
+		logger.Warnf("Stop the OpenVPN instance %s, which is no longer in %s, with: systemctl disable --now %s", name, config.Path, bootstrap.ListenerConfig{Name: name}.ServiceName())
+	}
+
+	switch {
+	case initializer.changes == 0:
+		logger.Infof("The OpenVPN server's config already matches %s. Nothing to change.", config.Path)
+	case initializer.restartNeeded:
+		instances := strings.Join(initializer.services()[1:], " ")
+		logger.Infof("Apply the new config with: systemctl enable %s && systemctl restart %s", instances, instances)
+	default:
+		logger.Infof("Client profiles issued from now on use the new %s", initializer.path(CLIENT_TEMPLATE_PATH))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// Run by OpenVPN through --tls-crypt-v2-verify before it processes a client's TLS handshake. OpenVPN passes the
+// metadata of the client key in the metadata_type and metadata_file environment variables, and refuses the client if
+// this exits with a non-zero status.
+func verifyTlsCryptV2Client(cliContext *cli.Context) error {
+	setLoggerLevel(cliContext)
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	metadataType, err := strconv.Atoi(os.Getenv("metadata_type"))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	metadata, err := ioutil.ReadFile(os.Getenv("metadata_file"))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	if err := verifyTlsCryptV2Metadata(metadataType, metadata); err != nil {
+		return err
+	}
+
+	logger.Debugf("Accepted the tls-crypt-v2 client key for certificate %s", string(metadata))
+	return nil
+}
@@ -2,12 +2,13 @@ package app
 
 import (
 	"fmt"
-	"github.com/gruntwork-io/gruntwork-cli/files"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/audit"
 	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -38,13 +39,26 @@ func waitForMessage(awsRegion string, queue string, timeout int) (string, string
 }
 
 func indexContainsValidCertificate(username string) (bool, error) {
-	indexFile, err := files.ReadFileAsString("/etc/openvpn/index.txt")
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
 	if err != nil {
 		return false, err
 	}
 
-	pattern := fmt.Sprintf("V\\s+.*(CN=%s)", username)
-	return regexp.MatchString(pattern, indexFile)
+	return len(index.FindByCommonName(username, pki.STATUS_VALID)) > 0, nil
+}
+
+func indexContainsCertificateOnHold(username string) (bool, error) {
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range index.FindByCommonName(username, pki.STATUS_REVOKED) {
+		if entry.RevocationReason == pki.REASON_CERTIFICATE_HOLD {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func getIpAddress() (string, error) {
@@ -68,9 +82,37 @@ func getIpAddress() (string, error) {
 	return ipaddress, nil
 }
 
+// The fields to log about a message from a queue, as worked out for its audit event
+func requestLogFields(queue string, event *audit.Event) logrus.Fields {
+	return logrus.Fields{
+		logging.FIELD_QUEUE:      queue,
+		logging.FIELD_OPERATION:  event.Action,
+		logging.FIELD_REQUEST_ID: event.RequestId,
+		logging.FIELD_MESSAGE_ID: event.MessageId,
+		logging.FIELD_USERNAME:   event.Target,
+	}
+}
+
+// Log the outcome of processing a message. A request that's waiting for approval is doing what it should.
+func logRequestResult(requestLogger *logrus.Entry, event *audit.Event, err error) {
+	switch {
+	case err == nil:
+		requestLogger.Infof("Processed %s request", event.Action)
+	case event.Result == audit.RESULT_PENDING:
+		requestLogger.Info(err.Error())
+	default:
+		requestLogger.WithError(err).Errorf("Failed to process %s request", event.Action)
+	}
+}
+
+// Waiting for a message and not getting one within the timeout isn't a problem, just a quiet queue
+func isNoMessagesError(err error) bool {
+	return strings.Contains(err.Error(), "Failed to receive messages")
+}
+
 func sleepOnFailedToReceiveMessages(err error) bool {
 	logger := logging.GetLogger(LOGGER_NAME)
-	if strings.Contains(err.Error(), "Failed to receive messages") {
+	if isNoMessagesError(err) {
 		logger.Warn(fmt.Sprintf("%s, sleeping for 30 seconds before retrying", err.Error()))
 		time.Sleep(time.Second * 30)
 		return true
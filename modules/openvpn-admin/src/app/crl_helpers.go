@@ -0,0 +1,227 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CRL that lives for a week limits how long a stolen copy can be replayed, while leaving time to fix a broken refresh
+// before OpenVPN starts rejecting everyone. process-revokes refreshes it daily by default.
+const DEFAULT_CRL_EXPIRATION_DAYS = 7
+const DEFAULT_CRL_REFRESH_INTERVAL = 24 * time.Hour
+
+// The user and group OpenVPN drops privileges to (see generate_server_conf in init-openvpn). OpenVPN re-reads the CRL
+// on every new connection, so it must stay readable by them.
+const OPENVPN_USER = "nobody"
+const OPENVPN_GROUP = "nogroup"
+
+// Serializes changes to the CA database and CRL, both within this process, e.g. a revocation arriving while the
+// scheduled CRL refresh is running, and with other processes, e.g. process-requests issuing a certificate while backup
+// takes a snapshot of the PKI
+var pkiLock = &pkiMutex{}
+
+type pkiMutex struct {
+	mutex      sync.Mutex
+	keyDirLock *pki.KeyDirLock
+}
+
+// Lock out the other goroutines of this process, then the other processes. Before the key dir exists, e.g. while init
+// creates the PKI, there's nothing for other processes to see, so only the other goroutines are locked out.
+func (lock *pkiMutex) Lock() {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	lock.mutex.Lock()
+	keyDirLock, err := pki.LockKeyDir(pkiLayout)
+	if err != nil {
+		logger.Debugf("Not locking %s against other processes: %s", pkiLayout.KeyDir, err)
+	}
+	lock.keyDirLock = keyDirLock
+}
+
+func (lock *pkiMutex) Unlock() {
+	if lock.keyDirLock != nil {
+		lock.keyDirLock.Unlock()
+		lock.keyDirLock = nil
+	}
+	lock.mutex.Unlock()
+}
+
+// Save the updated CA database and publish a new CRL that reflects it. We load the CA before touching anything on
+// disk so that a broken CA doesn't leave the database and the CRL out of sync.
+func writeIndexAndCrl(index *pki.Index, crlValidity time.Duration) error {
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		return err
+	}
+
+	if err := index.Write(); err != nil {
+		return err
+	}
+
+	return publishCrl(ca, index, crlValidity)
+}
+
+// Publish a fresh CRL from the current CA database, pushing its nextUpdate further into the future
+func refreshCrl(crlValidity time.Duration) error {
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		return err
+	}
+
+	return publishCrl(ca, index, crlValidity)
+}
+
+// Check that OpenVPN will be able to use the published CRL: it must parse, must not be past its nextUpdate, and must
+// be readable by the unprivileged user OpenVPN runs as
+func checkCrlHealth() (*pki.CrlStatus, error) {
+	path := pkiLayout.CrlPath()
+
+	status, err := pki.InspectCrl(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(UnhealthyCrl{Path: path, Reason: err.Error()})
+	}
+
+	if status.IsExpired(time.Now()) {
+		reason := fmt.Sprintf("it expired at %s", status.NextUpdate.Format(time.RFC3339))
+		return status, errors.WithStackTrace(UnhealthyCrl{Path: path, Reason: reason})
+	}
+
+	readable, err := isReadableBy(path, OPENVPN_USER, OPENVPN_GROUP)
+	if err != nil {
+		return status, errors.WithStackTrace(UnhealthyCrl{Path: path, Reason: err.Error()})
+	}
+	if !readable {
+		reason := fmt.Sprintf("it is not readable by the %s user", OPENVPN_USER)
+		return status, errors.WithStackTrace(UnhealthyCrl{Path: path, Reason: reason})
+	}
+
+	return status, nil
+}
+
+// Make sure the CRL is healthy before we start serving requests. If scheduled refreshes are enabled, we first try to
+// fix an expired CRL by publishing a new one.
+func assertCrlHealthyOnStartup(refreshInterval time.Duration, crlValidity time.Duration) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	_, err := checkCrlHealth()
+	if err == nil || refreshInterval == 0 {
+		return err
+	}
+
+	logger.Warnf("%s. Publishing a new CRL.", err.Error())
+	if err := refreshCrl(crlValidity); err != nil {
+		return err
+	}
+
+	_, err = checkCrlHealth()
+	return err
+}
+
+// NOTE: This method runs in an infinite loop, so call it in a goroutine
+func runScheduledCrlRefresh(refreshInterval time.Duration, crlValidity time.Duration) {
+	logger := logging.GetLogger(LOGGER_NAME)
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := refreshCrl(crlValidity); err != nil {
+			logger.Errorf("Failed to refresh the CRL: %s", err.Error())
+		}
+
+		status, err := checkCrlHealth()
+		if err != nil {
+			logger.Errorf("ALERT: %s", err.Error())
+			continue
+		}
+
+		logger.Infof("Refreshed CRL %s: %d revoked certificates, next update at %s", status.Number.String(), status.RevokedCount, status.NextUpdate.Format(time.RFC3339))
+	}
+}
+
+// The CRL validity to use when --crl-validity isn't given
+func defaultCrlValidity() time.Duration {
+	return time.Duration(getCrlExpirationDays()) * 24 * time.Hour
+}
+
+// init-openvpn records the CRL lifetime as default_crl_days in the easy-rsa OpenSSL config, so we read it from there
+// to stay consistent with CRLs generated by easy-rsa itself
+func getCrlExpirationDays() int {
+	config, err := files.ReadFileAsString(filepath.Join(easyRsaDir, EASY_RSA_CONFIG_FILE))
+	if err != nil {
+		return DEFAULT_CRL_EXPIRATION_DAYS
+	}
+
+	matches := defaultCrlDaysRegex.FindStringSubmatch(config)
+	if len(matches) < 2 {
+		return DEFAULT_CRL_EXPIRATION_DAYS
+	}
+
+	days, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return DEFAULT_CRL_EXPIRATION_DAYS
+	}
+	return days
+}
+
+// Record the CRL lifetime as default_crl_days in the easy-rsa OpenSSL config. Without easy-rsa installed, the config
+// only holds that setting. A config without the setting gets it at the start of its [ CA_default ] section, or in a new
+// [ CA_default ] section at the end. Returns true if the setting changed.
+func setCrlExpirationDays(days int) (bool, error) {
+	path := filepath.Join(easyRsaDir, EASY_RSA_CONFIG_FILE)
+	setting := fmt.Sprintf("default_crl_days= %d", days)
+
+	config := ""
+	if files.FileExists(path) {
+		existing, err := files.ReadFileAsString(path)
+		if err != nil {
+			return false, errors.WithStackTrace(err)
+		}
+		config = existing
+	}
+
+	switch {
+	case defaultCrlDaysRegex.MatchString(config):
+		config = defaultCrlDaysRegex.ReplaceAllLiteralString(config, setting)
+	case caDefaultSectionRegex.MatchString(config):
+		section := caDefaultSectionRegex.FindStringIndex(config)
+		config = config[:section[1]] + "\n" + setting + config[section[1]:]
+	default:
+		if config != "" && !strings.HasSuffix(config, "\n") {
+			config = config + "\n"
+		}
+		config = config + fmt.Sprintf("[ CA_default ]\n%s\n", setting)
+	}
+
+	return writeFileIfChanged(path, []byte(config), 0644)
+}
+
+var defaultCrlDaysRegex = regexp.MustCompile(`(?m)^[ \t]*default_crl_days[ \t]*=[ \t]*(\d+).*$`)
+var caDefaultSectionRegex = regexp.MustCompile(`(?m)^[ \t]*\[[ \t]*CA_default[ \t]*\][^\n]*`)
+
+// Custom errors
+
+type UnhealthyCrl struct {
+	Path   string
+	Reason string
+}
+
+func (err UnhealthyCrl) Error() string {
+	return fmt.Sprintf("The CRL %s is unusable by OpenVPN because %s", err.Path, err.Reason)
+}
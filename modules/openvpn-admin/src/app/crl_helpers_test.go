@@ -0,0 +1,59 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSetCrlExpirationDays(t *testing.T) {
+	testCases := []struct {
+		name     string
+		existing string
+		expected string
+	}{
+		{"no config", "", "[ CA_default ]\ndefault_crl_days= 7\n"},
+		{"existing setting", "[ CA_default ]\ndir = .\ndefault_crl_days= 3650\t# how long before next CRL\n", "[ CA_default ]\ndir = .\ndefault_crl_days= 7\n"},
+		{"section without setting", "[ ca ]\ndefault_ca = CA_default\n\n[ CA_default ]\ndir = .\n", "[ ca ]\ndefault_ca = CA_default\n\n[ CA_default ]\ndefault_crl_days= 7\ndir = .\n"},
+		{"no section", "[ req ]\ndefault_bits = 2048", "[ req ]\ndefault_bits = 2048\n[ CA_default ]\ndefault_crl_days= 7\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			useTestPki(t)
+			path := filepath.Join(easyRsaDir, EASY_RSA_CONFIG_FILE)
+			if testCase.existing != "" {
+				writeTestFile(t, path, testCase.existing)
+			}
+
+			changed, err := setCrlExpirationDays(7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Error("expected the setting to change")
+			}
+			if config := readTestFile(t, path); config != testCase.expected {
+				t.Errorf("expected config:\n%s\nbut got:\n%s", testCase.expected, config)
+			}
+			if days := getCrlExpirationDays(); days != 7 {
+				t.Errorf("expected to read back 7 days but got %d", days)
+			}
+
+			changed, err = setCrlExpirationDays(7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed {
+				t.Error("expected setting the same lifetime again to change nothing")
+			}
+		})
+	}
+}
+
+func TestGetCrlExpirationDaysDefaultsWithoutConfig(t *testing.T) {
+	useTestPki(t)
+
+	if days := getCrlExpirationDays(); days != DEFAULT_CRL_EXPIRATION_DAYS {
+		t.Errorf("expected the default of %d days but got %d", DEFAULT_CRL_EXPIRATION_DAYS, days)
+	}
+}
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/notify"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Valid certificates that expire within this long are expiring: they're reported as such by the metrics and listed by
+// the expiring command unless it's given a different --within
+const CERTIFICATE_EXPIRING_WINDOW = 30 * 24 * time.Hour
+
+// Which reminders have been sent for which certificates, so that restarting process-revokes doesn't send them again
+const EXPIRY_REMINDERS_PATH = OPENVPN_PATH + "/expiry-reminders.json"
+
+// How often process-revokes checks for certificates that are due a reminder
+const EXPIRY_SCAN_INTERVAL = 24 * time.Hour
+
+const DEFAULT_EXPIRY_REMINDER_DAYS = "30,7,1"
+
+// expiringCertificate is a valid certificate that expires soon. Renewed is set if its holder already has another valid
+// certificate that expires later.
+type expiringCertificate struct {
+	Username string
+	Serial   string
+	Expires  time.Time
+	DaysLeft int
+	Renewed  bool
+}
+
+// Find the valid certificates that expire within the given time, soonest first
+func findExpiringCertificates(within time.Duration, now time.Time) ([]expiringCertificate, error) {
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return nil, err
+	}
+
+	latestExpiry := map[string]time.Time{}
+	for _, entry := range index.Entries {
+		if entry.Status == pki.STATUS_VALID && entry.ExpirationTime.After(latestExpiry[entry.CommonName()]) {
+			latestExpiry[entry.CommonName()] = entry.ExpirationTime
+		}
+	}
+
+	expiring := []expiringCertificate{}
+	for _, entry := range index.Entries {
+		if entry.Status != pki.STATUS_VALID || !entry.ExpirationTime.After(now) || entry.ExpirationTime.After(now.Add(within)) {
+			continue
+		}
+		expiring = append(expiring, expiringCertificate{
+			Username: entry.CommonName(),
+			Serial:   entry.Serial,
+			Expires:  entry.ExpirationTime,
+			DaysLeft: daysUntil(entry.ExpirationTime, now),
+			Renewed:  latestExpiry[entry.CommonName()].After(entry.ExpirationTime),
+		})
+	}
+
+	sort.SliceStable(expiring, func(i, j int) bool { return expiring[i].Expires.Before(expiring[j].Expires) })
+	return expiring, nil
+}
+
+// Look up the certificates that an expiring request asks for, the ones that expire before its Until, and encode them
+// for the reply
+func reportExpiringCertificates(request CertificateRevokeRequest, now time.Time) (string, error) {
+	if request.Until == nil {
+		return "", errors.WithStackTrace(MissingExpiringWindow{})
+	}
+
+	expiring, err := findExpiringCertificates(request.Until.Sub(now), now)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := encodeReport(expiring)
+	if err != nil {
+		return "", err
+	}
+	if len(encoded) > MAX_REPORT_BYTES {
+		return "", errors.WithStackTrace(ExpiringReportTooLarge(len(expiring)))
+	}
+	return encoded, nil
+}
+
+// The number of days until the given time, counting part of a day as a day
+func daysUntil(expires time.Time, now time.Time) int {
+	return int(math.Ceil(expires.Sub(now).Hours() / 24))
+}
+
+func runScheduledExpiryReminders(notifier *notify.Notifier, reminderDays []int) {
+	logger := logging.GetLogger(LOGGER_NAME)
+	ticker := time.NewTicker(EXPIRY_SCAN_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if err := sendExpiryReminders(notifier, reminderDays, time.Now()); err != nil {
+			logger.Errorf("Failed to send certificate expiry reminders: %s", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Send a certificate.expiring notification for every user certificate that has reached one of the reminder days (e.g.
+// 30, 7 and 1 days before it expires) since its last reminder. Certificates whose holder has already renewed them are
+// skipped, as are the server's own certificates.
+func sendExpiryReminders(notifier *notify.Notifier, reminderDays []int, now time.Time) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	sent, err := readSentExpiryReminders()
+	if err != nil {
+		return err
+	}
+
+	expiring, err := findExpiringCertificates(time.Duration(reminderDays[0])*24*time.Hour, now)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	stillExpiring := map[string][]int{}
+
+	for _, certificate := range expiring {
+		if previous, ok := sent[certificate.Serial]; ok {
+			stillExpiring[certificate.Serial] = previous
+		}
+		if certificate.Renewed || certificate.Username == SERVER_COMMON_NAME || certificate.Username == pki.OCSP_RESPONDER_COMMON_NAME {
+			continue
+		}
+
+		// Only the most urgent reminder that's due is sent, so a certificate that was issued for less than 30 days
+		// doesn't get the 30 and 7 day reminders at once
+		due := 0
+		for _, days := range reminderDays {
+			if certificate.DaysLeft <= days {
+				due = days
+			}
+		}
+		if due == 0 || containsInt(sent[certificate.Serial], due) {
+			continue
+		}
+
+		expires := certificate.Expires
+		notification := &notify.Notification{
+			Event:    notify.EVENT_CERTIFICATE_EXPIRING,
+			Time:     now.UTC(),
+			Host:     hostname,
+			Username: certificate.Username,
+			Serial:   certificate.Serial,
+			Expires:  &expires,
+			Message:  "ask a VPN admin to replace it before then",
+		}
+
+		delivered, failures := notifier.Notify(notification)
+		for _, err := range failures {
+			logger.Errorf("Expiry reminder for %s: %s", certificate.Username, err)
+		}
+
+		// If no sink took the reminder, try again on the next scan
+		if len(delivered) == 0 {
+			continue
+		}
+		logger.Infof("Reminded %s that certificate %s expires in %d days", certificate.Username, certificate.Serial, certificate.DaysLeft)
+
+		for _, days := range reminderDays {
+			if days >= due && !containsInt(stillExpiring[certificate.Serial], days) {
+				stillExpiring[certificate.Serial] = append(stillExpiring[certificate.Serial], days)
+			}
+		}
+	}
+
+	// Certificates that have expired, been revoked or fallen outside of the reminder window are forgotten
+	return writeSentExpiryReminders(stillExpiring)
+}
+
+func readSentExpiryReminders() (map[string][]int, error) {
+	sent := map[string][]int{}
+
+	bytes, err := ioutil.ReadFile(EXPIRY_REMINDERS_PATH)
+	if os.IsNotExist(err) {
+		return sent, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	if err := json.Unmarshal(bytes, &sent); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return sent, nil
+}
+
+func writeSentExpiryReminders(sent map[string][]int) error {
+	bytes, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(ioutil.WriteFile(EXPIRY_REMINDERS_PATH, bytes, 0600))
+}
+
+// Parse a comma separated list of days, such as 30,7,1, into a list sorted from the most days to the fewest
+func parseReminderDays(value string) ([]int, error) {
+	days := []int{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		day, err := strconv.Atoi(field)
+		if err != nil || day <= 0 {
+			return nil, errors.WithStackTrace(InvalidReminderDays(value))
+		}
+		days = append(days, day)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days, nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Custom errors
+
+type MissingExpiringWindow struct{}
+
+func (err MissingExpiringWindow) Error() string {
+	return "A request for the expiring certificates must say the time to list the certificates that expire before."
+}
+
+type ExpiringReportTooLarge int
+
+func (count ExpiringReportTooLarge) Error() string {
+	return fmt.Sprintf("%d certificates expire in that time, which is too many to send in one reply. Use a shorter --%s.", int(count), OPTION_WITHIN)
+}
+
+type InvalidReminderDays string
+
+func (value InvalidReminderDays) Error() string {
+	return fmt.Sprintf("--%s must be a comma separated list of positive numbers of days, e.g. %s, but was '%s'", OPTION_EXPIRY_REMINDER_DAYS, DEFAULT_EXPIRY_REMINDER_DAYS, string(value))
+}
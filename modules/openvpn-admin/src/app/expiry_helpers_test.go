@@ -0,0 +1,71 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A CA database line for a certificate of the given user that expires at the given time
+func testIndexLine(status string, expires time.Time, serial string, username string) string {
+	revocation := ""
+	if status == "R" {
+		revocation = "240102030405Z"
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s\tunknown\t/CN=%s\n", status, expires.UTC().Format("060102150405Z"), revocation, serial, username)
+}
+
+func TestReportExpiringCertificatesListsThoseExpiringBeforeUntil(t *testing.T) {
+	useTestPki(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	day := 24 * time.Hour
+	writeTestFile(t, pkiLayout.IndexPath(), strings.Join([]string{
+		testIndexLine("V", now.Add(18*day), "02", "jane"),
+		testIndexLine("V", now.Add(200*day), "03", "jane"),
+		testIndexLine("V", now.Add(7*day), "01", "john"),
+		testIndexLine("R", now.Add(10*day), "04", "bob"),
+		testIndexLine("V", now.Add(60*day), "05", "alice"),
+		testIndexLine("V", now.Add(-day), "06", "carol"),
+	}, ""))
+
+	until := now.Add(30 * day)
+	body, err := reportExpiringCertificates(CertificateRevokeRequest{Action: EXPIRING_ACTION, Until: &until}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiring := []expiringCertificate{}
+	if err := decodeReport(body, &expiring); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []expiringCertificate{
+		{Username: "john", Serial: "01", Expires: now.Add(7 * day), DaysLeft: 7},
+		{Username: "jane", Serial: "02", Expires: now.Add(18 * day), DaysLeft: 18, Renewed: true},
+	}
+	if len(expiring) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, expiring)
+	}
+	for i := range expected {
+		if !expiring[i].Expires.Equal(expected[i].Expires) {
+			t.Errorf("Expected %s to expire at %s, got %s", expected[i].Serial, expected[i].Expires, expiring[i].Expires)
+		}
+		expiring[i].Expires = expected[i].Expires
+	}
+	if !reflect.DeepEqual(expiring, expected) {
+		t.Errorf("Expected %v, got %v", expected, expiring)
+	}
+}
+
+func TestReportExpiringCertificatesRefusesRequestWithoutUntil(t *testing.T) {
+	useTestPki(t)
+
+	_, err := reportExpiringCertificates(CertificateRevokeRequest{Action: EXPIRING_ACTION}, time.Now())
+	if _, ok := errors.Unwrap(err).(MissingExpiringWindow); !ok {
+		t.Fatalf("Expected MissingExpiringWindow, got %v", err)
+	}
+}
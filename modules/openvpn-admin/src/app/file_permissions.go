@@ -0,0 +1,114 @@
+//go:build !windows
+
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Return true if a process running as the given user and group could read the file at the given path. That means it
+// must be able to traverse every parent directory as well as read the file itself.
+func isReadableBy(path string, username string, groupname string) (bool, error) {
+	uid, gid, err := lookupUserAndGroup(username, groupname)
+	if err != nil {
+		return false, err
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+
+	for dir := filepath.Dir(absPath); ; dir = filepath.Dir(dir) {
+		ok, err := hasPermission(dir, uid, gid, 01)
+		if err != nil || !ok {
+			return false, err
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return hasPermission(absPath, uid, gid, 04)
+}
+
+// Check the given permission bit (04 read, 02 write, 01 execute) using the same owner, group, other precedence as the
+// kernel
+func hasPermission(path string, uid int, gid int, bit os.FileMode) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uid == 0 {
+		return true, nil
+	}
+
+	mode := info.Mode().Perm()
+	switch {
+	case int(stat.Uid) == uid:
+		return mode&(bit<<6) != 0, nil
+	case int(stat.Gid) == gid:
+		return mode&(bit<<3) != 0, nil
+	default:
+		return mode&bit != 0, nil
+	}
+}
+
+// Whether the file is owned by the user this process runs as
+func isOwnedByCurrentUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Geteuid()
+}
+
+// Give the user and group ownership of the given directory and everything in it, and let only them (and root) use it
+func restrictToUserAndGroup(dir string, username string, groupname string) error {
+	uid, gid, err := lookupUserAndGroup(username, groupname)
+	if err != nil {
+		return err
+	}
+
+	return errors.WithStackTrace(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chmod(path, 0770)
+	}))
+}
+
+// Some distros call the unprivileged group "nobody" rather than "nogroup", so fall back to the user's primary group
+func lookupUserAndGroup(username string, groupname string) (int, int, error) {
+	account, err := user.Lookup(username)
+	if err != nil {
+		return 0, 0, errors.WithStackTrace(err)
+	}
+
+	gidString := account.Gid
+	if group, err := user.LookupGroup(groupname); err == nil {
+		gidString = group.Gid
+	}
+
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return 0, 0, errors.WithStackTrace(err)
+	}
+
+	gid, err := strconv.Atoi(gidString)
+	if err != nil {
+		return 0, 0, errors.WithStackTrace(err)
+	}
+
+	return uid, gid, nil
+}
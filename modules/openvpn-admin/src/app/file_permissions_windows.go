@@ -0,0 +1,18 @@
+package app
+
+import (
+	"os"
+)
+
+// The OpenVPN server only runs on Linux, so there's nothing to check when openvpn-admin is used as a client on Windows
+func isReadableBy(path string, username string, groupname string) (bool, error) {
+	return true, nil
+}
+
+func isOwnedByCurrentUser(info os.FileInfo) bool {
+	return true
+}
+
+func restrictToUserAndGroup(dir string, username string, groupname string) error {
+	return nil
+}
@@ -4,10 +4,19 @@ import (
 	"fmt"
 	valid "github.com/asaskevich/govalidator"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
 	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/notify"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/policy"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const REQUEST_QUEUE_NAME_PREFIX = "openvpn-requests-"
@@ -18,6 +27,11 @@ func setLoggerLevel(cliContext *cli.Context) {
 	if debug {
 		logging.SetGlobalLogLevel(logrus.DebugLevel)
 	}
+
+	// Logging can't fail the command, so an unknown format falls back to text rather than losing the output
+	if err := logging.SetGlobalLogFormat(cliContext.String(OPTION_LOG_FORMAT)); err != nil {
+		logging.GetLogger(LOGGER_NAME).Warnf("%s. Logging as %s instead.", err, logging.FORMAT_TEXT)
+	}
 }
 
 func getAwsRegion(cliContext *cli.Context) (string, error) {
@@ -62,6 +76,209 @@ func getTimeout(cliContext *cli.Context) (int, error) {
 	return timeout, nil
 }
 
+// A negative wait makes no sense, so treat it as a mistake rather than as "don't wait"
+func getApprovalWait(cliContext *cli.Context) (time.Duration, error) {
+	wait := cliContext.Duration(OPTION_WAIT_FOR_APPROVAL)
+	if wait < 0 {
+		return 0, errors.WithStackTrace(fmt.Errorf("--%s must not be negative but was %s", OPTION_WAIT_FOR_APPROVAL, wait))
+	}
+	return wait, nil
+}
+
+func getApprovalSettings(cliContext *cli.Context) approvalSettings {
+	return approvalSettings{
+		Required:    cliContext.Bool(OPTION_REQUIRE_APPROVAL),
+		SnsTopicArn: cliContext.String(OPTION_APPROVAL_SNS_TOPIC_ARN),
+	}
+}
+
+func getTtl(cliContext *cli.Context) (time.Duration, error) {
+	ttl := cliContext.Duration(OPTION_TTL)
+	if ttl < 0 {
+		return 0, errors.WithStackTrace(fmt.Errorf("--%s must not be negative but was %s", OPTION_TTL, ttl))
+	}
+	return ttl, nil
+}
+
+// Load and check the policy file up front, so that a broken policy stops the server from starting. Returns nil if no
+// policy file was given.
+func getPolicy(cliContext *cli.Context) (*policy.Policy, error) {
+	path := cliContext.String(OPTION_POLICY_FILE)
+	if path == "" {
+		return nil, nil
+	}
+	return policy.Load(path)
+}
+
+func getNotifier(cliContext *cli.Context, awsRegion string) (*notify.Notifier, error) {
+	return openNotifier(awsRegion, cliContext.String(OPTION_NOTIFICATIONS_FILE))
+}
+
+func getInitConfig(cliContext *cli.Context) (*bootstrap.Config, error) {
+	path := cliContext.String(OPTION_CONFIG)
+	if path == "" {
+		return nil, errors.WithStackTrace(MissingInitConfig)
+	}
+	return bootstrap.Load(path)
+}
+
+func getServerConfig(cliContext *cli.Context) (*bootstrap.Config, error) {
+	path := cliContext.String(OPTION_CONFIG)
+	if path == "" {
+		return nil, errors.WithStackTrace(MissingInitConfig)
+	}
+	return bootstrap.LoadServer(path)
+}
+
+// The times report sessions covers. A session connected at any time between the two is in the report.
+func getReportWindow(cliContext *cli.Context, now time.Time) (time.Time, time.Time, error) {
+	since, err := parseReportTime(OPTION_SINCE, cliContext.String(OPTION_SINCE), now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	until := now
+	if value := cliContext.String(OPTION_UNTIL); value != "" {
+		until, err = parseReportTime(OPTION_UNTIL, value, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	if since.After(until) {
+		return time.Time{}, time.Time{}, errors.WithStackTrace(InvalidReportWindow{Since: since, Until: until})
+	}
+	return since, until, nil
+}
+
+func getReportFormat(cliContext *cli.Context) (string, error) {
+	format := cliContext.String(OPTION_FORMAT)
+	for _, known := range ReportFormats {
+		if format == known {
+			return format, nil
+		}
+	}
+	return "", errors.WithStackTrace(InvalidReportFormat(format))
+}
+
+// Checked when they're used, as backup and restore need different ones
+func getBackupSettings(cliContext *cli.Context) backupSettings {
+	return backupSettings{
+		AwsRegion:        cliContext.String(OPTION_AWS_REGION),
+		S3BucketName:     cliContext.String(OPTION_S3_BUCKET_NAME),
+		Url:              cliContext.String(OPTION_BACKUP_URL),
+		S3Endpoint:       cliContext.String(OPTION_S3_ENDPOINT),
+		SftpIdentityFile: cliContext.String(OPTION_SFTP_IDENTITY_FILE),
+		KmsKeyId:         cliContext.String(OPTION_KMS_KEY_ID),
+		AgeRecipients:    cliContext.StringSlice(OPTION_AGE_RECIPIENT),
+		AgeIdentityFile:  cliContext.String(OPTION_AGE_IDENTITY_FILE),
+		GpgRecipients:    cliContext.StringSlice(OPTION_GPG_RECIPIENT),
+	}
+}
+
+func getRoot(cliContext *cli.Context) (string, error) {
+	root, err := filepath.Abs(cliContext.String(OPTION_ROOT))
+	return root, errors.WithStackTrace(err)
+}
+
+func getExpiryReminderDays(cliContext *cli.Context) ([]int, error) {
+	return parseReminderDays(cliContext.String(OPTION_EXPIRY_REMINDER_DAYS))
+}
+
+func getAuditSettings(cliContext *cli.Context) auditSettings {
+	return auditSettings{
+		S3Bucket: cliContext.String(OPTION_AUDIT_S3_BUCKET),
+		LogGroup: cliContext.String(OPTION_AUDIT_LOG_GROUP),
+	}
+}
+
+func getPkiImportSource(cliContext *cli.Context) (string, error) {
+	source := cliContext.Args().First()
+	if source == "" || cliContext.NArg() > 1 {
+		return "", errors.WithStackTrace(MissingPkiImportSource)
+	}
+	return source, nil
+}
+
+func getPkiExportPath(cliContext *cli.Context) (string, error) {
+	bundlePath := cliContext.Args().First()
+	if bundlePath == "" || cliContext.NArg() > 1 {
+		return "", errors.WithStackTrace(MissingPkiExportPath)
+	}
+	return bundlePath, nil
+}
+
+func getApprovalRequestId(cliContext *cli.Context) (string, error) {
+	requestId := cliContext.Args().First()
+	if requestId == "" || cliContext.NArg() > 1 {
+		return "", errors.WithStackTrace(MissingApprovalRequestId)
+	}
+	return requestId, nil
+}
+
+func getRevocationReason(cliContext *cli.Context) (string, error) {
+	reason := cliContext.String(OPTION_REASON)
+	if reason == "" {
+		return "", nil
+	}
+
+	return pki.NormalizeUserRevocationReason(reason)
+}
+
+// Defaults to the default_crl_days that init-openvpn configured for easy-rsa
+func getCrlValidity(cliContext *cli.Context) (time.Duration, error) {
+	validity := cliContext.Duration(OPTION_CRL_VALIDITY)
+	if validity < 0 {
+		return 0, errors.WithStackTrace(InvalidCrlValidity(validity))
+	}
+	if validity == 0 {
+		validity = defaultCrlValidity()
+	}
+	return validity, nil
+}
+
+// The CRL must be refreshed well before it expires, otherwise there would be a window in which OpenVPN rejects everyone
+func getCrlRefreshInterval(cliContext *cli.Context, crlValidity time.Duration) (time.Duration, error) {
+	interval := cliContext.Duration(OPTION_CRL_REFRESH_INTERVAL)
+	if !cliContext.IsSet(OPTION_CRL_REFRESH_INTERVAL) && interval >= crlValidity {
+		interval = crlValidity / 2
+	}
+	if interval < 0 || (interval > 0 && interval >= crlValidity) {
+		return 0, errors.WithStackTrace(InvalidCrlRefreshInterval{Interval: interval, Validity: crlValidity})
+	}
+	return interval, nil
+}
+
+func getOcspListenAddress(cliContext *cli.Context) (string, error) {
+	port := cliContext.Int(OPTION_OCSP_PORT)
+	if port <= 0 || port > 65535 {
+		return "", errors.WithStackTrace(InvalidPort{Option: OPTION_OCSP_PORT, Port: port})
+	}
+
+	return net.JoinHostPort(cliContext.String(OPTION_OCSP_BIND_ADDRESS), strconv.Itoa(port)), nil
+}
+
+// Returns an empty address if metrics are disabled
+func getMetricsListenAddress(cliContext *cli.Context) (string, error) {
+	port := cliContext.Int(OPTION_METRICS_PORT)
+	if port == 0 {
+		return "", nil
+	}
+	if port < 0 || port > 65535 {
+		return "", errors.WithStackTrace(InvalidPort{Option: OPTION_METRICS_PORT, Port: port})
+	}
+
+	return net.JoinHostPort(cliContext.String(OPTION_METRICS_BIND_ADDRESS), strconv.Itoa(port)), nil
+}
+
+func getOcspResponseValidity(cliContext *cli.Context) (time.Duration, error) {
+	validity := cliContext.Duration(OPTION_OCSP_RESPONSE_VALIDITY)
+	if validity <= 0 {
+		return 0, errors.WithStackTrace(fmt.Errorf("--%s must be a positive duration", OPTION_OCSP_RESPONSE_VALIDITY))
+	}
+	return validity, nil
+}
+
 func getRequestUrl(cliContext *cli.Context) (string, error) {
 	var url string
 	var err error
@@ -163,3 +380,42 @@ type MultipleQueuesFoundWithPrefix struct {
 func (err MultipleQueuesFoundWithPrefix) Error() string {
 	return fmt.Sprintf("Expected to find exactly one queue with prefix '%s' but found %d: %v. Please specify which queue URL to use using the %s argument.", err.Prefix, len(err.QueueUrls), err.QueueUrls, err.ArgName)
 }
+
+type InvalidCrlValidity time.Duration
+
+func (err InvalidCrlValidity) Error() string {
+	return fmt.Sprintf("--%s must be a positive duration but was %s", OPTION_CRL_VALIDITY, time.Duration(err))
+}
+
+type InvalidCrlRefreshInterval struct {
+	Interval time.Duration
+	Validity time.Duration
+}
+
+func (err InvalidCrlRefreshInterval) Error() string {
+	return fmt.Sprintf("--%s (%s) must be a positive duration shorter than the CRL validity (%s)", OPTION_CRL_REFRESH_INTERVAL, err.Interval, err.Validity)
+}
+
+type InvalidPort struct {
+	Option string
+	Port   int
+}
+
+func (err InvalidPort) Error() string {
+	return fmt.Sprintf("--%s must be a port between 1 and 65535 but was %d", err.Option, err.Port)
+}
+
+type InvalidReportFormat string
+
+func (format InvalidReportFormat) Error() string {
+	return fmt.Sprintf("--%s must be one of %s, but was '%s'", OPTION_FORMAT, strings.Join(ReportFormats, ", "), string(format))
+}
+
+type InvalidReportWindow struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (err InvalidReportWindow) Error() string {
+	return fmt.Sprintf("--%s (%s) must not be after --%s (%s)", OPTION_SINCE, err.Since.Format(time.RFC3339), OPTION_UNTIL, err.Until.Format(time.RFC3339))
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The easy-rsa vars a test PKI is built with. EC keys keep the tests fast.
+const TEST_EASY_RSA_VARS = `export KEY_COUNTRY="US"
+export KEY_PROVINCE="AZ"
+export KEY_CITY="Phoenix"
+export KEY_ORG="Acme"
+export KEY_EMAIL="security@acme.com"
+export KEY_OU="VPN"
+export KEY_ALGORITHM="ecdsa-p256"
+export CA_EXPIRE="30"
+export KEY_EXPIRE="30"
+`
+
+// Point the PKI at an empty key dir and easy-rsa dir in a temp dir for the duration of the test
+func useTestPki(t *testing.T) {
+	t.Helper()
+
+	originalLayout, originalEasyRsaDir := pkiLayout, easyRsaDir
+	t.Cleanup(func() {
+		pkiLayout, easyRsaDir = originalLayout, originalEasyRsaDir
+	})
+
+	root := t.TempDir()
+	pkiLayout = pki.Layout{KeyDir: filepath.Join(root, "openvpn")}
+	easyRsaDir = filepath.Join(root, "openvpn-ca")
+
+	for _, dir := range []string{pkiLayout.KeyDir, easyRsaDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeTestFile(t, filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE), TEST_EASY_RSA_VARS)
+	writeTestFile(t, pkiLayout.IndexPath(), "")
+	writeTestFile(t, pkiLayout.SerialPath(), "01\n")
+}
+
+func writeTestFile(t *testing.T, path string, contents string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
@@ -0,0 +1,943 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/audit"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/backup"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where install-openvpn installs the templates for the client profile and the firewall rules
+const INSTALL_FILES_PATH = "/gruntwork/install-openvpn"
+const CLIENT_TEMPLATE_FILE = "openvpn-client.ovpn"
+const UFW_DEFAULTS_FILE = "ufw-default"
+const UFW_BEFORE_RULES_FILE = "before.rules"
+
+// easy-rsa 2, as installed by install-openvpn. Its scripts are linked into the easy-rsa dir, as make-cadir does.
+const EASY_RSA_INSTALL_PATH = "/usr/share/easy-rsa"
+
+const CLIENT_TEMPLATE_PATH = OPENVPN_PATH + "/" + CLIENT_TEMPLATE_FILE
+const UFW_DEFAULTS_PATH = "/etc/default/ufw"
+const UFW_BEFORE_RULES_PATH = "/etc/ufw/before.rules"
+const SYSCTL_CONF_PATH = "/etc/sysctl.d/99-openvpn.conf"
+const BACKUP_CRON_JOB_PATH = "/etc/cron.hourly/backup-openvpn-pki"
+const HOOK_SUDOERS_PATH = "/etc/sudoers.d/openvpn-admin"
+
+// OpenVPN runs the client-connect and client-disconnect hooks with sudo, as it runs as nobody by then
+const SUDO_PATH = "/usr/bin/sudo"
+
+// Paths sudoers takes as they are, without escaping
+var sudoersCommandRegex = regexp.MustCompile(`^/[A-Za-z0-9/._+-]+$`)
+
+// systemd-resolved's stub resolver listens on 127.0.0.53, so the nameservers to push to clients are in its own
+// resolv.conf instead
+const RESOLV_CONF_PATH = "/etc/resolv.conf"
+const SYSTEMD_RESOLVED_RESOLV_CONF_PATH = "/run/systemd/resolve/resolv.conf"
+const SYSTEMD_RESOLVED_STUB_ADDRESS = "127.0.0.53"
+
+// backup-openvpn-pki, which took backups before openvpn-admin backup, kept the key dir and vars.local under this
+// prefix in the backup bucket
+const BACKUP_S3_PREFIX = "server/"
+
+// serverInitializer brings a server in line with an init config file. Every step checks what's already there and only
+// changes what differs, so running it again converges rather than starting over. With a root other than /, all files
+// are written under that root and the running system (sysctl, UFW and services) is left alone.
+type serverInitializer struct {
+	Config    *bootstrap.Config
+	Root      string
+	AwsRegion string
+
+	changes       int
+	restartNeeded bool
+
+	// The OpenVPN instances whose config was removed because the config file no longer has a listener for them
+	removedInstances []string
+}
+
+func (initializer *serverInitializer) run() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if err := initializer.checkPrerequisites(); err != nil {
+		return err
+	}
+
+	pkiLayout = pki.Layout{KeyDir: initializer.path(OPENVPN_PATH)}
+	easyRsaDir = initializer.path(CA_PATH)
+
+	created, err := initializer.preparePki()
+	if err != nil {
+		return err
+	}
+	if created && initializer.Config.Backup != nil {
+		if err := initializer.backUpPki(); err != nil {
+			return err
+		}
+	}
+
+	spec, err := readKeySpec()
+	if err != nil {
+		return err
+	}
+
+	if err := initializer.writeServerConfig(spec); err != nil {
+		return err
+	}
+
+	if os.Geteuid() == 0 {
+		if err := restrictToUserAndGroup(pkiLayout.KeyDir, OPENVPN_USER, OPENVPN_GROUP); err != nil {
+			return err
+		}
+	} else {
+		logger.Warnf("Not running as root, so %s is left owned by the current user rather than %s", pkiLayout.KeyDir, OPENVPN_USER)
+	}
+
+	firewallChanged, err := initializer.writeNetworkConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := initializer.writeBackupCronJob(); err != nil {
+		return err
+	}
+
+	if err := initializer.applyToRunningSystem(firewallChanged); err != nil {
+		return err
+	}
+
+	if initializer.changes == 0 {
+		logger.Infof("The OpenVPN server is already set up as described in %s. Nothing to change.", initializer.Config.Path)
+	} else {
+		logger.Infof("Made %d changes to set up the OpenVPN server as described in %s", initializer.changes, initializer.Config.Path)
+	}
+	return nil
+}
+
+// Check for everything init needs before changing anything, so that a missing tool doesn't leave a half set up server
+func (initializer *serverInitializer) checkPrerequisites() error {
+	if initializer.isLive() && os.Geteuid() != 0 {
+		return errors.WithStackTrace(InitRequiresRoot{})
+	}
+	if initializer.Config.Backup != nil && initializer.Config.Backup.UsesAws() && initializer.AwsRegion == "" {
+		return errors.WithStackTrace(MissingAwsRegion)
+	}
+
+	for _, file := range []string{CLIENT_TEMPLATE_FILE, UFW_DEFAULTS_FILE, UFW_BEFORE_RULES_FILE} {
+		installedPath := initializer.path(filepath.Join(INSTALL_FILES_PATH, file))
+		if !files.FileExists(installedPath) {
+			return errors.WithStackTrace(MissingInstallFile(installedPath))
+		}
+	}
+
+	commands := []string{}
+	if strings.ToLower(initializer.Config.Pki.KeyAlgorithm) == pki.KEY_ALGORITHM_RSA {
+		commands = append(commands, "openssl")
+	}
+	if backupConfig := initializer.Config.Backup; backupConfig != nil {
+		if len(backupConfig.AgeRecipients) > 0 {
+			commands = append(commands, "age")
+		}
+		if len(backupConfig.GpgRecipients) > 0 {
+			commands = append(commands, "gpg")
+		}
+		if strings.HasPrefix(backupConfig.Url, backup.SCHEME_SFTP+"://") {
+			commands = append(commands, "sftp")
+		}
+	}
+	if initializer.isLive() {
+		commands = append(commands, "sysctl", "ufw", "systemctl")
+		commands = append(commands, SUDO_PATH)
+		if len(initializer.Config.Server.AccessGroups) > 0 {
+			commands = append(commands, "iptables")
+		}
+	}
+	for _, command := range commands {
+		if _, err := exec.LookPath(command); err != nil {
+			return errors.WithStackTrace(MissingCommand(command))
+		}
+	}
+
+	return nil
+}
+
+// Restore the PKI from the backup bucket if it isn't on this server yet, or create it if there's no backup either.
+// Returns true if a new CA was created.
+func (initializer *serverInitializer) preparePki() (bool, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if err := os.MkdirAll(easyRsaDir, 0700); err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+	if err := os.MkdirAll(pkiLayout.KeyDir, 0770); err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+
+	if err := initializer.linkEasyRsa(); err != nil {
+		return false, err
+	}
+
+	if !files.FileExists(pkiLayout.CaCertPath()) && initializer.Config.Backup != nil {
+		if err := initializer.restorePki(); err != nil {
+			return false, err
+		}
+	}
+
+	if err := initializer.writeEasyRsaVars(); err != nil {
+		return false, err
+	}
+
+	// An empty CA database, as easy-rsa's clean-all creates
+	if !files.FileExists(pkiLayout.IndexPath()) {
+		if err := ioutil.WriteFile(pkiLayout.IndexPath(), nil, 0600); err != nil {
+			return false, errors.WithStackTrace(err)
+		}
+	}
+	if !files.FileExists(pkiLayout.SerialPath()) {
+		if err := ioutil.WriteFile(pkiLayout.SerialPath(), []byte("01\n"), 0600); err != nil {
+			return false, errors.WithStackTrace(err)
+		}
+	}
+
+	created := false
+	if !files.FileExists(pkiLayout.CaCertPath()) {
+		err := buildCa()
+		initializer.audit(AUDIT_ACTION_BUILD_CA, "", err)
+		if err != nil {
+			return false, err
+		}
+		initializer.changed("Created the CA %s", pkiLayout.CaCertPath())
+		initializer.restartNeeded = true
+		created = true
+	}
+
+	if !files.FileExists(pkiLayout.CertPath(SERVER_COMMON_NAME)) {
+		err := buildServerCertificate()
+		initializer.audit(AUDIT_ACTION_BUILD_SERVER, SERVER_COMMON_NAME, err)
+		if err != nil {
+			return false, err
+		}
+		initializer.changed("Issued the server certificate %s", pkiLayout.CertPath(SERVER_COMMON_NAME))
+		initializer.restartNeeded = true
+	}
+
+	spec, err := readKeySpec()
+	if err != nil {
+		return false, err
+	}
+
+	// easy-rsa's build-dh did the same. This takes a long time, and elliptic curve PKIs don't need it.
+	if dhParamsFile := bootstrap.DhParamsFile(spec); dhParamsFile != "" && !files.FileExists(filepath.Join(pkiLayout.KeyDir, dhParamsFile)) {
+		logger.Infof("Generating %d bit Diffie-Hellman parameters. This can take 10 minutes or more.", spec.RsaBits)
+		if err := runCommand("openssl", "dhparam", "-out", filepath.Join(pkiLayout.KeyDir, dhParamsFile), strconv.Itoa(spec.RsaBits)); err != nil {
+			return false, err
+		}
+		initializer.changed("Generated the Diffie-Hellman parameters %s", dhParamsFile)
+		initializer.restartNeeded = true
+	}
+
+	if err := initializer.enableTlsCryptV2(); err != nil {
+		return false, err
+	}
+
+	if err := initializer.publishCrl(); err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+// Link easy-rsa's scripts into the easy-rsa dir and copy its config files, as make-cadir does. Skipped if easy-rsa
+// isn't installed, as openvpn-admin doesn't need it.
+func (initializer *serverInitializer) linkEasyRsa() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	installPath := initializer.path(EASY_RSA_INSTALL_PATH)
+	entries, err := ioutil.ReadDir(installPath)
+	if os.IsNotExist(err) {
+		logger.Debugf("%s does not exist. Not linking the easy-rsa scripts into %s.", installPath, easyRsaDir)
+		return nil
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	for _, entry := range entries {
+		target := filepath.Join(easyRsaDir, entry.Name())
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+
+		if entry.Name() == "vars" || filepath.Ext(entry.Name()) == ".cnf" {
+			contents, err := ioutil.ReadFile(filepath.Join(installPath, entry.Name()))
+			if err != nil {
+				return errors.WithStackTrace(err)
+			}
+			if err := ioutil.WriteFile(target, contents, 0644); err != nil {
+				return errors.WithStackTrace(err)
+			}
+		} else if err := os.Symlink(path.Join(EASY_RSA_INSTALL_PATH, entry.Name()), target); err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+
+	return nil
+}
+
+// Restore the latest backup of the PKI, so that the certificates issued by a previous server keep working. Does
+// nothing if there are no backups yet.
+func (initializer *serverInitializer) restorePki() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+	settings := initializer.backupSettings()
+
+	versions, err := listBackupVersions(settings)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 && settings.S3BucketName != "" {
+		return initializer.restoreLegacyPki()
+	}
+	if len(versions) == 0 {
+		logger.Infof("There are no backups of the PKI yet. Creating a new one.")
+		return nil
+	}
+
+	version := versions[len(versions)-1]
+	err = restoreBackup(settings, version, false)
+	initializer.audit(AUDIT_ACTION_RESTORE, version, err)
+	if err != nil {
+		return err
+	}
+
+	initializer.changed("Restored the PKI from backup version %s", version)
+	initializer.restartNeeded = true
+	return nil
+}
+
+// Restore the PKI from the files backup-openvpn-pki backed up one by one, for servers whose PKI hasn't been backed up
+// with openvpn-admin backup yet
+func (initializer *serverInitializer) restoreLegacyPki() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+	bucket := initializer.Config.Backup.S3BucketName
+
+	keys, err := aws_helpers.ListS3Objects(initializer.AwsRegion, bucket, BACKUP_S3_PREFIX)
+	if err != nil {
+		return err
+	}
+	if !containsString(keys, BACKUP_S3_PREFIX+"ca.crt") {
+		logger.Infof("There is no PKI in s3://%s/%s yet. Creating a new one.", bucket, BACKUP_S3_PREFIX)
+		return nil
+	}
+
+	logger.Infof("Restoring the PKI from s3://%s/%s", bucket, BACKUP_S3_PREFIX)
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, BACKUP_S3_PREFIX)
+
+		var target string
+		switch {
+		case name == EASY_RSA_VARS_FILE:
+			target = filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE)
+		case isBackedUpPkiFile(name):
+			target = filepath.Join(pkiLayout.KeyDir, filepath.FromSlash(name))
+		default:
+			continue
+		}
+
+		contents, err := aws_helpers.GetS3Object(initializer.AwsRegion, bucket, key)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0770); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		if err := ioutil.WriteFile(target, contents, 0600); err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+
+	initializer.changed("Restored the PKI from s3://%s/%s", bucket, BACKUP_S3_PREFIX)
+	initializer.restartNeeded = true
+	return nil
+}
+
+// The files in the key dir that are backed up: certificates, keys, the CA database, CA rotation state, pending
+// approvals, client configs and the audit log. The rest of the key dir, such as server.conf, is written by init.
+func isBackedUpPkiFile(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "..") {
+		return false
+	}
+
+	switch filepath.Ext(name) {
+	case ".crt", ".key", ".pem", ".csr":
+		return true
+	}
+
+	switch name {
+	case "serial", "serial.old", "index.txt", "index.txt.old", "index.txt.attr", "crlnumber", "ca-rotation.json", "audit.log":
+		return true
+	}
+
+	if matched, _ := path.Match("ccd/*/*", name); matched {
+		return true
+	}
+	matched, _ := path.Match(path.Base(APPROVALS_PATH)+"/*.json", name)
+	return matched
+}
+
+// Write vars.local from the config. The settings that can't change once the CA exists keep their current values.
+func (initializer *serverInitializer) writeEasyRsaVars() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	varsPath := filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE)
+	vars := initializer.Config.Pki.EasyRsaVars(OPENVPN_PATH, SERVER_COMMON_NAME)
+
+	if files.FileExists(pkiLayout.CaCertPath()) && files.FileExists(varsPath) {
+		existing, err := pki.ReadEasyRsaVars(varsPath)
+		if err != nil {
+			return err
+		}
+
+		kept := []string{}
+		for _, name := range bootstrap.ImmutableEasyRsaVars {
+			// vars files from before KEY_ALGORITHM existed are RSA
+			if existing[name] != vars[name] && !(name == "KEY_ALGORITHM" && existing[name] == "" && vars[name] == pki.KEY_ALGORITHM_RSA) {
+				kept = append(kept, name)
+			}
+			vars[name] = existing[name]
+		}
+		if len(kept) > 0 {
+			logger.Warnf("The PKI in %s already exists, so it keeps its %s rather than the ones in %s", pkiLayout.KeyDir, strings.Join(kept, ", "), initializer.Config.Path)
+		}
+	}
+
+	contents, err := bootstrap.RenderEasyRsaVars(vars)
+	if err != nil {
+		return err
+	}
+	return initializer.writeFile(varsPath, contents, 0644, false)
+}
+
+// Create the tls-crypt-v2 server key for a new PKI. A PKI that was created before tls-crypt-v2 support has client
+// profiles without a tls-crypt-v2 key, and enabling it would lock them out, so those keep working without it.
+func (initializer *serverInitializer) enableTlsCryptV2() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if isTlsCryptV2Enabled() {
+		return nil
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+	for _, entry := range index.Entries {
+		if entry.CommonName() != SERVER_COMMON_NAME && entry.CommonName() != pki.OCSP_RESPONDER_COMMON_NAME {
+			logger.Warnf("%s does not exist and the PKI has already issued client certificates. Not enabling tls-crypt-v2.", pkiLayout.TlsCryptV2ServerKeyPath())
+			return nil
+		}
+	}
+
+	if err := pki.GenerateTlsCryptV2ServerKey(pkiLayout.TlsCryptV2ServerKeyPath()); err != nil {
+		return err
+	}
+	initializer.changed("Generated the tls-crypt-v2 server key %s", pkiLayout.TlsCryptV2ServerKeyPath())
+	initializer.restartNeeded = true
+	return nil
+}
+
+// Record the CRL lifetime and publish a CRL if there isn't a usable one, or if its lifetime changed
+func (initializer *serverInitializer) publishCrl() error {
+	days := initializer.Config.Pki.CrlExpirationDays
+
+	daysChanged, err := setCrlExpirationDays(days)
+	if err != nil {
+		return err
+	}
+
+	status, err := pki.InspectCrl(pkiLayout.CrlPath())
+	if err == nil && !daysChanged && !status.IsExpired(time.Now()) {
+		return nil
+	}
+
+	err = refreshCrl(time.Duration(days) * 24 * time.Hour)
+	initializer.audit(AUDIT_ACTION_REFRESH_CRL, "", err)
+	if err != nil {
+		return err
+	}
+	initializer.changed("Published a CRL that is valid for %d days", days)
+	return nil
+}
+
+// Back up a newly created PKI straight away, rather than waiting for the hourly cron job
+func (initializer *serverInitializer) backUpPki() error {
+	_, err := createBackup(initializer.backupSettings())
+	return err
+}
+
+func (initializer *serverInitializer) backupSettings() backupSettings {
+	backupConfig := initializer.Config.Backup
+	return backupSettings{
+		AwsRegion:        initializer.AwsRegion,
+		S3BucketName:     backupConfig.S3BucketName,
+		Url:              backupConfig.Url,
+		S3Endpoint:       backupConfig.S3Endpoint,
+		SftpIdentityFile: backupConfig.SftpIdentityFile,
+		KmsKeyId:         backupConfig.KmsKeyId,
+		AgeRecipients:    backupConfig.AgeRecipients,
+		AgeIdentityFile:  backupConfig.AgeIdentityFile,
+		GpgRecipients:    backupConfig.GpgRecipients,
+	}
+}
+
+// Write the config of each listener's OpenVPN instance, and the client profile template that process-requests fills in
+// for each user
+func (initializer *serverInitializer) writeServerConfig(spec pki.KeySpec) error {
+	dnsServers, err := initializer.dnsServers()
+	if err != nil {
+		return err
+	}
+	confs := bootstrap.NewServerConfs(&initializer.Config.Server, spec, dnsServers)
+
+	if err := initializer.writeHookConfig(); err != nil {
+		return err
+	}
+
+	for _, conf := range confs {
+		// openvpn-admin refuses the tls-crypt-v2 key of each client whose certificate is no longer valid
+		if isTlsCryptV2Enabled() {
+			conf.TlsCryptV2VerifyCommand = fmt.Sprintf("%s tls-crypt-v2 verify", openVpnAdminPath())
+		}
+		conf.HookCommand = fmt.Sprintf("%s hook", openVpnAdminPath())
+		conf.HookTmpDir = HOOK_TMP_DIR
+
+		contents, err := bootstrap.RenderServerConf(conf)
+		if err != nil {
+			return err
+		}
+		if err := initializer.writeFile(initializer.serverConfPath(conf.Listener), contents, 0644, true); err != nil {
+			return err
+		}
+
+		// OpenVPN refuses to start if its client config dir doesn't exist, even if no client has a config file
+		if err := os.MkdirAll(pkiLayout.ClientConfigDir(conf.Listener.Name), 0755); err != nil {
+			return errors.WithStackTrace(err)
+		}
+	}
+
+	if err := initializer.removeStaleServerConfs(); err != nil {
+		return err
+	}
+
+	baseTemplate, err := ioutil.ReadFile(initializer.path(filepath.Join(INSTALL_FILES_PATH, CLIENT_TEMPLATE_FILE)))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	clientTemplate := bootstrap.RenderClientTemplate(string(baseTemplate), confs)
+	return initializer.writeFile(initializer.path(CLIENT_TEMPLATE_PATH), []byte(clientTemplate), 0644, false)
+}
+
+// Write the sudoers file that lets the client-connect and client-disconnect hooks start and end sessions as root, create
+// the directory OpenVPN passes client-connect files in, and write the access groups and connection policy for the hooks
+// and process-requests to read. Those two files are removed when the config file
+// doesn't have them.
+func (initializer *serverInitializer) writeHookConfig() error {
+	// A sudoers file sudo can't parse breaks sudo for everyone, so don't write one with a path that needs escaping
+	path := openVpnAdminPath()
+	if !sudoersCommandRegex.MatchString(path) {
+		return errors.WithStackTrace(UnsupportedHookPath(path))
+	}
+	if err := initializer.writeFile(initializer.path(HOOK_SUDOERS_PATH), bootstrap.RenderHookSudoers(path, OPENVPN_USER), 0440, false); err != nil {
+		return err
+	}
+
+	// OpenVPN refuses to start if its --tmp-dir doesn't exist. It's in the key dir, so it's given to the OpenVPN user
+	// along with the rest of the key dir.
+	if err := os.MkdirAll(initializer.path(HOOK_TMP_DIR), 0770); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	server := initializer.Config.Server
+
+	var accessGroups []byte
+	if len(server.AccessGroups) > 0 {
+		rendered, err := bootstrap.RenderAccessGroups(server.AccessGroups)
+		if err != nil {
+			return err
+		}
+		accessGroups = rendered
+	}
+	if err := initializer.writeOrRemoveFile(initializer.path(ACCESS_GROUPS_PATH), accessGroups, "access groups"); err != nil {
+		return err
+	}
+
+	var connectionPolicy []byte
+	if server.ConnectionPolicy != nil {
+		rendered, err := bootstrap.RenderConnectionPolicy(server.ConnectionPolicy)
+		if err != nil {
+			return err
+		}
+		connectionPolicy = rendered
+	}
+	return initializer.writeOrRemoveFile(initializer.path(CONNECTION_POLICY_PATH), connectionPolicy, "connection policy")
+}
+
+// Write a file that's only there when the config file has the given setting, or remove it if contents is nil
+func (initializer *serverInitializer) writeOrRemoveFile(path string, contents []byte, setting string) error {
+	if contents != nil {
+		return initializer.writeFile(path, contents, 0644, false)
+	}
+
+	if !files.FileExists(path) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	initializer.changed("Removed %s, as there is no %s in %s", path, setting, initializer.Config.Path)
+	return nil
+}
+
+// Remove the configs init wrote for listeners that are no longer in the config file, e.g. server.conf after moving
+// from a single listener to several. OpenVPN configs that init didn't write are left alone.
+func (initializer *serverInitializer) removeStaleServerConfs() error {
+	paths, err := filepath.Glob(filepath.Join(initializer.path(OPENVPN_PATH), "*.conf"))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".conf")
+		if initializer.hasListener(name) {
+			continue
+		}
+
+		contents, err := files.ReadFileAsString(path)
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		if !bootstrap.IsRenderedServerConf(contents) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		initializer.changed("Removed %s, as %s no longer has a listener named %s", path, initializer.Config.Path, name)
+		initializer.removedInstances = append(initializer.removedInstances, name)
+	}
+	return nil
+}
+
+func (initializer *serverInitializer) hasListener(name string) bool {
+	for _, listener := range initializer.Config.Server.AllListeners() {
+		if listener.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The systemd services that run the server: the openvpn-admin daemons and an OpenVPN instance for each listener
+func (initializer *serverInitializer) services() []string {
+	services := []string{"supervisor"}
+	for _, listener := range initializer.Config.Server.AllListeners() {
+		services = append(services, listener.ServiceName())
+	}
+	return services
+}
+
+// Where the config of the listener's OpenVPN instance goes
+func (initializer *serverInitializer) serverConfPath(listener bootstrap.ListenerConfig) string {
+	return initializer.path(filepath.Join(OPENVPN_PATH, listener.ConfFile()))
+}
+
+// The DNS servers to push to clients. Defaults to the nameservers the server itself uses.
+func (initializer *serverInitializer) dnsServers() ([]string, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if len(initializer.Config.Server.DnsServers) > 0 {
+		return initializer.Config.Server.DnsServers, nil
+	}
+
+	resolvConf, err := files.ReadFileAsString(initializer.path(RESOLV_CONF_PATH))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	nameservers := bootstrap.ParseNameservers(resolvConf)
+	if containsString(nameservers, SYSTEMD_RESOLVED_STUB_ADDRESS) {
+		resolvConf, err = files.ReadFileAsString(initializer.path(SYSTEMD_RESOLVED_RESOLV_CONF_PATH))
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		nameservers = bootstrap.ParseNameservers(resolvConf)
+	}
+
+	if len(nameservers) == 0 {
+		logger.Warnf("Found no nameservers in %s. Not pushing any DNS servers to clients.", initializer.path(RESOLV_CONF_PATH))
+	}
+	return nameservers, nil
+}
+
+// Write the UFW config that masquerades traffic from the VPN subnet, and turn on IP forwarding. Returns true if the
+// firewall config changed.
+func (initializer *serverInitializer) writeNetworkConfig() (bool, error) {
+	changesBefore := initializer.changes
+
+	defaults, err := ioutil.ReadFile(initializer.path(filepath.Join(INSTALL_FILES_PATH, UFW_DEFAULTS_FILE)))
+	if err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+	if err := initializer.writeFile(initializer.path(UFW_DEFAULTS_PATH), defaults, 0644, false); err != nil {
+		return false, err
+	}
+
+	beforeRules, err := ioutil.ReadFile(initializer.path(filepath.Join(INSTALL_FILES_PATH, UFW_BEFORE_RULES_FILE)))
+	if err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+	// The subnets were validated when the config was loaded
+	vpnSubnets := []*net.IPNet{}
+	for _, listener := range initializer.Config.Server.AllListeners() {
+		vpnSubnet, _ := bootstrap.ParseNetwork(listener.VpnSubnet)
+		vpnSubnets = append(vpnSubnets, vpnSubnet)
+	}
+	rules := bootstrap.RenderBeforeRules(string(beforeRules), vpnSubnets)
+	if err := initializer.writeFile(initializer.path(UFW_BEFORE_RULES_PATH), []byte(rules), 0640, false); err != nil {
+		return false, err
+	}
+
+	firewallChanged := initializer.changes > changesBefore
+	return firewallChanged, initializer.writeFile(initializer.path(SYSCTL_CONF_PATH), bootstrap.RenderSysctlConf(), 0644, false)
+}
+
+// Back up the PKI every hour. The cron job is removed if backups are no longer configured.
+func (initializer *serverInitializer) writeBackupCronJob() error {
+	cronJobPath := initializer.path(BACKUP_CRON_JOB_PATH)
+
+	if initializer.Config.Backup == nil {
+		if !files.FileExists(cronJobPath) {
+			return nil
+		}
+		if err := os.Remove(cronJobPath); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		initializer.changed("Removed %s, as there is no backup bucket in %s", cronJobPath, initializer.Config.Path)
+		return nil
+	}
+
+	contents, err := bootstrap.RenderBackupCronJob(initializer.Config.Backup, openVpnAdminPath(), initializer.AwsRegion)
+	if err != nil {
+		return err
+	}
+	return initializer.writeFile(cronJobPath, contents, 0755, false)
+}
+
+// Apply the settings to the running system and (re)start OpenVPN. Only done when the root is /.
+func (initializer *serverInitializer) applyToRunningSystem(firewallChanged bool) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if !initializer.isLive() {
+		logger.Infof("Wrote the server's files under %s. Not touching the running system's sysctl settings, firewall or services.", initializer.Root)
+		return nil
+	}
+
+	commands := [][]string{
+		{"sysctl", "-w", "net.ipv4.ip_forward=1"},
+	}
+	for _, listener := range initializer.Config.Server.AllListeners() {
+		commands = append(commands, []string{"ufw", "allow", listener.FirewallPort()})
+	}
+	commands = append(commands, []string{"ufw", "allow", "OpenSSH"})
+
+	// Disabling and enabling UFW is what makes it load the new rules
+	if firewallChanged {
+		commands = append(commands, []string{"ufw", "disable"})
+	}
+	commands = append(commands, []string{"ufw", "--force", "enable"})
+
+	for _, name := range initializer.removedInstances {
+		commands = append(commands, []string{"systemctl", "disable", "--now", bootstrap.ListenerConfig{Name: name}.ServiceName()})
+	}
+
+	// Without the hooks, nothing would remove the firewall rules of the clients that were connected before. With them,
+	// the traffic of clients the hooks haven't set up yet is dropped from the start.
+	if len(initializer.Config.Server.AccessGroups) == 0 {
+		if _, err := exec.LookPath("iptables"); err == nil {
+			if err := removeAccessFirewall(); err != nil {
+				return err
+			}
+		}
+	} else if err := ensureAccessFirewall(); err != nil {
+		return err
+	}
+
+	services := initializer.services()
+	commands = append(commands, append([]string{"systemctl", "enable"}, services...))
+	if initializer.restartNeeded {
+		commands = append(commands, append([]string{"systemctl", "restart"}, services...))
+	} else {
+		commands = append(commands, append([]string{"systemctl", "start"}, services...))
+	}
+
+	for _, command := range commands {
+		if err := runCommand(command[0], command[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the file if its contents differ from what's there. If restart is set, a change means OpenVPN and the
+// openvpn-admin daemons need restarting to pick it up.
+func (initializer *serverInitializer) writeFile(path string, contents []byte, mode os.FileMode, restart bool) error {
+	changed, err := writeFileIfChanged(path, contents, mode)
+	if err != nil || !changed {
+		return err
+	}
+
+	initializer.changed("Wrote %s", path)
+	if restart {
+		initializer.restartNeeded = true
+	}
+	return nil
+}
+
+func (initializer *serverInitializer) changed(format string, args ...interface{}) {
+	logging.GetLogger(LOGGER_NAME).Infof(format, args...)
+	initializer.changes++
+}
+
+// Record a PKI operation in the audit log under the root
+func (initializer *serverInitializer) audit(action string, target string, result error) {
+	recordAuditEvent(&audit.Log{Path: initializer.path(AUDIT_LOG_PATH)}, newLocalAuditEvent(action, target, result))
+}
+
+func (initializer *serverInitializer) isLive() bool {
+	return filepath.Clean(initializer.Root) == "/"
+}
+
+// The given absolute path under the root
+func (initializer *serverInitializer) path(absolutePath string) string {
+	return filepath.Join(initializer.Root, absolutePath)
+}
+
+func readKeySpec() (pki.KeySpec, error) {
+	vars, err := readEasyRsaVars()
+	if err != nil {
+		return pki.KeySpec{}, err
+	}
+	return vars.KeySpec()
+}
+
+// Write the file if its contents differ from what's there, creating its directory if needed. The mode only applies to
+// new files, so that init doesn't undo the permissions it gives the key dir. Returns true if the file was written.
+func writeFileIfChanged(path string, contents []byte, mode os.FileMode) (bool, error) {
+	existing, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(existing, contents) {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.WithStackTrace(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+	if os.IsNotExist(err) {
+		return true, errors.WithStackTrace(ioutil.WriteFile(path, contents, mode))
+	}
+
+	// Keep the existing file's mode and owner
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return false, errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(contents)
+	return true, errors.WithStackTrace(err)
+}
+
+// The path to put in server.conf for OpenVPN to run openvpn-admin with
+func openVpnAdminPath() string {
+	if path, err := exec.LookPath("openvpn-admin"); err == nil {
+		if absPath, err := filepath.Abs(path); err == nil {
+			return absPath
+		}
+	}
+	if path, err := os.Executable(); err == nil {
+		return path
+	}
+	return "openvpn-admin"
+}
+
+func runCommand(name string, args ...string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+	logger.Debugf("Running %s %s", name, strings.Join(args, " "))
+
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return errors.WithStackTrace(CommandFailed{Command: strings.Join(append([]string{name}, args...), " "), Output: strings.TrimSpace(string(output)), Cause: err})
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Custom errors
+
+type InitRequiresRoot struct{}
+
+func (err InitRequiresRoot) Error() string {
+	return fmt.Sprintf("openvpn-admin init must run as root, unless --%s points it at another directory", OPTION_ROOT)
+}
+
+type MissingInstallFile string
+
+func (path MissingInstallFile) Error() string {
+	return fmt.Sprintf("%s does not exist. Run install-openvpn before openvpn-admin init.", string(path))
+}
+
+type MissingCommand string
+
+func (command MissingCommand) Error() string {
+	return fmt.Sprintf("openvpn-admin init needs %s, but it isn't installed or isn't on the PATH", string(command))
+}
+
+type UnsupportedHookPath string
+
+func (path UnsupportedHookPath) Error() string {
+	return fmt.Sprintf("The openvpn-admin hooks start and end sessions with sudo, but the path %s has characters sudoers would need escaped. Install openvpn-admin somewhere like /usr/local/bin.", string(path))
+}
+
+type CommandFailed struct {
+	Command string
+	Output  string
+	Cause   error
+}
+
+func (err CommandFailed) Error() string {
+	return fmt.Sprintf("'%s' failed (%s): %s", err.Command, err.Cause, err.Output)
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The files install-openvpn installs, which init reads from under the root
+const TEST_INSTALL_FILES_DIR = "../../../install-openvpn/files"
+
+const TEST_INIT_CONFIG = `pki:
+  country: US
+  state: AZ
+  locality: Phoenix
+  org: Acme
+  org_unit: VPN
+  email: security@acme.com
+  key_algorithm: ecdsa-p256
+  ca_expiration_days: 30
+  cert_expiration_days: 30
+  crl_expiration_days: 7
+
+server:
+  vpn_subnet: 10.1.14.0/24
+  routes:
+    - 10.100.0.0/16
+  dns_servers: [10.100.0.2]
+`
+
+// Create a root with the files install-openvpn installs, and restore the PKI globals init points at the root once the
+// test is done
+func newTestInitRoot(t *testing.T) string {
+	t.Helper()
+
+	originalLayout, originalEasyRsaDir := pkiLayout, easyRsaDir
+	t.Cleanup(func() {
+		pkiLayout, easyRsaDir = originalLayout, originalEasyRsaDir
+	})
+
+	root := t.TempDir()
+	installPath := filepath.Join(root, INSTALL_FILES_PATH)
+	if err := os.MkdirAll(installPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{CLIENT_TEMPLATE_FILE, UFW_DEFAULTS_FILE, UFW_BEFORE_RULES_FILE} {
+		writeTestFile(t, filepath.Join(installPath, file), readTestFile(t, filepath.Join(TEST_INSTALL_FILES_DIR, file)))
+	}
+	return root
+}
+
+func runTestInit(t *testing.T, root string, configContents string) *serverInitializer {
+	t.Helper()
+
+	configPath := filepath.Join(t.TempDir(), "init.yaml")
+	writeTestFile(t, configPath, configContents)
+	config, err := bootstrap.Load(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initializer := &serverInitializer{Config: config, Root: root}
+	if err := initializer.run(); err != nil {
+		t.Fatal(err)
+	}
+	return initializer
+}
+
+func TestInitUnderRootCreatesPkiAndServerFiles(t *testing.T) {
+	root := newTestInitRoot(t)
+	runTestInit(t, root, TEST_INIT_CONFIG)
+
+	layout := pki.Layout{KeyDir: filepath.Join(root, OPENVPN_PATH)}
+	if pkiLayout.KeyDir != layout.KeyDir {
+		t.Fatalf("expected the key dir %s but got %s", layout.KeyDir, pkiLayout.KeyDir)
+	}
+
+	ca, err := pki.ReadCertificate(layout.CaCertPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := pki.ReadCertificate(layout.CertPath(SERVER_COMMON_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serverCert.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("the server certificate is not signed by the CA: %v", err)
+	}
+
+	index, err := pki.ReadIndex(layout.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := index.FindByCommonName(SERVER_COMMON_NAME, pki.STATUS_VALID); len(entries) != 1 {
+		t.Errorf("expected the server certificate in the CA database but found %v", entries)
+	}
+
+	crl, err := pki.ReadCrl(layout.CrlPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("the CRL is not signed by the CA: %v", err)
+	}
+
+	serverConf := readTestFile(t, filepath.Join(root, OPENVPN_PATH, "server.conf"))
+	for _, expected := range []string{"server 10.1.14.0 255.255.255.0", "10.100.0.2", "tls-crypt-v2", "tmp-dir " + HOOK_TMP_DIR} {
+		if !strings.Contains(serverConf, expected) {
+			t.Errorf("expected server.conf to contain %q:\n%s", expected, serverConf)
+		}
+	}
+
+	for _, path := range []string{
+		layout.TlsCryptV2ServerKeyPath(),
+		filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE),
+		filepath.Join(root, CLIENT_TEMPLATE_PATH),
+		filepath.Join(root, HOOK_SUDOERS_PATH),
+		filepath.Join(root, HOOK_TMP_DIR),
+		filepath.Join(root, UFW_DEFAULTS_PATH),
+		filepath.Join(root, UFW_BEFORE_RULES_PATH),
+		filepath.Join(root, SYSCTL_CONF_PATH),
+		filepath.Join(root, AUDIT_LOG_PATH),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected init to write %s: %v", path, err)
+		}
+	}
+
+	// There's no backup section, so there's nothing to back up
+	if _, err := os.Stat(filepath.Join(root, BACKUP_CRON_JOB_PATH)); !os.IsNotExist(err) {
+		t.Errorf("expected no backup cron job but got %v", err)
+	}
+}
+
+func TestInitUnderRootConvergesOnSecondRun(t *testing.T) {
+	root := newTestInitRoot(t)
+	runTestInit(t, root, TEST_INIT_CONFIG)
+
+	caCert := readTestFile(t, filepath.Join(root, OPENVPN_PATH, "ca.crt"))
+
+	initializer := runTestInit(t, root, TEST_INIT_CONFIG)
+	if initializer.changes != 0 || initializer.restartNeeded {
+		t.Errorf("expected a second run to change nothing but it made %d changes", initializer.changes)
+	}
+	if readTestFile(t, filepath.Join(root, OPENVPN_PATH, "ca.crt")) != caCert {
+		t.Error("a second run replaced the CA")
+	}
+}
+
+func TestInitUnderRootKeepsSettingsFixedByExistingCa(t *testing.T) {
+	root := newTestInitRoot(t)
+	runTestInit(t, root, TEST_INIT_CONFIG)
+
+	changedConfig := strings.Replace(TEST_INIT_CONFIG, "key_algorithm: ecdsa-p256", "key_algorithm: ecdsa-p384", 1)
+	changedConfig = strings.Replace(changedConfig, "crl_expiration_days: 7", "crl_expiration_days: 14", 1)
+	runTestInit(t, root, changedConfig)
+
+	vars, err := readEasyRsaVars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["KEY_ALGORITHM"] != "ecdsa-p256" {
+		t.Errorf("expected the existing CA's key algorithm to be kept but got %s", vars["KEY_ALGORITHM"])
+	}
+
+	status, err := pki.InspectCrl(pkiLayout.CrlPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime := status.NextUpdate.Sub(status.ThisUpdate); lifetime.Hours() != 14*24 {
+		t.Errorf("expected a CRL that is valid for 14 days but it is valid for %s", lifetime)
+	}
+}
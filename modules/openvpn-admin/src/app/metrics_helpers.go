@@ -0,0 +1,225 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/audit"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const METRICS_NAMESPACE = "openvpn_admin"
+
+// The queues process-requests and process-revokes listen on, as used in the queue label
+const METRICS_QUEUE_REQUESTS = "requests"
+const METRICS_QUEUE_REVOCATIONS = "revocations"
+
+// How long a daemon may go without hearing back from SQS before /healthz reports it as stuck, on top of twice the
+// --timeout it waits for each message
+const LIVENESS_GRACE_PERIOD = 2 * time.Minute
+
+// daemonMonitor keeps track of how process-requests or process-revokes is doing, for the metrics and health endpoints
+type daemonMonitor struct {
+	Queue            string
+	LivenessWindow   time.Duration
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	receiveErrors    prometheus.Counter
+	lock             sync.Mutex
+	lastPoll         time.Time
+	lastPollError    error
+	lastPollAttempt  time.Time
+	certificates     *prometheus.Desc
+	crlNextUpdate    *prometheus.Desc
+	caDatabaseErrors prometheus.Counter
+}
+
+func newDaemonMonitor(queue string, timeout int) *daemonMonitor {
+	labels := prometheus.Labels{"queue": queue}
+
+	return &daemonMonitor{
+		Queue:          queue,
+		LivenessWindow: 2*time.Duration(timeout)*time.Second + LIVENESS_GRACE_PERIOD,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   METRICS_NAMESPACE,
+			Name:        "requests_processed_total",
+			Help:        "The number of messages processed from the queue, by the action requested and its result.",
+			ConstLabels: labels,
+		}, []string{"action", "result"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   METRICS_NAMESPACE,
+			Name:        "request_duration_seconds",
+			Help:        "How long it took to process a message from the queue, by the action requested.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"action"}),
+		receiveErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   METRICS_NAMESPACE,
+			Name:        "queue_receive_errors_total",
+			Help:        "The number of times receiving a message from the queue failed.",
+			ConstLabels: labels,
+		}),
+		caDatabaseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "ca_database_read_errors_total",
+			Help:      "The number of times the CA database couldn't be read while collecting metrics.",
+		}),
+		certificates: prometheus.NewDesc(
+			prometheus.BuildFQName(METRICS_NAMESPACE, "", "certificates"),
+			fmt.Sprintf("The number of certificates in the CA database by status. Valid certificates that expire within %s are expiring.", CERTIFICATE_EXPIRING_WINDOW),
+			[]string{"status"}, nil,
+		),
+		crlNextUpdate: prometheus.NewDesc(
+			prometheus.BuildFQName(METRICS_NAMESPACE, "", "crl_next_update_timestamp_seconds"),
+			"When the published CRL expires, as a Unix timestamp. OpenVPN rejects every client once it has.",
+			nil, nil,
+		),
+	}
+}
+
+// Start serving /metrics, /healthz and /readyz on the given address in the background. Fails straight away if the
+// address can't be listened on.
+func (monitor *daemonMonitor) serve(address string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		monitor.requests,
+		monitor.requestDuration,
+		monitor.receiveErrors,
+		monitor.caDatabaseErrors,
+		monitor,
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", monitor.serveHealth)
+	mux.HandleFunc("/readyz", monitor.serveReadiness)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	logger.Infof("Serving metrics and health checks on %s", address)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logger.Errorf("The metrics server on %s stopped: %s", address, err)
+		}
+	}()
+	return nil
+}
+
+// Record the outcome of waiting for a message. Not getting a message within the timeout still means SQS answered.
+func (monitor *daemonMonitor) recordPoll(err error) {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+
+	monitor.lastPollAttempt = time.Now()
+	if err != nil && !isNoMessagesError(err) {
+		monitor.receiveErrors.Inc()
+		monitor.lastPollError = err
+		return
+	}
+	monitor.lastPoll = monitor.lastPollAttempt
+	monitor.lastPollError = nil
+}
+
+// Record a processed message, using the action and result worked out for the audit log
+func (monitor *daemonMonitor) recordRequest(event *audit.Event, duration time.Duration) {
+	monitor.requests.WithLabelValues(event.Action, event.Result).Inc()
+	monitor.requestDuration.WithLabelValues(event.Action).Observe(duration.Seconds())
+}
+
+// The daemon is alive as long as it keeps hearing back from SQS. Until it first does, it's given the benefit of the
+// doubt.
+func (monitor *daemonMonitor) serveHealth(writer http.ResponseWriter, request *http.Request) {
+	monitor.lock.Lock()
+	lastPoll := monitor.lastPoll
+	monitor.lock.Unlock()
+
+	if !lastPoll.IsZero() && time.Since(lastPoll) > monitor.LivenessWindow {
+		writeHealthResponse(writer, fmt.Errorf("no response from the %s queue since %s", monitor.Queue, lastPoll.Format(time.RFC3339)))
+		return
+	}
+	writeHealthResponse(writer, nil)
+}
+
+// The daemon is ready to process messages if its last attempt to receive one worked and it can read the CA database
+func (monitor *daemonMonitor) serveReadiness(writer http.ResponseWriter, request *http.Request) {
+	monitor.lock.Lock()
+	lastPollAttempt, lastPollError := monitor.lastPollAttempt, monitor.lastPollError
+	monitor.lock.Unlock()
+
+	switch {
+	case lastPollAttempt.IsZero():
+		writeHealthResponse(writer, fmt.Errorf("not connected to the %s queue yet", monitor.Queue))
+	case lastPollError != nil:
+		writeHealthResponse(writer, fmt.Errorf("failed to receive from the %s queue: %s", monitor.Queue, lastPollError))
+	default:
+		_, err := pki.ReadIndex(pkiLayout.IndexPath())
+		writeHealthResponse(writer, err)
+	}
+}
+
+func writeHealthResponse(writer http.ResponseWriter, err error) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(writer, err)
+		return
+	}
+	fmt.Fprintln(writer, "ok")
+}
+
+// daemonMonitor is a prometheus.Collector for the state of the PKI, which is read from disk on every scrape so that it
+// includes changes made by the other daemon and by hand
+func (monitor *daemonMonitor) Describe(descs chan<- *prometheus.Desc) {
+	descs <- monitor.certificates
+	descs <- monitor.crlNextUpdate
+}
+
+func (monitor *daemonMonitor) Collect(metrics chan<- prometheus.Metric) {
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		monitor.caDatabaseErrors.Inc()
+	} else {
+		for status, count := range countCertificatesByStatus(index, time.Now()) {
+			metrics <- prometheus.MustNewConstMetric(monitor.certificates, prometheus.GaugeValue, float64(count), status)
+		}
+	}
+
+	if crl, err := pki.InspectCrl(pkiLayout.CrlPath()); err == nil {
+		metrics <- prometheus.MustNewConstMetric(monitor.crlNextUpdate, prometheus.GaugeValue, float64(crl.NextUpdate.Unix()))
+	}
+}
+
+func countCertificatesByStatus(index *pki.Index, now time.Time) map[string]int {
+	counts := map[string]int{"valid": 0, "expiring": 0, "expired": 0, "revoked": 0, "on_hold": 0}
+
+	for _, entry := range index.Entries {
+		switch {
+		case entry.Status == pki.STATUS_REVOKED && entry.RevocationReason == pki.REASON_CERTIFICATE_HOLD:
+			counts["on_hold"]++
+		case entry.Status == pki.STATUS_REVOKED:
+			counts["revoked"]++
+		case entry.Status == pki.STATUS_EXPIRED || !entry.ExpirationTime.After(now):
+			counts["expired"]++
+		case entry.ExpirationTime.Before(now.Add(CERTIFICATE_EXPIRING_WINDOW)):
+			counts["expiring"]++
+		default:
+			counts["valid"]++
+		}
+	}
+
+	return counts
+}
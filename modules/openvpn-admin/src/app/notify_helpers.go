@@ -0,0 +1,171 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/audit"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/notify"
+	"os"
+)
+
+// SNS subjects can't be longer than this
+const MAX_SNS_SUBJECT_LEN = 100
+
+// Load the notifications file and create its sinks. Returns nil if there's no notifications file.
+func openNotifier(awsRegion string, path string) (*notify.Notifier, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	config, err := notify.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &notify.Notifier{}
+	for _, sinkConfig := range config.Sinks {
+		var sink notify.Sink
+
+		switch sinkConfig.Type {
+		case notify.SINK_WEBHOOK:
+			webhook, err := notify.NewWebhookSink(sinkConfig.Url, sinkConfig.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			sink = webhook
+		case notify.SINK_SLACK:
+			sink = &notify.SlackSink{Url: sinkConfig.Url}
+		case notify.SINK_SNS:
+			sink = snsNotificationSink{AwsRegion: awsRegion, TopicArn: sinkConfig.TopicArn}
+		case notify.SINK_SES:
+			sink = sesNotificationSink{AwsRegion: awsRegion, From: sinkConfig.From, To: sinkConfig.To}
+		case notify.SINK_SMTP:
+			smtp, err := notify.NewSmtpSink(sinkConfig)
+			if err != nil {
+				return nil, err
+			}
+			sink = smtp
+		}
+
+		notifier.Routes = append(notifier.Routes, notify.Route{Name: sinkConfig.Name, Events: sinkConfig.Events, Sink: sink})
+	}
+
+	return notifier, nil
+}
+
+// Publish the summary of each notification as the subject, and the notification itself as the message, so that email
+// subscribers get something readable and other subscribers get something they can parse
+type snsNotificationSink struct {
+	AwsRegion string
+	TopicArn  string
+}
+
+func (sink snsNotificationSink) Send(notification *notify.Notification) error {
+	message, err := json.Marshal(notification)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	subject := notification.Summary()
+	if len(subject) > MAX_SNS_SUBJECT_LEN {
+		subject = subject[:MAX_SNS_SUBJECT_LEN-3] + "..."
+	}
+
+	return aws_helpers.PublishToTopic(sink.AwsRegion, sink.TopicArn, subject, string(message))
+}
+
+// Email each notification through SES
+type sesNotificationSink struct {
+	AwsRegion string
+	From      string
+	To        []string
+}
+
+func (sink sesNotificationSink) Send(notification *notify.Notification) error {
+	recipients := notify.EmailRecipients(sink.To, notification)
+	if len(recipients) == 0 {
+		return nil
+	}
+	return aws_helpers.SendEmail(sink.AwsRegion, sink.From, recipients, notification.Summary(), notification.Text())
+}
+
+// Work out which notification, if any, the outcome of a message from one of the queues calls for. issued says whether
+// processing the message issued the user a new certificate, which can happen when they request one or when they fetch
+// one that was approved.
+func notificationForEvent(event *audit.Event, issued bool) *notify.Notification {
+	var eventType string
+
+	switch {
+	case event.Result == audit.RESULT_DENIED:
+		eventType = notify.EVENT_ACCESS_DENIED
+	case event.Result == audit.RESULT_PENDING:
+		eventType = notify.EVENT_REQUEST_PENDING_APPROVAL
+	case event.Result != audit.RESULT_SUCCESS:
+		return nil
+	case issued:
+		eventType = notify.EVENT_CERTIFICATE_ISSUED
+	case event.Action == REVOKE_ACTION:
+		eventType = notify.EVENT_CERTIFICATE_REVOKED
+	case event.Action == RELEASE_ACTION:
+		eventType = notify.EVENT_CERTIFICATE_RELEASED
+	case event.Action == APPROVE_ACTION:
+		eventType = notify.EVENT_REQUEST_APPROVED
+	case event.Action == DENY_ACTION:
+		eventType = notify.EVENT_REQUEST_DENIED
+	default:
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	notification := &notify.Notification{
+		Event:     eventType,
+		Time:      event.Time,
+		Host:      hostname,
+		Username:  event.Target,
+		Serial:    event.Serial,
+		Requester: event.Requester,
+		RequestId: event.RequestId,
+	}
+	if event.Result != audit.RESULT_SUCCESS {
+		notification.Message = event.Message
+	}
+	if notification.Requester == "" {
+		notification.Requester = event.RequesterId
+	}
+	return notification
+}
+
+// Send the notification the outcome of a message calls for in the background, so that slow sinks don't hold up the
+// queue. Sinks that fail are logged rather than retried.
+func sendNotification(notifier *notify.Notifier, event *audit.Event, issued bool) {
+	if notifier == nil {
+		return
+	}
+
+	notification := notificationForEvent(event, issued)
+	if notification == nil {
+		return
+	}
+
+	go func() {
+		logger := logging.GetLogger(LOGGER_NAME)
+
+		sent, failures := notifier.Notify(notification)
+		for _, err := range failures {
+			logger.Errorf("%s notification for %s: %s", notification.Event, notification.Username, err)
+		}
+		if len(sent) > 0 {
+			logger.Debugf("Sent %s notification for %s to %v", notification.Event, notification.Username, sent)
+		}
+	}()
+}
+
+// The username a message from the request queue is about, so that the serial of their latest certificate can be
+// compared before and after processing it
+func certificateRequestUsername(message *aws_helpers.QueueMessage) string {
+	request := CertificateRequest{}
+	json.Unmarshal([]byte(message.Body), &request)
+	return request.Username
+}
@@ -0,0 +1,243 @@
+package app
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The delegated responder certificate and key are kept in the key dir as ocsp-responder.crt and ocsp-responder.key
+const OCSP_RESPONDER_NAME = "ocsp-responder"
+const OCSP_RESPONDER_CERT_VALIDITY = 30 * 24 * time.Hour
+const OCSP_RESPONDER_RENEWAL_CHECK_INTERVAL = time.Hour
+
+// RFC 6960 requests are tiny, so anything bigger than this isn't worth parsing
+const MAX_OCSP_REQUEST_BYTES = 64 * 1024
+
+// ocspServer is an http.Handler that serves OCSP responses over both GET and POST, as described in RFC 6960, appendix A
+type ocspServer struct {
+	lock             sync.RWMutex
+	responders       pki.OcspResponders
+	responseValidity time.Duration
+}
+
+func (server *ocspServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	var requestBytes []byte
+	var err error
+
+	switch request.Method {
+	case http.MethodGet:
+		requestBytes, err = decodeOcspGetRequest(request.URL.EscapedPath())
+	case http.MethodPost:
+		requestBytes, err = ioutil.ReadAll(io.LimitReader(request.Body, MAX_OCSP_REQUEST_BYTES))
+	default:
+		http.Error(writer, "Only GET and POST are supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(writer, "Invalid OCSP request", http.StatusBadRequest)
+		return
+	}
+
+	server.lock.RLock()
+	responders := server.responders
+	server.lock.RUnlock()
+
+	response, err := responders.Respond(requestBytes, time.Now())
+	if err != nil {
+		logger.Errorf("Failed to create OCSP response: %s", err.Error())
+	}
+
+	writer.Header().Set("Content-Type", "application/ocsp-response")
+	writer.Write(response)
+}
+
+// Make sure we have a delegated responder certificate from the current CA that isn't close to expiring, issuing a new
+// one if necessary. While a CA rotation is in progress, the previous CA's certificates are still in use, so the
+// responder answers for them too, signing with the previous CA key itself: a delegated certificate from the previous CA
+// would need a serial from the range that now belongs to the new CA.
+func (server *ocspServer) renewResponderIfNeeded() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		return err
+	}
+
+	certificate, key, err := loadOcspResponderCert(ca)
+	if err != nil {
+		return err
+	}
+
+	if certificate == nil {
+		logger.Infof("Issuing a new OCSP responder certificate")
+		certificate, key, err = issueOcspResponderCert(ca)
+		if err != nil {
+			return err
+		}
+	}
+
+	current := &pki.OcspResponder{
+		Layout:           pkiLayout,
+		Issuer:           ca.Certificate,
+		ResponderCert:    certificate,
+		ResponderKey:     key,
+		ResponseValidity: server.responseValidity,
+	}
+	responders := pki.OcspResponders{current}
+
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil {
+		return err
+	}
+
+	if rotation != nil {
+		previousCa, err := pki.LoadCertificateAuthority(pkiLayout.PreviousCaCertPath(), pkiLayout.PreviousCaKeyPath())
+		if err != nil {
+			return err
+		}
+
+		current.IssuedBy = func(entry *pki.IndexEntry) (bool, error) {
+			fromPrevious, err := rotation.IssuedByPreviousCa(entry)
+			return !fromPrevious, err
+		}
+		responders = append(responders, &pki.OcspResponder{
+			Layout:           pkiLayout,
+			Issuer:           previousCa.Certificate,
+			ResponderCert:    previousCa.Certificate,
+			ResponderKey:     previousCa.Signer,
+			ResponseValidity: server.responseValidity,
+			IssuedBy:         rotation.IssuedByPreviousCa,
+		})
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.responders = responders
+	return nil
+}
+
+// NOTE: This method runs in an infinite loop, so call it in a goroutine
+func (server *ocspServer) runScheduledResponderRenewal() {
+	logger := logging.GetLogger(LOGGER_NAME)
+	ticker := time.NewTicker(OCSP_RESPONDER_RENEWAL_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := server.renewResponderIfNeeded(); err != nil {
+			logger.Errorf("Failed to renew the OCSP responder certificate: %s", err.Error())
+		}
+	}
+}
+
+// Return the existing responder certificate and key, or nil if they don't exist, weren't issued by the given CA or are
+// in the last third of their lifetime
+func loadOcspResponderCert(ca *pki.CertificateAuthority) (*x509.Certificate, crypto.Signer, error) {
+	certPath := pkiLayout.CertPath(OCSP_RESPONDER_NAME)
+	keyPath := pkiLayout.KeyPath(OCSP_RESPONDER_NAME)
+
+	if !files.FileExists(certPath) || !files.FileExists(keyPath) {
+		return nil, nil, nil
+	}
+
+	certificate, err := pki.ReadCertificate(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if certificate.CheckSignatureFrom(ca.Certificate) != nil {
+		return nil, nil, nil
+	}
+
+	renewAt := certificate.NotAfter.Add(-certificate.NotAfter.Sub(certificate.NotBefore) / 3)
+	if time.Now().After(renewAt) {
+		return nil, nil, nil
+	}
+
+	key, err := pki.ReadPrivateKey(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certificate, key, nil
+}
+
+func issueOcspResponderCert(ca *pki.CertificateAuthority) (*x509.Certificate, crypto.Signer, error) {
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.WithStackTrace(err)
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certificate, err := pki.Issue(pkiLayout, ca, index, pki.OcspResponderTemplate(OCSP_RESPONDER_CERT_VALIDITY), key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := index.Write(); err != nil {
+		return nil, nil, err
+	}
+
+	err = pki.WriteCertificateAndKey(pkiLayout.CertPath(OCSP_RESPONDER_NAME), pkiLayout.KeyPath(OCSP_RESPONDER_NAME), certificate, key)
+	return certificate, key, err
+}
+
+// If the OCSP responder has a certificate, make sure it's one from the current CA, e.g. before the previous CA is
+// retired and everything it issued is revoked. A running responder picks the new certificate up at its next check.
+func reissueOcspResponderCertIfNeeded() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if !files.FileExists(pkiLayout.CertPath(OCSP_RESPONDER_NAME)) {
+		return nil
+	}
+
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		return err
+	}
+
+	certificate, _, err := loadOcspResponderCert(ca)
+	if err != nil || certificate != nil {
+		return err
+	}
+
+	logger.Infof("Issuing a new OCSP responder certificate")
+	_, _, err = issueOcspResponderCert(ca)
+	return err
+}
+
+// A GET request carries the base64 encoded DER request in the path, which may additionally be URL encoded
+func decodeOcspGetRequest(escapedPath string) ([]byte, error) {
+	unescaped, err := url.PathUnescape(strings.TrimPrefix(escapedPath, "/"))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(unescaped)
+	return decoded, errors.WithStackTrace(err)
+}
@@ -0,0 +1,321 @@
+package app
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/backup"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pki export writes the PKI in the easy-rsa 3 layout under this dir of the bundle, so that an unpacked bundle can be
+// used by easy-rsa 3 as is, as well as imported by pki import
+const PKI_BUNDLE_DIR = "pki"
+
+// easy-rsa 3 reads this next to index.txt. openvpn-admin issues a new certificate for a user without revoking the old
+// one first, e.g. during a CA rotation, so subjects needn't be unique.
+const PKI_BUNDLE_INDEX_ATTR = "unique_subject = no\n"
+
+// Whether a key to encrypt the bundle with was given. A bundle may be left unencrypted, e.g. to take it to a machine
+// without access to any of the keys, as long as it's carried safely.
+func (settings backupSettings) hasKey() bool {
+	return settings.KmsKeyId != "" || len(settings.AgeRecipients) > 0 || len(settings.GpgRecipients) > 0
+}
+
+// Write the CA, the CA database, serial and CRL, every certificate and key the CA issued, and the tls-crypt-v2 keys to a
+// bundle at the given path, encrypted if the settings name a key. The bundle is a gzipped tar with a manifest, like a backup, but holds only
+// the PKI, not the server's own settings, so that it can be imported into another server or another tool.
+func exportPki(settings backupSettings, bundlePath string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	var keyWrapper backup.KeyWrapper
+	if settings.hasKey() {
+		var err error
+		keyWrapper, err = settings.keyWrapper()
+		if err != nil {
+			return err
+		}
+	}
+
+	bundleFiles, err := snapshotPkiBundle()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	host, _ := os.Hostname()
+	bundle, manifest, err := backup.Pack(now.Format(BACKUP_VERSION_LAYOUT), now, host, bundleFiles)
+	if err != nil {
+		return err
+	}
+
+	if keyWrapper != nil {
+		bundle, err = backup.Seal(keyWrapper, bundle)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := restoreFile(bundlePath, backup.File{Path: bundlePath, Mode: 0600, Contents: bundle}); err != nil {
+		return err
+	}
+
+	if keyWrapper == nil {
+		logger.Warnf("%s is not encrypted and holds the private keys of the PKI. Keep it safe, or export it with --%s, --%s or --%s.", bundlePath, OPTION_KMS_KEY_ID, OPTION_AGE_RECIPIENT, OPTION_GPG_RECIPIENT)
+	}
+	logger.Infof("Exported %d files of the PKI to %s", len(manifest.Files), bundlePath)
+	return nil
+}
+
+// Read the PKI from the key dir into the easy-rsa 3 layout. The key dir is in the easy-rsa 2 layout, so it's found the
+// same way pki import finds an easy-rsa 2 PKI. A CA key held in a PKCS#11 token or KMS isn't in the key dir, so it isn't
+// in the bundle either.
+func snapshotPkiBundle() ([]backup.File, error) {
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	if !files.FileExists(pkiLayout.CaCertPath()) {
+		return nil, errors.WithStackTrace(NoPkiToBackUp(pkiLayout.KeyDir))
+	}
+
+	// During a CA rotation, the certificates come from two CAs, which a single easy-rsa PKI can't hold
+	rotation, err := pki.ReadCaRotation(pkiLayout)
+	if err != nil {
+		return nil, err
+	}
+	if rotation != nil {
+		return nil, errors.WithStackTrace(CaRotationInProgress(rotation.StartedAt))
+	}
+
+	current, err := pki.FindForeignPki(pkiLayout.KeyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	bundleFiles := []backup.File{
+		{Path: PKI_BUNDLE_DIR, Mode: os.ModeDir | 0700},
+		{Path: path.Join(PKI_BUNDLE_DIR, "private"), Mode: os.ModeDir | 0700},
+		{Path: path.Join(PKI_BUNDLE_DIR, "issued"), Mode: os.ModeDir | 0755},
+		{Path: path.Join(PKI_BUNDLE_DIR, "certs_by_serial"), Mode: os.ModeDir | 0755},
+		{Path: path.Join(PKI_BUNDLE_DIR, "index.txt.attr"), Mode: 0644, Contents: []byte(PKI_BUNDLE_INDEX_ATTR)},
+	}
+
+	add := func(sourcePath string, name string, mode os.FileMode) error {
+		if sourcePath == "" {
+			return nil
+		}
+		contents, err := ioutil.ReadFile(sourcePath)
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		bundleFiles = append(bundleFiles, backup.File{Path: path.Join(PKI_BUNDLE_DIR, name), Mode: mode, Contents: contents})
+		return nil
+	}
+
+	for _, file := range []struct {
+		sourcePath string
+		name       string
+		mode       os.FileMode
+	}{
+		{current.CaCertPath, "ca.crt", 0644},
+		{current.CaKeyPath, "private/ca.key", 0600},
+		{current.IndexPath, "index.txt", 0644},
+		{current.SerialPath, "serial", 0644},
+		{current.CrlPath, "crl.pem", 0644},
+		{current.CrlNumberPath, "crlnumber", 0644},
+	} {
+		if err := add(file.sourcePath, file.name, file.mode); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, sourcePath := range current.CertificatePaths {
+		if err := add(sourcePath, path.Join("certs_by_serial", name), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, named := range current.Named {
+		if err := add(named.CertPath, path.Join("issued", named.Name+".crt"), 0644); err != nil {
+			return nil, err
+		}
+		if err := add(named.KeyPath, path.Join("private", named.Name+".key"), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	// easy-rsa 3 doesn't know about tls-crypt-v2 keys, so they're under private, where it won't look
+	if current.TlsCryptV2ServerKeyPath != "" {
+		bundleFiles = append(bundleFiles,
+			backup.File{Path: path.Join(PKI_BUNDLE_DIR, "private", path.Dir(pki.EASY_RSA_3_TLS_CRYPT_V2_SERVER_KEY)), Mode: os.ModeDir | 0700},
+			backup.File{Path: path.Join(PKI_BUNDLE_DIR, "private", pki.EASY_RSA_3_TLS_CRYPT_V2_CLIENT_KEY_DIR), Mode: os.ModeDir | 0700},
+		)
+		if err := add(current.TlsCryptV2ServerKeyPath, path.Join("private", pki.EASY_RSA_3_TLS_CRYPT_V2_SERVER_KEY), 0600); err != nil {
+			return nil, err
+		}
+	}
+	for username, sourcePath := range current.TlsCryptV2ClientKeyPaths {
+		if err := add(sourcePath, path.Join("private", pki.EASY_RSA_3_TLS_CRYPT_V2_CLIENT_KEY_DIR, username+".key"), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return bundleFiles, nil
+}
+
+// Import the PKI from a directory of easy-rsa 2, easy-rsa 3 or the openssl ca command, or from a bundle written by pki
+// export. The PKI is checked for consistency before anything is written, and a new CRL is published from its CA
+// database, as the CRL it came with may be out of date. Importing over an existing PKI isn't supported, as the two CA
+// databases can't be merged.
+func importPki(settings backupSettings, source string, crlValidity time.Duration) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if files.FileExists(pkiLayout.CaCertPath()) {
+		return errors.WithStackTrace(ImportWouldReplacePki(pkiLayout.KeyDir))
+	}
+
+	dir := source
+	if !files.IsDir(source) {
+		stagingDir, err := unpackPkiBundle(settings, source)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(stagingDir)
+		dir = stagingDir
+	}
+
+	foreign, err := pki.FindForeignPki(dir)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Found the %s PKI in %s", foreign.Kind, foreign.Dir)
+
+	// If vars.local keeps the CA key in a PKCS#11 token or KMS, that's what signs for the imported CA from now on
+	signer, err := findConfiguredCaSigner()
+	if err != nil {
+		return err
+	}
+	if signer != nil && foreign.CaKeyPath != "" {
+		logger.Warnf("Not importing %s, as %s says the CA key is kept in a PKCS#11 token or KMS", foreign.CaKeyPath, EASY_RSA_VARS_FILE)
+		foreign.CaKeyPath = ""
+	}
+
+	if err := foreign.Verify(signer, time.Now()); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(pkiLayout.KeyDir, 0770); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	// Another process may have created a PKI while this one was being checked
+	if files.FileExists(pkiLayout.CaCertPath()) {
+		return errors.WithStackTrace(ImportWouldReplacePki(pkiLayout.KeyDir))
+	}
+
+	if err := foreign.Write(pkiLayout); err != nil {
+		return err
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		return err
+	}
+	if err := publishCrl(ca, index, crlValidity); err != nil {
+		return err
+	}
+
+	logger.Infof("Imported the PKI into %s. Run init, or restart OpenVPN, for it to use the imported CA.", pkiLayout.KeyDir)
+	return nil
+}
+
+// The signer for a CA key that vars.local says is held outside of ca.key, or nil if there's no vars.local yet or it
+// keeps the CA key in ca.key
+func findConfiguredCaSigner() (crypto.Signer, error) {
+	if !files.FileExists(filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE)) {
+		return nil, nil
+	}
+	vars, err := readEasyRsaVars()
+	if err != nil {
+		return nil, err
+	}
+	return findExternalCaSigner(vars)
+}
+
+// Decrypt the bundle if it's encrypted, and unpack it into a temp dir next to the key dir. Returns the temp dir, which
+// the caller removes.
+func unpackPkiBundle(settings backupSettings, bundlePath string) (string, error) {
+	bundle, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	if bytes.HasPrefix(bundle, []byte(backup.SEALED_MAGIC)) {
+		header, _, err := backup.ReadHeader(bundle)
+		if err != nil {
+			return "", err
+		}
+		unwrapper, err := settings.unwrapperFor(header)
+		if err != nil {
+			return "", err
+		}
+		bundle, _, err = backup.Open(unwrapper, bundle)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	_, bundleFiles, err := backup.Unpack(bundle)
+	if err != nil {
+		return "", errors.WithStackTrace(NotAPkiBundle{Path: bundlePath, Cause: errors.Unwrap(err)})
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pkiLayout.KeyDir), 0755); err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	stagingDir, err := ioutil.TempDir(filepath.Dir(pkiLayout.KeyDir), ".openvpn-import-")
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	for _, file := range bundleFiles {
+		if err := restoreFile(filepath.Join(stagingDir, filepath.FromSlash(file.Path)), file); err != nil {
+			os.RemoveAll(stagingDir)
+			return "", err
+		}
+	}
+	return stagingDir, nil
+}
+
+// Custom errors
+
+type ImportWouldReplacePki string
+
+func (keyDir ImportWouldReplacePki) Error() string {
+	return fmt.Sprintf("There is already a PKI in %s. Move it out of the way before importing another one.", string(keyDir))
+}
+
+type NotAPkiBundle struct {
+	Path  string
+	Cause error
+}
+
+func (err NotAPkiBundle) Error() string {
+	return fmt.Sprintf("%s is neither a directory nor a bundle written by 'openvpn-admin pki export': %s", err.Path, strings.TrimSpace(err.Cause.Error()))
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportAndImportKeepTheTlsCryptV2Keys(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pki.GenerateTlsCryptV2ServerKey(pkiLayout.TlsCryptV2ServerKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := issueClientCertificate("alice", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTlsCryptV2ClientKey("alice", certificate.SerialNumber); err != nil {
+		t.Fatal(err)
+	}
+	aliceKey := readTestFile(t, pkiLayout.TlsCryptV2ClientKeyPath("alice"))
+	serverKey := readTestFile(t, pkiLayout.TlsCryptV2ServerKeyPath())
+
+	bundlePath := filepath.Join(t.TempDir(), "openvpn-pki.tar.gz")
+	if err := exportPki(backupSettings{}, bundlePath); err != nil {
+		t.Fatal(err)
+	}
+
+	useTestPki(t)
+	if err := importPki(backupSettings{}, bundlePath, 7*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if imported := readTestFile(t, pkiLayout.TlsCryptV2ServerKeyPath()); imported != serverKey {
+		t.Errorf("expected the tls-crypt-v2 server key to be imported")
+	}
+	if imported := readTestFile(t, pkiLayout.TlsCryptV2ClientKeyPath("alice")); imported != aliceKey {
+		t.Errorf("expected alice's tls-crypt-v2 client key to be imported")
+	}
+
+	// The imported client key still belongs to alice's certificate, so her profile embeds it rather than a new one
+	block, err := readTlsCryptV2ClientKeyBlock("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block != "<tls-crypt-v2>\n"+aliceKey+"</tls-crypt-v2>\n" {
+		t.Errorf("expected alice's profile to embed her imported client key, got %q", block)
+	}
+}
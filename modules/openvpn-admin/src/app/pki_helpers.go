@@ -0,0 +1,322 @@
+package app
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The common name and name attribute init-openvpn has always used for the OpenVPN server certificate
+const SERVER_COMMON_NAME = "server"
+
+// The files in the easy-rsa dir that hold the PKI settings and the CRL lifetime
+const EASY_RSA_VARS_FILE = "vars.local"
+const EASY_RSA_CONFIG_FILE = "openssl-1.0.0.cnf"
+
+// Signers for a CA key that isn't kept in ca.key are slow to set up (opening a PKCS#11 token logs in to it, and a KMS
+// signer has to fetch the public key), so we keep them for the lifetime of the process
+var pkcs11Token *pki.Pkcs11Token
+var kmsSigner *aws_helpers.KmsSigner
+var caSignerLock sync.Mutex
+
+func readEasyRsaVars() (pki.EasyRsaVars, error) {
+	return pki.ReadEasyRsaVars(filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE))
+}
+
+func openPkcs11Token(config pki.Pkcs11Config) (*pki.Pkcs11Token, error) {
+	caSignerLock.Lock()
+	defer caSignerLock.Unlock()
+
+	if pkcs11Token == nil {
+		token, err := pki.OpenPkcs11Token(config)
+		if err != nil {
+			return nil, err
+		}
+		pkcs11Token = token
+	}
+
+	return pkcs11Token, nil
+}
+
+func openKmsSigner(keyArn string, endpoint string) (*aws_helpers.KmsSigner, error) {
+	caSignerLock.Lock()
+	defer caSignerLock.Unlock()
+
+	if kmsSigner == nil {
+		signer, err := aws_helpers.NewKmsSigner(keyArn, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		kmsSigner = signer
+	}
+
+	return kmsSigner, nil
+}
+
+// Find the signer for a CA key that's held outside of ca.key, i.e. in the PKCS#11 token or the asymmetric KMS key
+// configured in vars.local. Returns nil if the CA key is in ca.key.
+func findExternalCaSigner(vars pki.EasyRsaVars) (crypto.Signer, error) {
+	pkcs11Config, err := vars.Pkcs11Config()
+	if err != nil {
+		return nil, err
+	}
+
+	kmsKeyArn := vars.KmsSigningKeyArn()
+
+	switch {
+	case pkcs11Config != nil && kmsKeyArn != "":
+		return nil, errors.WithStackTrace(ConflictingCaSigners{})
+	case pkcs11Config != nil:
+		token, err := openPkcs11Token(*pkcs11Config)
+		if err != nil {
+			return nil, err
+		}
+		return token.FindSigner()
+	case kmsKeyArn != "":
+		return openKmsSigner(kmsKeyArn, vars.KmsEndpoint())
+	default:
+		return nil, nil
+	}
+}
+
+// Load the CA certificate along with a signer for the CA key, which is either read from ca.key or is the PKCS#11 token
+// or KMS key configured in vars.local
+func loadCertificateAuthority() (*pki.CertificateAuthority, error) {
+	varsPath := filepath.Join(easyRsaDir, EASY_RSA_VARS_FILE)
+	if !files.FileExists(varsPath) {
+		return pki.LoadCertificateAuthority(pkiLayout.CaCertPath(), pkiLayout.CaKeyPath())
+	}
+
+	vars, err := pki.ReadEasyRsaVars(varsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := findExternalCaSigner(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if signer == nil {
+		return pki.LoadCertificateAuthority(pkiLayout.CaCertPath(), pkiLayout.CaKeyPath())
+	}
+
+	certificate, err := pki.ReadCertificate(pkiLayout.CaCertPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return pki.NewCertificateAuthority(certificate, signer)
+}
+
+// Create a new self-signed CA using the key algorithm, subject and expiration configured in vars.local
+func buildCa() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	// A CA whose key is held in a PKCS#11 token or KMS has no ca.key, so ca.crt is what tells us there's a CA already
+	for _, path := range []string{pkiLayout.CaCertPath(), pkiLayout.CaKeyPath()} {
+		if files.FileExists(path) {
+			return errors.WithStackTrace(CaAlreadyExists(path))
+		}
+	}
+
+	vars, err := readEasyRsaVars()
+	if err != nil {
+		return err
+	}
+
+	spec, err := vars.KeySpec()
+	if err != nil {
+		return err
+	}
+
+	validity, err := vars.CaValidity()
+	if err != nil {
+		return err
+	}
+
+	pkcs11Config, err := vars.Pkcs11Config()
+	if err != nil {
+		return err
+	}
+
+	template := pki.CaTemplate(vars.Subject(vars["KEY_ORG"]+" CA", vars["KEY_NAME"]), validity)
+
+	switch {
+	case pkcs11Config != nil && vars.KmsSigningKeyArn() != "":
+		return errors.WithStackTrace(ConflictingCaSigners{})
+	case pkcs11Config != nil:
+		return buildCaInToken(*pkcs11Config, spec, template)
+	case vars.KmsSigningKeyArn() != "":
+		return buildCaWithKms(vars.KmsSigningKeyArn(), vars.KmsEndpoint(), template)
+	}
+
+	logger.Infof("Generating a %s CA key", spec)
+	key, err := spec.Generate()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := pki.SelfSign(template, key)
+	if err != nil {
+		return err
+	}
+
+	return pki.WriteCertificateAndKey(pkiLayout.CaCertPath(), pkiLayout.CaKeyPath(), certificate, key)
+}
+
+// Generate the CA key in the PKCS#11 token so that it can't be exported. Only the CA certificate is written to disk.
+func buildCaInToken(config pki.Pkcs11Config, spec pki.KeySpec, template *x509.Certificate) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	token, err := openPkcs11Token(config)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Generating a %s CA key labelled '%s' in the PKCS#11 token '%s'", spec, config.KeyLabel, config.TokenLabel)
+	key, err := token.GenerateKey(spec)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := pki.SelfSign(template, key)
+	if err != nil {
+		return err
+	}
+
+	return pki.WriteCertificate(pkiLayout.CaCertPath(), certificate)
+}
+
+// Self-sign the CA certificate with an existing asymmetric KMS key. The key type is whatever the KMS key was created
+// with, so KEY_ALGORITHM only applies to the server and client keys.
+func buildCaWithKms(keyArn string, endpoint string, template *x509.Certificate) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	signer, err := openKmsSigner(keyArn, endpoint)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Signing the CA certificate with the KMS key %s", keyArn)
+	certificate, err := pki.SelfSign(template, signer)
+	if err != nil {
+		return err
+	}
+
+	return pki.WriteCertificate(pkiLayout.CaCertPath(), certificate)
+}
+
+// Issue the OpenVPN server certificate from the CA
+func buildServerCertificate() error {
+	logger := logging.GetLogger(LOGGER_NAME)
+	logger.Infof("Issuing the certificate for the OpenVPN server")
+
+	_, err := issueCertificate(SERVER_COMMON_NAME, func(vars pki.EasyRsaVars, validity time.Duration, publicKey crypto.PublicKey) *x509.Certificate {
+		return pki.ServerTemplate(vars.Subject(SERVER_COMMON_NAME, vars["KEY_NAME"]), validity, publicKey)
+	})
+	return err
+}
+
+// Issue a client certificate for the given user. As with easy-rsa's build-key, the name attribute is left empty. A ttl
+// can only shorten the validity configured for the PKI. The user's access groups are recorded as extra OUs, which the
+// client-connect hook reads back from the copy of the certificate the CA keeps.
+func issueClientCertificate(username string, ttl time.Duration, accessGroups []string) (*x509.Certificate, error) {
+	// The username ends up in file names and in the subject in the CA database, where a / would start another attribute
+	if !clientConfigUsernameRegex.MatchString(username) {
+		return nil, errors.WithStackTrace(InvalidUsername(username))
+	}
+
+	return issueCertificate(username, func(vars pki.EasyRsaVars, validity time.Duration, publicKey crypto.PublicKey) *x509.Certificate {
+		if ttl > 0 && ttl < validity {
+			validity = ttl
+		}
+		subject := vars.Subject(username, "")
+		for _, group := range accessGroups {
+			subject.OrganizationalUnit = append(subject.OrganizationalUnit, ACCESS_GROUP_OU_PREFIX+group)
+		}
+		return pki.ClientTemplate(subject, validity, publicKey)
+	})
+}
+
+type certificateTemplateFunc func(vars pki.EasyRsaVars, validity time.Duration, publicKey crypto.PublicKey) *x509.Certificate
+
+// Generate a key of the PKI's configured algorithm, sign a certificate for it and record it in the CA database, writing
+// the certificate and key to <name>.crt and <name>.key in the key dir
+func issueCertificate(name string, templateFunc certificateTemplateFunc) (*x509.Certificate, error) {
+	pkiLock.Lock()
+	defer pkiLock.Unlock()
+
+	vars, err := readEasyRsaVars()
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := vars.KeySpec()
+	if err != nil {
+		return nil, err
+	}
+
+	validity, err := vars.CertificateValidity()
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := spec.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	template := templateFunc(vars, validity, key.Public())
+	certificate, err := pki.Issue(pkiLayout, ca, index, template, key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pki.WriteCertificateAndKey(pkiLayout.CertPath(name), pkiLayout.KeyPath(name), certificate, key); err != nil {
+		return nil, err
+	}
+
+	return certificate, index.Write()
+}
+
+// Custom errors
+
+type ConflictingCaSigners struct{}
+
+func (err ConflictingCaSigners) Error() string {
+	return "vars.local configures both a PKCS#11 token and a KMS key for the CA key. Only one of PKCS11_MODULE_PATH and KMS_SIGNING_KEY_ARN may be set."
+}
+
+type CaAlreadyExists string
+
+func (err CaAlreadyExists) Error() string {
+	return fmt.Sprintf("A CA already exists at %s. Refusing to overwrite it.", string(err))
+}
+
+type InvalidUsername string
+
+func (username InvalidUsername) Error() string {
+	return fmt.Sprintf("Can't issue a certificate for '%s'. Usernames may only contain letters, digits and the characters _+=.@- and may not start with a period.", string(username))
+}
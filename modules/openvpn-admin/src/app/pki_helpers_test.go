@@ -0,0 +1,47 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"os"
+	"testing"
+)
+
+func TestBuildCaCreatesCertificateAndKey(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := loadCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Certificate.Subject.CommonName != "Acme CA" {
+		t.Errorf("expected the CA to be named after KEY_ORG, got %s", ca.Certificate.Subject.CommonName)
+	}
+	if !ca.Certificate.IsCA {
+		t.Errorf("expected a CA certificate")
+	}
+}
+
+// A CA whose key lives in a PKCS#11 token or KMS has a ca.crt but no ca.key
+func TestBuildCaRefusesToReplaceCaWithoutKeyFile(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(pkiLayout.CaKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+	original := readTestFile(t, pkiLayout.CaCertPath())
+
+	err := buildCa()
+	if _, ok := errors.Unwrap(err).(CaAlreadyExists); !ok {
+		t.Fatalf("expected CaAlreadyExists, got %v", err)
+	}
+	if readTestFile(t, pkiLayout.CaCertPath()) != original {
+		t.Errorf("expected ca.crt to be left alone")
+	}
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/aws_helpers"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/policy"
+	"strings"
+	"time"
+)
+
+// How long we remember who sent a message and which IAM groups they're in, so that a burst of requests doesn't look up
+// the same principal in IAM over and over
+const PRINCIPAL_CACHE_TTL = 5 * time.Minute
+
+type cachedPrincipal struct {
+	Principal  policy.Principal
+	ResolvedAt time.Time
+}
+
+// process-requests and process-revokes each handle one message at a time, so the cache doesn't need a lock
+var principalCache = map[string]cachedPrincipal{}
+
+// Decide whether the policy lets the sender of a message perform the operation on the target user's certificates.
+// Without a policy everything is allowed, as the IAM permissions on the queues are the only authorization. Returns a
+// PolicyDenied error if the policy denies the operation.
+func authorize(awsRegion string, accessPolicy *policy.Policy, senderId string, operation string, targetUsername string, requestedTtl time.Duration) (policy.Decision, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if accessPolicy == nil {
+		return policy.Decision{Effect: policy.ALLOW}, nil
+	}
+
+	requester, err := resolvePrincipal(awsRegion, senderId)
+	if err != nil {
+		return policy.Decision{}, err
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return policy.Decision{}, err
+	}
+
+	decision := accessPolicy.Evaluate(policy.Input{
+		Operation:      operation,
+		Requester:      requester,
+		TargetUsername: targetUsername,
+		DeviceCount:    len(index.FindByCommonName(targetUsername, pki.STATUS_VALID)),
+		RequestedTtl:   requestedTtl,
+		Now:            time.Now().UTC(),
+	})
+	logger.Infof("Policy decision for %s of %s by %s (%s): %s (%s)", operation, targetUsername, requester.String(), requester.Id, decision.Effect, decision.Explain())
+
+	if decision.Effect == policy.DENY {
+		return decision, errors.WithStackTrace(PolicyDenied{Operation: operation, Username: targetUsername, Decision: decision})
+	}
+	return decision, nil
+}
+
+// Work out which IAM principal sent a message from the SenderId SQS reports for it. For IAM users that is the user's
+// unique id, and for assumed roles it's the role's unique id followed by the session name. The session name is chosen
+// by whoever assumes the role, so a role never gets a username from it.
+func resolvePrincipal(awsRegion string, senderId string) (policy.Principal, error) {
+	if cached, ok := principalCache[senderId]; ok && time.Since(cached.ResolvedAt) < PRINCIPAL_CACHE_TTL {
+		return cached.Principal, nil
+	}
+
+	principal := policy.Principal{Id: senderId, Type: policy.PRINCIPAL_UNKNOWN, Groups: []string{}}
+
+	if roleId, sessionName, isRole := strings.Cut(senderId, ":"); isRole {
+		roleArn, err := aws_helpers.FindIamRoleArnById(awsRegion, roleId)
+		if err != nil {
+			return policy.Principal{}, err
+		}
+		if roleArn == "" {
+			return policy.Principal{}, errors.WithStackTrace(UnknownSender(senderId))
+		}
+
+		principal.Type = policy.PRINCIPAL_ROLE
+		principal.Id = roleId
+		principal.RoleArn = roleArn
+		principal.SessionName = sessionName
+	} else if strings.HasPrefix(senderId, "AIDA") {
+		userName, err := aws_helpers.FindIamUserNameById(awsRegion, senderId)
+		if err != nil {
+			return policy.Principal{}, err
+		}
+		if userName == "" {
+			return policy.Principal{}, errors.WithStackTrace(UnknownSender(senderId))
+		}
+
+		groups, err := aws_helpers.GetIamGroupsForUser(awsRegion, userName)
+		if err != nil {
+			return policy.Principal{}, err
+		}
+
+		principal.Type = policy.PRINCIPAL_USER
+		principal.Username = userName
+		principal.Groups = groups
+	}
+
+	principalCache[senderId] = cachedPrincipal{Principal: principal, ResolvedAt: time.Now()}
+	return principal, nil
+}
+
+// Custom errors
+
+// Sent back to the client, so it explains which rule denied the operation
+type PolicyDenied struct {
+	Operation string
+	Username  string
+	Decision  policy.Decision
+}
+
+func (err PolicyDenied) Error() string {
+	return fmt.Sprintf("Denied %s for %s by %s", err.Operation, err.Username, err.Decision.Explain())
+}
+
+type UnknownSender string
+
+func (err UnknownSender) Error() string {
+	return fmt.Sprintf("Could not find the IAM user or role with id %s that sent the message", string(err))
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/sessions"
+	"io/ioutil"
+	"time"
+)
+
+// The formats report sessions can print in
+const REPORT_FORMAT_TABLE = "table"
+const REPORT_FORMAT_CSV = "csv"
+const REPORT_FORMAT_JSON = "json"
+
+var ReportFormats = []string{REPORT_FORMAT_TABLE, REPORT_FORMAT_CSV, REPORT_FORMAT_JSON}
+
+// How far back report sessions looks by default
+const DEFAULT_REPORT_SINCE = "24h"
+
+// The most a report may take up in its reply, which has to fit in an SQS message of at most 256 KiB along with the rest
+// of the reply
+const MAX_REPORT_BYTES = 240 * 1024
+
+// The ways --since and --until can be written besides a duration, in the admin's time zone unless they have one
+var reportTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// Look up the sessions in the session log that a report request asks for, and encode them for the reply
+func reportSessions(request CertificateRevokeRequest) (string, error) {
+	if request.Since == nil || request.Until == nil {
+		return "", errors.WithStackTrace(MissingReportWindow{})
+	}
+
+	records, err := newSessionTracker(nil).History(sessions.Query{Since: *request.Since, Until: *request.Until, Username: request.Username})
+	if err != nil {
+		return "", err
+	}
+	return encodeSessionRecords(records)
+}
+
+// Encode the records as gzipped JSON in base64, which makes a report of thousands of sessions fit in one reply
+func encodeSessionRecords(records []*sessions.Record) (string, error) {
+	if records == nil {
+		records = []*sessions.Record{}
+	}
+	encoded, err := encodeReport(records)
+	if err != nil {
+		return "", err
+	}
+	if len(encoded) > MAX_REPORT_BYTES {
+		return "", errors.WithStackTrace(ReportTooLarge(len(records)))
+	}
+	return encoded, nil
+}
+
+func decodeSessionRecords(encoded string) ([]*sessions.Record, error) {
+	records := []*sessions.Record{}
+	if err := decodeReport(encoded, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Encode a report as gzipped JSON in base64
+func encodeReport(report interface{}) (string, error) {
+	reportJson, err := json.Marshal(report)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(reportJson); err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+func decodeReport(encoded string, report interface{}) error {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	reportJson, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	return errors.WithStackTrace(json.Unmarshal(reportJson, report))
+}
+
+// Parse --since or --until: a time such as 2026-10-13T03:00, in the local time zone unless it has one, or a duration
+// such as 24h, meaning that long before now
+func parseReportTime(option string, value string, now time.Time) (time.Time, error) {
+	for _, layout := range reportTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return now.Add(-duration), nil
+	}
+	return time.Time{}, errors.WithStackTrace(InvalidReportTime{Option: option, Value: value})
+}
+
+// Custom errors
+
+type MissingReportWindow struct{}
+
+func (err MissingReportWindow) Error() string {
+	return "A session report request must say the time to report from and the time to report to."
+}
+
+type ReportTooLarge int
+
+func (count ReportTooLarge) Error() string {
+	return fmt.Sprintf("The report has %d sessions, which is too many to send in one reply. Report on a shorter time or on a single user.", int(count))
+}
+
+type InvalidReportTime struct {
+	Option string
+	Value  string
+}
+
+func (err InvalidReportTime) Error() string {
+	return fmt.Sprintf("--%s must be a time such as 2026-10-13, 2026-10-13T03:00 or 2026-10-13T03:00:00Z, or how long before now such as 24h, but was '%s'", err.Option, err.Value)
+}
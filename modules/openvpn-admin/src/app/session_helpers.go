@@ -0,0 +1,53 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/bootstrap"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/sessions"
+	"io/ioutil"
+	"os"
+)
+
+// init and server render write the connection policy in the server config here, or remove the file if there is none
+const CONNECTION_POLICY_PATH = OPENVPN_PATH + "/connection-policy.json"
+
+// The hooks record each session's start and end in the session log, and keep the sessions that haven't ended in the
+// active sessions file
+const SESSION_LOG_PATH = OPENVPN_PATH + "/sessions.log"
+const ACTIVE_SESSIONS_PATH = OPENVPN_PATH + "/sessions-active.json"
+
+// The connection policy configured for the server. Without one, users may connect at any time, as often as they like.
+func readConnectionPolicy() (*bootstrap.ConnectionPolicyConfig, error) {
+	contents, err := ioutil.ReadFile(CONNECTION_POLICY_PATH)
+	if os.IsNotExist(err) {
+		return &bootstrap.ConnectionPolicyConfig{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return bootstrap.ParseConnectionPolicy(contents)
+}
+
+// The session tracker for the hooks. current is the session the hook is running for, which tells which run of its
+// OpenVPN instance is the live one, or nil outside of the hooks.
+func newSessionTracker(current *sessions.Session) *sessions.Tracker {
+	return &sessions.Tracker{
+		LogPath:    SESSION_LOG_PATH,
+		ActivePath: ACTIVE_SESSIONS_PATH,
+		IsStale: func(session *sessions.Session) bool {
+			return isStaleSession(session, current)
+		},
+	}
+}
+
+// A session is stale if the OpenVPN process it was connected to has exited, e.g. because the server restarted, as
+// OpenVPN doesn't run client-disconnect for the clients it had then. A process of the same instance with a different
+// start time is a later run of the instance, whatever its process id.
+func isStaleSession(session *sessions.Session, current *sessions.Session) bool {
+	if session.DaemonPid > 0 && !files.IsDir(fmt.Sprintf("/proc/%d", session.DaemonPid)) {
+		return true
+	}
+	return current != nil && current.Instance == session.Instance && current.DaemonStartTime != session.DaemonStartTime
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// Whether the server was set up with a tls-crypt-v2 server key. PKIs created before tls-crypt-v2 support don't have
+// one, and their client profiles keep working without a client key.
+func isTlsCryptV2Enabled() bool {
+	return files.FileExists(pkiLayout.TlsCryptV2ServerKeyPath())
+}
+
+// Generate a tls-crypt-v2 client key for the certificate with the given serial and write it to the tls-crypt-v2 dir
+func writeTlsCryptV2ClientKey(username string, serial *big.Int) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	if !isTlsCryptV2Enabled() {
+		logger.Debugf("%s does not exist. Not generating a tls-crypt-v2 client key for %s.", pkiLayout.TlsCryptV2ServerKeyPath(), username)
+		return nil
+	}
+
+	serverKey, err := pki.ReadTlsCryptV2ServerKey(pkiLayout.TlsCryptV2ServerKeyPath())
+	if err != nil {
+		return err
+	}
+
+	clientKey, err := serverKey.GenerateClientKey(pki.TlsCryptV2Metadata(serial))
+	if err != nil {
+		return err
+	}
+
+	path := pkiLayout.TlsCryptV2ClientKeyPath(username)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	logger.Debugf("Writing the tls-crypt-v2 client key for %s to %s", username, path)
+	return errors.WithStackTrace(ioutil.WriteFile(path, clientKey, 0600))
+}
+
+// Read a user's tls-crypt-v2 client key, wrapped in the <tls-crypt-v2> tag for a client profile. Returns an empty string
+// if tls-crypt-v2 isn't enabled. A user whose certificate was issued before the server key existed, e.g. on a PKI that
+// was switched over to tls-crypt-v2 or imported from elsewhere, gets a client key for that certificate the first time
+// their profile is built.
+func readTlsCryptV2ClientKeyBlock(username string) (string, error) {
+	if !isTlsCryptV2Enabled() {
+		return "", nil
+	}
+
+	path := pkiLayout.TlsCryptV2ClientKeyPath(username)
+	if !files.FileExists(path) {
+		certificate, err := pki.ReadCertificate(pkiLayout.CertPath(username))
+		if err != nil {
+			return "", err
+		}
+		if err := writeTlsCryptV2ClientKey(username, certificate.SerialNumber); err != nil {
+			return "", err
+		}
+	}
+
+	clientKey, err := files.ReadFileAsString(path)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("<tls-crypt-v2>\n%s</tls-crypt-v2>\n", clientKey), nil
+}
+
+// Decide whether a connecting client's tls-crypt-v2 key may be used. OpenVPN has already checked that the key was
+// wrapped with our server key, so all that's left is to make sure the certificate it was issued with is still valid,
+// which means a key is refused as soon as its certificate is revoked or put on hold.
+func verifyTlsCryptV2Metadata(metadataType int, metadata []byte) error {
+	if metadataType != pki.TLS_CRYPT_V2_METADATA_TYPE_USER {
+		return errors.WithStackTrace(UnknownTlsCryptV2MetadataType(metadataType))
+	}
+
+	serial, err := pki.ParseTlsCryptV2Metadata(metadata)
+	if err != nil {
+		return err
+	}
+
+	index, err := pki.ReadIndex(pkiLayout.IndexPath())
+	if err != nil {
+		return err
+	}
+
+	entry := index.FindBySerial(serial)
+	if entry == nil || entry.Status != pki.STATUS_VALID {
+		return errors.WithStackTrace(TlsCryptV2KeyRevoked(pki.TlsCryptV2Metadata(serial)))
+	}
+
+	return nil
+}
+
+// Custom errors
+
+type UnknownTlsCryptV2MetadataType int
+
+func (err UnknownTlsCryptV2MetadataType) Error() string {
+	return fmt.Sprintf("Refusing a tls-crypt-v2 client key with metadata type %d. Client keys issued by openvpn-admin have metadata type %d.", int(err), pki.TLS_CRYPT_V2_METADATA_TYPE_USER)
+}
+
+type TlsCryptV2KeyRevoked string
+
+func (err TlsCryptV2KeyRevoked) Error() string {
+	return fmt.Sprintf("Refusing the tls-crypt-v2 client key for certificate %s, which is not a valid certificate in the CA database", string(err))
+}
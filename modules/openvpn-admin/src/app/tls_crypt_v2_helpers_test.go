@@ -0,0 +1,55 @@
+package app
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/files"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"strings"
+	"testing"
+)
+
+func TestTlsCryptV2ClientKeyBlockIsEmptyWithoutServerKey(t *testing.T) {
+	useTestPki(t)
+
+	block, err := readTlsCryptV2ClientKeyBlock("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block != "" {
+		t.Errorf("expected no <tls-crypt-v2> block without a server key, got %q", block)
+	}
+}
+
+// Certificates issued before the server key existed have no client key until their profile is built
+func TestTlsCryptV2ClientKeyIsGeneratedForOlderCertificates(t *testing.T) {
+	useTestPki(t)
+
+	if err := buildCa(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issueClientCertificate("alice", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if files.FileExists(pkiLayout.TlsCryptV2ClientKeyPath("alice")) {
+		t.Fatalf("expected no client key without a server key")
+	}
+
+	if err := pki.GenerateTlsCryptV2ServerKey(pkiLayout.TlsCryptV2ServerKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := readTlsCryptV2ClientKeyBlock("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(block, "<tls-crypt-v2>\n-----BEGIN OpenVPN tls-crypt-v2 client key-----") {
+		t.Errorf("expected a <tls-crypt-v2> block, got %q", block)
+	}
+
+	again, err := readTlsCryptV2ClientKeyBlock("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != block {
+		t.Errorf("expected the client key to be generated once and then reused")
+	}
+}
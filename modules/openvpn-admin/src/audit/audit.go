@@ -0,0 +1,214 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/filelock"
+	"io"
+	"os"
+	"time"
+)
+
+// The outcome of an audited operation
+const RESULT_SUCCESS = "success"
+const RESULT_FAILURE = "failure"
+const RESULT_DENIED = "denied"
+const RESULT_PENDING = "pending"
+
+// Events are a few hundred bytes, so the last one is always within this many bytes of the end of the log
+const MAX_EVENT_LEN = 64 * 1024
+
+// Event is a single line in the audit log. Hash covers every other field, including the hash of the event before it, so
+// changing or removing an event breaks the chain from there on.
+type Event struct {
+	Time         time.Time
+	Action       string
+	RequesterId  string `json:",omitempty"`
+	Requester    string `json:",omitempty"`
+	Target       string `json:",omitempty"`
+	Serial       string `json:",omitempty"`
+	RequestId    string `json:",omitempty"`
+	MessageId    string `json:",omitempty"`
+	Result       string
+	Message      string `json:",omitempty"`
+	PreviousHash string
+	Hash         string
+}
+
+// Shipper sends a copy of each event somewhere outside of the OpenVPN server, so that the log survives the server. The
+// copy only survives whoever controls the server if the destination doesn't let the server change what it has written,
+// e.g. a CloudWatch Logs log group or an S3 bucket with Object Lock.
+type Shipper interface {
+	Ship(event *Event, line []byte) error
+}
+
+// Log is an append-only file of JSON events, one per line
+type Log struct {
+	Path     string
+	Shippers []Shipper
+}
+
+// Append the event to the log, chaining it to the last event in the log, and ship it. The event is written to the log
+// and shipped everywhere else even if shipping it somewhere fails, in which case the first shipping error is returned.
+func (log *Log) Append(event *Event) error {
+	file, err := os.OpenFile(log.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	// process-requests and process-revokes both append to the same log
+	if err := filelock.Lock(file); err != nil {
+		return err
+	}
+	defer filelock.Unlock(file)
+
+	previousHash, err := lastHash(file)
+	if err != nil {
+		return err
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+	event.PreviousHash = previousHash
+	event.Hash = ""
+
+	hash, err := hashEvent(event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	var shippingErr error
+	for _, shipper := range log.Shippers {
+		if err := shipper.Ship(event, line); err != nil && shippingErr == nil {
+			shippingErr = err
+		}
+	}
+	return shippingErr
+}
+
+// Check that every event in the log is chained to the one before it and hasn't been changed. Returns the number of
+// events in the log.
+func (log *Log) Verify() (int, error) {
+	file, err := os.Open(log.Path)
+	if err != nil {
+		return 0, errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, MAX_EVENT_LEN), MAX_EVENT_LEN)
+
+	previousHash := ""
+	count := 0
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		event := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return count, errors.WithStackTrace(BrokenChain{Path: log.Path, LineNumber: lineNumber, Reason: err.Error()})
+		}
+		if event.PreviousHash != previousHash {
+			return count, errors.WithStackTrace(BrokenChain{Path: log.Path, LineNumber: lineNumber, Reason: "the event doesn't follow the one before it, so events were removed or reordered"})
+		}
+
+		hash, err := hashLine(scanner.Bytes())
+		if err != nil {
+			return count, err
+		}
+		if hash != event.Hash {
+			return count, errors.WithStackTrace(BrokenChain{Path: log.Path, LineNumber: lineNumber, Reason: "the event was changed after it was written"})
+		}
+
+		previousHash = event.Hash
+		count++
+	}
+
+	return count, errors.WithStackTrace(scanner.Err())
+}
+
+func hashEvent(event *Event) (string, error) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	return hashLine(line)
+}
+
+// Hash the event with its fields in sorted order and without its own hash, so that the hash doesn't depend on the order
+// fields were written in, or on which fields this version of openvpn-admin knows about
+func hashLine(line []byte) (string, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	delete(fields, "Hash")
+
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Read the hash of the last event in the log, or an empty string if the log is empty
+func lastHash(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	offset := info.Size() - MAX_EVENT_LEN
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return "", errors.WithStackTrace(err)
+	}
+
+	tail = bytes.TrimRight(tail, "\n")
+	if len(tail) == 0 {
+		return "", nil
+	}
+	if newline := bytes.LastIndexByte(tail, '\n'); newline >= 0 {
+		tail = tail[newline+1:]
+	}
+
+	event := &Event{}
+	if err := json.Unmarshal(tail, event); err != nil {
+		return "", errors.WithStackTrace(BrokenChain{Path: file.Name(), Reason: fmt.Sprintf("can't read the last event: %s", err)})
+	}
+	return event.Hash, nil
+}
+
+// Custom errors
+
+type BrokenChain struct {
+	Path       string
+	LineNumber int
+	Reason     string
+}
+
+func (err BrokenChain) Error() string {
+	if err.LineNumber == 0 {
+		return fmt.Sprintf("The audit log %s is broken: %s", err.Path, err.Reason)
+	}
+	return fmt.Sprintf("The audit log %s is broken at line %d: %s", err.Path, err.LineNumber, err.Reason)
+}
@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type recordingShipper struct {
+	lines [][]byte
+	err   error
+}
+
+func (shipper *recordingShipper) Ship(event *Event, line []byte) error {
+	shipper.lines = append(shipper.lines, line)
+	return shipper.err
+}
+
+func newTestLog(t *testing.T, events int) *Log {
+	log := &Log{Path: filepath.Join(t.TempDir(), "audit.log")}
+	for i := 0; i < events; i++ {
+		event := &Event{Action: "request", Target: fmt.Sprintf("user-%d", i), Result: RESULT_SUCCESS}
+		if err := log.Append(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return log
+}
+
+func rewriteTestLog(t *testing.T, log *Log, rewrite func(lines [][]byte) [][]byte) {
+	contents, err := ioutil.ReadFile(log.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := rewrite(bytes.Split(bytes.TrimRight(contents, "\n"), []byte("\n")))
+	if err := ioutil.WriteFile(log.Path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertBrokenAt(t *testing.T, log *Log, lineNumber int) {
+	count, err := log.Verify()
+	if err == nil {
+		t.Fatalf("expected the log to be broken at line %d, but all %d events verified", lineNumber, count)
+	}
+	broken, ok := errors.Unwrap(err).(BrokenChain)
+	if !ok {
+		t.Fatalf("expected BrokenChain, got %v", err)
+	}
+	if broken.LineNumber != lineNumber {
+		t.Errorf("expected the log to be broken at line %d, got %v", lineNumber, broken)
+	}
+	if count != lineNumber-1 {
+		t.Errorf("expected %d events to verify, got %d", lineNumber-1, count)
+	}
+}
+
+func TestAppendChainsEvents(t *testing.T) {
+	log := newTestLog(t, 3)
+
+	count, err := log.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 events, got %d", count)
+	}
+
+	// A new Log for the same file picks the chain up where the last one left off
+	reopened := &Log{Path: log.Path}
+	if err := reopened.Append(&Event{Action: "revoke", Result: RESULT_DENIED}); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := reopened.Verify(); err != nil || count != 4 {
+		t.Errorf("expected 4 events, got %d: %v", count, err)
+	}
+}
+
+func TestVerifyDetectsChangedEvent(t *testing.T) {
+	log := newTestLog(t, 3)
+	rewriteTestLog(t, log, func(lines [][]byte) [][]byte {
+		lines[1] = bytes.Replace(lines[1], []byte(`"user-1"`), []byte(`"mallory"`), 1)
+		return lines
+	})
+	assertBrokenAt(t, log, 2)
+}
+
+func TestVerifyDetectsRemovedEvent(t *testing.T) {
+	log := newTestLog(t, 3)
+	rewriteTestLog(t, log, func(lines [][]byte) [][]byte {
+		return append(lines[:1], lines[2:]...)
+	})
+	assertBrokenAt(t, log, 2)
+}
+
+func TestVerifyDetectsReorderedEvents(t *testing.T) {
+	log := newTestLog(t, 3)
+	rewriteTestLog(t, log, func(lines [][]byte) [][]byte {
+		lines[1], lines[2] = lines[2], lines[1]
+		return lines
+	})
+	assertBrokenAt(t, log, 2)
+}
+
+func TestVerifyDetectsRemovedFirstEvent(t *testing.T) {
+	log := newTestLog(t, 2)
+	rewriteTestLog(t, log, func(lines [][]byte) [][]byte {
+		return lines[1:]
+	})
+	assertBrokenAt(t, log, 1)
+}
+
+func TestAppendWritesEventEvenIfShippingFails(t *testing.T) {
+	failing := &recordingShipper{err: fmt.Errorf("throttled")}
+	working := &recordingShipper{}
+	log := &Log{Path: filepath.Join(t.TempDir(), "audit.log"), Shippers: []Shipper{failing, working}}
+
+	if err := log.Append(&Event{Action: "request", Result: RESULT_SUCCESS}); err != failing.err {
+		t.Errorf("expected the shipping error, got %v", err)
+	}
+	if len(working.lines) != 1 {
+		t.Errorf("expected the event to be shipped to the other shippers, got %d", len(working.lines))
+	}
+	if count, err := log.Verify(); err != nil || count != 1 {
+		t.Errorf("expected the event in the log, got %d: %v", count, err)
+	}
+}
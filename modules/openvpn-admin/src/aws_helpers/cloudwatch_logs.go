@@ -0,0 +1,85 @@
+package aws_helpers
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"time"
+)
+
+// LogStream writes events to a CloudWatch Logs stream. Each writer must have a stream of its own, as CloudWatch Logs
+// only accepts events from whoever has the stream's current sequence token.
+type LogStream struct {
+	AwsRegion     string
+	LogGroup      string
+	Name          string
+	sequenceToken *string
+	created       bool
+}
+
+// Write a single event to the stream, creating the stream first if it doesn't exist
+func (stream *LogStream) Put(timestamp time.Time, message string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	sess, err := CreateAwsSession(stream.AwsRegion, NO_IAM_ROLE)
+	if err != nil {
+		return err
+	}
+	client := cloudwatchlogs.New(sess)
+
+	if !stream.created {
+		if err := stream.create(client); err != nil {
+			return err
+		}
+	}
+
+	logger.Debugf("Writing an event to CloudWatch Logs stream %s in %s", stream.Name, stream.LogGroup)
+	output, err := client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(stream.LogGroup),
+		LogStreamName: aws.String(stream.Name),
+		SequenceToken: stream.sequenceToken,
+		LogEvents: []*cloudwatchlogs.InputLogEvent{
+			{
+				Timestamp: aws.Int64(timestamp.UnixNano() / int64(time.Millisecond)),
+				Message:   aws.String(message),
+			},
+		},
+	})
+	if err != nil {
+		// Someone else wrote to the stream, e.g. an earlier run of the same process, so look up the token again next time
+		stream.created = false
+		return errors.WithStackTrace(err)
+	}
+
+	stream.sequenceToken = output.NextSequenceToken
+	return nil
+}
+
+// Create the stream, or pick up the sequence token of the stream that's already there
+func (stream *LogStream) create(client *cloudwatchlogs.CloudWatchLogs) error {
+	_, err := client.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(stream.LogGroup),
+		LogStreamName: aws.String(stream.Name),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		output, err := client.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+			LogGroupName:        aws.String(stream.LogGroup),
+			LogStreamNamePrefix: aws.String(stream.Name),
+		})
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		for _, existing := range output.LogStreams {
+			if aws.StringValue(existing.LogStreamName) == stream.Name {
+				stream.sequenceToken = existing.UploadSequenceToken
+			}
+		}
+	} else if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	stream.created = true
+	return nil
+}
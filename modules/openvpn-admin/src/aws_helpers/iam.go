@@ -2,6 +2,7 @@ package aws_helpers
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
@@ -58,6 +59,82 @@ func GetIamUserName(awsRegion string) (string, error) {
 	return *resp.User.UserName, nil
 }
 
+// Find the name of the IAM user with the given unique id, such as the AIDA... id that SQS reports as the sender of a
+// message. Returns an empty string if there is no such user.
+func FindIamUserNameById(awsRegion string, userId string) (string, error) {
+	iamClient, err := createIamClient(awsRegion)
+	if err != nil {
+		return "", err
+	}
+
+	userName := ""
+	err = iamClient.ListUsersPages(&iam.ListUsersInput{}, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		for _, user := range page.Users {
+			if aws.StringValue(user.UserId) == userId {
+				userName = aws.StringValue(user.UserName)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	return userName, nil
+}
+
+// Find the ARN of the IAM role with the given unique id, such as the AROA... id that SQS reports, followed by the session
+// name, as the sender of a message sent with the role's credentials. Returns an empty string if there is no such role.
+func FindIamRoleArnById(awsRegion string, roleId string) (string, error) {
+	iamClient, err := createIamClient(awsRegion)
+	if err != nil {
+		return "", err
+	}
+
+	roleArn := ""
+	err = iamClient.ListRolesPages(&iam.ListRolesInput{}, func(page *iam.ListRolesOutput, lastPage bool) bool {
+		for _, role := range page.Roles {
+			if aws.StringValue(role.RoleId) == roleId {
+				roleArn = aws.StringValue(role.Arn)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+
+	return roleArn, nil
+}
+
+func GetIamGroupsForUser(awsRegion string, userName string) ([]string, error) {
+	iamClient, err := createIamClient(awsRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	err = iamClient.ListGroupsForUserPages(&iam.ListGroupsForUserInput{UserName: aws.String(userName)}, func(page *iam.ListGroupsForUserOutput, lastPage bool) bool {
+		for _, group := range page.Groups {
+			groups = append(groups, aws.StringValue(group.GroupName))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	return groups, nil
+}
+
+// Whether an IAM call failed because the user, group or role it named doesn't exist
+func IsNoSuchIamEntity(err error) bool {
+	awsErr, ok := errors.Unwrap(err).(awserr.Error)
+	return ok && awsErr.Code() == iam.ErrCodeNoSuchEntityException
+}
+
 func createIamClient(awsRegion string) (*iam.IAM, error) {
 	sess, err := CreateAwsSession(awsRegion, NO_IAM_ROLE)
 	if err != nil {
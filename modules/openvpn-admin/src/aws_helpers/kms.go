@@ -0,0 +1,222 @@
+package aws_helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io"
+	"strings"
+)
+
+// The version of the AWS SDK we use predates asymmetric KMS keys, so we define the Sign and GetPublicKey operations
+// ourselves. They use the same JSON protocol as the rest of the KMS API.
+
+type kmsGetPublicKeyInput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId *string `min:"1" type:"string" required:"true"`
+}
+
+type kmsGetPublicKeyOutput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId             *string   `type:"string"`
+	KeyUsage          *string   `type:"string"`
+	PublicKey         []byte    `type:"blob"`
+	SigningAlgorithms []*string `type:"list"`
+}
+
+type kmsSignInput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId            *string `min:"1" type:"string" required:"true"`
+	Message          []byte  `type:"blob" required:"true"`
+	MessageType      *string `type:"string"`
+	SigningAlgorithm *string `type:"string" required:"true"`
+}
+
+type kmsSignOutput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId            *string `type:"string"`
+	Signature        []byte  `type:"blob"`
+	SigningAlgorithm *string `type:"string"`
+}
+
+// KmsSigner is a crypto.Signer backed by an asymmetric KMS key with the SIGN_VERIFY key usage. The private key never
+// leaves KMS: each signature is a kms:Sign call on a digest computed locally.
+type KmsSigner struct {
+	client    *kms.KMS
+	keyArn    string
+	publicKey crypto.PublicKey
+}
+
+// Create a signer for the KMS key with the given ARN. The region is taken from the ARN. If endpoint isn't empty, requests
+// are sent there instead of to AWS, which is useful for testing against a local KMS stand-in.
+func NewKmsSigner(keyArn string, endpoint string) (*KmsSigner, error) {
+	region, err := regionFromArn(keyArn)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := CreateAwsSession(region, NO_IAM_ROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	config := aws.NewConfig()
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	client := kms.New(sess, config)
+
+	output := &kmsGetPublicKeyOutput{}
+	operation := &request.Operation{Name: "GetPublicKey", HTTPMethod: "POST", HTTPPath: "/"}
+	if err := client.NewRequest(operation, &kmsGetPublicKeyInput{KeyId: aws.String(keyArn)}, output).Send(); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	if aws.StringValue(output.KeyUsage) != "SIGN_VERIFY" {
+		return nil, errors.WithStackTrace(KmsKeyCannotSign{KeyArn: keyArn, KeyUsage: aws.StringValue(output.KeyUsage)})
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(output.PublicKey)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	return &KmsSigner{client: client, keyArn: keyArn, publicKey: publicKey}, nil
+}
+
+func (signer *KmsSigner) Public() crypto.PublicKey {
+	return signer.publicKey
+}
+
+// Sign the given digest with the KMS key, using the KMS signing algorithm that matches the key type and options
+func (signer *KmsSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	algorithm, err := kmsSigningAlgorithm(signer.publicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &kmsSignInput{
+		KeyId:            aws.String(signer.keyArn),
+		Message:          digest,
+		MessageType:      aws.String("DIGEST"),
+		SigningAlgorithm: aws.String(algorithm),
+	}
+	output := &kmsSignOutput{}
+	operation := &request.Operation{Name: "Sign", HTTPMethod: "POST", HTTPPath: "/"}
+	if err := signer.client.NewRequest(operation, input, output).Send(); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	// KMS returns ECDSA signatures DER encoded and RSA signatures as raw bytes, which are the formats crypto.Signer
+	// callers expect
+	return output.Signature, nil
+}
+
+func kmsSigningAlgorithm(publicKey crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	hashNames := map[crypto.Hash]string{
+		crypto.SHA256: "SHA_256",
+		crypto.SHA384: "SHA_384",
+		crypto.SHA512: "SHA_512",
+	}
+
+	hashName, ok := hashNames[opts.HashFunc()]
+	if !ok {
+		return "", errors.WithStackTrace(UnsupportedKmsSigningAlgorithm(fmt.Sprintf("%T with hash %v", publicKey, opts.HashFunc())))
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		if _, isPss := opts.(*rsa.PSSOptions); isPss {
+			return "RSASSA_PSS_" + hashName, nil
+		}
+		return "RSASSA_PKCS1_V1_5_" + hashName, nil
+	case *ecdsa.PublicKey:
+		return "ECDSA_" + hashName, nil
+	default:
+		return "", errors.WithStackTrace(UnsupportedKmsSigningAlgorithm(fmt.Sprintf("%T", publicKey)))
+	}
+}
+
+// KmsKeyWrapper wraps the data keys that backups are encrypted with using a symmetric KMS key, so that only those
+// allowed to decrypt with the key can read the backups
+type KmsKeyWrapper struct {
+	AwsRegion string
+	KeyId     string
+}
+
+// A new AES-256 data key from KMS, in plaintext and encrypted with the KMS key
+func (wrapper KmsKeyWrapper) NewDataKey() ([]byte, []byte, error) {
+	sess, err := CreateAwsSession(wrapper.AwsRegion, NO_IAM_ROLE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output, err := kms.New(sess).GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(wrapper.KeyId),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, errors.WithStackTrace(err)
+	}
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+// Decrypt a data key with KMS. The encrypted key says which KMS key encrypted it, so the key id isn't needed.
+func (wrapper KmsKeyWrapper) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	sess, err := CreateAwsSession(wrapper.AwsRegion, NO_IAM_ROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := kms.New(sess).Decrypt(&kms.DecryptInput{CiphertextBlob: wrapped})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return output.Plaintext, nil
+}
+
+func (wrapper KmsKeyWrapper) Description() string {
+	return "kms:" + wrapper.KeyId
+}
+
+// KMS key ARNs look like arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab
+func regionFromArn(arn string) (string, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 6 || parts[0] != "arn" || parts[2] != "kms" || parts[3] == "" {
+		return "", errors.WithStackTrace(InvalidKmsKeyArn(arn))
+	}
+	return parts[3], nil
+}
+
+// Custom errors
+
+type InvalidKmsKeyArn string
+
+func (err InvalidKmsKeyArn) Error() string {
+	return fmt.Sprintf("Invalid KMS key ARN '%s'. Expected an ARN like arn:aws:kms:<region>:<account>:key/<key-id>.", string(err))
+}
+
+type KmsKeyCannotSign struct {
+	KeyArn   string
+	KeyUsage string
+}
+
+func (err KmsKeyCannotSign) Error() string {
+	return fmt.Sprintf("The KMS key %s has key usage %s. The CA needs an asymmetric key with key usage SIGN_VERIFY.", err.KeyArn, err.KeyUsage)
+}
+
+type UnsupportedKmsSigningAlgorithm string
+
+func (err UnsupportedKmsSigningAlgorithm) Error() string {
+	return fmt.Sprintf("KMS cannot sign for %s", string(err))
+}
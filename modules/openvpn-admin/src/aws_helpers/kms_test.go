@@ -0,0 +1,289 @@
+package aws_helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const TEST_KMS_KEY_ARN = "arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+// A local stand-in for KMS that answers GetPublicKey and Sign for a single asymmetric key, signing the way KMS does
+type testKms struct {
+	key        crypto.Signer
+	keyUsage   string
+	algorithms []string
+}
+
+func (stub *testKms) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	input := map[string]interface{}{}
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input["KeyId"] != TEST_KMS_KEY_ARN {
+		stub.fail(writer, "NotFoundException", fmt.Sprintf("Key '%v' does not exist", input["KeyId"]))
+		return
+	}
+
+	var output interface{}
+	switch request.Header.Get("X-Amz-Target") {
+	case "TrentService.GetPublicKey":
+		publicKey, err := x509.MarshalPKIXPublicKey(stub.key.Public())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		output = map[string]interface{}{"KeyId": TEST_KMS_KEY_ARN, "KeyUsage": stub.keyUsage, "PublicKey": publicKey}
+	case "TrentService.Sign":
+		signingInput := struct {
+			Message          []byte
+			MessageType      string
+			SigningAlgorithm string
+		}{}
+		body, _ := json.Marshal(input)
+		json.Unmarshal(body, &signingInput)
+
+		if signingInput.MessageType != "DIGEST" {
+			stub.fail(writer, "ValidationException", "expected a digest")
+			return
+		}
+		signature, err := stub.sign(signingInput.SigningAlgorithm, signingInput.Message)
+		if err != nil {
+			stub.fail(writer, "InvalidKeyUsageException", err.Error())
+			return
+		}
+		stub.algorithms = append(stub.algorithms, signingInput.SigningAlgorithm)
+		output = map[string]interface{}{"KeyId": TEST_KMS_KEY_ARN, "Signature": signature, "SigningAlgorithm": signingInput.SigningAlgorithm}
+	default:
+		stub.fail(writer, "UnknownOperationException", request.Header.Get("X-Amz-Target"))
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(writer).Encode(output)
+}
+
+// Sign the digest the way KMS does for the given signing algorithm: RSA signatures as raw bytes, PSS with a salt as
+// long as the hash, and ECDSA signatures DER encoded
+func (stub *testKms) sign(algorithm string, digest []byte) ([]byte, error) {
+	hashes := map[string]crypto.Hash{"SHA_256": crypto.SHA256, "SHA_384": crypto.SHA384, "SHA_512": crypto.SHA512}
+
+	switch key := stub.key.(type) {
+	case *rsa.PrivateKey:
+		for name, hash := range hashes {
+			switch algorithm {
+			case "RSASSA_PKCS1_V1_5_" + name:
+				return rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+			case "RSASSA_PSS_" + name:
+				return rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			}
+		}
+	case *ecdsa.PrivateKey:
+		for name := range hashes {
+			if algorithm == "ECDSA_"+name {
+				return ecdsa.SignASN1(rand.Reader, key, digest)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is not a valid signing algorithm for this key", algorithm)
+}
+
+func (stub *testKms) fail(writer http.ResponseWriter, errorType string, message string) {
+	writer.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	writer.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(writer).Encode(map[string]string{"__type": errorType, "message": message})
+}
+
+// Serve the given key from a KMS stand-in for the duration of the test, and return its endpoint
+func useTestKms(t *testing.T, stub *testKms) string {
+	t.Helper()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func digestOf(hashFunc crypto.Hash, message string) []byte {
+	var hasher hash.Hash
+	switch hashFunc {
+	case crypto.SHA256:
+		hasher = sha256.New()
+	case crypto.SHA384:
+		hasher = sha512.New384()
+	default:
+		hasher = sha512.New()
+	}
+	hasher.Write([]byte(message))
+	return hasher.Sum(nil)
+}
+
+func verifyTestSignature(publicKey crypto.PublicKey, opts crypto.SignerOpts, digest []byte, signature []byte) bool {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		if pssOptions, isPss := opts.(*rsa.PSSOptions); isPss {
+			return rsa.VerifyPSS(publicKey, opts.HashFunc(), digest, signature, pssOptions) == nil
+		}
+		return rsa.VerifyPKCS1v15(publicKey, opts.HashFunc(), digest, signature) == nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(publicKey, digest, signature)
+	default:
+		return false
+	}
+}
+
+func TestKmsSignerSignaturesVerifyWithThePublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name      string
+		key       crypto.Signer
+		opts      crypto.SignerOpts
+		algorithm string
+	}{
+		{"RSA PKCS#1 v1.5 SHA-256", rsaKey, crypto.SHA256, "RSASSA_PKCS1_V1_5_SHA_256"},
+		{"RSA PKCS#1 v1.5 SHA-512", rsaKey, crypto.SHA512, "RSASSA_PKCS1_V1_5_SHA_512"},
+		{"RSA-PSS SHA-256", rsaKey, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, "RSASSA_PSS_SHA_256"},
+		{"RSA-PSS SHA-384", rsaKey, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}, "RSASSA_PSS_SHA_384"},
+		{"ECDSA P-256 SHA-256", p256Key, crypto.SHA256, "ECDSA_SHA_256"},
+		{"ECDSA P-384 SHA-384", p384Key, crypto.SHA384, "ECDSA_SHA_384"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			stub := &testKms{key: testCase.key, keyUsage: "SIGN_VERIFY"}
+			signer, err := NewKmsSigner(TEST_KMS_KEY_ARN, useTestKms(t, stub))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(signer.Public(), testCase.key.Public()) {
+				t.Fatalf("Expected the public key of the KMS key, got %v", signer.Public())
+			}
+
+			digest := digestOf(testCase.opts.HashFunc(), "CN=john")
+			signature, err := signer.Sign(rand.Reader, digest, testCase.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stub.algorithms, []string{testCase.algorithm}) {
+				t.Errorf("Expected KMS to sign with %s, got %v", testCase.algorithm, stub.algorithms)
+			}
+
+			if !verifyTestSignature(signer.Public(), testCase.opts, digest, signature) {
+				t.Error("Signature doesn't verify with the public key")
+			}
+			if verifyTestSignature(signer.Public(), testCase.opts, digestOf(testCase.opts.HashFunc(), "CN=jane"), signature) {
+				t.Error("Signature verifies a different digest")
+			}
+		})
+	}
+}
+
+func TestNewKmsSignerRefusesKeysThatCannotSign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewKmsSigner(TEST_KMS_KEY_ARN, useTestKms(t, &testKms{key: key, keyUsage: "ENCRYPT_DECRYPT"}))
+	cannotSign, ok := errors.Unwrap(err).(KmsKeyCannotSign)
+	if !ok {
+		t.Fatalf("Expected KmsKeyCannotSign, got %v", err)
+	}
+	if cannotSign.KeyUsage != "ENCRYPT_DECRYPT" || cannotSign.KeyArn != TEST_KMS_KEY_ARN {
+		t.Errorf("Expected the key and its usage in the error, got %+v", cannotSign)
+	}
+}
+
+func TestKmsSignerRefusesUnsupportedHashesWithoutCallingKms(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &testKms{key: key, keyUsage: "SIGN_VERIFY"}
+	signer, err := NewKmsSigner(TEST_KMS_KEY_ARN, useTestKms(t, stub))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hashFunc := range []crypto.Hash{crypto.SHA1, crypto.SHA224, crypto.MD5} {
+		_, err := signer.Sign(rand.Reader, make([]byte, hashFunc.Size()), hashFunc)
+		if _, ok := errors.Unwrap(err).(UnsupportedKmsSigningAlgorithm); !ok {
+			t.Errorf("Expected UnsupportedKmsSigningAlgorithm for %v, got %v", hashFunc, err)
+		}
+	}
+	if len(stub.algorithms) != 0 {
+		t.Errorf("Expected no signatures from KMS, got %v", stub.algorithms)
+	}
+}
+
+func TestKmsSigningAlgorithm(t *testing.T) {
+	rsaKey := &rsa.PublicKey{}
+	ecdsaKey := &ecdsa.PublicKey{}
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name      string
+		publicKey crypto.PublicKey
+		opts      crypto.SignerOpts
+		expected  string
+	}{
+		{"RSA SHA-384", rsaKey, crypto.SHA384, "RSASSA_PKCS1_V1_5_SHA_384"},
+		{"RSA-PSS SHA-512", rsaKey, &rsa.PSSOptions{Hash: crypto.SHA512}, "RSASSA_PSS_SHA_512"},
+		{"ECDSA SHA-512", ecdsaKey, crypto.SHA512, "ECDSA_SHA_512"},
+		{"RSA SHA-1", rsaKey, crypto.SHA1, ""},
+		{"RSA-PSS SHA-1", rsaKey, &rsa.PSSOptions{Hash: crypto.SHA1}, ""},
+		{"ECDSA SHA-224", ecdsaKey, crypto.SHA224, ""},
+		{"Ed25519", ed25519Key, crypto.SHA256, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			algorithm, err := kmsSigningAlgorithm(testCase.publicKey, testCase.opts)
+			if testCase.expected == "" {
+				if _, ok := errors.Unwrap(err).(UnsupportedKmsSigningAlgorithm); !ok {
+					t.Fatalf("Expected UnsupportedKmsSigningAlgorithm, got %q, %v", algorithm, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if algorithm != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, algorithm)
+			}
+		})
+	}
+}
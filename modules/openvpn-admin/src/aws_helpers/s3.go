@@ -0,0 +1,169 @@
+package aws_helpers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"io/ioutil"
+	"strings"
+)
+
+// S3-compatible stores such as MinIO ignore the region, but the SDK needs one to sign requests
+const DEFAULT_S3_COMPATIBLE_REGION = "us-east-1"
+
+// Write the body to the given key in the bucket. The bucket's default encryption applies.
+func PutS3Object(awsRegion string, bucket string, key string, body []byte) error {
+	client, err := newS3Client(awsRegion, "")
+	if err != nil {
+		return err
+	}
+	return putS3Object(client, bucket, key, body)
+}
+
+// List the keys of every object in the bucket under the given prefix
+func ListS3Objects(awsRegion string, bucket string, prefix string) ([]string, error) {
+	client, err := newS3Client(awsRegion, "")
+	if err != nil {
+		return nil, err
+	}
+	return listS3Objects(client, bucket, prefix)
+}
+
+// Read the object with the given key in the bucket. Objects encrypted with KMS are decrypted by S3.
+func GetS3Object(awsRegion string, bucket string, key string) ([]byte, error) {
+	client, err := newS3Client(awsRegion, "")
+	if err != nil {
+		return nil, err
+	}
+	return getS3Object(client, bucket, key)
+}
+
+// S3Storage keeps backups under a prefix in an S3 bucket, or in a bucket of an S3-compatible store such as MinIO
+type S3Storage struct {
+	AwsRegion string
+	Bucket    string
+	Prefix    string
+
+	// The URL of an S3-compatible store, e.g. https://minio.acme.internal:9000. Optional. Defaults to S3.
+	Endpoint string
+}
+
+func (storage S3Storage) Put(name string, contents []byte) error {
+	client, err := newS3Client(storage.AwsRegion, storage.Endpoint)
+	if err != nil {
+		return err
+	}
+	return putS3Object(client, storage.Bucket, storage.Prefix+name, contents)
+}
+
+func (storage S3Storage) Get(name string) ([]byte, error) {
+	client, err := newS3Client(storage.AwsRegion, storage.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return getS3Object(client, storage.Bucket, storage.Prefix+name)
+}
+
+// The names directly under the prefix, leaving out anything further down
+func (storage S3Storage) List() ([]string, error) {
+	client, err := newS3Client(storage.AwsRegion, storage.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := listS3Objects(client, storage.Bucket, storage.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, storage.Prefix)
+		if name != "" && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (storage S3Storage) Description() string {
+	if storage.Endpoint != "" {
+		return fmt.Sprintf("s3://%s/%s at %s", storage.Bucket, storage.Prefix, storage.Endpoint)
+	}
+	return fmt.Sprintf("s3://%s/%s", storage.Bucket, storage.Prefix)
+}
+
+// A client for S3, or for the S3-compatible store at the endpoint if it isn't empty. S3-compatible stores generally
+// don't have a DNS name for each bucket, so their buckets are addressed by path.
+func newS3Client(awsRegion string, endpoint string) (*s3.S3, error) {
+	if endpoint != "" && awsRegion == "" {
+		awsRegion = DEFAULT_S3_COMPATIBLE_REGION
+	}
+
+	sess, err := CreateAwsSession(awsRegion, NO_IAM_ROLE)
+	if err != nil {
+		return nil, err
+	}
+
+	config := aws.NewConfig()
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+	return s3.New(sess, config), nil
+}
+
+func putS3Object(client *s3.S3, bucket string, key string, body []byte) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	logger.Debugf("Writing s3://%s/%s", bucket, key)
+	request, _ := client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+
+	// Buckets with Object Lock, such as the audit bucket, refuse objects without a Content-MD5 header
+	digest := md5.Sum(body)
+	request.HTTPRequest.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(digest[:]))
+
+	return errors.WithStackTrace(request.Send())
+}
+
+func listS3Objects(client *s3.S3, bucket string, prefix string) ([]string, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	logger.Debugf("Listing s3://%s/%s", bucket, prefix)
+	keys := []string{}
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	return keys, errors.WithStackTrace(err)
+}
+
+func getS3Object(client *s3.S3, bucket string, key string) ([]byte, error) {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	logger.Debugf("Reading s3://%s/%s", bucket, key)
+	output, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	defer output.Body.Close()
+
+	body, err := ioutil.ReadAll(output.Body)
+	return body, errors.WithStackTrace(err)
+}
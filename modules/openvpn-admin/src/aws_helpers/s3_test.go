@@ -0,0 +1,54 @@
+package aws_helpers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Buckets with Object Lock refuse objects without a Content-MD5 header, and the header must be signed along with the
+// rest of the request
+func TestPutS3ObjectSendsSignedContentMd5(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received = request
+		receivedBody, _ = ioutil.ReadAll(request.Body)
+	}))
+	defer server.Close()
+
+	client, err := newS3Client("", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"Action":"revoke"}`)
+	if err := putS3Object(client, "audit-bucket", "audit/2026/10/18/abc.json", body); err != nil {
+		t.Fatal(err)
+	}
+
+	if received == nil {
+		t.Fatal("expected a request")
+	}
+	if received.Method != http.MethodPut || received.URL.Path != "/audit-bucket/audit/2026/10/18/abc.json" {
+		t.Errorf("expected a PUT of the object, got %s %s", received.Method, received.URL.Path)
+	}
+	if string(receivedBody) != string(body) {
+		t.Errorf("expected the body %q, got %q", body, receivedBody)
+	}
+
+	digest := md5.Sum(body)
+	if received.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(digest[:]) {
+		t.Errorf("expected the Content-MD5 of the body, got %q", received.Header.Get("Content-MD5"))
+	}
+	if !strings.Contains(received.Header.Get("Authorization"), "content-md5") {
+		t.Errorf("expected Content-MD5 to be signed, got %q", received.Header.Get("Authorization"))
+	}
+}
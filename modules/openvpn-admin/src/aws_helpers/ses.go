@@ -0,0 +1,36 @@
+package aws_helpers
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+)
+
+// Send a plain text email. The from address must be verified in SES.
+func SendEmail(awsRegion string, from string, to []string, subject string, body string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	sess, err := CreateAwsSession(awsRegion, NO_IAM_ROLE)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Emailing '%s' to %v", subject, to)
+	output, err := ses.New(sess).SendEmail(&ses.SendEmailInput{
+		Source:      aws.String(from),
+		Destination: &ses.Destination{ToAddresses: aws.StringSlice(to)},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(subject), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(body), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	logger.Debugf("Email %s sent to %v", aws.StringValue(output.MessageId), to)
+
+	return nil
+}
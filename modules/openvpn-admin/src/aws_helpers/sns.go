@@ -0,0 +1,30 @@
+package aws_helpers
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+)
+
+func PublishToTopic(awsRegion string, topicArn string, subject string, message string) error {
+	logger := logging.GetLogger(LOGGER_NAME)
+
+	sess, err := CreateAwsSession(awsRegion, NO_IAM_ROLE)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Publishing '%s' to SNS topic %s", subject, topicArn)
+	output, err := sns.New(sess).Publish(&sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(message),
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	logger.Debugf("Message id %s published to SNS topic %s", aws.StringValue(output.MessageId), topicArn)
+
+	return nil
+}
@@ -6,7 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/google/uuid"
 	"github.com/gruntwork-io/gruntwork-cli/errors"
-	"github.com/gruntwork-io/gruntwork-cli/logging"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
 	"strconv"
 	"strings"
 )
@@ -100,7 +100,7 @@ func SendMessageToQueue(awsRegion string, queueUrl string, message string) error
 		}
 		return err
 	}
-	logger.Debugf("Message id %s sent to queue %s", res.MessageId, queueUrl)
+	logger.Debugf("Message id %s sent to queue %s", aws.StringValue(res.MessageId), queueUrl)
 
 	return nil
 }
@@ -114,14 +114,32 @@ func CreateSqsClient(awsRegion string) (*sqs.SQS, error) {
 	return sqs.New(sess), nil
 }
 
+// QueueMessage is a message received from an SQS queue. SenderId is the unique id of the IAM user, or the role id and
+// session name of the IAM role, that sent it.
+type QueueMessage struct {
+	MessageId string
+	Receipt   string
+	Body      string
+	SenderId  string
+}
+
+// Waits to receive a message from on the queueUrl and returns its receipt handle and body
+func WaitForQueueMessage(awsRegion string, queueUrl string, timeout int) (string, string, error) {
+	message, err := ReceiveQueueMessage(awsRegion, queueUrl, timeout)
+	if err != nil {
+		return "", "", err
+	}
+	return message.Receipt, message.Body, nil
+}
+
 // Waits to receive a message from on the queueUrl. Since the API only allows us to wait a max 20 seconds for a new
 // message to arrive, we must loop TIMEOUT/20 number of times to be able to wait for a total of TIMEOUT seconds
-func WaitForQueueMessage(awsRegion string, queueUrl string, timeout int) (string, string, error) {
+func ReceiveQueueMessage(awsRegion string, queueUrl string, timeout int) (*QueueMessage, error) {
 	logger := logging.GetLogger(LOGGER_NAME)
 
 	sqsClient, err := CreateSqsClient(awsRegion)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	cycles := timeout
@@ -138,6 +156,7 @@ func WaitForQueueMessage(awsRegion string, queueUrl string, timeout int) (string
 			QueueUrl: aws.String(queueUrl),
 			AttributeNames: aws.StringSlice([]string{
 				"SentTimestamp",
+				"SenderId",
 			}),
 			MaxNumberOfMessages: aws.Int64(1),
 			MessageAttributeNames: aws.StringSlice([]string{
@@ -147,16 +166,22 @@ func WaitForQueueMessage(awsRegion string, queueUrl string, timeout int) (string
 		})
 
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
 
 		if len(result.Messages) > 0 {
-			logger.Debugf("Message %s received on %s", *result.Messages[0].MessageId, queueUrl)
-			return *result.Messages[0].ReceiptHandle, *result.Messages[0].Body, nil
+			message := result.Messages[0]
+			logger.Debugf("Message %s received on %s", *message.MessageId, queueUrl)
+			return &QueueMessage{
+				MessageId: *message.MessageId,
+				Receipt:   *message.ReceiptHandle,
+				Body:      *message.Body,
+				SenderId:  aws.StringValue(message.Attributes["SenderId"]),
+			}, nil
 		}
 	}
 
-	return "", "", fmt.Errorf("Failed to receive messages on %s within %s seconds", queueUrl, strconv.Itoa(timeout))
+	return nil, fmt.Errorf("Failed to receive messages on %s within %s seconds", queueUrl, strconv.Itoa(timeout))
 }
 
 func FindQueuesWithNamePrefix(awsRegion string, namePrefix string) ([]string, error) {
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"crypto/rand"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"strings"
+)
+
+// The size of the data keys that are wrapped with age or GPG, for AES-256
+const DATA_KEY_BYTES = 32
+
+// AgeKeyWrapper wraps data keys with age, so that backups can be read by whoever holds the identity for one of the
+// recipients, without needing KMS
+type AgeKeyWrapper struct {
+	// The public keys to wrap data keys for, e.g. age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+	Recipients []string
+
+	// The file with the identity (private key) to unwrap data keys with. Only needed to restore.
+	IdentityFile string
+}
+
+func (wrapper AgeKeyWrapper) NewDataKey() ([]byte, []byte, error) {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args := []string{"--encrypt"}
+	for _, recipient := range wrapper.Recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	wrappedKey, err := runCommand(dataKey, "age", args...)
+	return dataKey, wrappedKey, err
+}
+
+func (wrapper AgeKeyWrapper) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	return runCommand(wrapped, "age", "--decrypt", "--identity", wrapper.IdentityFile)
+}
+
+func (wrapper AgeKeyWrapper) Description() string {
+	return "age:" + strings.Join(wrapper.Recipients, ",")
+}
+
+func newDataKey() ([]byte, error) {
+	dataKey := make([]byte, DATA_KEY_BYTES)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,245 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The layout of the archive. Archives from a later layout can't be restored.
+const ARCHIVE_FORMAT = 1
+
+// The manifest is the first entry of every archive
+const MANIFEST_NAME = "manifest.json"
+
+// A PKI is a few megabytes at most. Don't let a broken archive fill up memory.
+const MAX_ARCHIVE_BYTES = 256 * 1024 * 1024
+
+// File is a file or directory in a backup. Path is relative to the root the backup is restored under, with forward
+// slashes, and Contents is nil for directories.
+type File struct {
+	Path     string
+	Mode     os.FileMode
+	Contents []byte
+}
+
+// ManifestEntry describes a file in the archive, along with the SHA-256 of its contents so that a restore can tell it
+// got back what was backed up
+type ManifestEntry struct {
+	Path   string
+	Mode   os.FileMode
+	Size   int64  `json:",omitempty"`
+	Sha256 string `json:",omitempty"`
+}
+
+// Manifest lists everything in an archive, and says when and where it was taken
+type Manifest struct {
+	Format  int
+	Version string
+	Created time.Time
+	Host    string
+	Files   []ManifestEntry
+}
+
+// Pack the files into a gzipped tar archive, with a manifest of them as its first entry
+func Pack(version string, created time.Time, host string, files []File) ([]byte, *Manifest, error) {
+	manifest := &Manifest{Format: ARCHIVE_FORMAT, Version: version, Created: created.UTC(), Host: host, Files: []ManifestEntry{}}
+	for _, file := range files {
+		if err := checkPath(file.Path); err != nil {
+			return nil, nil, err
+		}
+		entry := ManifestEntry{Path: file.Path, Mode: file.Mode}
+		if !file.Mode.IsDir() {
+			entry.Size = int64(len(file.Contents))
+			entry.Sha256 = checksum(file.Contents)
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	manifestJson, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, errors.WithStackTrace(err)
+	}
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	if err := writeTarEntry(tarWriter, File{Path: MANIFEST_NAME, Mode: 0600, Contents: manifestJson}, created); err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		if err := writeTarEntry(tarWriter, file, created); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, nil, errors.WithStackTrace(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, nil, errors.WithStackTrace(err)
+	}
+	return buffer.Bytes(), manifest, nil
+}
+
+// Unpack an archive made by Pack. Fails unless the archive has exactly the files its manifest lists, with the contents
+// it lists.
+func Unpack(archive []byte) (*Manifest, []File, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, nil, errors.WithStackTrace(MalformedArchive(err.Error()))
+	}
+	tarReader := tar.NewReader(io.LimitReader(gzipReader, MAX_ARCHIVE_BYTES))
+
+	var manifest *Manifest
+	files := []File{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.WithStackTrace(MalformedArchive(err.Error()))
+		}
+
+		contents, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, nil, errors.WithStackTrace(MalformedArchive(err.Error()))
+		}
+
+		if manifest == nil {
+			if header.Name != MANIFEST_NAME {
+				return nil, nil, errors.WithStackTrace(MalformedArchive(fmt.Sprintf("the first entry is %s rather than %s", header.Name, MANIFEST_NAME)))
+			}
+			manifest = &Manifest{}
+			if err := json.Unmarshal(contents, manifest); err != nil {
+				return nil, nil, errors.WithStackTrace(MalformedArchive(fmt.Sprintf("can't read %s: %s", MANIFEST_NAME, err)))
+			}
+			if manifest.Format > ARCHIVE_FORMAT {
+				return nil, nil, errors.WithStackTrace(UnsupportedArchiveFormat(manifest.Format))
+			}
+			continue
+		}
+
+		file := File{Path: strings.TrimSuffix(header.Name, "/"), Mode: header.FileInfo().Mode()}
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg:
+			file.Contents = contents
+		default:
+			return nil, nil, errors.WithStackTrace(MalformedArchive(fmt.Sprintf("%s is neither a file nor a directory", header.Name)))
+		}
+		if err := checkPath(file.Path); err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+	}
+
+	if manifest == nil {
+		return nil, nil, errors.WithStackTrace(MalformedArchive("the archive is empty"))
+	}
+	return manifest, files, verifyManifest(manifest, files)
+}
+
+// Check that the files are the ones the manifest lists
+func verifyManifest(manifest *Manifest, files []File) error {
+	unpacked := map[string]File{}
+	for _, file := range files {
+		if _, ok := unpacked[file.Path]; ok {
+			return errors.WithStackTrace(MalformedArchive(fmt.Sprintf("%s is in the archive more than once", file.Path)))
+		}
+		unpacked[file.Path] = file
+	}
+
+	for _, entry := range manifest.Files {
+		file, ok := unpacked[entry.Path]
+		if !ok {
+			return errors.WithStackTrace(ChecksumMismatch{Path: entry.Path, Reason: "it's in the manifest but not in the archive"})
+		}
+		delete(unpacked, entry.Path)
+
+		if file.Mode.IsDir() != entry.Mode.IsDir() {
+			return errors.WithStackTrace(ChecksumMismatch{Path: entry.Path, Reason: "the manifest and the archive disagree on whether it's a directory"})
+		}
+		if entry.Mode.IsDir() {
+			continue
+		}
+		if int64(len(file.Contents)) != entry.Size || checksum(file.Contents) != entry.Sha256 {
+			return errors.WithStackTrace(ChecksumMismatch{Path: entry.Path, Reason: "its contents don't match the checksum in the manifest"})
+		}
+	}
+
+	for path := range unpacked {
+		return errors.WithStackTrace(ChecksumMismatch{Path: path, Reason: "it's in the archive but not in the manifest"})
+	}
+	return nil
+}
+
+func writeTarEntry(tarWriter *tar.Writer, file File, modified time.Time) error {
+	header := &tar.Header{
+		Name:    file.Path,
+		Mode:    int64(file.Mode.Perm()),
+		ModTime: modified,
+	}
+	if file.Mode.IsDir() {
+		header.Name += "/"
+		header.Typeflag = tar.TypeDir
+	} else {
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(len(file.Contents))
+	}
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	_, err := tarWriter.Write(file.Contents)
+	return errors.WithStackTrace(err)
+}
+
+// Paths must stay under the root the backup is restored to
+func checkPath(name string) error {
+	if name == "" || name == MANIFEST_NAME || strings.HasPrefix(name, "/") || path.Clean(name) != name || strings.HasPrefix(name, "../") || name == ".." {
+		return errors.WithStackTrace(MalformedArchive(fmt.Sprintf("'%s' is not a path the archive may have", name)))
+	}
+	return nil
+}
+
+func checksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// Custom errors
+
+type MalformedArchive string
+
+func (reason MalformedArchive) Error() string {
+	return fmt.Sprintf("The backup archive is malformed: %s", string(reason))
+}
+
+type UnsupportedArchiveFormat int
+
+func (format UnsupportedArchiveFormat) Error() string {
+	return fmt.Sprintf("The backup archive has format %d, but this version of openvpn-admin only knows formats up to %d. Restore it with a later version.", int(format), ARCHIVE_FORMAT)
+}
+
+type ChecksumMismatch struct {
+	Path   string
+	Reason string
+}
+
+func (err ChecksumMismatch) Error() string {
+	return fmt.Sprintf("The backup archive doesn't match its manifest for %s: %s", err.Path, err.Reason)
+}
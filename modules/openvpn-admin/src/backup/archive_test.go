@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testFiles = []File{
+	{Path: "openvpn", Mode: os.ModeDir | 0700},
+	{Path: "openvpn/ca.crt", Mode: 0644, Contents: []byte("certificate")},
+	{Path: "openvpn/ca.key", Mode: 0600, Contents: []byte("key")},
+}
+
+// Write a tar.gz archive with the given manifest and files, the way Pack does but without checking anything
+func packTestArchive(t *testing.T, manifest *Manifest, files []File) []byte {
+	t.Helper()
+
+	manifestJson, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, file := range append([]File{{Path: MANIFEST_NAME, Mode: 0600, Contents: manifestJson}}, files...) {
+		if err := writeTarEntry(tarWriter, file, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestPackAndUnpack(t *testing.T) {
+	created := time.Date(2026, 10, 18, 20, 8, 36, 0, time.UTC)
+	archive, manifest, err := Pack("20261018T200836Z", created, "vpn.acme.com", testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unpackedManifest, files, err := Unpack(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unpackedManifest, manifest) {
+		t.Errorf("expected the manifest %+v, got %+v", manifest, unpackedManifest)
+	}
+	if unpackedManifest.Version != "20261018T200836Z" || unpackedManifest.Host != "vpn.acme.com" || !unpackedManifest.Created.Equal(created) {
+		t.Errorf("unexpected manifest %+v", unpackedManifest)
+	}
+	if !reflect.DeepEqual(files, testFiles) {
+		t.Errorf("expected the files %+v, got %+v", testFiles, files)
+	}
+}
+
+func TestUnpackChecksFilesAgainstTheManifest(t *testing.T) {
+	_, manifest, err := Pack("20261018T200836Z", time.Now(), "vpn.acme.com", testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := append([]File{}, testFiles...)
+	changed[2] = File{Path: "openvpn/ca.key", Mode: 0600, Contents: []byte("another key")}
+
+	testCases := []struct {
+		name  string
+		files []File
+	}{
+		{"changed file", changed},
+		{"missing file", testFiles[:2]},
+		{"extra file", append(append([]File{}, testFiles...), File{Path: "openvpn/extra.key", Mode: 0600, Contents: []byte("key")})},
+		{"duplicate file", append(append([]File{}, testFiles...), testFiles[1])},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, _, err := Unpack(packTestArchive(t, manifest, testCase.files))
+			switch errors.Unwrap(err).(type) {
+			case ChecksumMismatch, MalformedArchive:
+			default:
+				t.Errorf("expected the archive to be refused, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnpackRefusesPathsOutsideTheRoot(t *testing.T) {
+	for _, name := range []string{"../etc/passwd", "/etc/passwd", "openvpn/../../etc/passwd"} {
+		files := []File{{Path: name, Mode: 0644, Contents: []byte("root:x:0:0")}}
+		manifest := &Manifest{Format: ARCHIVE_FORMAT, Files: []ManifestEntry{{Path: name, Mode: 0644, Size: 10, Sha256: checksum(files[0].Contents)}}}
+
+		_, _, err := Unpack(packTestArchive(t, manifest, files))
+		if _, ok := errors.Unwrap(err).(MalformedArchive); !ok {
+			t.Errorf("expected %s to be refused, got %v", name, err)
+		}
+	}
+}
+
+func TestUnpackRefusesLaterFormats(t *testing.T) {
+	_, _, err := Unpack(packTestArchive(t, &Manifest{Format: ARCHIVE_FORMAT + 1}, nil))
+	if _, ok := errors.Unwrap(err).(UnsupportedArchiveFormat); !ok {
+		t.Errorf("expected UnsupportedArchiveFormat, got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"os/exec"
+	"strings"
+)
+
+// Run a command with the given input and return what it wrote to stdout. age, gpg and sftp do the work that needs keys
+// or credentials openvpn-admin doesn't hold.
+func runCommand(input []byte, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(name, args...)
+	command.Stdin = bytes.NewReader(input)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		return nil, errors.WithStackTrace(CommandFailed{Command: strings.Join(append([]string{name}, args...), " "), Output: strings.TrimSpace(stderr.String()), Cause: err})
+	}
+	return stdout.Bytes(), nil
+}
+
+// Custom errors
+
+type CommandFailed struct {
+	Command string
+	Output  string
+	Cause   error
+}
+
+func (err CommandFailed) Error() string {
+	return fmt.Sprintf("'%s' failed (%s): %s", err.Command, err.Cause, err.Output)
+}
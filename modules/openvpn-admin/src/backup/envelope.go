@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+)
+
+// Every sealed backup starts with this line, followed by its header as a line of JSON and then the encrypted archive
+const SEALED_MAGIC = "openvpn-admin-backup\n"
+
+// KeyWrapper protects the data key each backup is encrypted with, e.g. by encrypting it with a KMS key. Only someone
+// who can unwrap the data key can read the backup.
+type KeyWrapper interface {
+	// A new 256 bit data key, both in plaintext and wrapped
+	NewDataKey() ([]byte, []byte, error)
+
+	// The plaintext of a data key this wrapper wrapped
+	UnwrapDataKey(wrapped []byte) ([]byte, error)
+
+	// What wraps the keys, e.g. kms:alias/openvpn-backups, as recorded in the header of the backups it seals
+	Description() string
+}
+
+// Header says how a sealed backup was encrypted, and what the archive in it must hash to. It's authenticated along
+// with the archive, so it can't be changed without the backup failing to open.
+type Header struct {
+	Format     int
+	KeyWrapper string
+	WrappedKey []byte
+	Nonce      []byte
+	Sha256     string
+}
+
+// Encrypt the archive with a new data key, using AES-256-GCM
+func Seal(wrapper KeyWrapper, archive []byte) ([]byte, error) {
+	dataKey, wrappedKey, err := wrapper.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := Header{
+		Format:     ARCHIVE_FORMAT,
+		KeyWrapper: wrapper.Description(),
+		WrappedKey: wrappedKey,
+		Nonce:      make([]byte, aead.NonceSize()),
+		Sha256:     checksum(archive),
+	}
+	if _, err := rand.Read(header.Nonce); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	preamble := append([]byte(SEALED_MAGIC), append(headerJson, '\n')...)
+
+	// The output can't share memory with the additional data
+	return aead.Seal(append([]byte{}, preamble...), header.Nonce, archive, preamble), nil
+}
+
+// Decrypt a sealed backup and check the archive in it against the checksum in its header
+func Open(wrapper KeyWrapper, sealed []byte) ([]byte, *Header, error) {
+	header, preamble, err := ReadHeader(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataKey, err := wrapper.UnwrapDataKey(header.WrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(header.Nonce) != aead.NonceSize() {
+		return nil, nil, errors.WithStackTrace(NotASealedBackup("the nonce in its header is the wrong size"))
+	}
+
+	archive, err := aead.Open(nil, header.Nonce, sealed[len(preamble):], preamble)
+	if err != nil {
+		return nil, nil, errors.WithStackTrace(NotASealedBackup("it was changed or corrupted after it was encrypted"))
+	}
+	if checksum(archive) != header.Sha256 {
+		return nil, nil, errors.WithStackTrace(NotASealedBackup("the archive in it doesn't match the checksum in its header"))
+	}
+	return archive, header, nil
+}
+
+// Read the header of a sealed backup without decrypting it. Also returns the bytes up to the end of the header.
+func ReadHeader(sealed []byte) (*Header, []byte, error) {
+	if !bytes.HasPrefix(sealed, []byte(SEALED_MAGIC)) {
+		return nil, nil, errors.WithStackTrace(NotASealedBackup("it doesn't start like one"))
+	}
+
+	end := bytes.IndexByte(sealed[len(SEALED_MAGIC):], '\n')
+	if end < 0 {
+		return nil, nil, errors.WithStackTrace(NotASealedBackup("its header never ends"))
+	}
+	preamble := sealed[:len(SEALED_MAGIC)+end+1]
+
+	header := &Header{}
+	if err := json.Unmarshal(preamble[len(SEALED_MAGIC):], header); err != nil {
+		return nil, nil, errors.WithStackTrace(NotASealedBackup(fmt.Sprintf("can't read its header: %s", err)))
+	}
+	if header.Format > ARCHIVE_FORMAT {
+		return nil, nil, errors.WithStackTrace(UnsupportedArchiveFormat(header.Format))
+	}
+	return header, preamble, nil
+}
+
+func newAead(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.WithStackTrace(err)
+}
+
+// Custom errors
+
+type NotASealedBackup string
+
+func (reason NotASealedBackup) Error() string {
+	return fmt.Sprintf("This is not a backup openvpn-admin can open: %s", string(reason))
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"testing"
+)
+
+// A key wrapper that "wraps" data keys by XORing them with a key of its own
+type testKeyWrapper struct {
+	key []byte
+}
+
+func newTestKeyWrapper(t *testing.T) *testKeyWrapper {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return &testKeyWrapper{key: key}
+}
+
+func (wrapper *testKeyWrapper) NewDataKey() ([]byte, []byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := wrapper.UnwrapDataKey(dataKey)
+	return dataKey, wrapped, err
+}
+
+func (wrapper *testKeyWrapper) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) != len(wrapper.key) {
+		return nil, fmt.Errorf("wrong key size")
+	}
+	dataKey := make([]byte, len(wrapped))
+	for i := range wrapped {
+		dataKey[i] = wrapped[i] ^ wrapper.key[i]
+	}
+	return dataKey, nil
+}
+
+func (wrapper *testKeyWrapper) Description() string {
+	return "test"
+}
+
+func TestSealAndOpen(t *testing.T) {
+	wrapper := newTestKeyWrapper(t)
+	archive := []byte("the archive")
+
+	sealed, err := Seal(wrapper, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, archive) {
+		t.Errorf("expected the archive to be encrypted")
+	}
+
+	opened, header, err := Open(wrapper, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, archive) {
+		t.Errorf("expected %q, got %q", archive, opened)
+	}
+	if header.KeyWrapper != "test" || header.Format != ARCHIVE_FORMAT {
+		t.Errorf("unexpected header %+v", header)
+	}
+}
+
+func TestOpenRefusesChangedBackups(t *testing.T) {
+	wrapper := newTestKeyWrapper(t)
+	sealed, err := Seal(wrapper, []byte("the archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, preamble, err := ReadHeader(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changedCiphertext := append([]byte{}, sealed...)
+	changedCiphertext[len(changedCiphertext)-1] ^= 1
+
+	// The header is authenticated along with the archive
+	changedHeader := bytes.Replace(sealed, []byte(`"KeyWrapper":"test"`), []byte(`"KeyWrapper":"tset"`), 1)
+
+	truncated := sealed[:len(preamble)+4]
+
+	for name, changed := range map[string][]byte{"ciphertext": changedCiphertext, "header": changedHeader, "truncated": truncated} {
+		_, _, err := Open(wrapper, changed)
+		if _, ok := errors.Unwrap(err).(NotASealedBackup); !ok {
+			t.Errorf("expected the backup with a changed %s to be refused, got %v", name, err)
+		}
+	}
+
+	if _, _, err := Open(newTestKeyWrapper(t), sealed); err == nil {
+		t.Errorf("expected the backup not to open with another key")
+	}
+}
+
+func TestReadHeaderRefusesOtherFiles(t *testing.T) {
+	for _, contents := range []string{"", "-----BEGIN CERTIFICATE-----\n", SEALED_MAGIC + "{\"Format\":1", SEALED_MAGIC + "not json\n"} {
+		if _, _, err := ReadHeader([]byte(contents)); err == nil {
+			t.Errorf("expected %q to be refused", contents)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"strings"
+)
+
+// GpgKeyWrapper wraps data keys with GPG, so that backups can be read by whoever holds the secret key of one of the
+// recipients, e.g. on a smartcard, without needing KMS. The recipients' public keys must be in the keyring of the user
+// backup runs as.
+type GpgKeyWrapper struct {
+	// The key ids, fingerprints or email addresses to wrap data keys for
+	Recipients []string
+}
+
+func (wrapper GpgKeyWrapper) NewDataKey() ([]byte, []byte, error) {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The keys are imported by hand, so they're trusted without a web of trust
+	args := []string{"--batch", "--yes", "--trust-model", "always", "--encrypt"}
+	for _, recipient := range wrapper.Recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	wrappedKey, err := runCommand(dataKey, "gpg", args...)
+	return dataKey, wrappedKey, err
+}
+
+// gpg finds the secret key the data key was wrapped for in the keyring, or asks the agent for it
+func (wrapper GpgKeyWrapper) UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	return runCommand(wrapped, "gpg", "--batch", "--quiet", "--decrypt")
+}
+
+func (wrapper GpgKeyWrapper) Description() string {
+	return "gpg:" + strings.Join(wrapper.Recipients, ",")
+}
@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SftpStorage keeps backups in a directory on another machine, which it reaches with the OpenSSH sftp client. The host
+// must already be in known_hosts, as there's no one to confirm its key when the backup runs from cron.
+type SftpStorage struct {
+	User string
+	Host string
+	Port int
+	Dir  string
+
+	// The SSH private key to log in with. Optional. Without it, sftp uses the keys in ~/.ssh.
+	IdentityFile string
+}
+
+// Upload to a temp name first, so that a backup that's listed is always whole
+func (storage SftpStorage) Put(name string, contents []byte) error {
+	localFile, err := writeTempFile(contents)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(localFile)
+
+	remotePath := storage.remotePath(name)
+	_, err = storage.run(
+		fmt.Sprintf(`-mkdir "%s"`, storage.Dir),
+		fmt.Sprintf(`put "%s" "%s.tmp"`, localFile, remotePath),
+		fmt.Sprintf(`rename "%s.tmp" "%s"`, remotePath, remotePath),
+	)
+	return err
+}
+
+func (storage SftpStorage) Get(name string) ([]byte, error) {
+	localFile, err := writeTempFile(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(localFile)
+
+	if _, err := storage.run(fmt.Sprintf(`get "%s" "%s"`, storage.remotePath(name), localFile)); err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadFile(localFile)
+	return contents, errors.WithStackTrace(err)
+}
+
+// A directory that doesn't exist yet has nothing in it
+func (storage SftpStorage) List() ([]string, error) {
+	output, err := storage.run(fmt.Sprintf(`-ls -1 "%s"`, storage.Dir))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// In batch mode, sftp echoes each command it runs
+		if line == "" || strings.HasPrefix(line, "sftp>") {
+			continue
+		}
+		names = append(names, path.Base(line))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (storage SftpStorage) Description() string {
+	host := storage.Host
+	if storage.Port != 0 {
+		host += ":" + strconv.Itoa(storage.Port)
+	}
+	if storage.User != "" {
+		host = storage.User + "@" + host
+	}
+	return fmt.Sprintf("sftp://%s/%s", host, storage.Dir)
+}
+
+func (storage SftpStorage) remotePath(name string) string {
+	return path.Join(storage.Dir, name)
+}
+
+// Run the sftp commands in batch mode, which stops at the first one that fails unless it starts with -
+func (storage SftpStorage) run(commands ...string) ([]byte, error) {
+	args := []string{"-b", "-", "-q", "-o", "BatchMode=yes"}
+	if storage.Port != 0 {
+		args = append(args, "-P", strconv.Itoa(storage.Port))
+	}
+	if storage.IdentityFile != "" {
+		args = append(args, "-i", storage.IdentityFile)
+	}
+
+	destination := storage.Host
+	if storage.User != "" {
+		destination = storage.User + "@" + destination
+	}
+	args = append(args, destination)
+
+	return runCommand([]byte(strings.Join(commands, "\n")+"\n"), "sftp", args...)
+}
+
+func writeTempFile(contents []byte) (string, error) {
+	file, err := ioutil.TempFile("", "openvpn-admin-backup-")
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(contents); err != nil {
+		os.Remove(file.Name())
+		return "", errors.WithStackTrace(err)
+	}
+	return filepath.ToSlash(file.Name()), nil
+}
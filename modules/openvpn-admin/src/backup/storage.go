@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The kinds of places backups can be kept, as the scheme of a backup URL
+const SCHEME_S3 = "s3"
+const SCHEME_FILE = "file"
+const SCHEME_SFTP = "sftp"
+
+// Storage is somewhere backups are kept, such as an S3 bucket or a directory on another machine. Backups are stored
+// under plain names, such as 20261018T200836Z.tar.gz.enc, in a single place.
+type Storage interface {
+	Put(name string, contents []byte) error
+	Get(name string) ([]byte, error)
+
+	// The names of everything stored, in no particular order
+	List() ([]string, error)
+
+	// Where the backups are kept, e.g. s3://acme-openvpn-backups/backups/, for logs and errors
+	Description() string
+}
+
+// Location is a parsed backup URL: s3://bucket/prefix, file:///dir or sftp://user@host:port/dir. A plain absolute path
+// is a file URL.
+type Location struct {
+	Scheme string
+	User   string
+	Host   string
+	Port   int
+	Path   string
+}
+
+func ParseUrl(backupUrl string) (*Location, error) {
+	if strings.HasPrefix(backupUrl, "/") {
+		return &Location{Scheme: SCHEME_FILE, Path: filepath.Clean(backupUrl)}, nil
+	}
+
+	parsed, err := url.Parse(backupUrl)
+	if err != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return nil, errors.WithStackTrace(InvalidBackupUrl(backupUrl))
+	}
+
+	location := &Location{Scheme: parsed.Scheme, Host: parsed.Hostname(), Path: parsed.Path}
+	if parsed.User != nil {
+		location.User = parsed.User.Username()
+		if _, hasPassword := parsed.User.Password(); hasPassword {
+			return nil, errors.WithStackTrace(InvalidBackupUrl(backupUrl))
+		}
+	}
+	if port := parsed.Port(); port != "" {
+		location.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, errors.WithStackTrace(InvalidBackupUrl(backupUrl))
+		}
+	}
+
+	switch location.Scheme {
+	case SCHEME_S3:
+		if location.Host == "" || location.User != "" || location.Port != 0 {
+			return nil, errors.WithStackTrace(InvalidBackupUrl(backupUrl))
+		}
+		location.Path = strings.Trim(location.Path, "/")
+	case SCHEME_FILE:
+		if location.Host != "" || !strings.HasPrefix(location.Path, "/") {
+			return nil, errors.WithStackTrace(InvalidBackupUrl(backupUrl))
+		}
+		location.Path = filepath.Clean(location.Path)
+	case SCHEME_SFTP:
+		// The path is relative to the user's home dir unless it starts with //, as with scp
+		if location.Host == "" || strings.ContainsAny(location.Path, "\"\r\n") {
+			return nil, errors.WithStackTrace(InvalidBackupUrl(backupUrl))
+		}
+		location.Path = strings.TrimPrefix(location.Path, "/")
+		if location.Path == "" {
+			location.Path = "."
+		}
+	default:
+		return nil, errors.WithStackTrace(InvalidBackupUrl(backupUrl))
+	}
+	return location, nil
+}
+
+// LocalStorage keeps backups in a directory, e.g. one a network file system is mounted on
+type LocalStorage struct {
+	Dir string
+}
+
+// Write through a temp file, so that a backup that's listed is always whole
+func (storage LocalStorage) Put(name string, contents []byte) error {
+	if err := os.MkdirAll(storage.Dir, 0700); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	path := filepath.Join(storage.Dir, name)
+	if err := ioutil.WriteFile(path+".tmp", contents, 0600); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(os.Rename(path+".tmp", path))
+}
+
+func (storage LocalStorage) Get(name string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(storage.Dir, name))
+	return contents, errors.WithStackTrace(err)
+}
+
+func (storage LocalStorage) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(storage.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (storage LocalStorage) Description() string {
+	return storage.Dir
+}
+
+// Custom errors
+
+type InvalidBackupUrl string
+
+func (backupUrl InvalidBackupUrl) Error() string {
+	return fmt.Sprintf("'%s' is not a backup URL. Use s3://<bucket>/<prefix>, file:///<dir> or sftp://<user>@<host>:<port>/<dir>.", string(backupUrl))
+}
@@ -0,0 +1,184 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"net"
+	"sort"
+	"time"
+)
+
+// The days a time window can be on, as they're written in the config
+var WEEKDAYS = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// AccessRule says whether a client may send traffic to a network. The rules for a client are ordered from the most to
+// the least specific network, so that the first rule whose network contains a destination decides.
+type AccessRule struct {
+	Network *net.IPNet
+	Allow   bool
+}
+
+// Render the access groups as the JSON file that process-requests and the client-connect hook read them from
+func RenderAccessGroups(groups []AccessGroupConfig) ([]byte, error) {
+	bytes, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return append(bytes, '\n'), nil
+}
+
+func ParseAccessGroups(contents []byte) ([]AccessGroupConfig, error) {
+	groups := []AccessGroupConfig{}
+	if err := json.Unmarshal(contents, &groups); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return groups, nil
+}
+
+// The networks routed to the group's members. Any that don't parse are left out, as the groups were validated when the
+// config was loaded.
+func (group AccessGroupConfig) Networks() []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, route := range group.Routes {
+		if network, err := ParseNetwork(route); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// The routes to push to a member of the given access groups, in the order they're configured, without duplicates
+func AccessRoutes(groups []AccessGroupConfig, memberOf []string) []*net.IPNet {
+	routes := []*net.IPNet{}
+	seen := map[string]bool{}
+	for _, group := range groups {
+		if !containsString(memberOf, group.Name) {
+			continue
+		}
+		for _, network := range group.Networks() {
+			if !seen[network.String()] {
+				routes = append(routes, network)
+				seen[network.String()] = true
+			}
+		}
+	}
+	return routes
+}
+
+// The firewall rules for a member of the given access groups: each network of an access group is allowed if it's a
+// network of one of the client's groups, and blocked otherwise. A network inside another is checked first, so a
+// member of a group that can reach 10.0.0.0/8 still can't reach 10.20.0.0/16 if that belongs to a group they're not in.
+func ClientAccessRules(groups []AccessGroupConfig, memberOf []string) []AccessRule {
+	allowed := map[string]bool{}
+	for _, network := range AccessRoutes(groups, memberOf) {
+		allowed[network.String()] = true
+	}
+
+	rules := []AccessRule{}
+	seen := map[string]bool{}
+	for _, group := range groups {
+		for _, network := range group.Networks() {
+			if !seen[network.String()] {
+				rules = append(rules, AccessRule{Network: network, Allow: allowed[network.String()]})
+				seen[network.String()] = true
+			}
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		iOnes, _ := rules[i].Network.Mask.Size()
+		jOnes, _ := rules[j].Network.Mask.Size()
+		return iOnes > jOnes
+	})
+	return rules
+}
+
+// Render the connection policy as the JSON file that the client-connect hook reads it from
+func RenderConnectionPolicy(policy *ConnectionPolicyConfig) ([]byte, error) {
+	bytes, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return append(bytes, '\n'), nil
+}
+
+func ParseConnectionPolicy(contents []byte) (*ConnectionPolicyConfig, error) {
+	policy := &ConnectionPolicyConfig{}
+	if err := json.Unmarshal(contents, policy); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return policy, nil
+}
+
+// Whether clients may connect at the given time: always if there are no time windows, or else if any of them contains
+// the time
+func (policy *ConnectionPolicyConfig) AllowsConnectingAt(now time.Time) bool {
+	if len(policy.TimeWindows) == 0 {
+		return true
+	}
+	for _, window := range policy.TimeWindows {
+		if window.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the window contains the given time. A window that can't be parsed contains no time, as the windows were
+// validated when the config was loaded.
+func (window TimeWindowConfig) Contains(now time.Time) bool {
+	location, err := time.LoadLocation(window.TimeZone)
+	if err != nil {
+		return false
+	}
+	start, startErr := parseTimeOfDay(window.Start)
+	end, endErr := parseTimeOfDay(window.End)
+	if startErr != nil || endErr != nil {
+		return false
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	switch {
+	case start < end:
+		return window.isOn(local.Weekday()) && minute >= start && minute < end
+	case minute >= start:
+		return window.isOn(local.Weekday())
+	case minute < end:
+		// The part of the window after midnight belongs to the day before
+		return window.isOn((local.Weekday() + 6) % 7)
+	default:
+		return false
+	}
+}
+
+func (window TimeWindowConfig) isOn(day time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, name := range window.Days {
+		if weekday, ok := WEEKDAYS[name]; ok && weekday == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a time of day written as 15:04 into the minutes since midnight
+func parseTimeOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a time of day, e.g. 08:30 or 18:00", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
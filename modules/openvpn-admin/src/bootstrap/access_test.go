@@ -0,0 +1,62 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Engineering can reach all of 10.0.0.0/8 except what belongs to the other groups, including production, which is
+// inside it
+var testAccessGroups = []AccessGroupConfig{
+	{Name: "engineering", IamGroups: []string{"engineers"}, Routes: []string{"10.0.0.0/8", "192.168.10.0/24"}},
+	{Name: "prod", IamGroups: []string{"sre"}, Routes: []string{"10.20.0.0/16", "10.20.5.0/24"}},
+	{Name: "finance", IamGroups: []string{"finance"}, Routes: []string{"192.168.10.0/24", "10.30.0.0/16"}},
+}
+
+func describeAccessRules(rules []AccessRule) string {
+	described := []string{}
+	for _, rule := range rules {
+		action := "drop"
+		if rule.Allow {
+			action = "allow"
+		}
+		described = append(described, fmt.Sprintf("%s %s", action, rule.Network))
+	}
+	return strings.Join(described, ", ")
+}
+
+func TestClientAccessRules(t *testing.T) {
+	testCases := []struct {
+		name     string
+		memberOf []string
+		expected string
+	}{
+		{"no groups", nil, "drop 192.168.10.0/24, drop 10.20.5.0/24, drop 10.20.0.0/16, drop 10.30.0.0/16, drop 10.0.0.0/8"},
+		{"engineering", []string{"engineering"}, "allow 192.168.10.0/24, drop 10.20.5.0/24, drop 10.20.0.0/16, drop 10.30.0.0/16, allow 10.0.0.0/8"},
+		{"prod", []string{"prod"}, "drop 192.168.10.0/24, allow 10.20.5.0/24, allow 10.20.0.0/16, drop 10.30.0.0/16, drop 10.0.0.0/8"},
+		{"engineering and finance", []string{"finance", "engineering"}, "allow 192.168.10.0/24, drop 10.20.5.0/24, drop 10.20.0.0/16, allow 10.30.0.0/16, allow 10.0.0.0/8"},
+		{"unknown group", []string{"marketing"}, "drop 192.168.10.0/24, drop 10.20.5.0/24, drop 10.20.0.0/16, drop 10.30.0.0/16, drop 10.0.0.0/8"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rules := describeAccessRules(ClientAccessRules(testAccessGroups, testCase.memberOf))
+			if rules != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, rules)
+			}
+		})
+	}
+}
+
+func TestAccessRoutesAreInConfigOrderWithoutDuplicates(t *testing.T) {
+	routes := []string{}
+	for _, route := range AccessRoutes(testAccessGroups, []string{"finance", "engineering"}) {
+		routes = append(routes, route.String())
+	}
+
+	expected := "10.0.0.0/8, 192.168.10.0/24, 10.30.0.0/16"
+	if strings.Join(routes, ", ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(routes, ", "))
+	}
+}
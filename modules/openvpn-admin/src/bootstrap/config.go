@@ -0,0 +1,753 @@
+package bootstrap
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/backup"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The defaults init-openvpn has always used
+const DEFAULT_KEY_ALGORITHM = pki.KEY_ALGORITHM_RSA
+const DEFAULT_KEY_SIZE = 4096
+const DEFAULT_EXPIRATION_DAYS = 3650
+
+// process-revokes refreshes the CRL daily, so it needn't live long. See DEFAULT_CRL_EXPIRATION_DAYS in the app package.
+const DEFAULT_CRL_EXPIRATION_DAYS = 7
+const DEFAULT_LINK_MTU = 1500
+const DEFAULT_PORT = 1194
+const DEFAULT_PROTOCOL = "udp"
+const DEFAULT_CIPHER = "AES-256-CBC"
+const DEFAULT_AUTH = "SHA256"
+const DEFAULT_KEEPALIVE_INTERVAL = 10
+const DEFAULT_KEEPALIVE_TIMEOUT = 120
+const DEFAULT_VERBOSITY = 4
+
+// The smallest MTU every IPv4 link must support
+const MIN_LINK_MTU = 576
+
+// Config describes an OpenVPN server for `openvpn-admin init`: the PKI to create (or restore), where to back it up, and
+// the network settings for server.conf. It replaces the command line options of init-openvpn.
+type Config struct {
+	Path   string        `yaml:"-"`
+	Pki    PkiConfig     `yaml:"pki"`
+	Backup *BackupConfig `yaml:"backup"`
+	Server ServerConfig  `yaml:"server"`
+}
+
+// PkiConfig holds the settings written to the easy-rsa vars file. The key algorithm and where the CA key is kept can't
+// change once the CA exists, so for an existing PKI those are read from its vars file instead.
+type PkiConfig struct {
+	Country            string        `yaml:"country"`
+	State              string        `yaml:"state"`
+	Locality           string        `yaml:"locality"`
+	Org                string        `yaml:"org"`
+	OrgUnit            string        `yaml:"org_unit"`
+	Email              string        `yaml:"email"`
+	KeyAlgorithm       string        `yaml:"key_algorithm"`
+	KeySize            int           `yaml:"key_size"`
+	CaExpirationDays   int           `yaml:"ca_expiration_days"`
+	CertExpirationDays int           `yaml:"cert_expiration_days"`
+	CrlExpirationDays  int           `yaml:"crl_expiration_days"`
+	Pkcs11             *Pkcs11Config `yaml:"pkcs11"`
+	KmsSigningKeyArn   string        `yaml:"kms_signing_key_arn"`
+}
+
+// Pkcs11Config is the PKCS#11 token to generate the CA key in, instead of writing it to ca.key
+type Pkcs11Config struct {
+	ModulePath string `yaml:"module_path"`
+	TokenLabel string `yaml:"token_label"`
+	PinFile    string `yaml:"pin_file"`
+	KeyLabel   string `yaml:"key_label"`
+}
+
+// BackupConfig is where the PKI is restored from when the server is replaced, and backed up to every hour: an S3
+// bucket, or a backup URL for S3, an S3-compatible store, a directory or an SFTP server. Backups are encrypted with
+// a KMS key, or for age or GPG recipients.
+type BackupConfig struct {
+	S3BucketName     string   `yaml:"s3_bucket_name"`
+	Url              string   `yaml:"url"`
+	S3Endpoint       string   `yaml:"s3_endpoint"`
+	SftpIdentityFile string   `yaml:"sftp_identity_file"`
+	KmsKeyId         string   `yaml:"kms_key_id"`
+	AgeRecipients    []string `yaml:"age_recipients"`
+	AgeIdentityFile  string   `yaml:"age_identity_file"`
+	GpgRecipients    []string `yaml:"gpg_recipients"`
+}
+
+// Whether backups go to S3 or are encrypted with KMS, either of which needs an AWS region
+func (backupConfig *BackupConfig) UsesAws() bool {
+	if backupConfig.KmsKeyId != "" {
+		return true
+	}
+	if backupConfig.S3Endpoint != "" {
+		return false
+	}
+	return backupConfig.S3BucketName != "" || strings.HasPrefix(backupConfig.Url, backup.SCHEME_S3+"://")
+}
+
+// The protocols OpenVPN can listen on
+var PROTOCOLS = []string{"udp", "tcp", "udp6", "tcp6"}
+
+// The name of the only OpenVPN instance when the config doesn't list listeners. Its config is server.conf, which
+// systemd runs as openvpn@server, as with init-openvpn.
+const DEFAULT_LISTENER_NAME = "server"
+
+// ServerConfig holds the settings for server.conf and the client profile template. Networks may be written in CIDR
+// notation (10.1.14.0/24) or as an address and netmask (10.1.14.0 255.255.255.0), as init-openvpn took them.
+//
+// The server listens on Port over Protocol and gives clients addresses from VpnSubnet, unless Listeners is set. Then it
+// runs an OpenVPN instance for each listener instead, all sharing the same PKI and the rest of these settings.
+type ServerConfig struct {
+	VpnSubnet        string                  `yaml:"vpn_subnet"`
+	Routes           []string                `yaml:"routes"`
+	LinkMtu          int                     `yaml:"link_mtu"`
+	DnsServers       []string                `yaml:"dns_servers"`
+	SearchDomains    []string                `yaml:"search_domains"`
+	Port             int                     `yaml:"port"`
+	Protocol         string                  `yaml:"protocol"`
+	Cipher           string                  `yaml:"cipher"`
+	DataCiphers      []string                `yaml:"data_ciphers"`
+	Auth             string                  `yaml:"auth"`
+	Keepalive        *KeepaliveConfig        `yaml:"keepalive"`
+	Verbosity        int                     `yaml:"verbosity"`
+	Plugins          []PluginConfig          `yaml:"plugins"`
+	Management       *ManagementConfig       `yaml:"management"`
+	Duo              *DuoConfig              `yaml:"duo"`
+	Listeners        []ListenerConfig        `yaml:"listeners"`
+	StaticIpCount    int                     `yaml:"static_ip_count"`
+	AccessGroups     []AccessGroupConfig     `yaml:"access_groups"`
+	ConnectionPolicy *ConnectionPolicyConfig `yaml:"connection_policy"`
+}
+
+// ListenerConfig is one OpenVPN instance. Its config is <name>.conf, which systemd runs as openvpn@<name>. Each
+// listener needs a VPN subnet of its own, since each instance hands out addresses independently.
+type ListenerConfig struct {
+	Name      string `yaml:"name"`
+	Port      int    `yaml:"port"`
+	Protocol  string `yaml:"protocol"`
+	VpnSubnet string `yaml:"vpn_subnet"`
+}
+
+// AccessGroupConfig gives the members of some IAM groups routes to networks that other VPN users can't reach. Users
+// get the access groups of the IAM groups they are in when their certificate is issued, and the server pushes each
+// group's routes to them and blocks traffic to the networks of the groups they aren't in.
+type AccessGroupConfig struct {
+	Name      string   `yaml:"name" json:"name"`
+	IamGroups []string `yaml:"iam_groups" json:"iam_groups"`
+	Routes    []string `yaml:"routes" json:"routes"`
+}
+
+// ConnectionPolicyConfig limits when and how often users may be connected. The client-connect hook refuses a client if
+// the user already has MaxSessionsPerUser sessions, or if it's outside all of the TimeWindows. Clients that are already
+// connected stay connected when a time window ends.
+type ConnectionPolicyConfig struct {
+	MaxSessionsPerUser int                `yaml:"max_sessions_per_user" json:"max_sessions_per_user"`
+	TimeWindows        []TimeWindowConfig `yaml:"time_windows" json:"time_windows"`
+}
+
+// TimeWindowConfig is a time of day, from Start until End, written as 15:04, on the given days (mon to sun) in the
+// given time zone. With no days it's every day, and without a time zone it's in UTC. A window that ends before it
+// starts runs past midnight, into the day after each of its days.
+type TimeWindowConfig struct {
+	Days     []string `yaml:"days" json:"days"`
+	Start    string   `yaml:"start" json:"start"`
+	End      string   `yaml:"end" json:"end"`
+	TimeZone string   `yaml:"time_zone" json:"time_zone"`
+}
+
+// KeepaliveConfig sets how often, in seconds, the server and clients ping each other, and how long they wait for a ping
+// before restarting the connection. The server pushes these to clients.
+type KeepaliveConfig struct {
+	Interval int `yaml:"interval"`
+	Timeout  int `yaml:"timeout"`
+}
+
+// PluginConfig loads an OpenVPN plugin, such as openvpn-plugin-auth-pam.so. Set AuthUserPass for plugins that check a
+// username and password, so that client profiles prompt for them.
+type PluginConfig struct {
+	Path         string   `yaml:"path"`
+	Args         []string `yaml:"args"`
+	AuthUserPass bool     `yaml:"auth_user_pass"`
+}
+
+// ManagementConfig enables OpenVPN's management interface, which can list and disconnect clients. Anyone who can reach
+// it controls the server, so it must listen on a loopback address unless it's protected by a password file. With
+// several listeners, each instance has its own management interface, on Port, Port + 1 and so on.
+type ManagementConfig struct {
+	Address      string `yaml:"address"`
+	Port         int    `yaml:"port"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// DuoConfig enables the duo_openvpn plugin (see https://duo.com/docs/openvpn)
+type DuoConfig struct {
+	Ikey string `yaml:"ikey"`
+	Skey string `yaml:"skey"`
+	Host string `yaml:"host"`
+}
+
+// Read the init config file at the given path, fill in the defaults and check every setting in it, so that a mistake
+// is reported before anything on the server is changed
+func Load(path string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	config := &Config{Path: path}
+	if err := yaml.UnmarshalStrict(bytes, config); err != nil {
+		return nil, errors.WithStackTrace(InvalidConfig{Path: path, Problems: []string{err.Error()}})
+	}
+
+	config.setDefaults()
+	if problems := config.validate(); len(problems) > 0 {
+		return nil, errors.WithStackTrace(InvalidConfig{Path: path, Problems: problems})
+	}
+
+	return config, nil
+}
+
+// Read the config file at the given path for `openvpn-admin server render`, which only uses the server settings. The
+// pki and backup settings may be left out, and aren't checked if they're there.
+func LoadServer(path string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	config := &Config{Path: path}
+	if err := yaml.UnmarshalStrict(bytes, config); err != nil {
+		return nil, errors.WithStackTrace(InvalidConfig{Path: path, Problems: []string{err.Error()}})
+	}
+
+	config.Server.setDefaults()
+	if problems := config.Server.validate(); len(problems) > 0 {
+		return nil, errors.WithStackTrace(InvalidConfig{Path: path, Problems: problems})
+	}
+
+	return config, nil
+}
+
+func (config *Config) setDefaults() {
+	if config.Pki.KeyAlgorithm == "" {
+		config.Pki.KeyAlgorithm = DEFAULT_KEY_ALGORITHM
+	}
+	if config.Pki.KeySize == 0 {
+		config.Pki.KeySize = DEFAULT_KEY_SIZE
+	}
+	if config.Pki.CaExpirationDays == 0 {
+		config.Pki.CaExpirationDays = DEFAULT_EXPIRATION_DAYS
+	}
+	if config.Pki.CertExpirationDays == 0 {
+		config.Pki.CertExpirationDays = DEFAULT_EXPIRATION_DAYS
+	}
+	if config.Pki.CrlExpirationDays == 0 {
+		config.Pki.CrlExpirationDays = DEFAULT_CRL_EXPIRATION_DAYS
+	}
+	if config.Pki.Pkcs11 != nil && config.Pki.Pkcs11.KeyLabel == "" {
+		config.Pki.Pkcs11.KeyLabel = pki.DEFAULT_PKCS11_KEY_LABEL
+	}
+	config.Server.setDefaults()
+}
+
+func (server *ServerConfig) setDefaults() {
+	if server.LinkMtu == 0 {
+		server.LinkMtu = DEFAULT_LINK_MTU
+	}
+	// The top level port and protocol are only used without listeners, and validation rejects them with listeners
+	if len(server.Listeners) == 0 {
+		if server.Port == 0 {
+			server.Port = DEFAULT_PORT
+		}
+		if server.Protocol == "" {
+			server.Protocol = DEFAULT_PROTOCOL
+		}
+	}
+	for i := range server.Listeners {
+		if server.Listeners[i].Protocol == "" {
+			server.Listeners[i].Protocol = DEFAULT_PROTOCOL
+		}
+	}
+	if server.Cipher == "" {
+		server.Cipher = DEFAULT_CIPHER
+	}
+	if server.Auth == "" {
+		server.Auth = DEFAULT_AUTH
+	}
+	if server.Keepalive == nil {
+		server.Keepalive = &KeepaliveConfig{Interval: DEFAULT_KEEPALIVE_INTERVAL, Timeout: DEFAULT_KEEPALIVE_TIMEOUT}
+	}
+	if server.Verbosity == 0 {
+		server.Verbosity = DEFAULT_VERBOSITY
+	}
+}
+
+// Check every setting and return a description of each one that's wrong, rather than stopping at the first
+func (config *Config) validate() []string {
+	problems := []string{}
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// These end up in vars.local, which is a shell script, so they must not be able to break out of their quotes
+	subject := [][2]string{
+		{"pki.country", config.Pki.Country},
+		{"pki.state", config.Pki.State},
+		{"pki.locality", config.Pki.Locality},
+		{"pki.org", config.Pki.Org},
+		{"pki.org_unit", config.Pki.OrgUnit},
+		{"pki.email", config.Pki.Email},
+	}
+	for _, field := range subject {
+		if field[1] == "" {
+			addProblem("%s is required", field[0])
+		} else if !isShellSafe(field[1]) {
+			addProblem("%s must not contain quotes, backslashes, $ or line breaks", field[0])
+		}
+	}
+	if len(config.Pki.Country) != 2 {
+		addProblem("pki.country must be a two-letter country code but was '%s'", config.Pki.Country)
+	}
+
+	if _, err := pki.ParseKeySpec(config.Pki.KeyAlgorithm, config.Pki.KeySize); err != nil {
+		addProblem("pki: %s", errors.Unwrap(err))
+	}
+
+	days := []struct {
+		name  string
+		value int
+	}{
+		{"pki.ca_expiration_days", config.Pki.CaExpirationDays},
+		{"pki.cert_expiration_days", config.Pki.CertExpirationDays},
+		{"pki.crl_expiration_days", config.Pki.CrlExpirationDays},
+	}
+	for _, field := range days {
+		if field.value <= 0 {
+			addProblem("%s must be a positive number of days but was %d", field.name, field.value)
+		}
+	}
+
+	if pkcs11 := config.Pki.Pkcs11; pkcs11 != nil {
+		if pkcs11.ModulePath == "" || pkcs11.TokenLabel == "" || pkcs11.PinFile == "" {
+			addProblem("pki.pkcs11 requires module_path, token_label and pin_file")
+		}
+		for _, value := range []string{pkcs11.ModulePath, pkcs11.TokenLabel, pkcs11.PinFile, pkcs11.KeyLabel} {
+			if !isShellSafe(value) {
+				addProblem("pki.pkcs11 settings must not contain quotes, backslashes, $ or line breaks")
+				break
+			}
+		}
+		if config.Pki.KmsSigningKeyArn != "" {
+			addProblem("only one of pki.pkcs11 and pki.kms_signing_key_arn may be set")
+		}
+	}
+	if arn := config.Pki.KmsSigningKeyArn; arn != "" && (!strings.HasPrefix(arn, "arn:") || !isShellSafe(arn)) {
+		addProblem("pki.kms_signing_key_arn must be the ARN of a KMS key but was '%s'", arn)
+	}
+
+	if config.Backup != nil {
+		problems = append(problems, config.Backup.validate()...)
+	}
+
+	return append(problems, config.Server.validate()...)
+}
+
+func (backupConfig *BackupConfig) validate() []string {
+	problems := []string{}
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case backupConfig.S3BucketName == "" && backupConfig.Url == "":
+		addProblem("backup requires s3_bucket_name or url")
+	case backupConfig.S3BucketName != "" && backupConfig.Url != "":
+		addProblem("only one of backup.s3_bucket_name and backup.url may be set")
+	case backupConfig.Url != "":
+		if _, err := backup.ParseUrl(backupConfig.Url); err != nil {
+			addProblem("backup.url: %s", errors.Unwrap(err))
+		}
+	}
+
+	keys := 0
+	for _, isSet := range []bool{backupConfig.KmsKeyId != "", len(backupConfig.AgeRecipients) > 0, len(backupConfig.GpgRecipients) > 0} {
+		if isSet {
+			keys++
+		}
+	}
+	if keys != 1 {
+		addProblem("backup requires exactly one of kms_key_id, age_recipients and gpg_recipients")
+	}
+
+	// They all go into the backup cron job
+	values := append([]string{backupConfig.S3BucketName, backupConfig.Url, backupConfig.S3Endpoint, backupConfig.SftpIdentityFile, backupConfig.KmsKeyId, backupConfig.AgeIdentityFile}, backupConfig.AgeRecipients...)
+	for _, value := range append(values, backupConfig.GpgRecipients...) {
+		if !isShellSafe(value) {
+			addProblem("backup settings must not contain quotes, backslashes, $ or line breaks")
+			break
+		}
+	}
+
+	return problems
+}
+
+// Check the server settings the same way as the rest of the config
+func (server *ServerConfig) validate() []string {
+	problems := []string{}
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	problems = append(problems, server.validateListeners()...)
+	problems = append(problems, server.validateAccessGroups()...)
+	problems = append(problems, server.validateConnectionPolicy()...)
+
+	if len(server.Routes) == 0 {
+		addProblem("server.routes must list at least one network to route over the VPN")
+	}
+	for _, route := range server.Routes {
+		if _, err := ParseNetwork(route); err != nil {
+			addProblem("server.routes: %s", err)
+		}
+	}
+	if server.LinkMtu < MIN_LINK_MTU || server.LinkMtu > 65535 {
+		addProblem("server.link_mtu must be between %d and 65535 but was %d", MIN_LINK_MTU, server.LinkMtu)
+	}
+	for _, dnsServer := range server.DnsServers {
+		if net.ParseIP(dnsServer) == nil {
+			addProblem("server.dns_servers: '%s' is not an IP address", dnsServer)
+		}
+	}
+	for _, domain := range server.SearchDomains {
+		if domain == "" || strings.ContainsAny(domain, " \t\r\n\"'\\") {
+			addProblem("server.search_domains: '%s' is not a domain name", domain)
+		}
+	}
+
+	// OpenSSL's names for ciphers and digests, e.g. AES-256-GCM, CHACHA20-POLY1305 and SHA256
+	if !algorithmNameRegex.MatchString(server.Cipher) {
+		addProblem("server.cipher: '%s' is not a cipher name", server.Cipher)
+	}
+	for _, cipher := range server.DataCiphers {
+		if !algorithmNameRegex.MatchString(cipher) {
+			addProblem("server.data_ciphers: '%s' is not a cipher name", cipher)
+		}
+	}
+	if !algorithmNameRegex.MatchString(server.Auth) {
+		addProblem("server.auth: '%s' is not a digest name", server.Auth)
+	}
+
+	if keepalive := server.Keepalive; keepalive.Interval <= 0 || keepalive.Timeout <= keepalive.Interval {
+		addProblem("server.keepalive.interval must be a positive number of seconds, and server.keepalive.timeout must be longer, but they were %d and %d", keepalive.Interval, keepalive.Timeout)
+	}
+	if server.Verbosity < 1 || server.Verbosity > 11 {
+		addProblem("server.verbosity must be between 1 and 11 but was %d", server.Verbosity)
+	}
+
+	for _, plugin := range server.Plugins {
+		if !strings.HasPrefix(plugin.Path, "/") || strings.ContainsAny(plugin.Path, " \t\r\n\"'\\") {
+			addProblem("server.plugins: path must be the absolute path of the plugin's shared library but was '%s'", plugin.Path)
+		}
+		// Each argument is passed to the plugin as a double quoted string
+		for _, arg := range plugin.Args {
+			if strings.ContainsAny(arg, "\r\n\"\\") {
+				addProblem("server.plugins: the arguments of %s must not contain double quotes, backslashes or line breaks", plugin.Path)
+				break
+			}
+		}
+	}
+
+	if management := server.Management; management != nil {
+		ip := net.ParseIP(management.Address)
+		if ip == nil {
+			addProblem("server.management.address: '%s' is not an IP address", management.Address)
+		} else if !ip.IsLoopback() && management.PasswordFile == "" {
+			addProblem("server.management.password_file is required when server.management.address is not a loopback address")
+		}
+		if lastPort := management.Port + len(server.AllListeners()) - 1; !isValidPort(management.Port) || !isValidPort(lastPort) {
+			addProblem("server.management.port must be between 1 and %d but was %d", 65535-len(server.AllListeners())+1, management.Port)
+		}
+		if management.PasswordFile != "" && (!strings.HasPrefix(management.PasswordFile, "/") || strings.ContainsAny(management.PasswordFile, " \t\r\n\"'\\")) {
+			addProblem("server.management.password_file must be an absolute path but was '%s'", management.PasswordFile)
+		}
+	}
+
+	if duo := server.Duo; duo != nil {
+		if duo.Ikey == "" || duo.Skey == "" || duo.Host == "" {
+			addProblem("server.duo requires ikey, skey and host")
+		}
+		// The plugin arguments are passed to OpenVPN as a single quoted string
+		if strings.ContainsAny(duo.Ikey+duo.Skey+duo.Host, " \t\r\n\"'\\") {
+			addProblem("server.duo settings must not contain whitespace, quotes or backslashes")
+		}
+	}
+
+	return problems
+}
+
+// Parse a network written as 10.1.14.0/24 or as 10.1.14.0 255.255.255.0. Only IPv4 networks are supported, and the
+// address must be the network's first address, as OpenVPN's server and route directives expect.
+func ParseNetwork(value string) (*net.IPNet, error) {
+	var network *net.IPNet
+
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) == 1 && strings.Contains(fields[0], "/"):
+		ip, parsed, err := net.ParseCIDR(fields[0])
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("'%s' is not an IPv4 network", value)
+		}
+		if !ip.Equal(parsed.IP) {
+			return nil, fmt.Errorf("'%s' is not the first address of its network, did you mean %s?", value, parsed)
+		}
+		network = parsed
+	case len(fields) == 2:
+		ip := net.ParseIP(fields[0]).To4()
+		maskIp := net.ParseIP(fields[1]).To4()
+		if ip == nil || maskIp == nil {
+			return nil, fmt.Errorf("'%s' is not an IPv4 network", value)
+		}
+		mask := net.IPMask(maskIp)
+		if _, bits := mask.Size(); bits == 0 {
+			return nil, fmt.Errorf("%s in '%s' is not a valid netmask", fields[1], value)
+		}
+		network = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		if !ip.Equal(network.IP) {
+			return nil, fmt.Errorf("'%s' is not the first address of its network, did you mean %s?", value, network)
+		}
+	default:
+		return nil, fmt.Errorf("'%s' is not a network. Use CIDR notation, e.g. 10.1.14.0/24.", value)
+	}
+
+	return network, nil
+}
+
+// The network as an address and netmask, which is how OpenVPN's server and route directives take it
+func AddressAndNetmask(network *net.IPNet) string {
+	return fmt.Sprintf("%s %s", network.IP, net.IP(network.Mask))
+}
+
+// The nameservers listed in a resolv.conf file
+func ParseNameservers(resolvConf string) []string {
+	nameservers := []string{}
+	for _, line := range strings.Split(resolvConf, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			nameservers = append(nameservers, fields[1])
+		}
+	}
+	return nameservers
+}
+
+// Check that every listener can run alongside the others: unique names, ports and subnets that don't overlap
+func (server *ServerConfig) validateListeners() []string {
+	problems := []string{}
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(server.Listeners) > 0 && (server.Port != 0 || server.Protocol != "" || server.VpnSubnet != "") {
+		addProblem("server.port, server.protocol and server.vpn_subnet can't be used with server.listeners. Set them on each listener instead.")
+	}
+
+	names := map[string]bool{}
+	firewallPorts := map[string]string{}
+	subnets := []*net.IPNet{}
+	subnetListeners := []string{}
+
+	for i, listener := range server.AllListeners() {
+		field := func(name string) string {
+			if len(server.Listeners) == 0 {
+				return "server." + name
+			}
+			return fmt.Sprintf("server.listeners[%d].%s", i, name)
+		}
+
+		if !listenerNameRegex.MatchString(listener.Name) {
+			addProblem("%s must be lowercase letters, digits, - and _ but was '%s'", field("name"), listener.Name)
+		} else if names[listener.Name] {
+			addProblem("%s: there is more than one listener named '%s'", field("name"), listener.Name)
+		}
+		names[listener.Name] = true
+
+		if !isValidPort(listener.Port) {
+			addProblem("%s must be between 1 and 65535 but was %d", field("port"), listener.Port)
+		}
+		if !containsString(PROTOCOLS, listener.Protocol) {
+			addProblem("%s must be one of %s but was '%s'", field("protocol"), strings.Join(PROTOCOLS, ", "), listener.Protocol)
+		} else if other, ok := firewallPorts[listener.FirewallPort()]; ok {
+			addProblem("%s: listeners %s and %s both listen on %s", field("port"), other, listener.Name, listener.FirewallPort())
+		} else {
+			firewallPorts[listener.FirewallPort()] = listener.Name
+		}
+
+		subnet, err := ParseNetwork(listener.VpnSubnet)
+		if err != nil {
+			addProblem("%s: %s", field("vpn_subnet"), err)
+			continue
+		}
+		if ones, _ := subnet.Mask.Size(); ones < 1 || ones > 29 {
+			addProblem("%s: %s must be between a /1 and a /29 network", field("vpn_subnet"), subnet)
+			continue
+		}
+		if server.StaticIpCount < 0 || server.StaticIpCount >= clientAddressCount(subnet) {
+			addProblem("server.static_ip_count must be between 0 and %d to leave addresses in %s for the dynamic pool, but was %d", clientAddressCount(subnet)-1, subnet, server.StaticIpCount)
+		}
+		for j, other := range subnets {
+			if other.Contains(subnet.IP) || subnet.Contains(other.IP) {
+				addProblem("%s: %s overlaps the VPN subnet %s of listener %s", field("vpn_subnet"), subnet, other, subnetListeners[j])
+			}
+		}
+		subnets = append(subnets, subnet)
+		subnetListeners = append(subnetListeners, listener.Name)
+	}
+
+	return problems
+}
+
+// Check that every access group has a unique name, IAM groups to take its members from and networks to route to them
+func (server *ServerConfig) validateAccessGroups() []string {
+	problems := []string{}
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	names := map[string]bool{}
+	for i, group := range server.AccessGroups {
+		// The name is recorded in the OU of the certificates of the group's members
+		if !listenerNameRegex.MatchString(group.Name) {
+			addProblem("server.access_groups[%d].name must be lowercase letters, digits, - and _ but was '%s'", i, group.Name)
+		} else if names[group.Name] {
+			addProblem("server.access_groups[%d].name: there is more than one access group named '%s'", i, group.Name)
+		}
+		names[group.Name] = true
+
+		if len(group.IamGroups) == 0 {
+			addProblem("server.access_groups[%d].iam_groups must list at least one IAM group", i)
+		}
+		for _, iamGroup := range group.IamGroups {
+			if !iamGroupNameRegex.MatchString(iamGroup) {
+				addProblem("server.access_groups[%d].iam_groups: '%s' is not an IAM group name", i, iamGroup)
+			}
+		}
+
+		if len(group.Routes) == 0 {
+			addProblem("server.access_groups[%d].routes must list at least one network to route to the group's members", i)
+		}
+		for _, route := range group.Routes {
+			if _, err := ParseNetwork(route); err != nil {
+				addProblem("server.access_groups[%d].routes: %s", i, err)
+			}
+		}
+	}
+
+	return problems
+}
+
+// Check the limits on sessions and that every time window can be parsed
+func (server *ServerConfig) validateConnectionPolicy() []string {
+	problems := []string{}
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	policy := server.ConnectionPolicy
+	if policy == nil {
+		return problems
+	}
+
+	if policy.MaxSessionsPerUser < 0 {
+		addProblem("server.connection_policy.max_sessions_per_user must be 0 for no limit or a positive number but was %d", policy.MaxSessionsPerUser)
+	}
+
+	for i, window := range policy.TimeWindows {
+		for _, day := range window.Days {
+			if _, ok := WEEKDAYS[day]; !ok {
+				addProblem("server.connection_policy.time_windows[%d].days: '%s' is not one of mon, tue, wed, thu, fri, sat or sun", i, day)
+			}
+		}
+
+		start, startErr := parseTimeOfDay(window.Start)
+		if startErr != nil {
+			addProblem("server.connection_policy.time_windows[%d].start: %s", i, startErr)
+		}
+		end, endErr := parseTimeOfDay(window.End)
+		if endErr != nil {
+			addProblem("server.connection_policy.time_windows[%d].end: %s", i, endErr)
+		}
+		if startErr == nil && endErr == nil && start == end {
+			addProblem("server.connection_policy.time_windows[%d] starts and ends at %s. Leave out time_windows to allow connections at any time.", i, window.Start)
+		}
+
+		if _, err := time.LoadLocation(window.TimeZone); err != nil {
+			addProblem("server.connection_policy.time_windows[%d].time_zone: '%s' is not a time zone, e.g. America/New_York", i, window.TimeZone)
+		}
+	}
+
+	return problems
+}
+
+// The listeners to run an OpenVPN instance for. Without listeners in the config, that's a single listener named
+// server, with the top level port, protocol and VPN subnet.
+func (server *ServerConfig) AllListeners() []ListenerConfig {
+	if len(server.Listeners) > 0 {
+		return server.Listeners
+	}
+	return []ListenerConfig{{Name: DEFAULT_LISTENER_NAME, Port: server.Port, Protocol: server.Protocol, VpnSubnet: server.VpnSubnet}}
+}
+
+// The port and protocol to open in the firewall, in the form ufw takes them, e.g. 1194/udp
+func (listener ListenerConfig) FirewallPort() string {
+	return fmt.Sprintf("%d/%s", listener.Port, strings.TrimSuffix(listener.Protocol, "6"))
+}
+
+// The name systemd's openvpn@ template runs the listener's instance as
+func (listener ListenerConfig) ServiceName() string {
+	return "openvpn@" + listener.Name
+}
+
+// The instance's config file in /etc/openvpn, which names the systemd service
+func (listener ListenerConfig) ConfFile() string {
+	return listener.Name + ".conf"
+}
+
+var listenerNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+var iamGroupNameRegex = regexp.MustCompile(`^[A-Za-z0-9_+=,.@-]{1,128}$`)
+
+var algorithmNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+func isValidPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isShellSafe(value string) bool {
+	return !strings.ContainsAny(value, "\"'`\\$\r\n")
+}
+
+// Custom errors
+
+type InvalidConfig struct {
+	Path     string
+	Problems []string
+}
+
+func (err InvalidConfig) Error() string {
+	return fmt.Sprintf("Invalid config file %s:\n  - %s", err.Path, strings.Join(err.Problems, "\n  - "))
+}
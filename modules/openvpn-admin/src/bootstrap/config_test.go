@@ -0,0 +1,355 @@
+package bootstrap
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const TEST_CONFIG = `pki:
+  country: US
+  state: AZ
+  locality: Phoenix
+  org: Acme
+  org_unit: VPN
+  email: security@acme.com
+server:
+  vpn_subnet: 172.16.1.0 255.255.255.0
+  routes: [10.0.0.0/16]
+`
+
+func writeTestConfig(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "openvpn.yml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// A valid config, as it's read from TEST_CONFIG before the defaults are filled in
+func newTestConfig() *Config {
+	return &Config{
+		Pki:    PkiConfig{Country: "US", State: "AZ", Locality: "Phoenix", Org: "Acme", OrgUnit: "VPN", Email: "security@acme.com"},
+		Server: ServerConfig{VpnSubnet: "172.16.1.0/24", Routes: []string{"10.0.0.0/16"}},
+	}
+}
+
+func TestLoadFillsInDefaults(t *testing.T) {
+	path := writeTestConfig(t, TEST_CONFIG)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Path != path {
+		t.Errorf("Expected the path %s, got %s", path, config.Path)
+	}
+	if config.Pki.KeyAlgorithm != DEFAULT_KEY_ALGORITHM || config.Pki.KeySize != DEFAULT_KEY_SIZE {
+		t.Errorf("Expected a %s %d key, got %s %d", DEFAULT_KEY_ALGORITHM, DEFAULT_KEY_SIZE, config.Pki.KeyAlgorithm, config.Pki.KeySize)
+	}
+	if config.Pki.CrlExpirationDays != DEFAULT_CRL_EXPIRATION_DAYS {
+		t.Errorf("Expected CRLs to last %d days, got %d", DEFAULT_CRL_EXPIRATION_DAYS, config.Pki.CrlExpirationDays)
+	}
+	if config.Server.Port != DEFAULT_PORT || config.Server.Protocol != DEFAULT_PROTOCOL {
+		t.Errorf("Expected to listen on %d/%s, got %d/%s", DEFAULT_PORT, DEFAULT_PROTOCOL, config.Server.Port, config.Server.Protocol)
+	}
+	if *config.Server.Keepalive != (KeepaliveConfig{Interval: DEFAULT_KEEPALIVE_INTERVAL, Timeout: DEFAULT_KEEPALIVE_TIMEOUT}) {
+		t.Errorf("Expected the default keepalive, got %+v", *config.Server.Keepalive)
+	}
+
+	listeners := config.Server.AllListeners()
+	if len(listeners) != 1 || listeners[0].Name != DEFAULT_LISTENER_NAME || listeners[0].VpnSubnet != "172.16.1.0 255.255.255.0" {
+		t.Errorf("Expected a single listener named %s, got %+v", DEFAULT_LISTENER_NAME, listeners)
+	}
+}
+
+func TestLoadRefusesUnknownSettings(t *testing.T) {
+	_, err := Load(writeTestConfig(t, strings.Replace(TEST_CONFIG, "routes:", "rotes:", 1)))
+
+	invalid, ok := errors.Unwrap(err).(InvalidConfig)
+	if !ok {
+		t.Fatalf("Expected InvalidConfig, got %v", err)
+	}
+	if len(invalid.Problems) != 1 || !strings.Contains(invalid.Problems[0], "rotes") {
+		t.Errorf("Expected the unknown setting to be reported, got %v", invalid.Problems)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	contents := strings.Replace(TEST_CONFIG, "country: US", "country: USA", 1)
+	contents = strings.Replace(contents, "routes: [10.0.0.0/16]", "routes: [10.0.0.1/16]\n  verbosity: 12", 1)
+
+	_, err := Load(writeTestConfig(t, contents))
+
+	invalid, ok := errors.Unwrap(err).(InvalidConfig)
+	if !ok {
+		t.Fatalf("Expected InvalidConfig, got %v", err)
+	}
+	expected := []string{
+		"pki.country must be a two-letter country code but was 'USA'",
+		"server.routes: '10.0.0.1/16' is not the first address of its network, did you mean 10.0.0.0/16?",
+		"server.verbosity must be between 1 and 11 but was 12",
+	}
+	if strings.Join(invalid.Problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the problems\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(invalid.Problems, "\n"))
+	}
+	if !strings.Contains(invalid.Error(), "\n  - server.verbosity must be between 1 and 11 but was 12") {
+		t.Errorf("Expected the error to list the problems, got %s", invalid.Error())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		change   func(config *Config)
+		expected []string
+	}{
+		{"valid", func(config *Config) {}, nil},
+		{
+			"listeners",
+			func(config *Config) {
+				config.Server.VpnSubnet = ""
+				config.Server.Listeners = []ListenerConfig{
+					{Name: "udp", Port: 1194, VpnSubnet: "172.16.0.0/16"},
+					{Name: "udp", Port: 443, Protocol: "tcp", VpnSubnet: "172.17.0.0/16"},
+					{Name: "udp6", Port: 1194, Protocol: "udp6", VpnSubnet: "172.18.0.0/16"},
+					{Name: "tcp", Port: 8443, Protocol: "tcp", VpnSubnet: "172.16.2.0/24"},
+				}
+			},
+			[]string{
+				"server.listeners[1].name: there is more than one listener named 'udp'",
+				"server.listeners[2].port: listeners udp and udp6 both listen on 1194/udp",
+				"server.listeners[3].vpn_subnet: 172.16.2.0/24 overlaps the VPN subnet 172.16.0.0/16 of listener udp",
+			},
+		},
+		{
+			"top level listener settings with listeners",
+			func(config *Config) {
+				config.Server.Listeners = []ListenerConfig{{Name: "udp", Port: 1194, VpnSubnet: "172.16.0.0/16"}}
+			},
+			[]string{"server.port, server.protocol and server.vpn_subnet can't be used with server.listeners. Set them on each listener instead."},
+		},
+		{
+			"listener settings",
+			func(config *Config) {
+				config.Server.VpnSubnet = ""
+				config.Server.Listeners = []ListenerConfig{
+					{Name: "Main", Port: 70000, Protocol: "sctp", VpnSubnet: "172.16.0.0/30"},
+					{Name: "backup", Port: 443, Protocol: "tcp", VpnSubnet: "172.17.0.0"},
+				}
+			},
+			[]string{
+				"server.listeners[0].name must be lowercase letters, digits, - and _ but was 'Main'",
+				"server.listeners[0].port must be between 1 and 65535 but was 70000",
+				"server.listeners[0].protocol must be one of udp, tcp, udp6, tcp6 but was 'sctp'",
+				"server.listeners[0].vpn_subnet: 172.16.0.0/30 must be between a /1 and a /29 network",
+				"server.listeners[1].vpn_subnet: '172.17.0.0' is not a network. Use CIDR notation, e.g. 10.1.14.0/24.",
+			},
+		},
+		{
+			"too many static IPs",
+			func(config *Config) { config.Server.StaticIpCount = 253 },
+			[]string{"server.static_ip_count must be between 0 and 252 to leave addresses in 172.16.1.0/24 for the dynamic pool, but was 253"},
+		},
+		{
+			"access groups",
+			func(config *Config) {
+				config.Server.AccessGroups = []AccessGroupConfig{
+					{Name: "Dev", Routes: []string{"10.1.0.1/16"}},
+					{Name: "ops", IamGroups: []string{"vpn ops"}, Routes: []string{"10.2.0.0/16"}},
+					{Name: "ops", IamGroups: []string{"ops"}},
+				}
+			},
+			[]string{
+				"server.access_groups[0].name must be lowercase letters, digits, - and _ but was 'Dev'",
+				"server.access_groups[0].iam_groups must list at least one IAM group",
+				"server.access_groups[0].routes: '10.1.0.1/16' is not the first address of its network, did you mean 10.1.0.0/16?",
+				"server.access_groups[1].iam_groups: 'vpn ops' is not an IAM group name",
+				"server.access_groups[2].name: there is more than one access group named 'ops'",
+				"server.access_groups[2].routes must list at least one network to route to the group's members",
+			},
+		},
+		{
+			"connection policy",
+			func(config *Config) {
+				config.Server.ConnectionPolicy = &ConnectionPolicyConfig{
+					MaxSessionsPerUser: -1,
+					TimeWindows: []TimeWindowConfig{
+						{Days: []string{"monday", "fri"}, Start: "25:00", End: "18:00"},
+						{Start: "09:00", End: "09:00", TimeZone: "Mars/Olympus_Mons"},
+						{Days: []string{"sat"}, Start: "22:00", End: "02:00", TimeZone: "America/New_York"},
+					},
+				}
+			},
+			[]string{
+				"server.connection_policy.max_sessions_per_user must be 0 for no limit or a positive number but was -1",
+				"server.connection_policy.time_windows[0].days: 'monday' is not one of mon, tue, wed, thu, fri, sat or sun",
+				"server.connection_policy.time_windows[0].start: '25:00' is not a time of day, e.g. 08:30 or 18:00",
+				"server.connection_policy.time_windows[1] starts and ends at 09:00. Leave out time_windows to allow connections at any time.",
+				"server.connection_policy.time_windows[1].time_zone: 'Mars/Olympus_Mons' is not a time zone, e.g. America/New_York",
+			},
+		},
+		{
+			"backup without a destination",
+			func(config *Config) { config.Backup = &BackupConfig{KmsKeyId: "alias/openvpn-backup"} },
+			[]string{"backup requires s3_bucket_name or url"},
+		},
+		{
+			"backup with two destinations and no key",
+			func(config *Config) {
+				config.Backup = &BackupConfig{S3BucketName: "acme-openvpn", Url: "file:///var/backups/openvpn"}
+			},
+			[]string{
+				"only one of backup.s3_bucket_name and backup.url may be set",
+				"backup requires exactly one of kms_key_id, age_recipients and gpg_recipients",
+			},
+		},
+		{
+			"backup with two keys",
+			func(config *Config) {
+				config.Backup = &BackupConfig{Url: "file:///var/backups/openvpn", AgeRecipients: []string{"age1example"}, GpgRecipients: []string{"ops@acme.com"}}
+			},
+			[]string{"backup requires exactly one of kms_key_id, age_recipients and gpg_recipients"},
+		},
+		{
+			"backup URL",
+			func(config *Config) {
+				config.Backup = &BackupConfig{Url: "ftp://backups.acme.com/openvpn", KmsKeyId: "alias/openvpn-backup"}
+			},
+			[]string{"backup.url: 'ftp://backups.acme.com/openvpn' is not a backup URL. Use s3://<bucket>/<prefix>, file:///<dir> or sftp://<user>@<host>:<port>/<dir>."},
+		},
+		{
+			"backup settings that would break out of the cron job",
+			func(config *Config) {
+				config.Backup = &BackupConfig{S3BucketName: "acme-openvpn", AgeRecipients: []string{"age1example", "age1$(reboot)"}}
+			},
+			[]string{"backup settings must not contain quotes, backslashes, $ or line breaks"},
+		},
+		{
+			"PKI settings that would break out of vars.local",
+			func(config *Config) {
+				config.Pki.Org = `Acme "Inc"`
+				config.Pki.OrgUnit = "VPN\nexport KEY_DIR=/tmp"
+				config.Pki.Email = ""
+			},
+			[]string{
+				"pki.org must not contain quotes, backslashes, $ or line breaks",
+				"pki.org_unit must not contain quotes, backslashes, $ or line breaks",
+				"pki.email is required",
+			},
+		},
+		{
+			"PKCS#11 and KMS",
+			func(config *Config) {
+				config.Pki.Pkcs11 = &Pkcs11Config{ModulePath: "/usr/lib/softhsm/libsofthsm2.so", TokenLabel: "openvpn`id`"}
+				config.Pki.KmsSigningKeyArn = "arn:aws:kms:us-east-1:111122223333:key/1234"
+			},
+			[]string{
+				"pki.pkcs11 requires module_path, token_label and pin_file",
+				"pki.pkcs11 settings must not contain quotes, backslashes, $ or line breaks",
+				"only one of pki.pkcs11 and pki.kms_signing_key_arn may be set",
+			},
+		},
+		{
+			"KMS key that isn't an ARN",
+			func(config *Config) { config.Pki.KmsSigningKeyArn = "key/1234" },
+			[]string{"pki.kms_signing_key_arn must be the ARN of a KMS key but was 'key/1234'"},
+		},
+		{
+			"PKI key and lifetimes",
+			func(config *Config) {
+				config.Pki.KeyAlgorithm = "dsa"
+				config.Pki.CertExpirationDays = -1
+			},
+			[]string{
+				"pki: Unknown key algorithm 'dsa'. Must be one of: rsa, ecdsa-p256, ecdsa-p384, ed25519",
+				"pki.cert_expiration_days must be a positive number of days but was -1",
+			},
+		},
+		{
+			"management interface",
+			func(config *Config) {
+				config.Server.VpnSubnet = ""
+				config.Server.Listeners = []ListenerConfig{{Name: "udp", Port: 1194, VpnSubnet: "172.16.0.0/16"}, {Name: "tcp", Port: 443, Protocol: "tcp", VpnSubnet: "172.17.0.0/16"}}
+				config.Server.Management = &ManagementConfig{Address: "10.0.0.5", Port: 65535}
+			},
+			[]string{
+				"server.management.password_file is required when server.management.address is not a loopback address",
+				"server.management.port must be between 1 and 65534 but was 65535",
+			},
+		},
+		{
+			"server settings",
+			func(config *Config) {
+				config.Server.Routes = nil
+				config.Server.DnsServers = []string{"10.0.0.2", "dns.acme.com"}
+				config.Server.Cipher = "AES 256"
+				config.Server.Keepalive = &KeepaliveConfig{Interval: 10, Timeout: 10}
+				config.Server.Plugins = []PluginConfig{{Path: "openvpn-plugin-auth-pam.so", Args: []string{`login "x"`}}}
+				config.Server.Duo = &DuoConfig{Ikey: "DIXXX", Skey: "secret key"}
+			},
+			[]string{
+				"server.routes must list at least one network to route over the VPN",
+				"server.dns_servers: 'dns.acme.com' is not an IP address",
+				"server.cipher: 'AES 256' is not a cipher name",
+				"server.keepalive.interval must be a positive number of seconds, and server.keepalive.timeout must be longer, but they were 10 and 10",
+				"server.plugins: path must be the absolute path of the plugin's shared library but was 'openvpn-plugin-auth-pam.so'",
+				"server.plugins: the arguments of openvpn-plugin-auth-pam.so must not contain double quotes, backslashes or line breaks",
+				"server.duo requires ikey, skey and host",
+				"server.duo settings must not contain whitespace, quotes or backslashes",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := newTestConfig()
+			testCase.change(config)
+			config.setDefaults()
+
+			problems := config.validate()
+			if strings.Join(problems, "\n") != strings.Join(testCase.expected, "\n") {
+				t.Errorf("Expected the problems\n%s\ngot\n%s", strings.Join(testCase.expected, "\n"), strings.Join(problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+		err      string
+	}{
+		{"10.1.14.0/24", "10.1.14.0/24", ""},
+		{"10.1.14.0 255.255.255.0", "10.1.14.0/24", ""},
+		{"10.1.14.1/24", "", "'10.1.14.1/24' is not the first address of its network, did you mean 10.1.14.0/24?"},
+		{"10.1.14.1 255.255.255.0", "", "'10.1.14.1 255.255.255.0' is not the first address of its network, did you mean 10.1.14.0/24?"},
+		{"10.1.14.0 255.0.255.0", "", "255.0.255.0 in '10.1.14.0 255.0.255.0' is not a valid netmask"},
+		{"fd00::/64", "", "'fd00::/64' is not an IPv4 network"},
+		{"10.1.14.0", "", "'10.1.14.0' is not a network. Use CIDR notation, e.g. 10.1.14.0/24."},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.value, func(t *testing.T) {
+			network, err := ParseNetwork(testCase.value)
+			if testCase.err != "" {
+				if err == nil || err.Error() != testCase.err {
+					t.Fatalf("Expected the error %q, got %v", testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if network.String() != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, network)
+			}
+		})
+	}
+}
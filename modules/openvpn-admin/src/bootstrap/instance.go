@@ -0,0 +1,133 @@
+package bootstrap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Instance is what openvpn-admin needs to know about one of the OpenVPN server's running instances, as read back from
+// its config file: the VPN subnet it hands out addresses from, and where it looks for client config files
+type Instance struct {
+	Name            string
+	VpnSubnet       *net.IPNet
+	ClientConfigDir string
+
+	// The last address of the dynamic pool, if the instance keeps the end of its subnet for static IPs
+	PoolEnd net.IP
+}
+
+// Read the settings openvpn-admin needs from an OpenVPN config file. Returns false if it isn't the config of a server
+// that hands out addresses with the server directive, e.g. a client config.
+func ParseInstance(name string, contents string) (*Instance, bool) {
+	instance := &Instance{Name: name}
+
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "server" && len(fields) >= 3:
+			if network, err := ParseNetwork(fields[1] + " " + fields[2]); err == nil {
+				instance.VpnSubnet = network
+			}
+		case fields[0] == "ifconfig-pool" && len(fields) >= 3:
+			instance.PoolEnd = net.ParseIP(fields[2]).To4()
+		case fields[0] == "client-config-dir" && len(fields) >= 2:
+			instance.ClientConfigDir = fields[1]
+		}
+	}
+
+	return instance, instance.VpnSubnet != nil
+}
+
+// The host number of the address in the instance's VPN subnet, or false if the address isn't one the instance can give
+// a client: the network address, the server's own address (the first host) and the broadcast address are out
+func (instance *Instance) HostNumber(ip net.IP) (uint32, bool) {
+	ip = ip.To4()
+	if ip == nil || !instance.VpnSubnet.Contains(ip) {
+		return 0, false
+	}
+
+	host := ipToUint32(ip) - ipToUint32(instance.VpnSubnet.IP)
+	if host < 2 || host >= subnetSize(instance.VpnSubnet)-1 {
+		return 0, false
+	}
+	return host, true
+}
+
+// The address with the given host number in the instance's VPN subnet
+func (instance *Instance) Address(host uint32) net.IP {
+	return uint32ToIp(ipToUint32(instance.VpnSubnet.IP) + host)
+}
+
+// Whether the address is outside the instance's dynamic pool, so that OpenVPN never gives it to another client
+func (instance *Instance) IsReservedForStaticIps(ip net.IP) bool {
+	return instance.PoolEnd != nil && ipToUint32(ip.To4()) > ipToUint32(instance.PoolEnd)
+}
+
+// ClientConfig is a client config file (CCD file), which OpenVPN reads when the client with the same common name
+// connects
+type ClientConfig struct {
+	StaticIp   net.IP
+	Netmask    net.IPMask
+	PushRoutes []*net.IPNet
+}
+
+// The marker RenderClientConfig puts at the top of each client config file
+const CLIENT_CONFIG_MARKER = "Written by openvpn-admin client-config set"
+
+func RenderClientConfig(username string, config *ClientConfig) []byte {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("# %s for %s, which overwrites any changes.\n", CLIENT_CONFIG_MARKER, username))
+
+	if config.StaticIp != nil {
+		builder.WriteString(fmt.Sprintf("ifconfig-push %s %s\n", config.StaticIp, net.IP(config.Netmask)))
+	}
+	for _, route := range config.PushRoutes {
+		builder.WriteString(fmt.Sprintf("push \"route %s\"\n", AddressAndNetmask(route)))
+	}
+
+	return []byte(builder.String())
+}
+
+// The address a client config file pins its client to, if any
+func ParseClientConfigStaticIp(contents string) net.IP {
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "ifconfig-push" {
+			return net.ParseIP(fields[1]).To4()
+		}
+	}
+	return nil
+}
+
+// The first and last address of the dynamic pool of a VPN subnet that keeps its last staticIpCount host addresses for
+// static IPs. The pool starts after the server's own address, as with OpenVPN's server directive.
+func dynamicPool(subnet *net.IPNet, staticIpCount int) (net.IP, net.IP) {
+	base := ipToUint32(subnet.IP)
+	return uint32ToIp(base + 2), uint32ToIp(base + subnetSize(subnet) - 2 - uint32(staticIpCount))
+}
+
+// The number of addresses a client can be given in a VPN subnet: all but the network, server and broadcast addresses
+func clientAddressCount(subnet *net.IPNet) int {
+	return int(subnetSize(subnet)) - 3
+}
+
+func subnetSize(subnet *net.IPNet) uint32 {
+	ones, bits := subnet.Mask.Size()
+	return uint32(1) << uint(bits-ones)
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIp(value uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, value)
+	return ip
+}
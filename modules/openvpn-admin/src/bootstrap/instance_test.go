@@ -0,0 +1,79 @@
+package bootstrap
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDynamicPool(t *testing.T) {
+	testCases := []struct {
+		subnet        string
+		staticIpCount int
+		start         string
+		end           string
+	}{
+		{"172.16.1.0/24", 0, "172.16.1.2", "172.16.1.254"},
+		{"172.16.1.0/24", 50, "172.16.1.2", "172.16.1.204"},
+		{"172.16.0.0/16", 1000, "172.16.0.2", "172.16.252.22"},
+		{"172.16.1.0/29", 2, "172.16.1.2", "172.16.1.4"},
+	}
+
+	for _, testCase := range testCases {
+		_, subnet, _ := net.ParseCIDR(testCase.subnet)
+		start, end := dynamicPool(subnet, testCase.staticIpCount)
+		if start.String() != testCase.start || end.String() != testCase.end {
+			t.Errorf("Expected the pool of %s with %d static IPs to be %s to %s, got %s to %s", testCase.subnet, testCase.staticIpCount, testCase.start, testCase.end, start, end)
+		}
+	}
+}
+
+func TestParseInstance(t *testing.T) {
+	contents := "port 1194\nserver 172.16.1.0 255.255.255.0 nopool\nifconfig-pool 172.16.1.2 172.16.1.204 255.255.255.0\nclient-config-dir /etc/openvpn/ccd/server\n"
+
+	instance, ok := ParseInstance("server", contents)
+	if !ok {
+		t.Fatal("Expected a server instance")
+	}
+	if instance.VpnSubnet.String() != "172.16.1.0/24" || instance.PoolEnd.String() != "172.16.1.204" || instance.ClientConfigDir != "/etc/openvpn/ccd/server" {
+		t.Errorf("Expected the subnet, pool and client config dir of the instance, got %+v", instance)
+	}
+
+	if _, ok := ParseInstance("client", "client\nremote vpn.acme.com 1194\n"); ok {
+		t.Error("Expected a client config not to be a server instance")
+	}
+}
+
+func TestHostNumber(t *testing.T) {
+	instance, _ := ParseInstance("server", "server 172.16.1.0 255.255.255.0 nopool\nifconfig-pool 172.16.1.2 172.16.1.204 255.255.255.0\n")
+
+	testCases := []struct {
+		ip       string
+		host     uint32
+		ok       bool
+		reserved bool
+	}{
+		{"172.16.1.0", 0, false, false},
+		{"172.16.1.1", 0, false, false},
+		{"172.16.1.2", 2, true, false},
+		{"172.16.1.204", 204, true, false},
+		{"172.16.1.205", 205, true, true},
+		{"172.16.1.254", 254, true, true},
+		{"172.16.1.255", 0, false, true},
+		{"172.16.2.10", 0, false, true},
+		{"fd00::10", 0, false, false},
+	}
+
+	for _, testCase := range testCases {
+		ip := net.ParseIP(testCase.ip)
+		host, ok := instance.HostNumber(ip)
+		if host != testCase.host || ok != testCase.ok {
+			t.Errorf("Expected %s to be host %d (%t), got %d (%t)", testCase.ip, testCase.host, testCase.ok, host, ok)
+		}
+		if ok && !instance.Address(host).Equal(ip) {
+			t.Errorf("Expected host %d to be %s, got %s", host, testCase.ip, instance.Address(host))
+		}
+		if ip.To4() != nil && instance.IsReservedForStaticIps(ip) != testCase.reserved {
+			t.Errorf("Expected %s to be reserved for static IPs: %t", testCase.ip, testCase.reserved)
+		}
+	}
+}
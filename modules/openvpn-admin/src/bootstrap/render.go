@@ -0,0 +1,497 @@
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// The easy-rsa vars settings that are fixed when the CA is created. An existing PKI keeps the ones in its vars file.
+var ImmutableEasyRsaVars = []string{
+	"KEY_SIZE",
+	"KEY_ALGORITHM",
+	"PKCS11_MODULE_PATH",
+	"PKCS11_TOKEN_LABEL",
+	"PKCS11_KEY_LABEL",
+	"PKCS11_PIN_FILE",
+	"KMS_SIGNING_KEY_ARN",
+}
+
+// The easy-rsa vars for the PKI. KEY_NAME is the name attribute of the server certificate, as with init-openvpn.
+func (config *PkiConfig) EasyRsaVars(keyDir string, keyName string) pki.EasyRsaVars {
+	vars := pki.EasyRsaVars{
+		"KEY_DIR":             keyDir,
+		"PKCS11_MODULE_PATH":  "dummy",
+		"PKCS11_TOKEN_LABEL":  "",
+		"PKCS11_KEY_LABEL":    "",
+		"PKCS11_PIN_FILE":     "",
+		"KMS_SIGNING_KEY_ARN": config.KmsSigningKeyArn,
+		"KEY_SIZE":            strconv.Itoa(config.KeySize),
+		"KEY_ALGORITHM":       strings.ToLower(config.KeyAlgorithm),
+		"CA_EXPIRE":           strconv.Itoa(config.CaExpirationDays),
+		"KEY_EXPIRE":          strconv.Itoa(config.CertExpirationDays),
+		"KEY_COUNTRY":         config.Country,
+		"KEY_PROVINCE":        config.State,
+		"KEY_CITY":            config.Locality,
+		"KEY_ORG":             config.Org,
+		"KEY_EMAIL":           config.Email,
+		"KEY_OU":              config.OrgUnit,
+		"KEY_NAME":            keyName,
+	}
+
+	if config.Pkcs11 != nil {
+		vars["PKCS11_MODULE_PATH"] = config.Pkcs11.ModulePath
+		vars["PKCS11_TOKEN_LABEL"] = config.Pkcs11.TokenLabel
+		vars["PKCS11_KEY_LABEL"] = config.Pkcs11.KeyLabel
+		vars["PKCS11_PIN_FILE"] = config.Pkcs11.PinFile
+	}
+
+	return vars
+}
+
+// Render an easy-rsa vars file (vars.local) that easy-rsa's own scripts can source as well as openvpn-admin
+func RenderEasyRsaVars(vars pki.EasyRsaVars) ([]byte, error) {
+	return render(easyRsaVarsTemplate, vars)
+}
+
+// ServerConf is everything that goes into the config file of one of the OpenVPN server's instances
+type ServerConf struct {
+	Listener      ListenerConfig
+	VpnSubnet     *net.IPNet
+	Routes        []*net.IPNet
+	LinkMtu       int
+	DnsServers    []string
+	SearchDomains []string
+	Cipher        string
+	DataCiphers   []string
+	Auth          string
+	Keepalive     KeepaliveConfig
+	Verbosity     int
+	Plugins       []PluginConfig
+	Management    *ManagementConfig
+	StaticIpCount int
+	KeySpec       pki.KeySpec
+
+	// The --tls-crypt-v2-verify command. tls-crypt-v2 is only enabled if this is set.
+	TlsCryptV2VerifyCommand string
+
+	// The command OpenVPN runs with client-connect or client-disconnect appended when a client connects or
+	// disconnects. The hooks are only enabled if this is set.
+	HookCommand string
+
+	// The directory OpenVPN creates the files it passes client-connect in, which the hook refuses to write outside of
+	HookTmpDir string
+
+	Duo *DuoConfig
+}
+
+// The settings for each listener's instance, in the order of the listeners, for a validated server config, a PKI with
+// the given key spec and the given DNS servers, which may differ from the configured ones when those default to the
+// server's own nameservers
+func NewServerConfs(server *ServerConfig, spec pki.KeySpec, dnsServers []string) []*ServerConf {
+	// The networks were validated when the config was loaded
+	routes := []*net.IPNet{}
+	for _, route := range server.Routes {
+		network, _ := ParseNetwork(route)
+		routes = append(routes, network)
+	}
+
+	confs := []*ServerConf{}
+	for i, listener := range server.AllListeners() {
+		conf := &ServerConf{
+			Listener:      listener,
+			Routes:        routes,
+			LinkMtu:       server.LinkMtu,
+			DnsServers:    dnsServers,
+			SearchDomains: server.SearchDomains,
+			Cipher:        server.Cipher,
+			DataCiphers:   server.DataCiphers,
+			Auth:          server.Auth,
+			Keepalive:     *server.Keepalive,
+			Verbosity:     server.Verbosity,
+			Plugins:       server.Plugins,
+			StaticIpCount: server.StaticIpCount,
+			KeySpec:       spec,
+			Duo:           server.Duo,
+		}
+		conf.VpnSubnet, _ = ParseNetwork(listener.VpnSubnet)
+
+		// Each instance needs a management port of its own
+		if server.Management != nil {
+			management := *server.Management
+			management.Port += i
+			conf.Management = &management
+		}
+
+		confs = append(confs, conf)
+	}
+
+	return confs
+}
+
+// Where the instance keeps the addresses it gave each client. The instance named server keeps the name init-openvpn
+// used, so upgrading doesn't reshuffle client addresses.
+func (conf *ServerConf) IpPoolFile() string {
+	if conf.Listener.Name == DEFAULT_LISTENER_NAME {
+		return "ipp.txt"
+	}
+	return fmt.Sprintf("ipp-%s.txt", conf.Listener.Name)
+}
+
+// The Diffie-Hellman parameters file for an RSA PKI, named the way easy-rsa's build-dh names it. Elliptic curve PKIs
+// use ECDH instead, so they have none.
+func DhParamsFile(spec pki.KeySpec) string {
+	if spec.IsEllipticCurve() {
+		return ""
+	}
+	return fmt.Sprintf("dh%d.pem", spec.RsaBits)
+}
+
+// The curve OpenVPN should use for ECDH. Ed25519 PKIs leave it to OpenVPN to pick one.
+func (conf *ServerConf) EcdhCurve() string {
+	switch conf.KeySpec.Algorithm {
+	case pki.KEY_ALGORITHM_ECDSA_P256:
+		return "prime256v1"
+	case pki.KEY_ALGORITHM_ECDSA_P384:
+		return "secp384r1"
+	default:
+		return ""
+	}
+}
+
+func (conf *ServerConf) DhParamsFile() string {
+	return DhParamsFile(conf.KeySpec)
+}
+
+// Whether clients must send a username and password, for Duo or for a plugin that checks them
+func (conf *ServerConf) AuthUserPass() bool {
+	if conf.Duo != nil {
+		return true
+	}
+	for _, plugin := range conf.Plugins {
+		if plugin.AuthUserPass {
+			return true
+		}
+	}
+	return false
+}
+
+func RenderServerConf(conf *ServerConf) ([]byte, error) {
+	return render(serverConfTemplate, conf)
+}
+
+// The dynamic pool, as OpenVPN's ifconfig-pool takes it, when the end of the VPN subnet is kept for static IPs
+func (conf *ServerConf) DynamicPool() string {
+	start, end := dynamicPool(conf.VpnSubnet, conf.StaticIpCount)
+	return fmt.Sprintf("%s %s %s", start, end, net.IP(conf.VpnSubnet.Mask))
+}
+
+// Whether the contents of an OpenVPN config file were written by RenderServerConf
+func IsRenderedServerConf(contents string) bool {
+	return strings.Contains(contents, SERVER_CONF_MARKER)
+}
+
+// Bring the client profile template installed by install-openvpn in line with the server's instances: a remote for each
+// of them, in order, the same ciphers and MTU, and the settings the PKI and authentication plugins need. Directives the
+// base template already has are changed in place, and the rest are added at the end. The settings other than the
+// listeners are the same for every instance, so they're taken from the first.
+func RenderClientTemplate(base string, confs []*ServerConf) string {
+	conf := confs[0]
+
+	remotes := []string{strconv.Itoa(conf.Listener.Port)}
+	if len(confs) > 1 {
+		// With several remotes, each line names its own protocol
+		remotes = []string{}
+		for _, instance := range confs {
+			remotes = append(remotes, fmt.Sprintf("%d %s", instance.Listener.Port, instance.Listener.Protocol))
+		}
+	}
+
+	template := setDirective(base, "proto", conf.Listener.Protocol)
+	template = setDirective(template, "remote __SERVER_ADDRESS__", remotes...)
+	template = setDirective(template, "cipher", conf.Cipher)
+	template = setDirective(template, "auth", conf.Auth)
+
+	var builder strings.Builder
+	builder.WriteString(template)
+
+	if len(confs) > 1 {
+		// A UDP remote that can't be reached never refuses the connection, so without this the client would wait out
+		// the 60 second TLS handshake window before trying the next remote
+		builder.WriteString("# Try the remotes in order, giving each one 10 seconds to answer.\nserver-poll-timeout 10\n")
+	}
+
+	if len(conf.DataCiphers) > 0 {
+		builder.WriteString("# The ciphers the server negotiates with OpenVPN 2.5 and newer.\n")
+		builder.WriteString(fmt.Sprintf("data-ciphers %s\n", strings.Join(conf.DataCiphers, ":")))
+	}
+	if conf.LinkMtu != DEFAULT_LINK_MTU {
+		builder.WriteString(fmt.Sprintf("# The server's link MTU, which the client must match.\nlink-mtu %d\n", conf.LinkMtu))
+	}
+	if conf.KeySpec.IsEllipticCurve() {
+		builder.WriteString("# Elliptic curve certificates can only be used with TLS 1.2 or newer.\ntls-version-min 1.2\n")
+	}
+	if conf.Duo != nil {
+		builder.WriteString("# Duo Plugin parameters (see https://duo.com/docs/openvpn#configure-the-client).\n")
+		builder.WriteString("# Enables password prompt, as required for Duo authentication.\nauth-user-pass\n\n")
+		builder.WriteString("# Disable renegotiating the connection every hour to avoid unexpected push notifications.\nreneg-sec 0\n")
+	} else if conf.AuthUserPass() {
+		builder.WriteString("# Enables password prompt, as required by the server's authentication plugin.\nauth-user-pass\n")
+	}
+
+	return builder.String()
+}
+
+// Set the value of the first active (not commented out) occurrence of the directive in an OpenVPN config, or add the
+// directive at the end if there is none. With several values, the directive is repeated once for each.
+func setDirective(config string, directive string, values ...string) string {
+	lines := []string{}
+	for _, value := range values {
+		lines = append(lines, fmt.Sprintf("%s %s", directive, value))
+	}
+	block := strings.Join(lines, "\n")
+	regex := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(directive) + `([ \t][^\n]*)?$`)
+
+	location := regex.FindStringIndex(config)
+	if location == nil {
+		if config != "" && !strings.HasSuffix(config, "\n") {
+			config += "\n"
+		}
+		return config + block + "\n"
+	}
+	return config[:location[0]] + block + config[location[1]:]
+}
+
+// Fill in the VPN subnets that the UFW before.rules installed by install-openvpn masquerade. Each line with the
+// placeholder is repeated for every subnet.
+func RenderBeforeRules(base string, vpnSubnets []*net.IPNet) string {
+	lines := []string{}
+	for _, line := range strings.Split(base, "\n") {
+		if !strings.Contains(line, "__VPN_SUBNET__") {
+			lines = append(lines, line)
+			continue
+		}
+		for _, subnet := range vpnSubnets {
+			lines = append(lines, strings.Replace(line, "__VPN_SUBNET__", subnet.String(), -1))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// The sysctl settings the server needs to route traffic from VPN clients
+func RenderSysctlConf() []byte {
+	return []byte("# Written by openvpn-admin init. Lets the OpenVPN server route traffic from VPN clients.\nnet.ipv4.ip_forward=1\n")
+}
+
+// The environment variables OpenVPN sets for its client-connect and client-disconnect scripts that the hooks pass on to
+// the commands they run with sudo. sudo clears the rest.
+var HookEnvironmentVariables = []string{
+	"common_name",
+	"trusted_ip",
+	"trusted_ip6",
+	"trusted_port",
+	"ifconfig_pool_remote_ip",
+	"tls_serial_0",
+	"bytes_received",
+	"bytes_sent",
+	"config",
+	"daemon_pid",
+	"daemon_start_time",
+}
+
+// The sudoers file that lets the openvpn-admin hooks, which OpenVPN runs as nobody after it drops its privileges, start
+// and end sessions as root, since that changes the firewall and writes the session log. The commands it allows take no
+// arguments: the hooks write the client's config as nobody, and the commands check the environment they're given.
+func RenderHookSudoers(openVpnAdminPath string, user string) []byte {
+	return []byte(fmt.Sprintf(`# Written by openvpn-admin init or server render. Lets the openvpn-admin hooks start and end sessions as root.
+Cmnd_Alias OPENVPN_ADMIN_HOOKS = %s hook start-session "", %s hook end-session ""
+Defaults!OPENVPN_ADMIN_HOOKS env_keep += "%s"
+%s ALL=(root) NOPASSWD: OPENVPN_ADMIN_HOOKS
+`, openVpnAdminPath, openVpnAdminPath, strings.Join(HookEnvironmentVariables, " "), user))
+}
+
+// The hourly cron job that backs up the PKI with openvpn-admin backup
+func RenderBackupCronJob(backupConfig *BackupConfig, openVpnAdminPath string, awsRegion string) ([]byte, error) {
+	return render(backupCronJobTemplate, struct {
+		OpenVpnAdminPath string
+		AwsRegion        string
+		*BackupConfig
+	}{openVpnAdminPath, awsRegion, backupConfig})
+}
+
+func render(text string, data interface{}) ([]byte, error) {
+	funcs := template.FuncMap{
+		"network": AddressAndNetmask,
+		"join":    strings.Join,
+	}
+	tmpl, err := template.New("").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return buffer.Bytes(), nil
+}
+
+const easyRsaVarsTemplate = `# easy-rsa parameter settings, written by openvpn-admin init
+
+# This variable should point to
+# the top level of the easy-rsa
+# tree.
+export EASY_RSA="` + "`pwd`" + `"
+
+#
+# This variable should point to
+# the requested executables
+#
+export OPENSSL="openssl"
+export PKCS11TOOL="pkcs11-tool"
+export GREP="grep"
+
+# This variable should point to
+# the openssl.cnf file included
+# with easy-rsa.
+export KEY_CONFIG=$EASY_RSA/openssl-1.0.0.cnf
+
+# WARNING: clean-all will do
+# a rm -rf on this directory
+export KEY_DIR="{{.KEY_DIR}}"
+
+# When PKCS11_MODULE_PATH is set to a PKCS#11 module
+# (e.g. SoftHSM's libsofthsm2.so), openvpn-admin keeps
+# the CA key in the token with the given label, under
+# PKCS11_KEY_LABEL, and reads the token PIN from
+# PKCS11_PIN_FILE. There is no ca.key in that case.
+export PKCS11_MODULE_PATH="{{.PKCS11_MODULE_PATH}}"
+export PKCS11_PIN="dummy"
+export PKCS11_TOKEN_LABEL="{{.PKCS11_TOKEN_LABEL}}"
+export PKCS11_KEY_LABEL="{{.PKCS11_KEY_LABEL}}"
+export PKCS11_PIN_FILE="{{.PKCS11_PIN_FILE}}"
+
+# When KMS_SIGNING_KEY_ARN is set to the ARN of an
+# asymmetric KMS key, openvpn-admin signs with that key
+# through kms:Sign instead, and there is no ca.key either.
+export KMS_SIGNING_KEY_ARN="{{.KMS_SIGNING_KEY_ARN}}"
+
+# The size of RSA keys and DH parameters
+export KEY_SIZE={{.KEY_SIZE}}
+
+# The key algorithm openvpn-admin uses for the CA, server
+# and client keys: rsa (using KEY_SIZE), ecdsa-p256,
+# ecdsa-p384 or ed25519.
+export KEY_ALGORITHM={{.KEY_ALGORITHM}}
+
+# In how many days should the root CA key expire?
+export CA_EXPIRE={{.CA_EXPIRE}}
+
+# In how many days should certificates expire?
+export KEY_EXPIRE={{.KEY_EXPIRE}}
+
+# These are the default values for fields
+# which will be placed in the certificate.
+export KEY_COUNTRY="{{.KEY_COUNTRY}}"
+export KEY_PROVINCE="{{.KEY_PROVINCE}}"
+export KEY_CITY="{{.KEY_CITY}}"
+export KEY_ORG="{{.KEY_ORG}}"
+export KEY_EMAIL="{{.KEY_EMAIL}}"
+export KEY_OU="{{.KEY_OU}}"
+
+# X509 Subject Field
+export KEY_NAME="{{.KEY_NAME}}"
+`
+
+// RenderServerConf's header says this, so that init can tell the instances it set up from other OpenVPN configs
+const SERVER_CONF_MARKER = "It was written by openvpn-admin init or server render"
+
+const serverConfTemplate = `##
+## This is a configuration file for the OpenVPN Server.
+## ` + SERVER_CONF_MARKER + `, which overwrite any changes.
+##
+
+port {{.Listener.Port}}
+proto {{.Listener.Protocol}}
+dev tun
+ca ca.crt
+cert server.crt
+key server.key  # This file should be kept secret
+{{if .DhParamsFile}}dh {{.DhParamsFile}}
+{{else}}dh none
+{{if .EcdhCurve}}ecdh-curve {{.EcdhCurve}}
+{{end}}{{end -}}
+topology subnet
+crl-verify /etc/openvpn/crl.pem
+{{if .TlsCryptV2VerifyCommand}}tls-crypt-v2 tls-crypt-v2-server.key
+script-security 2
+tls-crypt-v2-verify "{{.TlsCryptV2VerifyCommand}}"
+{{else if .HookCommand}}script-security 2
+{{end -}}
+persist-key
+persist-tun
+{{if .StaticIpCount}}server {{network .VpnSubnet}} nopool
+# The end of the subnet is kept for the static IPs set with openvpn-admin client-config set
+ifconfig-pool {{.DynamicPool}}
+{{else}}server {{network .VpnSubnet}}
+{{end -}}
+ifconfig-pool-persist {{.IpPoolFile}}
+client-config-dir /etc/openvpn/ccd/{{.Listener.Name}}
+{{if .HookCommand}}# openvpn-admin checks each client against the connection policy, applies its access groups and records its session
+tmp-dir {{.HookTmpDir}}
+client-connect "{{.HookCommand}} client-connect"
+client-disconnect "{{.HookCommand}} client-disconnect"
+{{end -}}
+
+link-mtu {{.LinkMtu}} # OpenVPN default is 1500
+
+# Certain Windows-specific network settings
+# can be pushed to clients, such as DNS
+# or WINS server addresses.
+{{range .DnsServers}}push "dhcp-option DNS {{.}}"
+{{end}}{{range .SearchDomains}}push "dhcp-option DOMAIN {{.}}"
+{{end}}
+keepalive {{.Keepalive.Interval}} {{.Keepalive.Timeout}}
+cipher {{.Cipher}}
+{{if .DataCiphers}}data-ciphers {{join .DataCiphers ":"}}
+{{end -}}
+auth {{.Auth}}
+user  nobody
+group nogroup
+{{range .Routes}}push "route {{network .}}"
+{{end}}
+verb {{.Verbosity}}
+daemon
+mute 20
+{{if .Management}}
+management {{.Management.Address}} {{.Management.Port}}{{if .Management.PasswordFile}} {{.Management.PasswordFile}}{{end}}
+{{end -}}
+{{if .Plugins}}
+{{range .Plugins}}plugin {{.Path}}{{range .Args}} "{{.}}"{{end}}
+{{end}}{{end -}}
+{{if .Duo}}
+plugin /opt/duo/duo_openvpn.so '{{.Duo.Ikey}} {{.Duo.Skey}} {{.Duo.Host}}'
+reneg-sec 0
+{{end -}}
+`
+
+const backupCronJobTemplate = `#!/bin/bash
+##
+## This is for backing up the OpenVPN Server PKI. It was written by openvpn-admin init.
+##
+"{{.OpenVpnAdminPath}}" backup
+{{- if .AwsRegion}} --aws-region "{{.AwsRegion}}"{{end}}
+{{- if .S3BucketName}} --s3-bucket-name "{{.S3BucketName}}"{{end}}
+{{- if .Url}} --backup-url "{{.Url}}"{{end}}
+{{- if .S3Endpoint}} --s3-endpoint "{{.S3Endpoint}}"{{end}}
+{{- if .SftpIdentityFile}} --sftp-identity-file "{{.SftpIdentityFile}}"{{end}}
+{{- if .KmsKeyId}} --kms-key-id "{{.KmsKeyId}}"{{end}}
+{{- range .AgeRecipients}} --age-recipient "{{.}}"{{end}}
+{{- range .GpgRecipients}} --gpg-recipient "{{.}}"{{end}}
+`
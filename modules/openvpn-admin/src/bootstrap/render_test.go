@@ -0,0 +1,186 @@
+package bootstrap
+
+import (
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/pki"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Render the config file of each of the instances of the given config, with the defaults filled in and the hooks enabled
+func renderTestServerConfs(t *testing.T, config *Config, keyAlgorithm string) []string {
+	t.Helper()
+
+	config.setDefaults()
+	if problems := config.validate(); len(problems) > 0 {
+		t.Fatalf("Expected a valid config, got %v", problems)
+	}
+	spec, err := pki.ParseKeySpec(keyAlgorithm, DEFAULT_KEY_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := []string{}
+	for _, conf := range NewServerConfs(&config.Server, spec, []string{"10.0.0.2"}) {
+		conf.HookCommand = "/usr/local/bin/openvpn-admin hook"
+		conf.HookTmpDir = "/run/openvpn-admin"
+		contents, err := RenderServerConf(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rendered = append(rendered, string(contents))
+	}
+	return rendered
+}
+
+func TestRenderServerConf(t *testing.T) {
+	testCases := []struct {
+		name         string
+		change       func(config *Config)
+		keyAlgorithm string
+		expected     [][]string
+		unexpected   []string
+	}{
+		{
+			"defaults",
+			func(config *Config) {},
+			pki.KEY_ALGORITHM_RSA,
+			[][]string{{
+				"port 1194\nproto udp\n",
+				"dh dh4096.pem\n",
+				"server 172.16.1.0 255.255.255.0\n",
+				"ifconfig-pool-persist ipp.txt\n",
+				"client-config-dir /etc/openvpn/ccd/server\n",
+				"tmp-dir /run/openvpn-admin\n",
+				"client-connect \"/usr/local/bin/openvpn-admin hook client-connect\"\n",
+				"client-disconnect \"/usr/local/bin/openvpn-admin hook client-disconnect\"\n",
+				"push \"dhcp-option DNS 10.0.0.2\"\n",
+				"keepalive 10 120\ncipher AES-256-CBC\nauth SHA256\n",
+				"push \"route 10.0.0.0 255.255.0.0\"\n",
+				SERVER_CONF_MARKER,
+			}},
+			[]string{"dh none", "ecdh-curve", "nopool", "ifconfig-pool ", "tls-crypt-v2", "management", "plugin"},
+		},
+		{
+			"elliptic curve PKI with static IPs",
+			func(config *Config) {
+				config.Server.StaticIpCount = 50
+				config.Server.DataCiphers = []string{"AES-256-GCM", "CHACHA20-POLY1305"}
+			},
+			pki.KEY_ALGORITHM_ECDSA_P384,
+			[][]string{{
+				"dh none\necdh-curve secp384r1\n",
+				"server 172.16.1.0 255.255.255.0 nopool\n",
+				"ifconfig-pool 172.16.1.2 172.16.1.204 255.255.255.0\n",
+				"data-ciphers AES-256-GCM:CHACHA20-POLY1305\n",
+			}},
+			[]string{"dh dh", "\nserver 172.16.1.0 255.255.255.0\n"},
+		},
+		{
+			"listeners",
+			func(config *Config) {
+				config.Server.VpnSubnet = ""
+				config.Server.Listeners = []ListenerConfig{
+					{Name: "udp", Port: 1194, VpnSubnet: "172.16.0.0/16"},
+					{Name: "tcp", Port: 443, Protocol: "tcp", VpnSubnet: "172.17.0.0/16"},
+				}
+				config.Server.Management = &ManagementConfig{Address: "127.0.0.1", Port: 7505}
+				config.Server.Plugins = []PluginConfig{{Path: "/usr/lib/openvpn/openvpn-plugin-auth-pam.so", Args: []string{"login"}}}
+			},
+			pki.KEY_ALGORITHM_ED25519,
+			[][]string{
+				{
+					"port 1194\nproto udp\n",
+					"dh none\ntopology",
+					"server 172.16.0.0 255.255.0.0\n",
+					"ifconfig-pool-persist ipp-udp.txt\n",
+					"client-config-dir /etc/openvpn/ccd/udp\n",
+					"management 127.0.0.1 7505\n",
+					"plugin /usr/lib/openvpn/openvpn-plugin-auth-pam.so \"login\"\n",
+				},
+				{
+					"port 443\nproto tcp\n",
+					"server 172.17.0.0 255.255.0.0\n",
+					"ifconfig-pool-persist ipp-tcp.txt\n",
+					"client-config-dir /etc/openvpn/ccd/tcp\n",
+					"management 127.0.0.1 7506\n",
+				},
+			},
+			[]string{"ecdh-curve"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := newTestConfig()
+			testCase.change(config)
+
+			rendered := renderTestServerConfs(t, config, testCase.keyAlgorithm)
+			if len(rendered) != len(testCase.expected) {
+				t.Fatalf("Expected %d instances, got %d", len(testCase.expected), len(rendered))
+			}
+			for i, contents := range rendered {
+				for _, expected := range testCase.expected[i] {
+					if !strings.Contains(contents, expected) {
+						t.Errorf("Expected instance %d to contain %q, got\n%s", i, expected, contents)
+					}
+				}
+				for _, unexpected := range testCase.unexpected {
+					if strings.Contains(contents, unexpected) {
+						t.Errorf("Expected instance %d not to contain %q, got\n%s", i, unexpected, contents)
+					}
+				}
+				if !IsRenderedServerConf(contents) {
+					t.Errorf("Expected instance %d to be recognized as rendered", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderBeforeRules(t *testing.T) {
+	base := "*nat\n:POSTROUTING ACCEPT [0:0]\n-A POSTROUTING -s __VPN_SUBNET__ -o eth0 -j MASQUERADE\nCOMMIT"
+
+	testCases := []struct {
+		name     string
+		subnets  []string
+		expected string
+	}{
+		{"one subnet", []string{"172.16.1.0/24"}, "*nat\n:POSTROUTING ACCEPT [0:0]\n-A POSTROUTING -s 172.16.1.0/24 -o eth0 -j MASQUERADE\nCOMMIT"},
+		{"several subnets", []string{"172.16.0.0/16", "172.17.0.0/16"}, "*nat\n:POSTROUTING ACCEPT [0:0]\n-A POSTROUTING -s 172.16.0.0/16 -o eth0 -j MASQUERADE\n-A POSTROUTING -s 172.17.0.0/16 -o eth0 -j MASQUERADE\nCOMMIT"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			subnets := []*net.IPNet{}
+			for _, subnet := range testCase.subnets {
+				_, network, _ := net.ParseCIDR(subnet)
+				subnets = append(subnets, network)
+			}
+
+			rendered := RenderBeforeRules(base, subnets)
+			if rendered != testCase.expected {
+				t.Errorf("Expected\n%s\ngot\n%s", testCase.expected, rendered)
+			}
+		})
+	}
+}
+
+// The hooks run as nobody, so sudo must only let them run the two hook commands with no arguments
+func TestRenderHookSudoers(t *testing.T) {
+	sudoers := string(RenderHookSudoers("/usr/local/bin/openvpn-admin", "nobody"))
+
+	expected := []string{
+		"Cmnd_Alias OPENVPN_ADMIN_HOOKS = /usr/local/bin/openvpn-admin hook start-session \"\", /usr/local/bin/openvpn-admin hook end-session \"\"\n",
+		"Defaults!OPENVPN_ADMIN_HOOKS env_keep += \"" + strings.Join(HookEnvironmentVariables, " ") + "\"\n",
+		"nobody ALL=(root) NOPASSWD: OPENVPN_ADMIN_HOOKS\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(sudoers, line) {
+			t.Errorf("Expected the sudoers file to contain %q, got\n%s", line, sudoers)
+		}
+	}
+	if strings.Contains(sudoers, "ALL=(ALL)") || strings.Count(sudoers, "NOPASSWD") != 1 {
+		t.Errorf("Expected the sudoers file to allow nothing else, got\n%s", sudoers)
+	}
+}
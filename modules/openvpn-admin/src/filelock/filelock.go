@@ -0,0 +1,19 @@
+//go:build !windows
+
+package filelock
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"os"
+	"syscall"
+)
+
+// Take an exclusive lock on the file, waiting for any other process that holds it. The server-side commands use this to
+// take turns at the files they share, e.g. the CA database, the audit log and the session log.
+func Lock(file *os.File) error {
+	return errors.WithStackTrace(syscall.Flock(int(file.Fd()), syscall.LOCK_EX))
+}
+
+func Unlock(file *os.File) error {
+	return errors.WithStackTrace(syscall.Flock(int(file.Fd()), syscall.LOCK_UN))
+}
@@ -0,0 +1,14 @@
+package filelock
+
+import (
+	"os"
+)
+
+// The server side of openvpn-admin only runs on Linux, so there is never another process to lock out on Windows
+func Lock(file *os.File) error {
+	return nil
+}
+
+func Unlock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// The formats log output can be written in
+const FORMAT_TEXT = "text"
+const FORMAT_JSON = "json"
+
+var Formats = []string{FORMAT_TEXT, FORMAT_JSON}
+
+// The fields every message about a request from a queue carries, so that all the lines about one request can be found
+const FIELD_QUEUE = "queue"
+const FIELD_OPERATION = "operation"
+const FIELD_REQUEST_ID = "request_id"
+const FIELD_MESSAGE_ID = "message_id"
+const FIELD_USERNAME = "username"
+
+var globalLogLevel = logrus.InfoLevel
+var globalLogFormat = FORMAT_TEXT
+var globalLock = sync.Mutex{}
+
+// Create a new logger. This works like GetLogger in gruntwork-cli, except that the logger writes in the global log format
+// and strips secrets from everything it logs, whatever the level.
+func GetLogger(name string) *logrus.Logger {
+	defer globalLock.Unlock()
+	globalLock.Lock()
+
+	logger := logrus.New()
+	logger.Level = globalLogLevel
+
+	if globalLogFormat == FORMAT_JSON {
+		logger.Formatter = redactingFormatter{Formatter: &logrus.JSONFormatter{}}
+	} else {
+		logger.Formatter = redactingFormatter{Formatter: &logrus.TextFormatter{FullTimestamp: true}}
+	}
+
+	return logger
+}
+
+// Set the log level. Like in gruntwork-cli, this ONLY affects loggers created by GetLogger after it's called.
+func SetGlobalLogLevel(level logrus.Level) {
+	defer globalLock.Unlock()
+	globalLock.Lock()
+
+	globalLogLevel = level
+}
+
+// Set the log format to one of Formats. This ONLY affects loggers created by GetLogger after it's called.
+func SetGlobalLogFormat(format string) error {
+	defer globalLock.Unlock()
+	globalLock.Lock()
+
+	for _, knownFormat := range Formats {
+		if format == knownFormat {
+			globalLogFormat = format
+			return nil
+		}
+	}
+	return InvalidLogFormat(format)
+}
+
+// Custom errors
+
+type InvalidLogFormat string
+
+func (format InvalidLogFormat) Error() string {
+	return fmt.Sprintf("Unknown log format '%s', expected one of: %s", string(format), strings.Join(Formats, ", "))
+}
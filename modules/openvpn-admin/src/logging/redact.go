@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"regexp"
+)
+
+// PEM blocks hold private keys, certificates, CSRs and OpenVPN's static and tls-crypt-v2 keys, whether they're logged on
+// their own, inline in a client config, or JSON encoded in a queue message. A block that was cut short is redacted to
+// the end of the text.
+var pemBlockRegex = regexp.MustCompile(`-----BEGIN ([^\n\\]+?)-----[\s\S]*?(?:-----END [^\n\\]+?-----|$)`)
+
+// Redact strips every PEM block from the given text
+func Redact(text string) string {
+	return pemBlockRegex.ReplaceAllString(text, "[REDACTED $1]")
+}
+
+// redactingFormatter strips secrets from the message and fields of every entry before formatting it
+type redactingFormatter struct {
+	Formatter logrus.Formatter
+}
+
+func (formatter redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	redacted := *entry
+	redacted.Message = Redact(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			redacted.Data[key] = Redact(value)
+		case error:
+			redacted.Data[key] = Redact(value.Error())
+		default:
+			redacted.Data[key] = value
+		}
+	}
+
+	return formatter.Formatter.Format(&redacted)
+}
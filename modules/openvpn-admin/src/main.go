@@ -1,10 +1,16 @@
 package main
 
 import (
-	"github.com/gruntwork-io/gruntwork-cli/entrypoint"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
 	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/app"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/logging"
+	"github.com/urfave/cli"
+	"os"
 )
 
+const DEFAULT_SUCCESS_EXIT_CODE = 0
+const DEFAULT_ERROR_EXIT_CODE = 1
+
 // This variable is set at build time using -ldflags parameters. For example, we typically set this flag in circle.yml
 // to the latest Git tag when building our Go apps:
 //
@@ -15,5 +21,35 @@ var VERSION string
 
 func main() {
 	openVpnAdminApp := app.CreateApp(VERSION)
-	entrypoint.RunApp(openVpnAdminApp)
+	runApp(openVpnAdminApp)
+}
+
+// This is entrypoint.RunApp from gruntwork-cli, except that the error the app fails with is logged like everything
+// else: in the --log-format the user asked for, and with secrets redacted
+func runApp(app *cli.App) {
+	cli.OsExiter = func(exitCode int) {
+		// Do nothing. The default calls os.Exit, and we exit below once the error has been logged.
+	}
+
+	defer errors.Recover(checkForErrorsAndExit)
+	err := app.Run(os.Args)
+	checkForErrorsAndExit(err)
+}
+
+// If there is an error, log it and exit with a non-zero exit code. Otherwise, exit 0.
+func checkForErrorsAndExit(err error) {
+	exitCode := DEFAULT_SUCCESS_EXIT_CODE
+
+	if err != nil {
+		logging.GetLogger("").WithError(err).Error(errors.PrintErrorWithStackTrace(err))
+
+		errorWithExitCode, isErrorWithExitCode := err.(errors.ErrorWithExitCode)
+		if isErrorWithExitCode {
+			exitCode = errorWithExitCode.ExitCode
+		} else {
+			exitCode = DEFAULT_ERROR_EXIT_CODE
+		}
+	}
+
+	os.Exit(exitCode)
 }
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replaced with the username in the addresses of email sinks
+const USERNAME_PLACEHOLDER = "{username}"
+
+// SmtpSink emails each notification through an SMTP server, using STARTTLS if the server offers it
+type SmtpSink struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Create an SMTP sink from its config, reading its password from smtp_password_file if set
+func NewSmtpSink(config *SinkConfig) (*SmtpSink, error) {
+	sink := &SmtpSink{
+		Host:     config.SmtpHost,
+		Port:     config.SmtpPort,
+		Username: config.SmtpUsername,
+		From:     config.From,
+		To:       config.To,
+	}
+	if config.SmtpPasswordFile == "" {
+		return sink, nil
+	}
+
+	password, err := ioutil.ReadFile(config.SmtpPasswordFile)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	sink.Password = strings.TrimSpace(string(password))
+	return sink, nil
+}
+
+func (sink *SmtpSink) Send(notification *Notification) error {
+	recipients := EmailRecipients(sink.To, notification)
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if sink.Username != "" {
+		auth = smtp.PlainAuth("", sink.Username, sink.Password, sink.Host)
+	}
+
+	address := net.JoinHostPort(sink.Host, strconv.Itoa(sink.Port))
+	message := FormatEmail(sink.From, recipients, notification)
+	return errors.WithStackTrace(smtp.SendMail(address, auth, sink.From, recipients, message))
+}
+
+// The addresses to email the notification to, with {username} replaced. Addresses that need a username are skipped for
+// notifications that aren't about a user.
+func EmailRecipients(to []string, notification *Notification) []string {
+	recipients := []string{}
+	for _, address := range to {
+		if strings.Contains(address, USERNAME_PLACEHOLDER) {
+			if notification.Username == "" {
+				continue
+			}
+			address = strings.Replace(address, USERNAME_PLACEHOLDER, notification.Username, -1)
+		}
+		recipients = append(recipients, address)
+	}
+	return recipients
+}
+
+// Format the notification as a plain text email, with its summary as the subject
+func FormatEmail(from string, to []string, notification *Notification) []byte {
+	var message bytes.Buffer
+
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notification.Summary()))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&message, "\r\n")
+	message.WriteString(strings.Replace(notification.Text(), "\n", "\r\n", -1))
+
+	return message.Bytes()
+}
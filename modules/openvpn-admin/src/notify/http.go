@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers webhooks receive along with the notification
+const HEADER_EVENT = "X-OpenVPN-Admin-Event"
+const HEADER_TIMESTAMP = "X-OpenVPN-Admin-Timestamp"
+const HEADER_SIGNATURE = "X-OpenVPN-Admin-Signature"
+
+// Slow endpoints hold up processing the queues, so give up on them after this long
+const HTTP_TIMEOUT = 10 * time.Second
+
+var httpClient = &http.Client{Timeout: HTTP_TIMEOUT}
+
+// WebhookSink POSTs each notification as JSON. If it has a secret, it signs the timestamp and body so that the receiver
+// can check that the notification came from openvpn-admin and isn't being replayed.
+type WebhookSink struct {
+	Url    string
+	Secret []byte
+}
+
+// Create a webhook sink, reading its secret from secretFile unless that's empty
+func NewWebhookSink(url string, secretFile string) (*WebhookSink, error) {
+	sink := &WebhookSink{Url: url}
+	if secretFile == "" {
+		return sink, nil
+	}
+
+	secret, err := ioutil.ReadFile(secretFile)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	sink.Secret = bytes.TrimSpace(secret)
+	if len(sink.Secret) == 0 {
+		return nil, errors.WithStackTrace(fmt.Errorf("the webhook secret in %s is empty", secretFile))
+	}
+	return sink, nil
+}
+
+func (sink *WebhookSink) Send(notification *Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	headers := map[string]string{HEADER_EVENT: notification.Event}
+	if len(sink.Secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers[HEADER_TIMESTAMP] = timestamp
+		headers[HEADER_SIGNATURE] = "sha256=" + Sign(sink.Secret, timestamp, body)
+	}
+
+	return post(sink.Url, body, headers)
+}
+
+// Sign computes the hex encoded HMAC-SHA256 of "<timestamp>.<body>", as sent in the X-OpenVPN-Admin-Signature header
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SlackSink posts each notification's summary to a Slack incoming webhook, or anything that accepts the same payload
+type SlackSink struct {
+	Url string
+}
+
+func (sink *SlackSink) Send(notification *Notification) error {
+	body, err := json.Marshal(map[string]string{"text": notification.Summary()})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return post(sink.Url, body, nil)
+}
+
+func post(url string, body []byte, headers map[string]string) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	// The URL of a Slack webhook is a secret, so leave it out of the error
+	response, err := httpClient.Do(request)
+	if urlErr, isUrlErr := err.(*neturl.Error); isUrlErr {
+		return errors.WithStackTrace(urlErr.Err)
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return errors.WithStackTrace(UnexpectedResponse{StatusCode: response.StatusCode, Body: strings.TrimSpace(string(responseBody))})
+	}
+	return nil
+}
+
+// Custom errors
+
+type UnexpectedResponse struct {
+	StatusCode int
+	Body       string
+}
+
+func (err UnexpectedResponse) Error() string {
+	return fmt.Sprintf("The endpoint responded with %d %s", err.StatusCode, err.Body)
+}
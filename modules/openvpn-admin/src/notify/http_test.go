@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// A server that records each request it gets and responds with the given status
+func newTestWebhookServer(t *testing.T, status int) (*httptest.Server, *[]receivedWebhook) {
+	received := &[]receivedWebhook{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		*received = append(*received, receivedWebhook{header: request.Header, body: body})
+		writer.WriteHeader(status)
+		writer.Write([]byte("go away\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestWebhookSinkSignsTimestampAndBody(t *testing.T) {
+	server, received := newTestWebhookServer(t, http.StatusNoContent)
+
+	secretFile := filepath.Join(t.TempDir(), "webhook-secret")
+	if err := ioutil.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewWebhookSink(server.URL, secretFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notification := &Notification{Event: EVENT_CERTIFICATE_ISSUED, Username: "alice"}
+	if err := sink.Send(notification); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*received) != 1 {
+		t.Fatalf("expected one request, got %d", len(*received))
+	}
+	webhook := (*received)[0]
+
+	if webhook.header.Get(HEADER_EVENT) != EVENT_CERTIFICATE_ISSUED || webhook.header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers %v", webhook.header)
+	}
+	sent := &Notification{}
+	if err := json.Unmarshal(webhook.body, sent); err != nil || sent.Username != "alice" {
+		t.Errorf("expected the notification as JSON, got %s", webhook.body)
+	}
+
+	timestamp := webhook.header.Get(HEADER_TIMESTAMP)
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sentAt, 0)) > time.Minute {
+		t.Errorf("expected the current time as the timestamp, got %q", timestamp)
+	}
+
+	// The way a receiver checks the signature, trimming the secret the same way
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(webhook.body)))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(webhook.header.Get(HEADER_SIGNATURE)), []byte(expected)) {
+		t.Errorf("expected the signature %s, got %s", expected, webhook.header.Get(HEADER_SIGNATURE))
+	}
+
+	// The signature covers the timestamp, so it can't be replayed with another
+	if Sign(sink.Secret, strconv.FormatInt(sentAt+1, 10), webhook.body) == Sign(sink.Secret, timestamp, webhook.body) {
+		t.Errorf("expected the signature to depend on the timestamp")
+	}
+}
+
+func TestWebhookSinkWithoutSecretDoesntSign(t *testing.T) {
+	server, received := newTestWebhookServer(t, http.StatusOK)
+
+	sink, err := NewWebhookSink(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(&Notification{Event: EVENT_CERTIFICATE_REVOKED}); err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := (*received)[0]
+	if webhook.header.Get(HEADER_SIGNATURE) != "" || webhook.header.Get(HEADER_TIMESTAMP) != "" {
+		t.Errorf("expected no signature without a secret, got %v", webhook.header)
+	}
+}
+
+func TestNewWebhookSinkRefusesEmptySecret(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "webhook-secret")
+	if err := ioutil.WriteFile(secretFile, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWebhookSink("https://siem.example.com/hooks/openvpn", secretFile); err == nil {
+		t.Errorf("expected an empty secret to be refused")
+	}
+}
+
+func TestWebhookSinkReportsErrorResponses(t *testing.T) {
+	server, _ := newTestWebhookServer(t, http.StatusForbidden)
+
+	err := (&WebhookSink{Url: server.URL, Secret: []byte("s3cret")}).Send(&Notification{Event: EVENT_CERTIFICATE_ISSUED})
+	unexpected, ok := errors.Unwrap(err).(UnexpectedResponse)
+	if !ok {
+		t.Fatalf("expected UnexpectedResponse, got %v", err)
+	}
+	if unexpected.StatusCode != http.StatusForbidden || unexpected.Body != "go away" {
+		t.Errorf("unexpected response %+v", unexpected)
+	}
+}
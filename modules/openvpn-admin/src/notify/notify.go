@@ -0,0 +1,308 @@
+package notify
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+// The events a sink can subscribe to
+const EVENT_CERTIFICATE_ISSUED = "certificate.issued"
+const EVENT_CERTIFICATE_REVOKED = "certificate.revoked"
+const EVENT_CERTIFICATE_RELEASED = "certificate.released"
+const EVENT_REQUEST_PENDING_APPROVAL = "request.pending_approval"
+const EVENT_REQUEST_APPROVED = "request.approved"
+const EVENT_REQUEST_DENIED = "request.denied"
+const EVENT_ACCESS_DENIED = "access.denied"
+const EVENT_CERTIFICATE_EXPIRING = "certificate.expiring"
+
+var Events = []string{
+	EVENT_CERTIFICATE_ISSUED,
+	EVENT_CERTIFICATE_REVOKED,
+	EVENT_CERTIFICATE_RELEASED,
+	EVENT_REQUEST_PENDING_APPROVAL,
+	EVENT_REQUEST_APPROVED,
+	EVENT_REQUEST_DENIED,
+	EVENT_ACCESS_DENIED,
+	EVENT_CERTIFICATE_EXPIRING,
+}
+
+// Subscribing to this event subscribes to all of them
+const ALL_EVENTS = "*"
+
+// Sent by 'openvpn-admin notify test' to every sink, whatever it subscribes to
+const EVENT_TEST = "test"
+
+// The kinds of sink notifications can be sent to
+const SINK_WEBHOOK = "webhook"
+const SINK_SLACK = "slack"
+const SINK_SNS = "sns"
+const SINK_SES = "ses"
+const SINK_SMTP = "smtp"
+
+var SinkTypes = []string{SINK_WEBHOOK, SINK_SLACK, SINK_SNS, SINK_SES, SINK_SMTP}
+
+// The SMTP port to use if a sink doesn't set one, which is the submission port
+const DEFAULT_SMTP_PORT = 587
+
+// Notification is what's sent to sinks. Webhooks receive it as JSON, Slack and SNS receive its summary, and email sinks
+// receive its summary as the subject and its text as the body.
+type Notification struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Serial    string    `json:"serial,omitempty"`
+	Requester string    `json:"requester,omitempty"`
+	RequestId string    `json:"request_id,omitempty"`
+	Message   string    `json:"message,omitempty"`
+
+	// Only set for certificate.expiring
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// A one line, human readable description of the notification
+func (notification *Notification) Summary() string {
+	var summary string
+
+	switch notification.Event {
+	case EVENT_CERTIFICATE_ISSUED:
+		summary = fmt.Sprintf("Issued certificate %s to %s", notification.Serial, notification.Username)
+	case EVENT_CERTIFICATE_REVOKED:
+		summary = fmt.Sprintf("Revoked certificate %s of %s", notification.Serial, notification.Username)
+	case EVENT_CERTIFICATE_RELEASED:
+		summary = fmt.Sprintf("Released the hold on certificate %s of %s", notification.Serial, notification.Username)
+	case EVENT_REQUEST_PENDING_APPROVAL:
+		summary = fmt.Sprintf("Certificate request %s for %s is waiting for approval", notification.RequestId, notification.Username)
+	case EVENT_REQUEST_APPROVED:
+		summary = fmt.Sprintf("Approved certificate request %s for %s", notification.RequestId, notification.Username)
+	case EVENT_REQUEST_DENIED:
+		summary = fmt.Sprintf("Denied certificate request %s for %s", notification.RequestId, notification.Username)
+	case EVENT_ACCESS_DENIED:
+		summary = fmt.Sprintf("Access denied for %s", notification.Username)
+	case EVENT_CERTIFICATE_EXPIRING:
+		days := "days"
+		if notification.DaysLeft() == 1 {
+			days = "day"
+		}
+		summary = fmt.Sprintf("Certificate %s of %s expires in %d %s", notification.Serial, notification.Username, notification.DaysLeft(), days)
+	case EVENT_TEST:
+		summary = "Test notification from openvpn-admin"
+	default:
+		summary = fmt.Sprintf("%s for %s", notification.Event, notification.Username)
+	}
+
+	if notification.Requester != "" && notification.Event != EVENT_TEST {
+		summary = fmt.Sprintf("%s, requested by %s", summary, notification.Requester)
+	}
+	if notification.Host != "" {
+		summary = fmt.Sprintf("[%s] %s", notification.Host, summary)
+	}
+	if notification.Message != "" {
+		summary = fmt.Sprintf("%s: %s", summary, notification.Message)
+	}
+	return summary
+}
+
+// The number of days until the certificate expires, counting part of a day as a day
+func (notification *Notification) DaysLeft() int {
+	if notification.Expires == nil {
+		return 0
+	}
+	return int(math.Ceil(notification.Expires.Sub(notification.Time).Hours() / 24))
+}
+
+// A plain text description of the notification, with all of its details, for the body of an email
+func (notification *Notification) Text() string {
+	lines := []string{notification.Summary(), ""}
+
+	details := [][2]string{
+		{"Event", notification.Event},
+		{"User", notification.Username},
+		{"Certificate serial", notification.Serial},
+		{"Requested by", notification.Requester},
+		{"Request id", notification.RequestId},
+		{"Server", notification.Host},
+		{"Time", notification.Time.Format(time.RFC1123)},
+	}
+	if notification.Expires != nil {
+		details = append(details, [2]string{"Expires", notification.Expires.Format(time.RFC1123)})
+	}
+
+	for _, detail := range details {
+		if detail[1] != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", detail[0], detail[1]))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Sink delivers notifications somewhere admins will see them
+type Sink interface {
+	Send(notification *Notification) error
+}
+
+// SinkConfig is a single entry in a notifications file. Which fields apply depends on the type.
+type SinkConfig struct {
+	Name       string   `yaml:"name"`
+	Type       string   `yaml:"type"`
+	Url        string   `yaml:"url"`
+	SecretFile string   `yaml:"secret_file"`
+	TopicArn   string   `yaml:"topic_arn"`
+	Events     []string `yaml:"events"`
+
+	// Email sinks. Each address in To may contain {username}, which is replaced with the user the event is about, so
+	// that e.g. expiry reminders reach the certificate holder.
+	From             string   `yaml:"from"`
+	To               []string `yaml:"to"`
+	SmtpHost         string   `yaml:"smtp_host"`
+	SmtpPort         int      `yaml:"smtp_port"`
+	SmtpUsername     string   `yaml:"smtp_username"`
+	SmtpPasswordFile string   `yaml:"smtp_password_file"`
+}
+
+// Config lists the sinks to send notifications to
+type Config struct {
+	Path  string        `yaml:"-"`
+	Sinks []*SinkConfig `yaml:"sinks"`
+}
+
+// Read the notifications file at the given path and check every sink in it, so that mistakes show up when the server
+// starts rather than when the first notification is sent
+func Load(path string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	config := &Config{Path: path}
+	if err := yaml.UnmarshalStrict(bytes, config); err != nil {
+		return nil, errors.WithStackTrace(InvalidConfig{Path: path, Cause: err})
+	}
+
+	for i, sink := range config.Sinks {
+		if sink.Name == "" {
+			sink.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := sink.validate(); err != nil {
+			return nil, errors.WithStackTrace(InvalidConfig{Path: path, Cause: fmt.Errorf("sink '%s': %s", sink.Name, err)})
+		}
+	}
+
+	return config, nil
+}
+
+func (sink *SinkConfig) validate() error {
+	switch sink.Type {
+	case SINK_WEBHOOK, SINK_SLACK:
+		if !strings.HasPrefix(sink.Url, "https://") && !strings.HasPrefix(sink.Url, "http://") {
+			return fmt.Errorf("url must be an http or https URL but was '%s'", sink.Url)
+		}
+	case SINK_SNS:
+		if !strings.HasPrefix(sink.TopicArn, "arn:") {
+			return fmt.Errorf("topic_arn must be the ARN of an SNS topic but was '%s'", sink.TopicArn)
+		}
+	case SINK_SES, SINK_SMTP:
+		if sink.From == "" || len(sink.To) == 0 {
+			return fmt.Errorf("from and to are required for %s sinks", sink.Type)
+		}
+		if sink.Type == SINK_SMTP && sink.SmtpHost == "" {
+			return fmt.Errorf("smtp_host is required for %s sinks", SINK_SMTP)
+		}
+		if sink.Type == SINK_SMTP && sink.SmtpPort == 0 {
+			sink.SmtpPort = DEFAULT_SMTP_PORT
+		}
+	default:
+		return fmt.Errorf("type must be one of %s but was '%s'", strings.Join(SinkTypes, ", "), sink.Type)
+	}
+
+	if sink.SecretFile != "" && sink.Type != SINK_WEBHOOK {
+		return fmt.Errorf("secret_file only applies to %s sinks", SINK_WEBHOOK)
+	}
+	if (sink.SmtpHost != "" || sink.SmtpPort != 0 || sink.SmtpUsername != "" || sink.SmtpPasswordFile != "") && sink.Type != SINK_SMTP {
+		return fmt.Errorf("the smtp_ settings only apply to %s sinks", SINK_SMTP)
+	}
+	if (sink.From != "" || len(sink.To) > 0) && sink.Type != SINK_SES && sink.Type != SINK_SMTP {
+		return fmt.Errorf("from and to only apply to %s and %s sinks", SINK_SES, SINK_SMTP)
+	}
+
+	if len(sink.Events) == 0 {
+		return fmt.Errorf("events must list at least one of %s, or %s for all of them", strings.Join(Events, ", "), ALL_EVENTS)
+	}
+	for _, event := range sink.Events {
+		if event != ALL_EVENTS && !contains(Events, event) {
+			return fmt.Errorf("unknown event '%s', expected one of %s, or %s for all of them", event, strings.Join(Events, ", "), ALL_EVENTS)
+		}
+	}
+
+	return nil
+}
+
+// Route sends the events a sink subscribes to to that sink
+type Route struct {
+	Name   string
+	Events []string
+	Sink   Sink
+}
+
+func (route Route) Matches(event string) bool {
+	return event == EVENT_TEST || contains(route.Events, ALL_EVENTS) || contains(route.Events, event)
+}
+
+// Notifier sends each notification to the sinks that subscribe to its event
+type Notifier struct {
+	Routes []Route
+}
+
+// Send the notification to every sink that subscribes to its event, even if sending it to some of them fails. Returns
+// the names of the sinks it was sent to, and an error for each sink it couldn't be sent to.
+func (notifier *Notifier) Notify(notification *Notification) ([]string, []error) {
+	sent := []string{}
+	failures := []error{}
+
+	for _, route := range notifier.Routes {
+		if !route.Matches(notification.Event) {
+			continue
+		}
+		if err := route.Sink.Send(notification); err != nil {
+			failures = append(failures, errors.WithStackTrace(SinkFailed{Sink: route.Name, Cause: err}))
+			continue
+		}
+		sent = append(sent, route.Name)
+	}
+
+	return sent, failures
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Custom errors
+
+type InvalidConfig struct {
+	Path  string
+	Cause error
+}
+
+func (err InvalidConfig) Error() string {
+	return fmt.Sprintf("The notifications file %s is invalid: %s", err.Path, err.Cause)
+}
+
+type SinkFailed struct {
+	Sink  string
+	Cause error
+}
+
+func (err SinkFailed) Error() string {
+	return fmt.Sprintf("Failed to notify %s: %s", err.Sink, err.Cause)
+}
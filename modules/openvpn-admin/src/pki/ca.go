@@ -0,0 +1,120 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+)
+
+// CertificateAuthority is the CA certificate along with something that can sign on its behalf
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	Signer      crypto.Signer
+}
+
+// Load the CA certificate and private key from the given PEM files
+func LoadCertificateAuthority(certPath string, keyPath string) (*CertificateAuthority, error) {
+	certificate, err := ReadCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ReadPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCertificateAuthority(certificate, signer)
+}
+
+// Pair a CA certificate with the signer for its private key, which may be a key in a PKCS#11 token or a remote signing
+// service rather than a key on disk. Fails if the signer's key doesn't belong to the certificate.
+func NewCertificateAuthority(certificate *x509.Certificate, signer crypto.Signer) (*CertificateAuthority, error) {
+	publicKey, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(certificate.PublicKey) {
+		return nil, errors.WithStackTrace(CaKeyMismatch(certificate.Subject.String()))
+	}
+
+	return &CertificateAuthority{Certificate: certificate, Signer: signer}, nil
+}
+
+// Read the first certificate in the given PEM file
+func ReadCertificate(path string) (*x509.Certificate, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	for {
+		var block *pem.Block
+		block, bytes = pem.Decode(bytes)
+		if block == nil {
+			return nil, errors.WithStackTrace(NoPemBlockFound{Path: path, Type: "CERTIFICATE"})
+		}
+		if block.Type == "CERTIFICATE" {
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			return certificate, errors.WithStackTrace(err)
+		}
+	}
+}
+
+// Read an unencrypted private key in any of the PEM encodings written by OpenSSL
+func ReadPrivateKey(path string) (crypto.Signer, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	for {
+		var block *pem.Block
+		block, bytes = pem.Decode(bytes)
+		if block == nil {
+			return nil, errors.WithStackTrace(NoPemBlockFound{Path: path, Type: "PRIVATE KEY"})
+		}
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			return key, errors.WithStackTrace(err)
+		case "EC PRIVATE KEY":
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			return key, errors.WithStackTrace(err)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.WithStackTrace(err)
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, errors.WithStackTrace(UnsupportedKeyType(fmt.Sprintf("%T", key)))
+			}
+			return signer, nil
+		}
+	}
+}
+
+// Custom errors
+
+type NoPemBlockFound struct {
+	Path string
+	Type string
+}
+
+func (err NoPemBlockFound) Error() string {
+	return fmt.Sprintf("Could not find a PEM block of type %s in %s", err.Type, err.Path)
+}
+
+type CaKeyMismatch string
+
+func (err CaKeyMismatch) Error() string {
+	return fmt.Sprintf("The CA signing key does not match the public key in the CA certificate %s", string(err))
+}
+
+type UnsupportedKeyType string
+
+func (err UnsupportedKeyType) Error() string {
+	return fmt.Sprintf("Unsupported private key type %s", string(err))
+}
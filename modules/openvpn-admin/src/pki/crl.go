@@ -0,0 +1,190 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Generate a PEM encoded CRL listing every revoked certificate in the given CA database, along with its revocation
+// reason if one was recorded
+func GenerateCrl(ca *CertificateAuthority, index *Index, number *big.Int, now time.Time, validity time.Duration) ([]byte, error) {
+	entries := []x509.RevocationListEntry{}
+	for _, entry := range index.Entries {
+		if entry.Status != STATUS_REVOKED {
+			continue
+		}
+
+		serial, err := entry.SerialNumber()
+		if err != nil {
+			return nil, err
+		}
+
+		reasonCode := 0
+		if entry.RevocationReason != "" {
+			reasonCode, err = ReasonCode(entry.RevocationReason)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: entry.RevocationTime,
+			ReasonCode:     reasonCode,
+		})
+	}
+
+	template := &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now.UTC(),
+		NextUpdate:                now.UTC().Add(validity),
+		RevokedCertificateEntries: entries,
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, signingIssuer(ca.Certificate), ca.Signer)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// easy-rsa 2 creates CA certificates without a keyUsage extension. RFC 5280 treats that as unrestricted, but Go refuses
+// to sign with an issuer that doesn't explicitly have the crlSign bit, so we fill in the implied key usage.
+func signingIssuer(certificate *x509.Certificate) *x509.Certificate {
+	if certificate.KeyUsage != 0 {
+		return certificate
+	}
+
+	issuer := *certificate
+	issuer.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	return &issuer
+}
+
+// CrlScope is a CA along with the part of the CA database that it issued
+type CrlScope struct {
+	CA    *CertificateAuthority
+	Index *Index
+}
+
+// Regenerate the CRL for the PKI in the given layout, bumping the CRL number each time as RFC 5280 requires
+func UpdateCrl(layout Layout, ca *CertificateAuthority, index *Index, validity time.Duration) error {
+	return UpdateCrls(layout, []CrlScope{{CA: ca, Index: index}}, validity)
+}
+
+// Regenerate the CRL file with one CRL per CA, e.g. for both the new and the previous CA during a CA rotation. OpenVPN
+// loads every CRL in the file, and OpenSSL needs a CRL from each CA in the trust bundle to verify its certificates.
+func UpdateCrls(layout Layout, scopes []CrlScope, validity time.Duration) error {
+	number, err := readCrlNumber(layout.CrlNumberPath())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	crls := []byte{}
+	for _, scope := range scopes {
+		crl, err := GenerateCrl(scope.CA, scope.Index, number, now, validity)
+		if err != nil {
+			return err
+		}
+		crls = append(crls, crl...)
+	}
+
+	if err := writeFileAtomically(layout.CrlPath(), crls, 0644); err != nil {
+		return err
+	}
+
+	next := new(big.Int).Add(number, big.NewInt(1))
+	return writeFileAtomically(layout.CrlNumberPath(), []byte(FormatSerial(next)+"\n"), 0644)
+}
+
+// Read and parse the PEM or DER encoded CRL at the given path
+func ReadCrl(path string) (*x509.RevocationList, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	if block, _ := pem.Decode(bytes); block != nil {
+		bytes = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(bytes)
+	return crl, errors.WithStackTrace(err)
+}
+
+// CrlStatus summarizes a published CRL for health checks and monitoring
+type CrlStatus struct {
+	Number       *big.Int
+	ThisUpdate   time.Time
+	NextUpdate   time.Time
+	RevokedCount int
+}
+
+func InspectCrl(path string) (*CrlStatus, error) {
+	crl, err := ReadCrl(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CrlStatus{
+		Number:       crl.Number,
+		ThisUpdate:   crl.ThisUpdate,
+		NextUpdate:   crl.NextUpdate,
+		RevokedCount: len(crl.RevokedCertificateEntries),
+	}, nil
+}
+
+// OpenVPN rejects every client certificate once the CRL's nextUpdate has passed
+func (status *CrlStatus) IsExpired(now time.Time) bool {
+	return !status.NextUpdate.After(now)
+}
+
+// The crlnumber file uses the same hex format as the serial file. If it doesn't exist yet (easy-rsa 2 doesn't create
+// one), we start counting at 1.
+func readCrlNumber(path string) (*big.Int, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return big.NewInt(1), nil
+	}
+	return ReadSerial(path)
+}
+
+// OpenSSL writes serials as upper case hex with an even number of digits
+func FormatSerial(serial *big.Int) string {
+	hex := strings.ToUpper(serial.Text(16))
+	if len(hex)%2 == 1 {
+		hex = "0" + hex
+	}
+	return hex
+}
+
+// Copy the file to <path>.old, as OpenSSL keeps the previous version of its CA database and serial file. The file
+// itself stays where it is until its new version is renamed over it.
+func keepPreviousVersion(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return copyFile(path, path+".old", info.Mode().Perm())
+}
+
+func writeFileAtomically(path string, contents []byte, mode os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, contents, mode); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(os.Rename(tmpPath, path))
+}
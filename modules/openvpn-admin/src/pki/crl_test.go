@@ -0,0 +1,179 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func readTestCrl(t *testing.T, layout Layout, ca *CertificateAuthority) *x509.RevocationList {
+	t.Helper()
+
+	crl, err := ReadCrl(layout.CrlPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Certificate); err != nil {
+		t.Fatalf("CRL is not signed by %s: %v", ca.Certificate.Subject.CommonName, err)
+	}
+	return crl
+}
+
+func TestUpdateCrlListsRevokedCertificates(t *testing.T) {
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Test CA")
+
+	issueTestCertificate(t, layout, ca, "alice")
+	bob := issueTestCertificate(t, layout, ca, "bob")
+	carol := issueTestCertificate(t, layout, ca, "carol")
+	revokeTestCertificate(t, layout, bob, REASON_KEY_COMPROMISE)
+	revokeTestCertificate(t, layout, carol, REASON_CERTIFICATE_HOLD)
+
+	index := readTestIndex(t, layout.IndexPath())
+	if err := UpdateCrl(layout, ca, index, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	crl := readTestCrl(t, layout, ca)
+	if crl.Number.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("expected CRL number 1 but got %s", crl.Number)
+	}
+
+	reasons := map[string]int{}
+	for _, entry := range crl.RevokedCertificateEntries {
+		reasons[FormatSerial(entry.SerialNumber)] = entry.ReasonCode
+	}
+	expected := map[string]int{FormatSerial(bob.SerialNumber): 1, FormatSerial(carol.SerialNumber): 6}
+	if len(reasons) != len(expected) {
+		t.Fatalf("expected %v in the CRL but found %v", expected, reasons)
+	}
+	for serial, reason := range expected {
+		if reasons[serial] != reason {
+			t.Errorf("expected serial %s with reason %d in the CRL but found %v", serial, reason, reasons)
+		}
+	}
+}
+
+func TestUpdateCrlBumpsCrlNumber(t *testing.T) {
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Test CA")
+	index := readTestIndex(t, layout.IndexPath())
+
+	for expected := int64(1); expected <= 2; expected++ {
+		if err := UpdateCrl(layout, ca, index, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if crl := readTestCrl(t, layout, ca); crl.Number.Cmp(big.NewInt(expected)) != 0 {
+			t.Errorf("expected CRL number %d but got %s", expected, crl.Number)
+		}
+	}
+
+	if number := readTestFile(t, layout.CrlNumberPath()); number != "03\n" {
+		t.Errorf("unexpected crlnumber file: %q", number)
+	}
+}
+
+func TestReleasedCertificateLeavesCrl(t *testing.T) {
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Test CA")
+
+	alice := issueTestCertificate(t, layout, ca, "alice")
+	revokeTestCertificate(t, layout, alice, REASON_CERTIFICATE_HOLD)
+
+	index := readTestIndex(t, layout.IndexPath())
+	if err := index.FindBySerial(alice.SerialNumber).Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateCrl(layout, ca, index, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if crl := readTestCrl(t, layout, ca); len(crl.RevokedCertificateEntries) != 0 {
+		t.Errorf("expected an empty CRL but found %d entries", len(crl.RevokedCertificateEntries))
+	}
+}
+
+// easy-rsa 2 CA certificates have no keyUsage extension at all
+func TestGenerateCrlWithEasyRsa2Ca(t *testing.T) {
+	key := newTestKey(t)
+	now := time.Now().UTC()
+	certificate, err := SelfSign(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Legacy CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewCertificateAuthority(certificate, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GenerateCrl(ca, &Index{}, big.NewInt(1), now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInspectCrlReportsExpiry(t *testing.T) {
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Test CA")
+	index := readTestIndex(t, layout.IndexPath())
+
+	if err := UpdateCrl(layout, ca, index, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := InspectCrl(layout.CrlPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RevokedCount != 0 || status.Number.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("unexpected CRL status: %+v", status)
+	}
+	if status.IsExpired(time.Now()) {
+		t.Error("expected a fresh CRL not to be expired")
+	}
+	if !status.IsExpired(time.Now().Add(2 * time.Hour)) {
+		t.Error("expected the CRL to be expired after its nextUpdate")
+	}
+}
+
+func TestUpdateCrlsWritesOneCrlPerCa(t *testing.T) {
+	layout := newTestLayout(t)
+	oldCa := newTestCa(t, "Old CA")
+	newCa := newTestCa(t, "New CA")
+
+	alice := issueTestCertificate(t, layout, oldCa, "alice")
+	revokeTestCertificate(t, layout, alice, REASON_SUPERSEDED)
+	index := readTestIndex(t, layout.IndexPath())
+
+	scopes := []CrlScope{{CA: newCa, Index: &Index{}}, {CA: oldCa, Index: index}}
+	if err := UpdateCrls(layout, scopes, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	rest := []byte(readTestFile(t, layout.CrlPath()))
+	for _, scope := range scopes {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			t.Fatalf("expected a CRL from %s", scope.CA.Certificate.Subject.CommonName)
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := crl.CheckSignatureFrom(scope.CA.Certificate); err != nil {
+			t.Errorf("CRL is not signed by %s: %v", scope.CA.Certificate.Subject.CommonName, err)
+		}
+		if len(crl.RevokedCertificateEntries) != len(scope.Index.Entries) {
+			t.Errorf("expected %d entries in the CRL from %s but found %d", len(scope.Index.Entries), scope.CA.Certificate.Subject.CommonName, len(crl.RevokedCertificateEntries))
+		}
+	}
+}
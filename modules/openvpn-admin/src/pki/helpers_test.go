@@ -0,0 +1,96 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Create an empty CA database and serial file in a temp dir, the way easy-rsa's clean-all does
+func newTestLayout(t *testing.T) Layout {
+	t.Helper()
+
+	layout := Layout{KeyDir: t.TempDir()}
+	if err := ioutil.WriteFile(layout.IndexPath(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(layout.SerialPath(), []byte("01\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
+
+func newTestCa(t *testing.T, commonName string) *CertificateAuthority {
+	t.Helper()
+
+	key := newTestKey(t)
+	now := time.Now().UTC()
+	certificate, err := SelfSign(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := NewCertificateAuthority(certificate, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Issue a client certificate from the given CA and record it in the CA database
+func issueTestCertificate(t *testing.T, layout Layout, ca *CertificateAuthority, commonName string) *x509.Certificate {
+	t.Helper()
+
+	index, err := ReadIndex(layout.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		NotAfter:    time.Now().UTC().Add(24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := Issue(layout, ca, index, template, newTestKey(t).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
@@ -0,0 +1,625 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The kinds of PKI that can be imported
+const SOURCE_EASY_RSA_2 = "easy-rsa 2"
+const SOURCE_EASY_RSA_3 = "easy-rsa 3"
+const SOURCE_OPENSSL = "OpenSSL CA"
+
+// The openssl ca command and easy-rsa name the copy of each certificate they issue after its serial
+var serialFileRegex = regexp.MustCompile(`^([0-9A-Fa-f]+)\.pem$`)
+
+// The certificates in a key dir that belong to the CA rather than being issued by it
+var caCertificateNames = map[string]bool{"ca": true, "ca-previous": true}
+
+// Where easy-rsa 3 PKIs written by pki export keep the tls-crypt-v2 keys, under private. The server key and the client
+// keys are in dirs of their own, so that their names can't clash with each other or with a certificate's key.
+const EASY_RSA_3_TLS_CRYPT_V2_SERVER_KEY = "tls-crypt-v2/server.key"
+const EASY_RSA_3_TLS_CRYPT_V2_CLIENT_KEY_DIR = "tls-crypt-v2/clients"
+
+// ForeignPki is a PKI created by easy-rsa 2, easy-rsa 3 or the openssl ca command, found in a directory on disk. Paths
+// are empty for the optional files the directory doesn't have.
+type ForeignPki struct {
+	Kind          string
+	Dir           string
+	CaCertPath    string
+	CaKeyPath     string
+	IndexPath     string
+	SerialPath    string
+	CrlPath       string
+	CrlNumberPath string
+
+	// The copy the CA kept of each certificate it issued, by file name, e.g. 0A.pem
+	CertificatePaths map[string]string
+
+	// The certificates and keys of servers and users, e.g. server.crt and server.key
+	Named []NamedCertificate
+
+	// The tls-crypt-v2 server key and the client key of each user, by username. A PKI that uses tls-auth or hasn't
+	// been switched over to tls-crypt-v2 has neither.
+	TlsCryptV2ServerKeyPath  string
+	TlsCryptV2ClientKeyPaths map[string]string
+
+	rotationPath string
+}
+
+// NamedCertificate is a certificate kept under the name it was issued for, along with its key, if the CA has it
+type NamedCertificate struct {
+	Name     string
+	CertPath string
+	KeyPath  string
+}
+
+// Find the PKI in the given directory, which may be an easy-rsa 3 dir (or the pki dir in it), an easy-rsa 2 dir (or
+// the keys dir in it), or a CA dir of the openssl ca command, e.g. demoCA
+func FindForeignPki(dir string) (*ForeignPki, error) {
+	switch {
+	case fileExists(filepath.Join(dir, "pki", "ca.crt")):
+		return findEasyRsa3Pki(filepath.Join(dir, "pki"))
+	case fileExists(filepath.Join(dir, "ca.crt")) && fileExists(filepath.Join(dir, "private", "ca.key")):
+		return findEasyRsa3Pki(dir)
+	case fileExists(filepath.Join(dir, "cacert.pem")):
+		return findOpensslPki(dir)
+	case fileExists(filepath.Join(dir, "keys", "ca.crt")):
+		return findEasyRsa2Pki(filepath.Join(dir, "keys"))
+	case fileExists(filepath.Join(dir, "ca.crt")):
+		return findEasyRsa2Pki(dir)
+	}
+	return nil, errors.WithStackTrace(NoPkiFound(dir))
+}
+
+func findEasyRsa3Pki(dir string) (*ForeignPki, error) {
+	foreign := &ForeignPki{
+		Kind:          SOURCE_EASY_RSA_3,
+		Dir:           dir,
+		CaCertPath:    filepath.Join(dir, "ca.crt"),
+		CaKeyPath:     optionalPath(filepath.Join(dir, "private", "ca.key")),
+		IndexPath:     filepath.Join(dir, "index.txt"),
+		SerialPath:    optionalPath(filepath.Join(dir, "serial")),
+		CrlPath:       optionalPath(filepath.Join(dir, "crl.pem")),
+		CrlNumberPath: optionalPath(filepath.Join(dir, "crlnumber")),
+	}
+
+	certificatePaths, err := findSerialFiles(filepath.Join(dir, "certs_by_serial"))
+	if err != nil {
+		return nil, err
+	}
+	foreign.CertificatePaths = certificatePaths
+
+	named, err := findNamedCertificates(filepath.Join(dir, "issued"), filepath.Join(dir, "private"))
+	if err != nil {
+		return nil, err
+	}
+	foreign.Named = named
+
+	foreign.TlsCryptV2ServerKeyPath = optionalPath(filepath.Join(dir, "private", filepath.FromSlash(EASY_RSA_3_TLS_CRYPT_V2_SERVER_KEY)))
+	clientKeyPaths, err := findKeyFiles(filepath.Join(dir, "private", filepath.FromSlash(EASY_RSA_3_TLS_CRYPT_V2_CLIENT_KEY_DIR)))
+	if err != nil {
+		return nil, err
+	}
+	foreign.TlsCryptV2ClientKeyPaths = clientKeyPaths
+
+	return foreign, nil
+}
+
+func findOpensslPki(dir string) (*ForeignPki, error) {
+	foreign := &ForeignPki{
+		Kind:          SOURCE_OPENSSL,
+		Dir:           dir,
+		CaCertPath:    filepath.Join(dir, "cacert.pem"),
+		CaKeyPath:     optionalPath(filepath.Join(dir, "private", "cakey.pem")),
+		IndexPath:     filepath.Join(dir, "index.txt"),
+		SerialPath:    optionalPath(filepath.Join(dir, "serial")),
+		CrlPath:       optionalPath(filepath.Join(dir, "crl.pem")),
+		CrlNumberPath: optionalPath(filepath.Join(dir, "crlnumber")),
+	}
+	if foreign.CrlPath == "" {
+		foreign.CrlPath = optionalPath(filepath.Join(dir, "crl", "crl.pem"))
+	}
+
+	certificatePaths, err := findSerialFiles(filepath.Join(dir, "newcerts"))
+	if err != nil {
+		return nil, err
+	}
+	foreign.CertificatePaths = certificatePaths
+
+	return foreign, nil
+}
+
+// openvpn-admin keeps its PKI in the easy-rsa 2 layout, so this also finds the PKI in the key dir of another server
+func findEasyRsa2Pki(dir string) (*ForeignPki, error) {
+	foreign := &ForeignPki{
+		Kind:          SOURCE_EASY_RSA_2,
+		Dir:           dir,
+		CaCertPath:    filepath.Join(dir, "ca.crt"),
+		CaKeyPath:     optionalPath(filepath.Join(dir, "ca.key")),
+		IndexPath:     filepath.Join(dir, "index.txt"),
+		SerialPath:    optionalPath(filepath.Join(dir, "serial")),
+		CrlPath:       optionalPath(filepath.Join(dir, "crl.pem")),
+		CrlNumberPath: optionalPath(filepath.Join(dir, "crlnumber")),
+		rotationPath:  optionalPath(Layout{KeyDir: dir}.CaRotationPath()),
+	}
+
+	certificatePaths, err := findSerialFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	foreign.CertificatePaths = certificatePaths
+
+	named, err := findNamedCertificates(dir, dir)
+	if err != nil {
+		return nil, err
+	}
+	foreign.Named = named
+
+	layout := Layout{KeyDir: dir}
+	foreign.TlsCryptV2ServerKeyPath = optionalPath(layout.TlsCryptV2ServerKeyPath())
+	clientKeyPaths, err := findKeyFiles(filepath.Dir(layout.TlsCryptV2ClientKeyPath("")))
+	if err != nil {
+		return nil, err
+	}
+	foreign.TlsCryptV2ClientKeyPaths = clientKeyPaths
+
+	return foreign, nil
+}
+
+// The <SERIAL>.pem files in the given dir, by file name. A dir that doesn't exist has none.
+func findSerialFiles(dir string) (map[string]string, error) {
+	paths := map[string]string{}
+
+	names, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if serialFileRegex.MatchString(name) {
+			paths[name] = filepath.Join(dir, name)
+		}
+	}
+	return paths, nil
+}
+
+// The <name>.key files in the given dir, by name. A dir that doesn't exist has none.
+func findKeyFiles(dir string) (map[string]string, error) {
+	paths := map[string]string{}
+
+	names, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range names {
+		if name := strings.TrimSuffix(fileName, ".key"); name != fileName && name != "" {
+			paths[name] = filepath.Join(dir, fileName)
+		}
+	}
+	return paths, nil
+}
+
+// The <name>.crt files in certDir, other than the CA's, along with <name>.key in keyDir
+func findNamedCertificates(certDir string, keyDir string) ([]NamedCertificate, error) {
+	named := []NamedCertificate{}
+
+	names, err := listFiles(certDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range names {
+		name := strings.TrimSuffix(fileName, ".crt")
+		if name == fileName || caCertificateNames[name] {
+			continue
+		}
+		named = append(named, NamedCertificate{
+			Name:     name,
+			CertPath: filepath.Join(certDir, fileName),
+			KeyPath:  optionalPath(filepath.Join(keyDir, name+".key")),
+		})
+	}
+	return named, nil
+}
+
+// Check that the PKI is one openvpn-admin can take over: the CA certificate must be a valid CA whose key is either in
+// the PKI or given as signer, e.g. for a CA key in a PKCS#11 token, the CA database must be readable and every
+// certificate and CRL in the PKI must have been issued by the CA and agree with the CA database, and every tls-crypt-v2
+// client key must have been wrapped with the PKI's server key for a certificate in the CA database. Every problem found
+// is reported at once, so that they can all be fixed before trying again.
+func (foreign *ForeignPki) Verify(signer crypto.Signer, now time.Time) error {
+	problems := []string{}
+	report := func(path string, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", foreign.relPath(path), fmt.Sprintf(format, args...)))
+	}
+
+	if foreign.rotationPath != "" {
+		report(foreign.rotationPath, "a CA rotation is in progress. Retire the previous CA before importing the PKI.")
+	}
+
+	caCertificate, err := ReadCertificate(foreign.CaCertPath)
+	if err != nil {
+		report(foreign.CaCertPath, "%s", errors.Unwrap(err))
+		return errors.WithStackTrace(InconsistentPki{Dir: foreign.Dir, Problems: problems})
+	}
+
+	if !caCertificate.IsCA {
+		report(foreign.CaCertPath, "%s is not a CA certificate", caCertificate.Subject)
+	}
+	if !caCertificate.NotAfter.After(now) {
+		report(foreign.CaCertPath, "the CA certificate expired on %s", caCertificate.NotAfter.Format(time.RFC3339))
+	}
+
+	switch {
+	case signer != nil:
+		if _, err := NewCertificateAuthority(caCertificate, signer); err != nil {
+			report(foreign.CaCertPath, "%s", errors.Unwrap(err))
+		}
+	case foreign.CaKeyPath == "":
+		report(foreign.CaCertPath, "the CA key is missing")
+	case isEncryptedKey(foreign.CaKeyPath):
+		report(foreign.CaKeyPath, "the CA key is encrypted. Decrypt it with 'openssl pkey' first.")
+	default:
+		if _, err := LoadCertificateAuthority(foreign.CaCertPath, foreign.CaKeyPath); err != nil {
+			report(foreign.CaKeyPath, "%s", errors.Unwrap(err))
+		}
+	}
+
+	index, err := ReadIndex(foreign.IndexPath)
+	if err != nil {
+		report(foreign.IndexPath, "%s", errors.Unwrap(err))
+		return errors.WithStackTrace(InconsistentPki{Dir: foreign.Dir, Problems: problems})
+	}
+
+	entries := map[string]*IndexEntry{}
+	for _, entry := range index.Entries {
+		serial, err := entry.SerialNumber()
+		if err != nil {
+			report(foreign.IndexPath, "%s", errors.Unwrap(err))
+			continue
+		}
+		if _, ok := entries[FormatSerial(serial)]; ok {
+			report(foreign.IndexPath, "serial %s is listed more than once", FormatSerial(serial))
+			continue
+		}
+		entries[FormatSerial(serial)] = entry
+	}
+
+	if foreign.SerialPath != "" {
+		if _, err := ReadSerial(foreign.SerialPath); err != nil {
+			report(foreign.SerialPath, "%s", errors.Unwrap(err))
+		}
+	}
+
+	checkIssued := func(path string) *x509.Certificate {
+		certificate, err := ReadCertificate(path)
+		if err != nil {
+			report(path, "%s", errors.Unwrap(err))
+			return nil
+		}
+		if err := certificate.CheckSignatureFrom(caCertificate); err != nil {
+			report(path, "%s was not issued by the CA %s", certificate.Subject, caCertificate.Subject)
+			return nil
+		}
+		entry, ok := entries[FormatSerial(certificate.SerialNumber)]
+		if !ok {
+			report(path, "serial %s is not in the CA database", FormatSerial(certificate.SerialNumber))
+			return nil
+		}
+		if entry.CommonName() != certificate.Subject.CommonName {
+			report(path, "the CA database lists serial %s for %s, not %s", entry.Serial, entry.CommonName(), certificate.Subject.CommonName)
+		}
+		return certificate
+	}
+
+	for _, name := range sortedKeys(foreign.CertificatePaths) {
+		path := foreign.CertificatePaths[name]
+		certificate := checkIssued(path)
+		if certificate != nil && FormatSerial(certificate.SerialNumber) != strings.ToUpper(strings.TrimSuffix(name, ".pem")) {
+			report(path, "holds the certificate with serial %s", FormatSerial(certificate.SerialNumber))
+		}
+	}
+
+	for _, named := range foreign.Named {
+		certificate := checkIssued(named.CertPath)
+		if certificate == nil || named.KeyPath == "" {
+			continue
+		}
+		if isEncryptedKey(named.KeyPath) {
+			report(named.KeyPath, "the key is encrypted. Decrypt it with 'openssl pkey' first.")
+			continue
+		}
+		key, err := ReadPrivateKey(named.KeyPath)
+		if err != nil {
+			report(named.KeyPath, "%s", errors.Unwrap(err))
+			continue
+		}
+		if publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !publicKey.Equal(certificate.PublicKey) {
+			report(named.KeyPath, "the key does not belong to %s", foreign.relPath(named.CertPath))
+		}
+	}
+
+	if foreign.CrlPath != "" {
+		crl, err := ReadCrl(foreign.CrlPath)
+		switch {
+		case err != nil:
+			report(foreign.CrlPath, "%s", errors.Unwrap(err))
+		case crl.CheckSignatureFrom(caCertificate) != nil:
+			report(foreign.CrlPath, "the CRL was not issued by the CA %s", caCertificate.Subject)
+		default:
+			// A CRL older than the CA database may leave out later revocations, but one that revokes a certificate
+			// the CA database says is valid means the two have drifted apart
+			for _, revoked := range crl.RevokedCertificateEntries {
+				if entry, ok := entries[FormatSerial(revoked.SerialNumber)]; ok && entry.Status == STATUS_VALID {
+					report(foreign.CrlPath, "serial %s is revoked, but the CA database lists it as valid", entry.Serial)
+				}
+			}
+		}
+	}
+
+	if foreign.CrlNumberPath != "" {
+		if _, err := ReadSerial(foreign.CrlNumberPath); err != nil {
+			report(foreign.CrlNumberPath, "%s", errors.Unwrap(err))
+		}
+	}
+
+	// A PKI being switched over to tls-crypt-v2 may have a server key and client keys for only some users, as the rest
+	// get theirs when their profile is next built. Client keys without the server key that wrapped them can't be used.
+	var serverKey *TlsCryptV2ServerKey
+	if foreign.TlsCryptV2ServerKeyPath != "" {
+		serverKey, err = ReadTlsCryptV2ServerKey(foreign.TlsCryptV2ServerKeyPath)
+		if err != nil {
+			report(foreign.TlsCryptV2ServerKeyPath, "%s", errors.Unwrap(err))
+		}
+	}
+	for _, name := range sortedKeys(foreign.TlsCryptV2ClientKeyPaths) {
+		path := foreign.TlsCryptV2ClientKeyPaths[name]
+		if foreign.TlsCryptV2ServerKeyPath == "" {
+			report(path, "the tls-crypt-v2 server key the client key was wrapped with is missing")
+			continue
+		}
+		if serverKey == nil {
+			continue
+		}
+
+		metadata, err := serverKey.ReadClientKeyMetadata(path)
+		if err != nil {
+			report(path, "%s", errors.Unwrap(err))
+			continue
+		}
+		serial, err := ParseTlsCryptV2Metadata(metadata)
+		if err != nil {
+			report(path, "%s", errors.Unwrap(err))
+			continue
+		}
+		entry, ok := entries[FormatSerial(serial)]
+		switch {
+		case !ok:
+			report(path, "the client key is for serial %s, which is not in the CA database", FormatSerial(serial))
+		case entry.CommonName() != name:
+			report(path, "the client key is for serial %s, which the CA database lists for %s", FormatSerial(serial), entry.CommonName())
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.WithStackTrace(InconsistentPki{Dir: foreign.Dir, Problems: problems})
+	}
+	return nil
+}
+
+// Write the PKI into the given layout. Call Verify first. The certificates and keys are written first and ca.crt last,
+// so that a PKI with a ca.crt is always whole. The serial file continues after the highest serial in the CA database,
+// as easy-rsa 3 picks serials at random, and the CRL number after the number of the existing CRL, so that clients
+// never see a CRL number go backwards. The CA isn't trusted to have left a current CRL, so publish a new one after.
+func (foreign *ForeignPki) Write(layout Layout) error {
+	for _, name := range sortedKeys(foreign.CertificatePaths) {
+		target := filepath.Join(layout.KeyDir, strings.ToUpper(strings.TrimSuffix(name, ".pem"))+".pem")
+		if err := copyFile(foreign.CertificatePaths[name], target, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, named := range foreign.Named {
+		if err := copyFile(named.CertPath, layout.CertPath(named.Name), 0644); err != nil {
+			return err
+		}
+		if named.KeyPath != "" {
+			if err := copyFile(named.KeyPath, layout.KeyPath(named.Name), 0600); err != nil {
+				return err
+			}
+		}
+	}
+
+	if foreign.TlsCryptV2ServerKeyPath != "" {
+		if err := copyFile(foreign.TlsCryptV2ServerKeyPath, layout.TlsCryptV2ServerKeyPath(), 0600); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(foreign.TlsCryptV2ClientKeyPaths) {
+		target := layout.TlsCryptV2ClientKeyPath(name)
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return errors.WithStackTrace(err)
+		}
+		if err := copyFile(foreign.TlsCryptV2ClientKeyPaths[name], target, 0600); err != nil {
+			return err
+		}
+	}
+
+	index, err := ReadIndex(foreign.IndexPath)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(foreign.IndexPath, layout.IndexPath(), 0644); err != nil {
+		return err
+	}
+
+	serial, err := foreign.nextSerial(index)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(layout.SerialPath(), []byte(FormatSerial(serial)+"\n"), 0644); err != nil {
+		return err
+	}
+
+	crlNumber, err := foreign.nextCrlNumber()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(layout.CrlNumberPath(), []byte(FormatSerial(crlNumber)+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if foreign.CaKeyPath != "" {
+		if err := copyFile(foreign.CaKeyPath, layout.CaKeyPath(), 0600); err != nil {
+			return err
+		}
+	}
+	return copyFile(foreign.CaCertPath, layout.CaCertPath(), 0644)
+}
+
+func (foreign *ForeignPki) nextSerial(index *Index) (*big.Int, error) {
+	serial := big.NewInt(1)
+	if foreign.SerialPath != "" {
+		current, err := ReadSerial(foreign.SerialPath)
+		if err != nil {
+			return nil, err
+		}
+		serial = current
+	}
+
+	for _, entry := range index.Entries {
+		entrySerial, err := entry.SerialNumber()
+		if err != nil {
+			return nil, err
+		}
+		if entrySerial.Cmp(serial) >= 0 {
+			serial = new(big.Int).Add(entrySerial, big.NewInt(1))
+		}
+	}
+	return serial, nil
+}
+
+func (foreign *ForeignPki) nextCrlNumber() (*big.Int, error) {
+	number := big.NewInt(1)
+	if foreign.CrlNumberPath != "" {
+		current, err := ReadSerial(foreign.CrlNumberPath)
+		if err != nil {
+			return nil, err
+		}
+		number = current
+	}
+
+	if foreign.CrlPath != "" {
+		crl, err := ReadCrl(foreign.CrlPath)
+		if err != nil {
+			return nil, err
+		}
+		if crl.Number != nil && crl.Number.Cmp(number) >= 0 {
+			number = new(big.Int).Add(crl.Number, big.NewInt(1))
+		}
+	}
+	return number, nil
+}
+
+func (foreign *ForeignPki) relPath(path string) string {
+	relPath, err := filepath.Rel(foreign.Dir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// OpenSSL marks encrypted keys either with a PEM block type of their own (PKCS#8) or with a Proc-Type header
+// (traditional)
+func isEncryptedKey(path string) bool {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	for {
+		var block *pem.Block
+		block, bytes = pem.Decode(bytes)
+		if block == nil {
+			return false
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" || strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+			return true
+		}
+	}
+}
+
+func copyFile(source string, target string, mode os.FileMode) error {
+	contents, err := ioutil.ReadFile(source)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return writeFileAtomically(target, contents, mode)
+}
+
+// The names of the regular files in the given dir. A dir that doesn't exist has none.
+func listFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	names := []string{}
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+func optionalPath(path string) string {
+	if !fileExists(path) {
+		return ""
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func sortedKeys(paths map[string]string) []string {
+	keys := []string{}
+	for key := range paths {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Custom errors
+
+type NoPkiFound string
+
+func (dir NoPkiFound) Error() string {
+	return fmt.Sprintf("Could not find an easy-rsa 2, easy-rsa 3 or OpenSSL CA in %s", string(dir))
+}
+
+type InconsistentPki struct {
+	Dir      string
+	Problems []string
+}
+
+func (err InconsistentPki) Error() string {
+	return fmt.Sprintf("The PKI in %s can't be imported until these problems are fixed:\n  %s", err.Dir, strings.Join(err.Problems, "\n  "))
+}
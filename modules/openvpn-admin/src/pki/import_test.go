@@ -0,0 +1,192 @@
+package pki
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A PKI in the layout openvpn-admin keeps it in, with a client certificate for alice and bob, and a tls-crypt-v2 server
+// key and a client key for alice only, like a PKI part way through being switched over to tls-crypt-v2
+func newTestForeignPki(t *testing.T) Layout {
+	t.Helper()
+
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Acme CA")
+	if err := WriteCertificateAndKey(layout.CaCertPath(), layout.CaKeyPath(), ca.Certificate, ca.Signer); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := issueTestCertificate(t, layout, ca, "alice")
+	if err := WriteCertificate(layout.CertPath("alice"), alice); err != nil {
+		t.Fatal(err)
+	}
+	bob := issueTestCertificate(t, layout, ca, "bob")
+	if err := WriteCertificate(layout.CertPath("bob"), bob); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateTlsCryptV2ServerKey(layout.TlsCryptV2ServerKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+	writeTestClientKey(t, layout.TlsCryptV2ServerKeyPath(), layout.TlsCryptV2ClientKeyPath("alice"), alice.SerialNumber.Int64())
+	return layout
+}
+
+func writeTestClientKey(t *testing.T, serverKeyPath string, path string, serial int64) {
+	t.Helper()
+
+	serverKey, err := ReadTlsCryptV2ServerKey(serverKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := serverKey.GenerateClientKey(TlsCryptV2Metadata(big.NewInt(serial)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, clientKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func verifyTestForeignPki(t *testing.T, dir string) error {
+	t.Helper()
+
+	foreign, err := FindForeignPki(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return foreign.Verify(nil, time.Now())
+}
+
+func assertInconsistent(t *testing.T, err error, problem string) {
+	t.Helper()
+
+	inconsistent, ok := errors.Unwrap(err).(InconsistentPki)
+	if !ok {
+		t.Fatalf("expected InconsistentPki, got %v", err)
+	}
+	for _, reported := range inconsistent.Problems {
+		if strings.Contains(reported, problem) {
+			return
+		}
+	}
+	t.Errorf("expected a problem with %q, got %v", problem, inconsistent.Problems)
+}
+
+func TestVerifyAcceptsPkiSwitchingOverToTlsCryptV2(t *testing.T) {
+	layout := newTestForeignPki(t)
+
+	foreign, err := FindForeignPki(layout.KeyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foreign.Kind != SOURCE_EASY_RSA_2 || len(foreign.Named) != 2 || len(foreign.CertificatePaths) != 2 {
+		t.Errorf("unexpected PKI %+v", foreign)
+	}
+	if foreign.TlsCryptV2ServerKeyPath == "" || len(foreign.TlsCryptV2ClientKeyPaths) != 1 {
+		t.Errorf("expected the tls-crypt-v2 server key and alice's client key, got %+v", foreign)
+	}
+
+	if err := foreign.Verify(nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyRefusesClientKeysWithoutServerKey(t *testing.T) {
+	layout := newTestForeignPki(t)
+	if err := os.Remove(layout.TlsCryptV2ServerKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	assertInconsistent(t, verifyTestForeignPki(t, layout.KeyDir), "tls-crypt-v2/alice.key: the tls-crypt-v2 server key the client key was wrapped with is missing")
+}
+
+func TestVerifyRefusesClientKeysOfAnotherServerKey(t *testing.T) {
+	layout := newTestForeignPki(t)
+
+	otherServerKeyPath := filepath.Join(t.TempDir(), "other-server.key")
+	if err := GenerateTlsCryptV2ServerKey(otherServerKeyPath); err != nil {
+		t.Fatal(err)
+	}
+	writeTestClientKey(t, otherServerKeyPath, layout.TlsCryptV2ClientKeyPath("bob"), 2)
+
+	assertInconsistent(t, verifyTestForeignPki(t, layout.KeyDir), "tls-crypt-v2/bob.key: The tls-crypt-v2 client key")
+}
+
+func TestVerifyRefusesClientKeysForAnotherUser(t *testing.T) {
+	layout := newTestForeignPki(t)
+
+	// bob's key carries alice's serial
+	writeTestClientKey(t, layout.TlsCryptV2ServerKeyPath(), layout.TlsCryptV2ClientKeyPath("bob"), 1)
+	// and carol has no certificate at all
+	writeTestClientKey(t, layout.TlsCryptV2ServerKeyPath(), layout.TlsCryptV2ClientKeyPath("carol"), 9)
+
+	err := verifyTestForeignPki(t, layout.KeyDir)
+	assertInconsistent(t, err, "tls-crypt-v2/bob.key: the client key is for serial 01, which the CA database lists for alice")
+	assertInconsistent(t, err, "tls-crypt-v2/carol.key: the client key is for serial 09, which is not in the CA database")
+}
+
+func TestVerifyRefusesCertificatesTheCaDatabaseDoesntList(t *testing.T) {
+	layout := newTestForeignPki(t)
+
+	index, err := ReadIndex(layout.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Entries = index.Entries[:1]
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifyTestForeignPki(t, layout.KeyDir)
+	assertInconsistent(t, err, "02.pem: serial 02 is not in the CA database")
+	assertInconsistent(t, err, "bob.crt: serial 02 is not in the CA database")
+}
+
+func TestWriteCopiesTheTlsCryptV2Keys(t *testing.T) {
+	source := newTestForeignPki(t)
+	foreign, err := FindForeignPki(source.KeyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := Layout{KeyDir: t.TempDir()}
+	if err := foreign.Write(target); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{target.CaCertPath(), target.CaKeyPath(), target.IssuedCertPath("02"), target.CertPath("alice"), target.TlsCryptV2ServerKeyPath(), target.TlsCryptV2ClientKeyPath("alice")} {
+		if !fileExists(path) {
+			t.Errorf("expected %s to be written", path)
+		}
+	}
+
+	serial, err := ReadSerial(target.SerialPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FormatSerial(serial) != "03" {
+		t.Errorf("expected the serial to continue at 03, got %s", FormatSerial(serial))
+	}
+
+	serverKey, err := ReadTlsCryptV2ServerKey(target.TlsCryptV2ServerKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := serverKey.ReadClientKeyMetadata(target.TlsCryptV2ClientKeyPath("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(metadata) != "01" {
+		t.Errorf("expected alice's client key to carry serial 01, got %q", metadata)
+	}
+}
@@ -0,0 +1,268 @@
+package pki
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The status flags used in the first column of an OpenSSL CA database (index.txt)
+const STATUS_VALID = "V"
+const STATUS_REVOKED = "R"
+const STATUS_EXPIRED = "E"
+
+// OpenSSL writes dates before 2050 as UTCTime and later dates as GeneralizedTime
+const utcTimeFormat = "060102150405Z"
+const generalizedTimeFormat = "20060102150405Z"
+
+var commonNameRegex = regexp.MustCompile(`/CN=([^/]+)`)
+var organizationalUnitRegex = regexp.MustCompile(`/OU=([^/]+)`)
+
+// IndexEntry is a single line in an OpenSSL CA database. See the "ca" section of the OpenSSL docs for the format.
+type IndexEntry struct {
+	Status           string
+	ExpirationTime   time.Time
+	RevocationTime   time.Time
+	RevocationReason string
+	HoldInstruction  string
+	Serial           string
+	Filename         string
+	Subject          string
+}
+
+// Index is an OpenSSL CA database, as written by easy-rsa and the openssl ca command
+type Index struct {
+	Path    string
+	Entries []*IndexEntry
+}
+
+// Read the OpenSSL CA database at the given path
+func ReadIndex(path string) (*Index, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	index := &Index{Path: path}
+	for lineNumber, line := range strings.Split(string(bytes), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		entry, err := parseIndexEntry(line)
+		if err != nil {
+			return nil, errors.WithStackTrace(MalformedIndexEntry{Path: path, LineNumber: lineNumber + 1, Cause: err})
+		}
+		index.Entries = append(index.Entries, entry)
+	}
+
+	return index, nil
+}
+
+// Write the database back to disk. Like the openssl ca command, the previous version of the database is kept in
+// index.txt.old. That's a copy rather than the file itself, so that index.txt is always there for the OCSP responder
+// and the hooks, and the new version replaces it in a single rename.
+func (index *Index) Write() error {
+	var builder strings.Builder
+	for _, entry := range index.Entries {
+		builder.WriteString(entry.String())
+		builder.WriteString("\n")
+	}
+
+	newPath := index.Path + ".new"
+	if err := ioutil.WriteFile(newPath, []byte(builder.String()), 0644); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	if err := keepPreviousVersion(index.Path); err != nil {
+		return err
+	}
+
+	return errors.WithStackTrace(os.Rename(newPath, index.Path))
+}
+
+// Return all entries with the given common name and status. Pass an empty status to match entries with any status.
+func (index *Index) FindByCommonName(commonName string, status string) []*IndexEntry {
+	matches := []*IndexEntry{}
+	for _, entry := range index.Entries {
+		if entry.CommonName() == commonName && (status == "" || entry.Status == status) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// Return the entry with the given serial number, or nil if there is no such entry
+func (index *Index) FindBySerial(serial *big.Int) *IndexEntry {
+	for _, entry := range index.Entries {
+		entrySerial, err := entry.SerialNumber()
+		if err == nil && entrySerial.Cmp(serial) == 0 {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (entry *IndexEntry) CommonName() string {
+	matches := commonNameRegex.FindStringSubmatch(entry.Subject)
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
+
+// The values of each OU attribute in the subject, in order
+func (entry *IndexEntry) OrganizationalUnits() []string {
+	units := []string{}
+	for _, matches := range organizationalUnitRegex.FindAllStringSubmatch(entry.Subject, -1) {
+		units = append(units, matches[1])
+	}
+	return units
+}
+
+func (entry *IndexEntry) SerialNumber() (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(entry.Serial, 16)
+	if !ok {
+		return nil, errors.WithStackTrace(InvalidSerialNumber(entry.Serial))
+	}
+	return serial, nil
+}
+
+// Mark the entry as revoked at the given time for the given reason. An empty reason records no reason at all, which
+// matches the behavior of easy-rsa's revoke-full script.
+func (entry *IndexEntry) Revoke(revocationTime time.Time, reason string) error {
+	if reason != "" {
+		if _, err := ReasonCode(reason); err != nil {
+			return err
+		}
+	}
+
+	entry.Status = STATUS_REVOKED
+	entry.RevocationTime = revocationTime.UTC()
+	entry.RevocationReason = reason
+	entry.HoldInstruction = ""
+	if reason == REASON_CERTIFICATE_HOLD {
+		entry.HoldInstruction = HOLD_INSTRUCTION_REJECT
+	}
+
+	return nil
+}
+
+// Return a revoked entry that is on hold to the valid state
+func (entry *IndexEntry) Release() error {
+	if entry.Status != STATUS_REVOKED || entry.RevocationReason != REASON_CERTIFICATE_HOLD {
+		return errors.WithStackTrace(CertificateNotOnHold(entry.Serial))
+	}
+
+	entry.Status = STATUS_VALID
+	entry.RevocationTime = time.Time{}
+	entry.RevocationReason = ""
+	entry.HoldInstruction = ""
+
+	return nil
+}
+
+func (entry *IndexEntry) String() string {
+	revocation := ""
+	if entry.Status == STATUS_REVOKED {
+		revocation = formatIndexTime(entry.RevocationTime)
+		if entry.RevocationReason != "" {
+			revocation = revocation + "," + entry.RevocationReason
+		}
+		if entry.HoldInstruction != "" {
+			revocation = revocation + "," + entry.HoldInstruction
+		}
+	}
+
+	return strings.Join([]string{
+		entry.Status,
+		formatIndexTime(entry.ExpirationTime),
+		revocation,
+		entry.Serial,
+		entry.Filename,
+		entry.Subject,
+	}, "\t")
+}
+
+func parseIndexEntry(line string) (*IndexEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("expected 6 tab-separated fields but found %d", len(fields))
+	}
+
+	expirationTime, err := parseIndexTime(fields[1])
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &IndexEntry{
+		Status:         fields[0],
+		ExpirationTime: expirationTime,
+		Serial:         fields[3],
+		Filename:       fields[4],
+		Subject:        fields[5],
+	}
+
+	switch entry.Status {
+	case STATUS_VALID, STATUS_EXPIRED:
+	case STATUS_REVOKED:
+		revocationFields := strings.Split(fields[2], ",")
+		entry.RevocationTime, err = parseIndexTime(revocationFields[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(revocationFields) > 1 {
+			entry.RevocationReason = revocationFields[1]
+		}
+		if len(revocationFields) > 2 {
+			entry.HoldInstruction = revocationFields[2]
+		}
+	default:
+		return nil, fmt.Errorf("unknown status '%s'", entry.Status)
+	}
+
+	return entry, nil
+}
+
+func parseIndexTime(value string) (time.Time, error) {
+	if len(value) == len(generalizedTimeFormat) {
+		return time.Parse(generalizedTimeFormat, value)
+	}
+	return time.Parse(utcTimeFormat, value)
+}
+
+func formatIndexTime(value time.Time) string {
+	if value.UTC().Year() >= 2050 {
+		return value.UTC().Format(generalizedTimeFormat)
+	}
+	return value.UTC().Format(utcTimeFormat)
+}
+
+// Custom errors
+
+type MalformedIndexEntry struct {
+	Path       string
+	LineNumber int
+	Cause      error
+}
+
+func (err MalformedIndexEntry) Error() string {
+	return fmt.Sprintf("Malformed entry on line %d of %s: %s", err.LineNumber, err.Path, err.Cause.Error())
+}
+
+type InvalidSerialNumber string
+
+func (err InvalidSerialNumber) Error() string {
+	return fmt.Sprintf("'%s' is not a valid hex serial number", string(err))
+}
+
+type CertificateNotOnHold string
+
+func (err CertificateNotOnHold) Error() string {
+	return fmt.Sprintf("Certificate with serial %s is not on hold", string(err))
+}
@@ -0,0 +1,196 @@
+package pki
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// An easy-rsa CA database with a valid, a revoked, an on-hold and an expired certificate. The last line expires after
+// 2049, so OpenSSL writes it as GeneralizedTime.
+const TEST_INDEX = "V\t301231235959Z\t\t01\tunknown\t/CN=alice/OU=engineering\n" +
+	"R\t301231235959Z\t240102030405Z,keyCompromise\t02\tunknown\t/CN=bob\n" +
+	"R\t301231235959Z\t240102030405Z,certificateHold,holdInstructionReject\t03\tunknown\t/CN=carol\n" +
+	"E\t20510101000000Z\t\t0A\tunknown\t/CN=dave\n"
+
+func writeTestIndex(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "index.txt")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readTestIndex(t *testing.T, path string) *Index {
+	t.Helper()
+
+	index, err := ReadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func TestIndexRoundTripsEasyRsaDatabase(t *testing.T) {
+	path := writeTestIndex(t, TEST_INDEX)
+	index := readTestIndex(t, path)
+
+	if len(index.Entries) != 4 {
+		t.Fatalf("expected 4 entries but found %d", len(index.Entries))
+	}
+
+	bob := index.Entries[1]
+	if bob.CommonName() != "bob" || bob.RevocationReason != REASON_KEY_COMPROMISE {
+		t.Errorf("unexpected entry for bob: %+v", bob)
+	}
+	if !bob.RevocationTime.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected revocation time for bob: %s", bob.RevocationTime)
+	}
+
+	carol := index.Entries[2]
+	if carol.RevocationReason != REASON_CERTIFICATE_HOLD || carol.HoldInstruction != HOLD_INSTRUCTION_REJECT {
+		t.Errorf("unexpected entry for carol: %+v", carol)
+	}
+
+	dave := index.Entries[3]
+	if dave.ExpirationTime.Year() != 2051 {
+		t.Errorf("unexpected expiration time for dave: %s", dave.ExpirationTime)
+	}
+
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if written := readTestFile(t, path); written != TEST_INDEX {
+		t.Errorf("database changed on a round trip:\n%s", written)
+	}
+	if old := readTestFile(t, path+".old"); old != TEST_INDEX {
+		t.Errorf("expected the previous database in index.txt.old but found:\n%s", old)
+	}
+	if _, err := os.Stat(path + ".new"); !os.IsNotExist(err) {
+		t.Errorf("expected index.txt.new to be renamed into place but got %v", err)
+	}
+}
+
+func TestIndexWriteKeepsCopyOfPreviousVersion(t *testing.T) {
+	path := writeTestIndex(t, TEST_INDEX)
+	index := readTestIndex(t, path)
+
+	if err := index.Entries[0].Revoke(time.Now(), REASON_SUPERSEDED); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+	revoked := readTestFile(t, path)
+	if revoked == TEST_INDEX || readTestFile(t, path+".old") != TEST_INDEX {
+		t.Fatalf("expected the revocation in index.txt and the original database in index.txt.old")
+	}
+
+	// index.txt.old is a copy, so writing the database again doesn't change it along with index.txt
+	current, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.Stat(path + ".old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(current, old) {
+		t.Fatal("index.txt.old is the same file as index.txt")
+	}
+
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if readTestFile(t, path+".old") != revoked {
+		t.Error("expected index.txt.old to hold the previous version of the database")
+	}
+}
+
+func TestIndexFindsEntries(t *testing.T) {
+	index := readTestIndex(t, writeTestIndex(t, TEST_INDEX))
+
+	if matches := index.FindByCommonName("bob", ""); len(matches) != 1 || matches[0].Serial != "02" {
+		t.Errorf("unexpected matches for bob: %v", matches)
+	}
+	if matches := index.FindByCommonName("bob", STATUS_VALID); len(matches) != 0 {
+		t.Errorf("expected no valid certificate for bob but found %v", matches)
+	}
+	if entry := index.FindBySerial(big.NewInt(10)); entry == nil || entry.CommonName() != "dave" {
+		t.Errorf("unexpected entry for serial 0A: %v", entry)
+	}
+	if entry := index.FindBySerial(big.NewInt(11)); entry != nil {
+		t.Errorf("expected no entry for serial 0B but found %v", entry)
+	}
+}
+
+func TestIndexRevokeAndReleaseSurviveRoundTrip(t *testing.T) {
+	path := writeTestIndex(t, TEST_INDEX)
+	index := readTestIndex(t, path)
+
+	revocationTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := index.Entries[0].Revoke(revocationTime, REASON_CERTIFICATE_HOLD); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	index = readTestIndex(t, path)
+	alice := index.Entries[0]
+	if alice.Status != STATUS_REVOKED || !alice.RevocationTime.Equal(revocationTime) || alice.HoldInstruction != HOLD_INSTRUCTION_REJECT {
+		t.Fatalf("revocation was not written: %+v", alice)
+	}
+
+	if err := alice.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if written := readTestFile(t, path); written != TEST_INDEX {
+		t.Errorf("expected the released database to match the original but found:\n%s", written)
+	}
+}
+
+func TestIndexRefusesToReleaseCertificateNotOnHold(t *testing.T) {
+	index := readTestIndex(t, writeTestIndex(t, TEST_INDEX))
+
+	for _, entry := range index.Entries[:2] {
+		err := entry.Release()
+		if _, ok := errors.Unwrap(err).(CertificateNotOnHold); !ok {
+			t.Errorf("expected CertificateNotOnHold for %s but got %v", entry.CommonName(), err)
+		}
+	}
+}
+
+func TestIndexRefusesUnknownRevocationReason(t *testing.T) {
+	index := readTestIndex(t, writeTestIndex(t, TEST_INDEX))
+
+	err := index.Entries[0].Revoke(time.Now(), "lostIt")
+	if _, ok := errors.Unwrap(err).(UnknownRevocationReason); !ok {
+		t.Fatalf("expected UnknownRevocationReason but got %v", err)
+	}
+	if index.Entries[0].Status != STATUS_VALID {
+		t.Errorf("entry was changed by a failed revocation: %+v", index.Entries[0])
+	}
+}
+
+func TestReadIndexReportsMalformedLine(t *testing.T) {
+	path := writeTestIndex(t, TEST_INDEX+"X\t301231235959Z\t\t0B\tunknown\t/CN=erin\n")
+
+	_, err := ReadIndex(path)
+	malformed, ok := errors.Unwrap(err).(MalformedIndexEntry)
+	if !ok {
+		t.Fatalf("expected MalformedIndexEntry but got %v", err)
+	}
+	if malformed.LineNumber != 5 {
+		t.Errorf("expected the error on line 5 but got line %d", malformed.LineNumber)
+	}
+}
@@ -0,0 +1,160 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// The short names OpenSSL uses when it writes a subject in the CA database
+var attributeShortNames = map[string]string{
+	"2.5.4.6":              "C",
+	"2.5.4.8":              "ST",
+	"2.5.4.7":              "L",
+	"2.5.4.10":             "O",
+	"2.5.4.11":             "OU",
+	"2.5.4.3":              "CN",
+	"2.5.4.41":             "name",
+	"1.2.840.113549.1.9.1": "emailAddress",
+}
+
+// Sign a certificate for the given public key and record it in the CA database, the same way the openssl ca command
+// does: the serial is taken from (and bumped in) the serial file, a copy of the certificate is kept as <SERIAL>.pem in
+// the key dir and a valid entry is added to index.txt. The caller is responsible for writing the updated index.
+func Issue(layout Layout, ca *CertificateAuthority, index *Index, template *x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	serial, err := ReadSerial(layout.SerialPath())
+	if err != nil {
+		return nil, err
+	}
+
+	template.SerialNumber = serial
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().UTC()
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signingIssuer(ca.Certificate), publicKey, ca.Signer)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	if err := writeSerial(layout.SerialPath(), new(big.Int).Add(serial, big.NewInt(1))); err != nil {
+		return nil, err
+	}
+
+	serialHex := FormatSerial(serial)
+	if err := ioutil.WriteFile(layout.IssuedCertPath(serialHex), EncodeCertificate(certificate), 0644); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	index.Entries = append(index.Entries, &IndexEntry{
+		Status:         STATUS_VALID,
+		ExpirationTime: certificate.NotAfter,
+		Serial:         serialHex,
+		Filename:       "unknown",
+		Subject:        FormatSubject(certificate.Subject),
+	})
+
+	return certificate, nil
+}
+
+// Self-sign a CA certificate with the given key. CA certificates aren't recorded in the CA database, so they get a random
+// serial rather than one from the serial file.
+func SelfSign(template *x509.Certificate, key crypto.Signer) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	template.SerialNumber = serial
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	return certificate, errors.WithStackTrace(err)
+}
+
+func EncodeCertificate(certificate *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+}
+
+// Encode a private key as PKCS #8, which OpenSSL and OpenVPN can read for every key type we support
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Write a certificate and its private key to the given paths, making sure the key is only readable by its owner
+func WriteCertificateAndKey(certPath string, keyPath string, certificate *x509.Certificate, key crypto.Signer) error {
+	keyPem, err := EncodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomically(keyPath, keyPem, 0600); err != nil {
+		return err
+	}
+
+	return WriteCertificate(certPath, certificate)
+}
+
+// Write one or more certificates to a single PEM file, e.g. a bundle of CA certificates
+func WriteCertificate(path string, certificates ...*x509.Certificate) error {
+	contents := []byte{}
+	for _, certificate := range certificates {
+		contents = append(contents, EncodeCertificate(certificate)...)
+	}
+	return writeFileAtomically(path, contents, 0644)
+}
+
+// Format a distinguished name the way OpenSSL does in the CA database, e.g. /C=US/ST=CA/CN=jane/emailAddress=x@y.com
+func FormatSubject(name pkix.Name) string {
+	var builder strings.Builder
+	for _, attribute := range name.Names {
+		key := attribute.Type.String()
+		if shortName, ok := attributeShortNames[key]; ok {
+			key = shortName
+		}
+		builder.WriteString(fmt.Sprintf("/%s=%v", key, attribute.Value))
+	}
+	return builder.String()
+}
+
+// The serial file contains the next serial to issue as hex. Like OpenSSL, we keep the previous value in serial.old.
+func ReadSerial(path string) (*big.Int, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	value := strings.TrimSpace(string(bytes))
+	serial, ok := new(big.Int).SetString(value, 16)
+	if !ok {
+		return nil, errors.WithStackTrace(InvalidSerialNumber(value))
+	}
+	return serial, nil
+}
+
+func writeSerial(path string, serial *big.Int) error {
+	if err := keepPreviousVersion(path); err != nil {
+		return err
+	}
+	return writeFileAtomically(path, []byte(FormatSerial(serial)+"\n"), 0644)
+}
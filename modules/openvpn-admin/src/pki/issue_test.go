@@ -0,0 +1,61 @@
+package pki
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSerialRoundTripKeepsPreviousValue(t *testing.T) {
+	layout := newTestLayout(t)
+
+	if err := writeSerial(layout.SerialPath(), big.NewInt(255)); err != nil {
+		t.Fatal(err)
+	}
+	if written := readTestFile(t, layout.SerialPath()); written != "FF\n" {
+		t.Errorf("unexpected serial file: %q", written)
+	}
+	if old := readTestFile(t, layout.SerialPath()+".old"); old != "01\n" {
+		t.Errorf("unexpected serial.old file: %q", old)
+	}
+
+	if err := writeSerial(layout.SerialPath(), big.NewInt(256)); err != nil {
+		t.Fatal(err)
+	}
+	serial, err := ReadSerial(layout.SerialPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Cmp(big.NewInt(256)) != 0 || readTestFile(t, layout.SerialPath()) != "0100\n" {
+		t.Errorf("unexpected serial %s", FormatSerial(serial))
+	}
+}
+
+func TestIssueAdvancesSerialAndRecordsCertificate(t *testing.T) {
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Test CA")
+
+	alice := issueTestCertificate(t, layout, ca, "alice")
+	bob := issueTestCertificate(t, layout, ca, "bob")
+
+	if alice.SerialNumber.Cmp(big.NewInt(1)) != 0 || bob.SerialNumber.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("unexpected serials %s and %s", FormatSerial(alice.SerialNumber), FormatSerial(bob.SerialNumber))
+	}
+
+	serial, err := ReadSerial(layout.SerialPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("expected the next serial to be 03 but got %s", FormatSerial(serial))
+	}
+
+	index := readTestIndex(t, layout.IndexPath())
+	entry := index.FindBySerial(bob.SerialNumber)
+	if entry == nil || entry.CommonName() != "bob" || entry.Status != STATUS_VALID {
+		t.Fatalf("unexpected entry for bob: %v", entry)
+	}
+	if !entry.ExpirationTime.Equal(bob.NotAfter.Truncate(time.Second)) {
+		t.Errorf("expected expiration time %s but got %s", bob.NotAfter, entry.ExpirationTime)
+	}
+}
@@ -0,0 +1,31 @@
+package pki
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/filelock"
+	"os"
+)
+
+// KeyDirLock is an exclusive lock on the PKI in a key dir, held by one process at a time
+type KeyDirLock struct {
+	file *os.File
+}
+
+// Lock the PKI in the key dir, waiting for any other process that holds the lock to release it
+func LockKeyDir(layout Layout) (*KeyDirLock, error) {
+	file, err := os.OpenFile(layout.LockPath(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	if err := filelock.Lock(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &KeyDirLock{file: file}, nil
+}
+
+func (lock *KeyDirLock) Unlock() error {
+	defer lock.file.Close()
+	return filelock.Unlock(lock.file)
+}
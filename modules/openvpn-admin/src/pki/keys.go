@@ -0,0 +1,100 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"strings"
+)
+
+// The key algorithms supported for the CA, server and client certificates
+const KEY_ALGORITHM_RSA = "rsa"
+const KEY_ALGORITHM_ECDSA_P256 = "ecdsa-p256"
+const KEY_ALGORITHM_ECDSA_P384 = "ecdsa-p384"
+const KEY_ALGORITHM_ED25519 = "ed25519"
+
+var KeyAlgorithms = []string{
+	KEY_ALGORITHM_RSA,
+	KEY_ALGORITHM_ECDSA_P256,
+	KEY_ALGORITHM_ECDSA_P384,
+	KEY_ALGORITHM_ED25519,
+}
+
+const MIN_RSA_KEY_SIZE = 2048
+
+// KeySpec is a key algorithm along with the key size, which only applies to RSA keys
+type KeySpec struct {
+	Algorithm string
+	RsaBits   int
+}
+
+func ParseKeySpec(algorithm string, rsaBits int) (KeySpec, error) {
+	algorithm = strings.ToLower(algorithm)
+	if algorithm == "" {
+		algorithm = KEY_ALGORITHM_RSA
+	}
+
+	for _, supported := range KeyAlgorithms {
+		if algorithm != supported {
+			continue
+		}
+		if algorithm == KEY_ALGORITHM_RSA && rsaBits < MIN_RSA_KEY_SIZE {
+			return KeySpec{}, errors.WithStackTrace(RsaKeyTooSmall(rsaBits))
+		}
+		return KeySpec{Algorithm: algorithm, RsaBits: rsaBits}, nil
+	}
+
+	return KeySpec{}, errors.WithStackTrace(UnknownKeyAlgorithm(algorithm))
+}
+
+// Generate a new private key of this type
+func (spec KeySpec) Generate() (crypto.Signer, error) {
+	var key crypto.Signer
+	var err error
+
+	switch spec.Algorithm {
+	case KEY_ALGORITHM_RSA:
+		key, err = rsa.GenerateKey(rand.Reader, spec.RsaBits)
+	case KEY_ALGORITHM_ECDSA_P256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KEY_ALGORITHM_ECDSA_P384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KEY_ALGORITHM_ED25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, errors.WithStackTrace(UnknownKeyAlgorithm(spec.Algorithm))
+	}
+
+	return key, errors.WithStackTrace(err)
+}
+
+// Elliptic curve PKIs don't need Diffie-Hellman parameters, as OpenVPN can use ECDH for the key exchange instead
+func (spec KeySpec) IsEllipticCurve() bool {
+	return spec.Algorithm != KEY_ALGORITHM_RSA
+}
+
+func (spec KeySpec) String() string {
+	if spec.Algorithm == KEY_ALGORITHM_RSA {
+		return fmt.Sprintf("%s-%d", spec.Algorithm, spec.RsaBits)
+	}
+	return spec.Algorithm
+}
+
+// Custom errors
+
+type UnknownKeyAlgorithm string
+
+func (err UnknownKeyAlgorithm) Error() string {
+	return fmt.Sprintf("Unknown key algorithm '%s'. Must be one of: %s", string(err), strings.Join(KeyAlgorithms, ", "))
+}
+
+type RsaKeyTooSmall int
+
+func (err RsaKeyTooSmall) Error() string {
+	return fmt.Sprintf("RSA keys must be at least %d bits but the key size is %d", MIN_RSA_KEY_SIZE, int(err))
+}
@@ -0,0 +1,86 @@
+package pki
+
+import (
+	"path/filepath"
+)
+
+// Layout describes where the files of an easy-rsa managed PKI live. easy-rsa 2 keeps everything, including the CA
+// database, in a single KEY_DIR.
+type Layout struct {
+	KeyDir string
+}
+
+func (layout Layout) IndexPath() string {
+	return filepath.Join(layout.KeyDir, "index.txt")
+}
+
+func (layout Layout) SerialPath() string {
+	return filepath.Join(layout.KeyDir, "serial")
+}
+
+func (layout Layout) CrlPath() string {
+	return filepath.Join(layout.KeyDir, "crl.pem")
+}
+
+func (layout Layout) CrlNumberPath() string {
+	return filepath.Join(layout.KeyDir, "crlnumber")
+}
+
+// The file the processes that change the PKI lock while they do, so that none of them sees another's changes halfway
+func (layout Layout) LockPath() string {
+	return filepath.Join(layout.KeyDir, ".lock")
+}
+
+func (layout Layout) CaCertPath() string {
+	return filepath.Join(layout.KeyDir, "ca.crt")
+}
+
+func (layout Layout) CaKeyPath() string {
+	return filepath.Join(layout.KeyDir, "ca.key")
+}
+
+func (layout Layout) CertPath(name string) string {
+	return filepath.Join(layout.KeyDir, name+".crt")
+}
+
+func (layout Layout) KeyPath(name string) string {
+	return filepath.Join(layout.KeyDir, name+".key")
+}
+
+// The copy of each issued certificate the CA keeps, named after its serial in hex
+func (layout Layout) IssuedCertPath(serial string) string {
+	return filepath.Join(layout.KeyDir, serial+".pem")
+}
+
+// The CA being replaced while a CA rotation is in progress
+func (layout Layout) PreviousCaCertPath() string {
+	return filepath.Join(layout.KeyDir, "ca-previous.crt")
+}
+
+func (layout Layout) PreviousCaKeyPath() string {
+	return filepath.Join(layout.KeyDir, "ca-previous.key")
+}
+
+func (layout Layout) CaRotationPath() string {
+	return filepath.Join(layout.KeyDir, "ca-rotation.json")
+}
+
+// The key the OpenVPN server unwraps tls-crypt-v2 client keys with. tls-crypt-v2 is only used if this file exists.
+func (layout Layout) TlsCryptV2ServerKeyPath() string {
+	return filepath.Join(layout.KeyDir, "tls-crypt-v2-server.key")
+}
+
+// Client keys are kept in a directory of their own so that their names can never clash with a certificate's key
+func (layout Layout) TlsCryptV2ClientKeyPath(name string) string {
+	return filepath.Join(layout.KeyDir, "tls-crypt-v2", name+".key")
+}
+
+// Each OpenVPN instance reads the client config files (CCD files) of connecting clients from a directory of its own,
+// named after the instance
+func (layout Layout) ClientConfigDir(instance string) string {
+	return filepath.Join(layout.KeyDir, "ccd", instance)
+}
+
+func (layout Layout) ClientConfigPath(instance string, name string) string {
+	return filepath.Join(layout.ClientConfigDir(instance), name)
+}
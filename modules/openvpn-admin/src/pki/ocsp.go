@@ -0,0 +1,147 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"golang.org/x/crypto/ocsp"
+	"time"
+)
+
+// id-pkix-ocsp-nocheck from RFC 6960, section 4.2.2.2.1. Clients don't check the revocation status of a responder
+// certificate with this extension, so it should be short lived.
+var oidOcspNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// The common name of delegated OCSP responder certificates. IAM usernames can't contain spaces, so this can never clash
+// with a VPN user's certificate.
+const OCSP_RESPONDER_COMMON_NAME = "OCSP Responder"
+
+// OcspResponder answers OCSP requests for certificates issued by a CA from that CA's database, signing the responses
+// with a delegated responder certificate, or with the CA key itself if ResponderCert is the Issuer
+type OcspResponder struct {
+	Layout           Layout
+	Issuer           *x509.Certificate
+	ResponderCert    *x509.Certificate
+	ResponderKey     crypto.Signer
+	ResponseValidity time.Duration
+	// Whether the Issuer issued the certificate of the given CA database entry. During a CA rotation the database holds
+	// the certificates of two CAs with distinct serials, and each CA only vouches for its own. Nil means every entry.
+	IssuedBy func(entry *IndexEntry) (bool, error)
+}
+
+// OcspResponders answers OCSP requests for several CAs, handing each request to the responder for the CA it names
+type OcspResponders []*OcspResponder
+
+// Return a DER encoded OCSP response for the given DER encoded OCSP request
+func (responder *OcspResponder) Respond(requestBytes []byte, now time.Time) ([]byte, error) {
+	return OcspResponders{responder}.Respond(requestBytes, now)
+}
+
+// Return a DER encoded OCSP response for the given DER encoded OCSP request, from the responder whose CA issued the
+// certificate in question
+func (responders OcspResponders) Respond(requestBytes []byte, now time.Time) ([]byte, error) {
+	request, err := ocsp.ParseRequest(requestBytes)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+
+	for _, responder := range responders {
+		if responder.isIssuer(request) {
+			return responder.respond(request, now)
+		}
+	}
+	return ocsp.UnauthorizedErrorResponse, nil
+}
+
+func (responder *OcspResponder) respond(request *ocsp.Request, now time.Time) ([]byte, error) {
+	// Read the database on every request so that revocations are reflected immediately
+	index, err := ReadIndex(responder.Layout.IndexPath())
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, err
+	}
+
+	template := ocsp.Response{
+		SerialNumber: request.SerialNumber,
+		Status:       ocsp.Unknown,
+		ThisUpdate:   now.UTC(),
+		NextUpdate:   now.UTC().Add(responder.ResponseValidity),
+	}
+	// A response signed by the CA key itself needn't carry the CA certificate, which the client already has
+	if responder.ResponderCert != responder.Issuer {
+		template.Certificate = responder.ResponderCert
+	}
+
+	entry := index.FindBySerial(request.SerialNumber)
+	if entry != nil && responder.IssuedBy != nil {
+		issued, err := responder.IssuedBy(entry)
+		if err != nil {
+			return ocsp.InternalErrorErrorResponse, err
+		}
+		if !issued {
+			entry = nil
+		}
+	}
+
+	if entry != nil {
+		switch entry.Status {
+		case STATUS_REVOKED:
+			template.Status = ocsp.Revoked
+			template.RevokedAt = entry.RevocationTime
+			if entry.RevocationReason != "" {
+				template.RevocationReason, err = ReasonCode(entry.RevocationReason)
+				if err != nil {
+					return ocsp.InternalErrorErrorResponse, err
+				}
+			}
+		default:
+			template.Status = ocsp.Good
+		}
+	}
+
+	response, err := ocsp.CreateResponse(responder.Issuer, responder.ResponderCert, template, responder.ResponderKey)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, errors.WithStackTrace(err)
+	}
+	return response, nil
+}
+
+// OCSP identifies the issuer by hashes of its name and public key rather than by the certificate itself
+func (responder *OcspResponder) isIssuer(request *ocsp.Request) bool {
+	if !request.HashAlgorithm.Available() {
+		return false
+	}
+
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(responder.Issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return false
+	}
+
+	nameHash := request.HashAlgorithm.New()
+	nameHash.Write(responder.Issuer.RawSubject)
+
+	keyHash := request.HashAlgorithm.New()
+	keyHash.Write(publicKeyInfo.PublicKey.RightAlign())
+
+	return bytes.Equal(nameHash.Sum(nil), request.IssuerNameHash) && bytes.Equal(keyHash.Sum(nil), request.IssuerKeyHash)
+}
+
+// Build the template for a delegated OCSP responder certificate, as described in RFC 6960, section 4.2.2.2
+func OcspResponderTemplate(validity time.Duration) *x509.Certificate {
+	now := time.Now().UTC()
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: OCSP_RESPONDER_COMMON_NAME},
+		NotBefore:   now,
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidOcspNoCheck, Value: []byte{0x05, 0x00}},
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package pki
+
+import (
+	"crypto/x509"
+	"golang.org/x/crypto/ocsp"
+	"testing"
+	"time"
+)
+
+func TestOcspResponderAnswersFromTheCaDatabase(t *testing.T) {
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Test CA")
+
+	responderKey := newTestKey(t)
+	index, err := ReadIndex(layout.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderCert, err := Issue(layout, ca, index, OcspResponderTemplate(time.Hour), responderKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	good := issueTestCertificate(t, layout, ca, "alice")
+	revoked := issueTestCertificate(t, layout, ca, "bob")
+	revokeTestCertificate(t, layout, revoked, "keyCompromise")
+
+	responder := &OcspResponder{
+		Layout:           layout,
+		Issuer:           ca.Certificate,
+		ResponderCert:    responderCert,
+		ResponderKey:     responderKey,
+		ResponseValidity: time.Hour,
+	}
+
+	response := queryOcsp(t, responder, good, ca.Certificate)
+	if response.Status != ocsp.Good {
+		t.Errorf("expected alice's certificate to be good, got status %d", response.Status)
+	}
+	if response.Certificate == nil || !response.Certificate.Equal(responderCert) {
+		t.Errorf("expected the response to carry the delegated responder certificate")
+	}
+
+	response = queryOcsp(t, responder, revoked, ca.Certificate)
+	if response.Status != ocsp.Revoked {
+		t.Errorf("expected bob's certificate to be revoked, got status %d", response.Status)
+	}
+	if response.RevocationReason != ocsp.KeyCompromise {
+		t.Errorf("expected revocation reason %d, got %d", ocsp.KeyCompromise, response.RevocationReason)
+	}
+}
+
+func TestOcspRespondersAnswerForBothCasDuringRotation(t *testing.T) {
+	layout := newTestLayout(t)
+	previousCa := newTestCa(t, "Previous CA")
+	fromPrevious := issueTestCertificate(t, layout, previousCa, "alice")
+
+	rotation, err := NewCaRotation(layout, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentCa := newTestCa(t, "Current CA")
+	fromCurrent := issueTestCertificate(t, layout, currentCa, "alice")
+
+	responders := OcspResponders{
+		{
+			Layout:           layout,
+			Issuer:           currentCa.Certificate,
+			ResponderCert:    currentCa.Certificate,
+			ResponderKey:     currentCa.Signer,
+			ResponseValidity: time.Hour,
+			IssuedBy: func(entry *IndexEntry) (bool, error) {
+				issued, err := rotation.IssuedByPreviousCa(entry)
+				return !issued, err
+			},
+		},
+		{
+			Layout:           layout,
+			Issuer:           previousCa.Certificate,
+			ResponderCert:    previousCa.Certificate,
+			ResponderKey:     previousCa.Signer,
+			ResponseValidity: time.Hour,
+			IssuedBy:         rotation.IssuedByPreviousCa,
+		},
+	}
+
+	response := queryOcsp(t, responders, fromPrevious, previousCa.Certificate)
+	if response.Status != ocsp.Good {
+		t.Errorf("expected the previous CA's certificate to be good, got status %d", response.Status)
+	}
+	if response.Certificate != nil {
+		t.Errorf("expected a response signed by the CA itself not to carry a certificate")
+	}
+
+	response = queryOcsp(t, responders, fromCurrent, currentCa.Certificate)
+	if response.Status != ocsp.Good {
+		t.Errorf("expected the current CA's certificate to be good, got status %d", response.Status)
+	}
+
+	// The serial of a certificate from the previous CA, asked about as if the current CA had issued it
+	crossed := *fromPrevious
+	crossed.Issuer = currentCa.Certificate.Subject
+	crossed.RawIssuer = currentCa.Certificate.RawSubject
+	response = queryOcsp(t, responders, &crossed, currentCa.Certificate)
+	if response.Status != ocsp.Unknown {
+		t.Errorf("expected the current CA not to vouch for a serial of the previous CA, got status %d", response.Status)
+	}
+}
+
+func TestOcspResponderRefusesOtherIssuers(t *testing.T) {
+	layout := newTestLayout(t)
+	ca := newTestCa(t, "Test CA")
+	other := newTestCa(t, "Other CA")
+	certificate := issueTestCertificate(t, layout, other, "alice")
+
+	responder := &OcspResponder{Layout: layout, Issuer: ca.Certificate, ResponderCert: ca.Certificate, ResponderKey: ca.Signer, ResponseValidity: time.Hour}
+
+	request, err := ocsp.CreateRequest(certificate, other.Certificate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseBytes, err := responder.Respond(request, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ocsp.ParseResponse(responseBytes, nil); err != (ocsp.ResponseError{Status: ocsp.Unauthorized}) {
+		t.Errorf("expected an unauthorized response, got %v", err)
+	}
+}
+
+type ocspTestResponder interface {
+	Respond(requestBytes []byte, now time.Time) ([]byte, error)
+}
+
+func queryOcsp(t *testing.T, responder ocspTestResponder, certificate *x509.Certificate, issuer *x509.Certificate) *ocsp.Response {
+	t.Helper()
+
+	request, err := ocsp.CreateRequest(certificate, issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseBytes, err := responder.Respond(request, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := ocsp.ParseResponseForCert(responseBytes, certificate, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func revokeTestCertificate(t *testing.T, layout Layout, certificate *x509.Certificate, reason string) {
+	t.Helper()
+
+	index, err := ReadIndex(layout.IndexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := index.FindBySerial(certificate.SerialNumber)
+	if entry == nil {
+		t.Fatalf("serial %s is not in the CA database", FormatSerial(certificate.SerialNumber))
+	}
+	if err := entry.Revoke(time.Now(), reason); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Write(); err != nil {
+		t.Fatal(err)
+	}
+}
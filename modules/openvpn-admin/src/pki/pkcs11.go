@@ -0,0 +1,49 @@
+package pki
+
+import (
+	"fmt"
+)
+
+// The label the CA key pair is stored under in the token when PKCS11_KEY_LABEL isn't set
+const DEFAULT_PKCS11_KEY_LABEL = "openvpn-ca"
+
+// Pkcs11Config identifies the token and key pair the CA signs with when its private key is held in an HSM (or SoftHSM)
+// rather than in ca.key
+type Pkcs11Config struct {
+	ModulePath string
+	TokenLabel string
+	Pin        string
+	KeyLabel   string
+}
+
+// Custom errors
+
+type Pkcs11NotSupported struct{}
+
+func (err Pkcs11NotSupported) Error() string {
+	return "This build of openvpn-admin does not support PKCS#11. Rebuild it with CGO_ENABLED=1 to keep the CA key in a PKCS#11 token."
+}
+
+type Pkcs11KeyNotFound struct {
+	TokenLabel string
+	KeyLabel   string
+}
+
+func (err Pkcs11KeyNotFound) Error() string {
+	return fmt.Sprintf("Could not find a key pair labelled '%s' in the PKCS#11 token '%s'", err.KeyLabel, err.TokenLabel)
+}
+
+type Pkcs11KeyAlreadyExists struct {
+	TokenLabel string
+	KeyLabel   string
+}
+
+func (err Pkcs11KeyAlreadyExists) Error() string {
+	return fmt.Sprintf("A key pair labelled '%s' already exists in the PKCS#11 token '%s'. Refusing to overwrite it.", err.KeyLabel, err.TokenLabel)
+}
+
+type UnsupportedPkcs11KeyAlgorithm string
+
+func (err UnsupportedPkcs11KeyAlgorithm) Error() string {
+	return fmt.Sprintf("Key algorithm '%s' is not supported for keys generated in a PKCS#11 token", string(err))
+}
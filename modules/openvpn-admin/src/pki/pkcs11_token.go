@@ -0,0 +1,77 @@
+//go:build cgo
+
+package pki
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+)
+
+// Pkcs11Token is an open session with the token that holds the CA key. Keys generated in the token are marked
+// sensitive and non-extractable, so the CA key never exists outside of it.
+type Pkcs11Token struct {
+	config  Pkcs11Config
+	context *crypto11.Context
+}
+
+func OpenPkcs11Token(config Pkcs11Config) (*Pkcs11Token, error) {
+	context, err := crypto11.Configure(&crypto11.Config{
+		Path:       config.ModulePath,
+		TokenLabel: config.TokenLabel,
+		Pin:        config.Pin,
+	})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return &Pkcs11Token{config: config, context: context}, nil
+}
+
+// Find the CA key pair in the token
+func (token *Pkcs11Token) FindSigner() (crypto.Signer, error) {
+	signer, err := token.context.FindKeyPair(nil, []byte(token.config.KeyLabel))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	if signer == nil {
+		return nil, errors.WithStackTrace(Pkcs11KeyNotFound{TokenLabel: token.config.TokenLabel, KeyLabel: token.config.KeyLabel})
+	}
+	return signer, nil
+}
+
+// Generate the CA key pair in the token. Ed25519 isn't supported, as few PKCS#11 modules implement it.
+func (token *Pkcs11Token) GenerateKey(spec KeySpec) (crypto.Signer, error) {
+	existing, err := token.context.FindKeyPair(nil, []byte(token.config.KeyLabel))
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	if existing != nil {
+		return nil, errors.WithStackTrace(Pkcs11KeyAlreadyExists{TokenLabel: token.config.TokenLabel, KeyLabel: token.config.KeyLabel})
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	label := []byte(token.config.KeyLabel)
+
+	var signer crypto.Signer
+	switch spec.Algorithm {
+	case KEY_ALGORITHM_RSA:
+		signer, err = token.context.GenerateRSAKeyPairWithLabel(id, label, spec.RsaBits)
+	case KEY_ALGORITHM_ECDSA_P256:
+		signer, err = token.context.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P256())
+	case KEY_ALGORITHM_ECDSA_P384:
+		signer, err = token.context.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P384())
+	default:
+		return nil, errors.WithStackTrace(UnsupportedPkcs11KeyAlgorithm(spec.Algorithm))
+	}
+
+	return signer, errors.WithStackTrace(err)
+}
+
+func (token *Pkcs11Token) Close() error {
+	return errors.WithStackTrace(token.context.Close())
+}
@@ -0,0 +1,27 @@
+//go:build !cgo
+
+package pki
+
+import (
+	"crypto"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+)
+
+// PKCS#11 modules are C libraries, so without cgo we can only report that the token can't be used
+type Pkcs11Token struct{}
+
+func OpenPkcs11Token(config Pkcs11Config) (*Pkcs11Token, error) {
+	return nil, errors.WithStackTrace(Pkcs11NotSupported{})
+}
+
+func (token *Pkcs11Token) FindSigner() (crypto.Signer, error) {
+	return nil, errors.WithStackTrace(Pkcs11NotSupported{})
+}
+
+func (token *Pkcs11Token) GenerateKey(spec KeySpec) (crypto.Signer, error) {
+	return nil, errors.WithStackTrace(Pkcs11NotSupported{})
+}
+
+func (token *Pkcs11Token) Close() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+//go:build cgo
+
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Where distributions install the SoftHSM module. SOFTHSM2_MODULE overrides these, e.g. in CI, where the test must not
+// be skipped.
+var softHsmModulePaths = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+}
+
+const TEST_TOKEN_LABEL = "openvpn-admin-test"
+const TEST_TOKEN_PIN = "1234"
+
+// Create an empty SoftHSM token in a temp dir, and return the config of a key pair in it. Skips the test if SoftHSM
+// isn't installed.
+func newTestPkcs11Config(t *testing.T, keyLabel string) Pkcs11Config {
+	t.Helper()
+
+	modulePath := os.Getenv("SOFTHSM2_MODULE")
+	for _, path := range softHsmModulePaths {
+		if _, err := os.Stat(path); modulePath == "" && err == nil {
+			modulePath = path
+		}
+	}
+	if modulePath == "" {
+		t.Skip("SoftHSM isn't installed. Install softhsm2 or set SOFTHSM2_MODULE to test PKCS#11 tokens.")
+	}
+
+	dir := t.TempDir()
+	tokenDir := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokenDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	confPath := filepath.Join(dir, "softhsm2.conf")
+	if err := ioutil.WriteFile(confPath, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokenDir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", confPath)
+
+	initToken := exec.Command("softhsm2-util", "--init-token", "--free", "--label", TEST_TOKEN_LABEL, "--pin", TEST_TOKEN_PIN, "--so-pin", "5678")
+	if output, err := initToken.CombinedOutput(); err != nil {
+		t.Fatalf("Can't create a SoftHSM token: %s\n%s", err, output)
+	}
+
+	return Pkcs11Config{ModulePath: modulePath, TokenLabel: TEST_TOKEN_LABEL, Pin: TEST_TOKEN_PIN, KeyLabel: keyLabel}
+}
+
+func openTestPkcs11Token(t *testing.T, config Pkcs11Config) *Pkcs11Token {
+	t.Helper()
+
+	token, err := OpenPkcs11Token(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPkcs11TokenCaIssuesCertificates(t *testing.T) {
+	testCases := []struct {
+		algorithm string
+		rsaBits   int
+	}{
+		{KEY_ALGORITHM_RSA, 2048},
+		{KEY_ALGORITHM_ECDSA_P256, 0},
+		{KEY_ALGORITHM_ECDSA_P384, 0},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.algorithm, func(t *testing.T) {
+			config := newTestPkcs11Config(t, DEFAULT_PKCS11_KEY_LABEL)
+			spec, err := ParseKeySpec(testCase.algorithm, testCase.rsaBits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token := openTestPkcs11Token(t, config)
+			key, err := token.GenerateKey(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().UTC()
+			caCertificate, err := SelfSign(&x509.Certificate{
+				Subject:               pkix.Name{CommonName: "Test CA"},
+				NotBefore:             now.Add(-time.Hour),
+				NotAfter:              now.Add(24 * time.Hour),
+				KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+				BasicConstraintsValid: true,
+				IsCA:                  true,
+			}, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := token.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The key is found again by its label once the token is reopened, the way every command after build-ca
+			// finds it
+			token = openTestPkcs11Token(t, config)
+			defer token.Close()
+			signer, err := token.FindSigner()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca, err := NewCertificateAuthority(caCertificate, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			layout := newTestLayout(t)
+			certificate := issueTestCertificate(t, layout, ca, "john")
+			if err := certificate.CheckSignatureFrom(caCertificate); err != nil {
+				t.Errorf("Expected the certificate to be signed by the CA in the token, got %s", err)
+			}
+			if !bytes.Equal(certificate.RawIssuer, caCertificate.RawSubject) {
+				t.Errorf("Expected the certificate to be issued by %s, got %s", caCertificate.Subject, certificate.Issuer)
+			}
+		})
+	}
+}
+
+func TestPkcs11TokenRefusesToOverwriteKey(t *testing.T) {
+	config := newTestPkcs11Config(t, DEFAULT_PKCS11_KEY_LABEL)
+	spec, err := ParseKeySpec(KEY_ALGORITHM_ECDSA_P256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := openTestPkcs11Token(t, config)
+	defer token.Close()
+	if _, err := token.GenerateKey(spec); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = token.GenerateKey(spec)
+	if _, ok := errors.Unwrap(err).(Pkcs11KeyAlreadyExists); !ok {
+		t.Fatalf("Expected Pkcs11KeyAlreadyExists, got %v", err)
+	}
+}
+
+func TestPkcs11TokenReportsMissingKey(t *testing.T) {
+	token := openTestPkcs11Token(t, newTestPkcs11Config(t, "missing"))
+	defer token.Close()
+
+	_, err := token.FindSigner()
+	notFound, ok := errors.Unwrap(err).(Pkcs11KeyNotFound)
+	if !ok || notFound.KeyLabel != "missing" || notFound.TokenLabel != TEST_TOKEN_LABEL {
+		t.Fatalf("Expected Pkcs11KeyNotFound for the key labelled missing, got %v", err)
+	}
+}
+
+func TestPkcs11TokenRefusesEd25519(t *testing.T) {
+	spec, err := ParseKeySpec(KEY_ALGORITHM_ED25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := openTestPkcs11Token(t, newTestPkcs11Config(t, DEFAULT_PKCS11_KEY_LABEL))
+	defer token.Close()
+
+	_, err = token.GenerateKey(spec)
+	if _, ok := errors.Unwrap(err).(UnsupportedPkcs11KeyAlgorithm); !ok {
+		t.Fatalf("Expected UnsupportedPkcs11KeyAlgorithm, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package pki
+
+import (
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"strings"
+)
+
+// The revocation reasons understood by OpenSSL, as written to the CA database. The values are the CRLReason codes
+// from RFC 5280, section 5.3.1.
+const REASON_UNSPECIFIED = "unspecified"
+const REASON_KEY_COMPROMISE = "keyCompromise"
+const REASON_CA_COMPROMISE = "CACompromise"
+const REASON_AFFILIATION_CHANGED = "affiliationChanged"
+const REASON_SUPERSEDED = "superseded"
+const REASON_CESSATION_OF_OPERATION = "cessationOfOperation"
+const REASON_CERTIFICATE_HOLD = "certificateHold"
+const REASON_REMOVE_FROM_CRL = "removeFromCRL"
+
+// OpenSSL requires a hold instruction for certificates revoked with the certificateHold reason
+const HOLD_INSTRUCTION_REJECT = "holdInstructionReject"
+
+var reasonCodes = map[string]int{
+	REASON_UNSPECIFIED:            0,
+	REASON_KEY_COMPROMISE:         1,
+	REASON_CA_COMPROMISE:          2,
+	REASON_AFFILIATION_CHANGED:    3,
+	REASON_SUPERSEDED:             4,
+	REASON_CESSATION_OF_OPERATION: 5,
+	REASON_CERTIFICATE_HOLD:       6,
+	REASON_REMOVE_FROM_CRL:        8,
+}
+
+// The reasons an administrator may choose from when revoking a certificate
+var UserRevocationReasons = []string{
+	REASON_KEY_COMPROMISE,
+	REASON_SUPERSEDED,
+	REASON_CESSATION_OF_OPERATION,
+	REASON_CERTIFICATE_HOLD,
+}
+
+// Return the RFC 5280 CRLReason code for the given reason. OpenSSL matches reason names case-insensitively, so we do
+// too.
+func ReasonCode(reason string) (int, error) {
+	for name, code := range reasonCodes {
+		if strings.EqualFold(name, reason) {
+			return code, nil
+		}
+	}
+	return 0, errors.WithStackTrace(UnknownRevocationReason(reason))
+}
+
+// Return the canonical spelling of a user supplied revocation reason, or an error if it's not one of
+// UserRevocationReasons
+func NormalizeUserRevocationReason(reason string) (string, error) {
+	for _, name := range UserRevocationReasons {
+		if strings.EqualFold(name, reason) {
+			return name, nil
+		}
+	}
+	return "", errors.WithStackTrace(UnknownRevocationReason(reason))
+}
+
+// Custom errors
+
+type UnknownRevocationReason string
+
+func (err UnknownRevocationReason) Error() string {
+	return fmt.Sprintf("Unknown revocation reason '%s'. Must be one of: %s", string(err), strings.Join(UserRevocationReasons, ", "))
+}
@@ -0,0 +1,86 @@
+package pki
+
+import (
+	"encoding/json"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+// CaRotation records a CA rotation in progress. Both CAs share the CA database and serial file, so every certificate
+// with a serial below FirstSerial was issued by the previous CA, and every certificate from FirstSerial on by the new one.
+type CaRotation struct {
+	StartedAt   time.Time `json:"started_at"`
+	FirstSerial string    `json:"first_serial"`
+}
+
+// Start a CA rotation at the current position of the serial file
+func NewCaRotation(layout Layout, now time.Time) (*CaRotation, error) {
+	serial, err := ReadSerial(layout.SerialPath())
+	if err != nil {
+		return nil, err
+	}
+	return &CaRotation{StartedAt: now.UTC(), FirstSerial: FormatSerial(serial)}, nil
+}
+
+// Read the CA rotation in progress, or return nil if there isn't one
+func ReadCaRotation(layout Layout) (*CaRotation, error) {
+	bytes, err := ioutil.ReadFile(layout.CaRotationPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	rotation := &CaRotation{}
+	if err := json.Unmarshal(bytes, rotation); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return rotation, nil
+}
+
+func (rotation *CaRotation) Write(layout Layout) error {
+	bytes, err := json.MarshalIndent(rotation, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return writeFileAtomically(layout.CaRotationPath(), append(bytes, '\n'), 0644)
+}
+
+// Whether the certificate in the given CA database entry was issued by the CA being replaced
+func (rotation *CaRotation) IssuedByPreviousCa(entry *IndexEntry) (bool, error) {
+	firstSerial, ok := new(big.Int).SetString(rotation.FirstSerial, 16)
+	if !ok {
+		return false, errors.WithStackTrace(InvalidSerialNumber(rotation.FirstSerial))
+	}
+
+	serial, err := entry.SerialNumber()
+	if err != nil {
+		return false, err
+	}
+
+	return serial.Cmp(firstSerial) < 0, nil
+}
+
+// Split the CA database into the entries issued by the previous CA and those issued by the new CA
+func (rotation *CaRotation) SplitIndex(index *Index) (*Index, *Index, error) {
+	previous := &Index{Path: index.Path}
+	current := &Index{Path: index.Path}
+
+	for _, entry := range index.Entries {
+		fromPrevious, err := rotation.IssuedByPreviousCa(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		if fromPrevious {
+			previous.Entries = append(previous.Entries, entry)
+		} else {
+			current.Entries = append(current.Entries, entry)
+		}
+	}
+
+	return previous, current, nil
+}
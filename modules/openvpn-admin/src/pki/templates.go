@@ -0,0 +1,56 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+)
+
+// The certificate templates below mirror the v3_ca, server and usr_cert extensions in easy-rsa's openssl-1.0.0.cnf,
+// except that we always set an explicit key usage.
+
+func CaTemplate(subject pkix.Name, validity time.Duration) *x509.Certificate {
+	now := time.Now().UTC()
+	return &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+}
+
+func ServerTemplate(subject pkix.Name, validity time.Duration, publicKey crypto.PublicKey) *x509.Certificate {
+	now := time.Now().UTC()
+	return &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+		KeyUsage:              leafKeyUsage(publicKey),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+}
+
+func ClientTemplate(subject pkix.Name, validity time.Duration, publicKey crypto.PublicKey) *x509.Certificate {
+	now := time.Now().UTC()
+	return &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+		KeyUsage:              leafKeyUsage(publicKey),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+}
+
+// Key encipherment only makes sense for RSA keys, where TLS may use the key to encrypt the pre-master secret
+func leafKeyUsage(publicKey crypto.PublicKey) x509.KeyUsage {
+	if _, isRsa := publicKey.(*rsa.PublicKey); isRsa {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
@@ -0,0 +1,211 @@
+package pki
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"math/big"
+)
+
+// The PEM types OpenVPN uses for tls-crypt-v2 keys (see doc/tls-crypt-v2.txt in the OpenVPN source)
+const TLS_CRYPT_V2_SERVER_KEY_PEM_TYPE = "OpenVPN tls-crypt-v2 server key"
+const TLS_CRYPT_V2_CLIENT_KEY_PEM_TYPE = "OpenVPN tls-crypt-v2 client key"
+
+// OpenVPN keys are made up of a 512 bit cipher key followed by a 512 bit HMAC key, of which tls-crypt-v2 uses the first
+// 256 bits each. A server key is one such key, and a client key is two of them.
+const TLS_CRYPT_V2_KEY_LEN = 128
+const TLS_CRYPT_V2_CLIENT_KEY_LEN = 2 * TLS_CRYPT_V2_KEY_LEN
+const TLS_CRYPT_V2_TAG_LEN = sha256.Size
+const TLS_CRYPT_V2_MAX_WRAPPED_KEY_LEN = 1024
+const TLS_CRYPT_V2_MAX_METADATA_LEN = TLS_CRYPT_V2_MAX_WRAPPED_KEY_LEN - (TLS_CRYPT_V2_CLIENT_KEY_LEN + TLS_CRYPT_V2_TAG_LEN + 2)
+
+// The first byte of the metadata says how to interpret the rest of it. OpenVPN passes it to --tls-crypt-v2-verify in
+// the metadata_type environment variable.
+const TLS_CRYPT_V2_METADATA_TYPE_USER = 0x00
+const TLS_CRYPT_V2_METADATA_TYPE_TIMESTAMP = 0x01
+
+// TlsCryptV2ServerKey is the key the OpenVPN server uses to unwrap the client keys that clients send when they connect
+type TlsCryptV2ServerKey struct {
+	cipherKey []byte
+	hmacKey   []byte
+}
+
+// Generate a new random server key and write it to the given path, the same way `openvpn --genkey tls-crypt-v2-server`
+// does
+func GenerateTlsCryptV2ServerKey(path string) error {
+	key := make([]byte, TLS_CRYPT_V2_KEY_LEN)
+	if _, err := rand.Read(key); err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	return writeFileAtomically(path, pem.EncodeToMemory(&pem.Block{Type: TLS_CRYPT_V2_SERVER_KEY_PEM_TYPE, Bytes: key}), 0600)
+}
+
+func ReadTlsCryptV2ServerKey(path string) (*TlsCryptV2ServerKey, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	block, _ := pem.Decode(bytes)
+	if block == nil || block.Type != TLS_CRYPT_V2_SERVER_KEY_PEM_TYPE {
+		return nil, errors.WithStackTrace(NoPemBlockFound{Path: path, Type: TLS_CRYPT_V2_SERVER_KEY_PEM_TYPE})
+	}
+	if len(block.Bytes) != TLS_CRYPT_V2_KEY_LEN {
+		return nil, errors.WithStackTrace(InvalidTlsCryptV2Key{Path: path, Length: len(block.Bytes)})
+	}
+
+	return &TlsCryptV2ServerKey{
+		cipherKey: block.Bytes[:32],
+		hmacKey:   block.Bytes[64:96],
+	}, nil
+}
+
+// Generate a new random client key and wrap it with the server key, the same way `openvpn --tls-crypt-v2 <server key>
+// --genkey tls-crypt-v2-client` does with user metadata. Returns the client key in PEM format, ready to be embedded in
+// a client profile.
+func (serverKey *TlsCryptV2ServerKey) GenerateClientKey(metadata []byte) ([]byte, error) {
+	if len(metadata)+1 > TLS_CRYPT_V2_MAX_METADATA_LEN {
+		return nil, errors.WithStackTrace(TlsCryptV2MetadataTooLong(len(metadata)))
+	}
+
+	clientKey := make([]byte, TLS_CRYPT_V2_CLIENT_KEY_LEN)
+	if _, err := rand.Read(clientKey); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	typedMetadata := append([]byte{TLS_CRYPT_V2_METADATA_TYPE_USER}, metadata...)
+
+	wrappedKey, err := serverKey.wrap(clientKey, typedMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: TLS_CRYPT_V2_CLIENT_KEY_PEM_TYPE, Bytes: append(clientKey, wrappedKey...)}), nil
+}
+
+// WKc = T || AES-256-CTR(Ke, IV, Kc || metadata) || len, where T = HMAC-SHA256(Ka, len || Kc || metadata), the IV is the
+// first 128 bits of T and len is the length of WKc as a 16 bit big endian number
+func (serverKey *TlsCryptV2ServerKey) wrap(clientKey []byte, metadata []byte) ([]byte, error) {
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(TLS_CRYPT_V2_TAG_LEN+len(clientKey)+len(metadata)+len(length)))
+
+	mac := hmac.New(sha256.New, serverKey.hmacKey)
+	mac.Write(length)
+	mac.Write(clientKey)
+	mac.Write(metadata)
+	tag := mac.Sum(nil)
+
+	block, err := aes.NewCipher(serverKey.cipherKey)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	plaintext := append(append([]byte{}, clientKey...), metadata...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(block, tag[:aes.BlockSize]).XORKeyStream(ciphertext, plaintext)
+
+	wrappedKey := append(tag, ciphertext...)
+	return append(wrappedKey, length...), nil
+}
+
+// Check that the client key at the given path was wrapped with this server key, the way the OpenVPN server does when a
+// client connects, and return the metadata in it, without its type
+func (serverKey *TlsCryptV2ServerKey) ReadClientKeyMetadata(path string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	block, _ := pem.Decode(bytes)
+	if block == nil || block.Type != TLS_CRYPT_V2_CLIENT_KEY_PEM_TYPE {
+		return nil, errors.WithStackTrace(NoPemBlockFound{Path: path, Type: TLS_CRYPT_V2_CLIENT_KEY_PEM_TYPE})
+	}
+
+	// The client key is followed by the wrapped key, which must at least hold the tag, a copy of the client key, a
+	// metadata type and the length
+	wrappedKeyLen := len(block.Bytes) - TLS_CRYPT_V2_CLIENT_KEY_LEN
+	if wrappedKeyLen < TLS_CRYPT_V2_TAG_LEN+TLS_CRYPT_V2_CLIENT_KEY_LEN+3 || wrappedKeyLen > TLS_CRYPT_V2_MAX_WRAPPED_KEY_LEN {
+		return nil, errors.WithStackTrace(TlsCryptV2ClientKeyNotWrapped(path))
+	}
+
+	metadata, err := serverKey.unwrap(block.Bytes[:TLS_CRYPT_V2_CLIENT_KEY_LEN], block.Bytes[TLS_CRYPT_V2_CLIENT_KEY_LEN:])
+	if err != nil {
+		return nil, errors.WithStackTrace(TlsCryptV2ClientKeyNotWrapped(path))
+	}
+	if metadata[0] != TLS_CRYPT_V2_METADATA_TYPE_USER {
+		return nil, errors.WithStackTrace(TlsCryptV2ClientKeyNotWrapped(path))
+	}
+	return metadata[1:], nil
+}
+
+// Reverse wrap: decrypt the wrapped key and check its tag and that it holds the given client key. Returns the metadata,
+// with its type.
+func (serverKey *TlsCryptV2ServerKey) unwrap(clientKey []byte, wrappedKey []byte) ([]byte, error) {
+	length := wrappedKey[len(wrappedKey)-2:]
+	if int(binary.BigEndian.Uint16(length)) != len(wrappedKey) {
+		return nil, fmt.Errorf("the wrapped key's length doesn't match its size")
+	}
+	tag := wrappedKey[:TLS_CRYPT_V2_TAG_LEN]
+	ciphertext := wrappedKey[TLS_CRYPT_V2_TAG_LEN : len(wrappedKey)-2]
+
+	block, err := aes.NewCipher(serverKey.cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, tag[:aes.BlockSize]).XORKeyStream(plaintext, ciphertext)
+
+	mac := hmac.New(sha256.New, serverKey.hmacKey)
+	mac.Write(length)
+	mac.Write(plaintext)
+	if !hmac.Equal(mac.Sum(nil), tag) || !hmac.Equal(plaintext[:TLS_CRYPT_V2_CLIENT_KEY_LEN], clientKey) {
+		return nil, fmt.Errorf("the wrapped key wasn't wrapped with this server key")
+	}
+	return plaintext[TLS_CRYPT_V2_CLIENT_KEY_LEN:], nil
+}
+
+// The metadata we put in each client key: the serial of the certificate issued along with it, in hex, so that the key
+// can be refused as soon as the certificate is revoked
+func TlsCryptV2Metadata(serial *big.Int) []byte {
+	return []byte(FormatSerial(serial))
+}
+
+// Parse the certificate serial out of the metadata written by TlsCryptV2Metadata
+func ParseTlsCryptV2Metadata(metadata []byte) (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(string(metadata), 16)
+	if !ok {
+		return nil, errors.WithStackTrace(InvalidSerialNumber(string(metadata)))
+	}
+	return serial, nil
+}
+
+// Custom errors
+
+type InvalidTlsCryptV2Key struct {
+	Path   string
+	Length int
+}
+
+func (err InvalidTlsCryptV2Key) Error() string {
+	return fmt.Sprintf("The tls-crypt-v2 key in %s is %d bytes long. Expected %d bytes.", err.Path, err.Length, TLS_CRYPT_V2_KEY_LEN)
+}
+
+type TlsCryptV2ClientKeyNotWrapped string
+
+func (path TlsCryptV2ClientKeyNotWrapped) Error() string {
+	return fmt.Sprintf("The tls-crypt-v2 client key in %s was not wrapped with the tls-crypt-v2 server key", string(path))
+}
+
+type TlsCryptV2MetadataTooLong int
+
+func (err TlsCryptV2MetadataTooLong) Error() string {
+	return fmt.Sprintf("The tls-crypt-v2 metadata is %d bytes long. The maximum is %d bytes.", int(err), TLS_CRYPT_V2_MAX_METADATA_LEN-1)
+}
@@ -0,0 +1,165 @@
+package pki
+
+import (
+	"bufio"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var oidName = asn1.ObjectIdentifier{2, 5, 4, 41}
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// EasyRsaVars holds the exported settings from an easy-rsa vars file (e.g. /etc/openvpn-ca/vars.local), which is
+// where init-openvpn records the subject fields, expiration and key algorithm for the PKI
+type EasyRsaVars map[string]string
+
+// Read the "export NAME=value" lines from an easy-rsa vars file. Anything else, such as shell commands, is ignored.
+func ReadEasyRsaVars(path string) (EasyRsaVars, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	vars := EasyRsaVars{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "export ") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		vars[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+	}
+
+	return vars, errors.WithStackTrace(scanner.Err())
+}
+
+// The subject easy-rsa would use for a certificate with the given common name. The name attribute is only set when
+// nameAttribute isn't empty, which matches how easy-rsa handles an empty KEY_NAME.
+func (vars EasyRsaVars) Subject(commonName string, nameAttribute string) pkix.Name {
+	subject := pkix.Name{
+		Country:            nonEmpty(vars["KEY_COUNTRY"]),
+		Province:           nonEmpty(vars["KEY_PROVINCE"]),
+		Locality:           nonEmpty(vars["KEY_CITY"]),
+		Organization:       nonEmpty(vars["KEY_ORG"]),
+		OrganizationalUnit: nonEmpty(vars["KEY_OU"]),
+		CommonName:         commonName,
+	}
+
+	if nameAttribute != "" {
+		subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{Type: oidName, Value: nameAttribute})
+	}
+	if email := vars["KEY_EMAIL"]; email != "" {
+		subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{Type: oidEmailAddress, Value: email})
+	}
+
+	return subject
+}
+
+// The key algorithm for the PKI. Vars files written before KEY_ALGORITHM was introduced default to RSA.
+func (vars EasyRsaVars) KeySpec() (KeySpec, error) {
+	rsaBits := 0
+	if keySize := vars["KEY_SIZE"]; keySize != "" {
+		parsed, err := strconv.Atoi(keySize)
+		if err != nil {
+			return KeySpec{}, errors.WithStackTrace(InvalidEasyRsaVar{Name: "KEY_SIZE", Value: keySize})
+		}
+		rsaBits = parsed
+	}
+	return ParseKeySpec(vars["KEY_ALGORITHM"], rsaBits)
+}
+
+// How long server and client certificates are valid for
+func (vars EasyRsaVars) CertificateValidity() (time.Duration, error) {
+	return vars.days("KEY_EXPIRE")
+}
+
+// How long the CA certificate is valid for
+func (vars EasyRsaVars) CaValidity() (time.Duration, error) {
+	return vars.days("CA_EXPIRE")
+}
+
+// The PKCS#11 token holding the CA key, or nil if the CA key is in ca.key. easy-rsa's vars file sets
+// PKCS11_MODULE_PATH to "dummy" when no token is used. The PIN is read from PKCS11_PIN_FILE rather than the vars file,
+// as the vars file is backed up to S3 along with the rest of the PKI.
+func (vars EasyRsaVars) Pkcs11Config() (*Pkcs11Config, error) {
+	modulePath := vars["PKCS11_MODULE_PATH"]
+	if modulePath == "" || modulePath == "dummy" {
+		return nil, nil
+	}
+
+	tokenLabel := vars["PKCS11_TOKEN_LABEL"]
+	if tokenLabel == "" {
+		return nil, errors.WithStackTrace(InvalidEasyRsaVar{Name: "PKCS11_TOKEN_LABEL", Value: tokenLabel})
+	}
+
+	pinFile := vars["PKCS11_PIN_FILE"]
+	if pinFile == "" {
+		return nil, errors.WithStackTrace(InvalidEasyRsaVar{Name: "PKCS11_PIN_FILE", Value: pinFile})
+	}
+
+	pin, err := ioutil.ReadFile(pinFile)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	keyLabel := vars["PKCS11_KEY_LABEL"]
+	if keyLabel == "" {
+		keyLabel = DEFAULT_PKCS11_KEY_LABEL
+	}
+
+	return &Pkcs11Config{
+		ModulePath: modulePath,
+		TokenLabel: tokenLabel,
+		Pin:        strings.TrimSpace(string(pin)),
+		KeyLabel:   keyLabel,
+	}, nil
+}
+
+// The ARN of the asymmetric KMS key that signs on behalf of the CA, or an empty string if the CA key isn't in KMS
+func (vars EasyRsaVars) KmsSigningKeyArn() string {
+	return vars["KMS_SIGNING_KEY_ARN"]
+}
+
+// An alternative KMS endpoint, e.g. a local KMS stand-in for testing. Empty unless set in the vars file.
+func (vars EasyRsaVars) KmsEndpoint() string {
+	return vars["KMS_ENDPOINT"]
+}
+
+func (vars EasyRsaVars) days(name string) (time.Duration, error) {
+	days, err := strconv.Atoi(vars[name])
+	if err != nil || days <= 0 {
+		return 0, errors.WithStackTrace(InvalidEasyRsaVar{Name: name, Value: vars[name]})
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// Custom errors
+
+type InvalidEasyRsaVar struct {
+	Name  string
+	Value string
+}
+
+func (err InvalidEasyRsaVar) Error() string {
+	return fmt.Sprintf("Invalid value '%s' for %s in the easy-rsa vars file", err.Value, err.Name)
+}
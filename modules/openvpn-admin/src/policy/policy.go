@@ -0,0 +1,262 @@
+package policy
+
+import (
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"time"
+)
+
+// The operations a policy can authorize
+const OPERATION_REQUEST = "request"
+const OPERATION_REVOKE = "revoke"
+const OPERATION_RELEASE = "release"
+const OPERATION_LIST = "list"
+const OPERATION_CLIENT_CONFIG = "client-config"
+const OPERATION_REPORT = "report"
+
+// Approving or denying a certificate request that waits for approval. The target is the user the request is for.
+const OPERATION_APPROVE = "approve"
+
+var Operations = []string{OPERATION_REQUEST, OPERATION_REVOKE, OPERATION_RELEASE, OPERATION_LIST, OPERATION_CLIENT_CONFIG, OPERATION_REPORT, OPERATION_APPROVE}
+
+// The decisions a rule can make. Only a new certificate can be parked until an admin approves it.
+const ALLOW = "allow"
+const DENY = "deny"
+const REQUIRE_APPROVAL = "require_approval"
+
+// Rule is a single entry in a policy file. A rule applies to an operation if Operations is empty or contains it, and
+// when the CEL expression in When evaluates to true.
+type Rule struct {
+	Name       string   `yaml:"name"`
+	Operations []string `yaml:"operations"`
+	When       string   `yaml:"when"`
+	Decision   string   `yaml:"decision"`
+	Message    string   `yaml:"message"`
+
+	program cel.Program
+}
+
+// Policy is a list of rules, of which the first one that applies decides. If none applies, Default decides.
+type Policy struct {
+	Path    string  `yaml:"-"`
+	Default string  `yaml:"default"`
+	Rules   []*Rule `yaml:"rules"`
+}
+
+// The kinds of IAM identity that can send a message to one of the queues
+const PRINCIPAL_USER = "user"
+const PRINCIPAL_ROLE = "role"
+const PRINCIPAL_UNKNOWN = "unknown"
+
+// Principal is the IAM identity that sent a message to one of the queues. Roles have no username or groups: whoever
+// assumes a role picks its session name, so the session name says nothing about who they are, and is only kept for
+// the logs.
+type Principal struct {
+	Id          string
+	Type        string
+	Username    string
+	Groups      []string
+	RoleArn     string
+	SessionName string
+}
+
+// A name for the principal for logs and audit events. For roles that includes the session name, which is no proof of
+// identity, but helps to tell sessions apart.
+func (principal Principal) String() string {
+	switch principal.Type {
+	case PRINCIPAL_USER:
+		return principal.Username
+	case PRINCIPAL_ROLE:
+		return fmt.Sprintf("%s (session %s)", principal.RoleArn, principal.SessionName)
+	}
+	return principal.Id
+}
+
+// Input is what a policy's rules can look at
+type Input struct {
+	Operation      string
+	Requester      Principal
+	TargetUsername string
+	DeviceCount    int
+	RequestedTtl   time.Duration
+	Now            time.Time
+}
+
+// Decision is the outcome of evaluating a policy, along with the rule that made it, if any
+type Decision struct {
+	Effect  string
+	Rule    string
+	Message string
+}
+
+// Read the policy file at the given path and compile all of its rules, so that mistakes show up when the server
+// starts rather than when a request comes in
+func Load(path string) (*Policy, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	policy := &Policy{Path: path}
+	if err := yaml.UnmarshalStrict(bytes, policy); err != nil {
+		return nil, errors.WithStackTrace(InvalidPolicy{Path: path, Cause: err})
+	}
+
+	if policy.Default == "" {
+		policy.Default = ALLOW
+	}
+	if policy.Default != ALLOW && policy.Default != DENY {
+		return nil, errors.WithStackTrace(InvalidPolicy{Path: path, Cause: fmt.Errorf("default must be %s or %s but was '%s'", ALLOW, DENY, policy.Default)})
+	}
+
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := rule.compile(env); err != nil {
+			return nil, errors.WithStackTrace(InvalidPolicy{Path: path, Cause: fmt.Errorf("rule '%s': %s", rule.Name, err)})
+		}
+	}
+
+	return policy, nil
+}
+
+// The variables a rule's when expression can use
+func newEnv() (*cel.Env, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("operation", cel.StringType),
+		cel.Variable("requester", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("target", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("requested_ttl", cel.DurationType),
+		cel.Variable("now", cel.TimestampType),
+	)
+	return env, errors.WithStackTrace(err)
+}
+
+func (rule *Rule) compile(env *cel.Env) error {
+	for _, operation := range rule.Operations {
+		if !isOperation(operation) {
+			return fmt.Errorf("unknown operation '%s'", operation)
+		}
+	}
+
+	switch rule.Decision {
+	case ALLOW, DENY:
+	case REQUIRE_APPROVAL:
+		if len(rule.Operations) != 1 || rule.Operations[0] != OPERATION_REQUEST {
+			return fmt.Errorf("%s only applies to the %s operation, so the rule must list only that operation", REQUIRE_APPROVAL, OPERATION_REQUEST)
+		}
+	default:
+		return fmt.Errorf("decision must be one of %s, %s or %s but was '%s'", ALLOW, DENY, REQUIRE_APPROVAL, rule.Decision)
+	}
+
+	// A rule without a condition applies to all of its operations
+	when := rule.When
+	if when == "" {
+		when = "true"
+	}
+
+	ast, issues := env.Compile(when)
+	if issues != nil && issues.Err() != nil {
+		return issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return fmt.Errorf("when must be a boolean expression but is a %s", ast.OutputType())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return err
+	}
+	rule.program = program
+	return nil
+}
+
+// Find the first rule that applies to the input and return its decision. A rule that fails to evaluate, e.g. because
+// it looks up a field that doesn't exist, denies the operation rather than being skipped.
+func (policy *Policy) Evaluate(input Input) Decision {
+	activation := map[string]interface{}{
+		"operation": input.Operation,
+		"requester": map[string]interface{}{
+			"id":           input.Requester.Id,
+			"type":         input.Requester.Type,
+			"username":     input.Requester.Username,
+			"groups":       input.Requester.Groups,
+			"role_arn":     input.Requester.RoleArn,
+			"session_name": input.Requester.SessionName,
+		},
+		"target": map[string]interface{}{
+			"username":     input.TargetUsername,
+			"device_count": input.DeviceCount,
+		},
+		"requested_ttl": input.RequestedTtl,
+		"now":           input.Now,
+	}
+
+	for _, rule := range policy.Rules {
+		if !rule.appliesTo(input.Operation) {
+			continue
+		}
+
+		result, _, err := rule.program.Eval(activation)
+		if err != nil {
+			return Decision{Effect: DENY, Rule: rule.Name, Message: fmt.Sprintf("failed to evaluate the rule: %s", err)}
+		}
+		if matched, ok := result.Value().(bool); ok && matched {
+			return Decision{Effect: rule.Decision, Rule: rule.Name, Message: rule.Message}
+		}
+	}
+
+	return Decision{Effect: policy.Default}
+}
+
+func (rule *Rule) appliesTo(operation string) bool {
+	if len(rule.Operations) == 0 {
+		return true
+	}
+	for _, ruleOperation := range rule.Operations {
+		if ruleOperation == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func isOperation(operation string) bool {
+	for _, known := range Operations {
+		if known == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// A human readable explanation of the decision, suitable for sending back to the client
+func (decision Decision) Explain() string {
+	if decision.Rule == "" {
+		return "the policy's default, as no rule applies"
+	}
+	if decision.Message == "" {
+		return fmt.Sprintf("policy rule '%s'", decision.Rule)
+	}
+	return fmt.Sprintf("policy rule '%s': %s", decision.Rule, decision.Message)
+}
+
+// Custom errors
+
+type InvalidPolicy struct {
+	Path  string
+	Cause error
+}
+
+func (err InvalidPolicy) Error() string {
+	return fmt.Sprintf("Invalid policy in %s: %s", err.Path, err.Cause)
+}
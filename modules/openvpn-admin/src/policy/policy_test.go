@@ -0,0 +1,149 @@
+package policy
+
+import (
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const TEST_POLICY = `
+default: deny
+rules:
+  - name: admins
+    when: '"openvpn-admins" in requester.groups'
+    decision: allow
+  - name: external-accounts
+    operations: [request]
+    when: requester.role_arn == "arn:aws:iam::111111111111:role/external"
+    decision: require_approval
+    message: Requests through the external accounts role need approval
+  - name: own-certificates-only
+    when: requester.username != target.username
+    decision: deny
+    message: You can only manage your own certificates
+  - name: short-lived
+    operations: [request]
+    when: requested_ttl > duration("720h")
+    decision: deny
+  - name: office-hours
+    operations: [request]
+    when: now.getHours("UTC") < 8
+    decision: require_approval
+  - name: self-service
+    operations: [request, list]
+    decision: allow
+`
+
+var alice = Principal{Id: "AIDAALICE", Type: PRINCIPAL_USER, Username: "alice", Groups: []string{"openvpn-users"}}
+var admin = Principal{Id: "AIDAADMIN", Type: PRINCIPAL_USER, Username: "bob", Groups: []string{"openvpn-users", "openvpn-admins"}}
+var noon = time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)
+
+func TestEvaluate(t *testing.T) {
+	policy := loadTestPolicy(t, TEST_POLICY)
+
+	testCases := []struct {
+		name     string
+		input    Input
+		effect   string
+		ruleName string
+	}{
+		{"own request", Input{Operation: OPERATION_REQUEST, Requester: alice, TargetUsername: "alice", Now: noon}, ALLOW, "self-service"},
+		{"someone else's request", Input{Operation: OPERATION_REQUEST, Requester: alice, TargetUsername: "carol", Now: noon}, DENY, "own-certificates-only"},
+		{"admin revokes", Input{Operation: OPERATION_REVOKE, Requester: admin, TargetUsername: "alice", Now: noon}, ALLOW, "admins"},
+		{"user revokes own", Input{Operation: OPERATION_REVOKE, Requester: alice, TargetUsername: "alice", Now: noon}, DENY, ""},
+		{"user approves own", Input{Operation: OPERATION_APPROVE, Requester: alice, TargetUsername: "alice", Now: noon}, DENY, ""},
+		{"admin approves", Input{Operation: OPERATION_APPROVE, Requester: admin, TargetUsername: "alice", Now: noon}, ALLOW, "admins"},
+		{"long ttl", Input{Operation: OPERATION_REQUEST, Requester: alice, TargetUsername: "alice", RequestedTtl: 1000 * time.Hour, Now: noon}, DENY, "short-lived"},
+		{"early morning", Input{Operation: OPERATION_REQUEST, Requester: alice, TargetUsername: "alice", Now: noon.Add(-6 * time.Hour)}, REQUIRE_APPROVAL, "office-hours"},
+	}
+
+	for _, testCase := range testCases {
+		decision := policy.Evaluate(testCase.input)
+		if decision.Effect != testCase.effect || decision.Rule != testCase.ruleName {
+			t.Errorf("%s: expected %s by '%s', got %s by '%s'", testCase.name, testCase.effect, testCase.ruleName, decision.Effect, decision.Rule)
+		}
+	}
+}
+
+// Whoever assumes a role picks the session name, so naming the session after the target user must not help
+func TestEvaluateNeverTreatsRoleSessionNameAsUsername(t *testing.T) {
+	policy := loadTestPolicy(t, TEST_POLICY)
+
+	role := Principal{Id: "AROAOTHER", Type: PRINCIPAL_ROLE, RoleArn: "arn:aws:iam::111111111111:role/other", SessionName: "alice"}
+	decision := policy.Evaluate(Input{Operation: OPERATION_REQUEST, Requester: role, TargetUsername: "alice", Now: noon})
+	if decision.Effect != DENY || decision.Rule != "own-certificates-only" {
+		t.Errorf("expected a role to be denied whatever its session name, got %s by '%s'", decision.Effect, decision.Rule)
+	}
+
+	external := Principal{Id: "AROAEXTERNAL", Type: PRINCIPAL_ROLE, RoleArn: "arn:aws:iam::111111111111:role/external", SessionName: "anything"}
+	decision = policy.Evaluate(Input{Operation: OPERATION_REQUEST, Requester: external, TargetUsername: "alice", Now: noon})
+	if decision.Effect != REQUIRE_APPROVAL || decision.Rule != "external-accounts" {
+		t.Errorf("expected the external accounts role to need approval, got %s by '%s'", decision.Effect, decision.Rule)
+	}
+}
+
+func TestEvaluateDeniesWhenRuleFailsToEvaluate(t *testing.T) {
+	policy := loadTestPolicy(t, `
+default: allow
+rules:
+  - name: broken
+    when: requester.no_such_field == "x"
+    decision: allow
+`)
+
+	decision := policy.Evaluate(Input{Operation: OPERATION_LIST, Requester: alice, TargetUsername: "alice", Now: noon})
+	if decision.Effect != DENY || decision.Rule != "broken" {
+		t.Errorf("expected a rule that fails to evaluate to deny, got %s by '%s'", decision.Effect, decision.Rule)
+	}
+}
+
+func TestEvaluateFallsBackToDefault(t *testing.T) {
+	policy := loadTestPolicy(t, "rules: []\n")
+
+	decision := policy.Evaluate(Input{Operation: OPERATION_REVOKE, Requester: alice, TargetUsername: "carol", Now: noon})
+	if decision.Effect != ALLOW || decision.Rule != "" {
+		t.Errorf("expected the default of allow, got %s by '%s'", decision.Effect, decision.Rule)
+	}
+}
+
+func TestLoadRejectsInvalidPolicies(t *testing.T) {
+	testCases := map[string]string{
+		"unknown operation":         "rules:\n  - operations: [delete]\n    decision: allow\n",
+		"unknown decision":          "rules:\n  - decision: maybe\n",
+		"approval for revocations":  "rules:\n  - operations: [revoke]\n    decision: require_approval\n",
+		"non-boolean condition":     "rules:\n  - when: target.username\n    decision: allow\n",
+		"syntax error":              "rules:\n  - when: requester.username ==\n    decision: allow\n",
+		"unknown default":           "default: maybe\n",
+		"unknown field in the file": "defaults: deny\n",
+	}
+
+	for name, contents := range testCases {
+		path := writeTestPolicy(t, contents)
+		_, err := Load(path)
+		if _, ok := errors.Unwrap(err).(InvalidPolicy); !ok {
+			t.Errorf("%s: expected InvalidPolicy, got %v", name, err)
+		}
+	}
+}
+
+func loadTestPolicy(t *testing.T, contents string) *Policy {
+	t.Helper()
+
+	policy, err := Load(writeTestPolicy(t, contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func writeTestPolicy(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "policy.yml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,173 @@
+package sessions
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The longest line the session log may have. Events are a few hundred bytes.
+const MAX_EVENT_LEN = 64 * 1024
+
+// Record is a session as the session log tells it, from its start event and, once it ended, its end event
+type Record struct {
+	Username      string
+	Serial        string `json:",omitempty"`
+	Instance      string
+	VpnIp         string
+	TrustedIp     string `json:",omitempty"`
+	TrustedPort   int    `json:",omitempty"`
+	Start         time.Time
+	End           *time.Time `json:",omitempty"`
+	BytesReceived int64      `json:",omitempty"`
+	BytesSent     int64      `json:",omitempty"`
+	EndReason     string     `json:",omitempty"`
+}
+
+// Query selects the sessions that were connected at any time from Since to Until, optionally only those of one user
+type Query struct {
+	Since    time.Time
+	Until    time.Time
+	Username string
+}
+
+// The sessions that match the query, oldest first. A session that hasn't ended has no End.
+func (tracker *Tracker) History(query Query) ([]*Record, error) {
+	var records []*Record
+	err := tracker.withActiveSessions(func(active []*Session) ([]*Session, error) {
+		// Reading the log with the active sessions file locked means no hook is halfway through writing to it
+		all, err := readRecords(tracker.LogPath, active)
+		if err != nil {
+			return active, err
+		}
+		for _, record := range all {
+			if record.matches(query) {
+				records = append(records, record)
+			}
+		}
+		return active, nil
+	})
+	return records, err
+}
+
+func (record *Record) matches(query Query) bool {
+	if query.Username != "" && record.Username != query.Username {
+		return false
+	}
+	if record.Start.After(query.Until) {
+		return false
+	}
+	return record.End == nil || !record.End.Before(query.Since)
+}
+
+// Pair the start and end events in the session log into records. Sessions with a start event but no end event are
+// active, unless they're missing from the active sessions, e.g. because the active sessions file was removed, in which
+// case when they ended is unknown. Active sessions whose start event is in a log that was rotated away are taken from
+// the active sessions.
+func readRecords(logPath string, active []*Session) ([]*Record, error) {
+	// A log that doesn't exist yet has no events
+	var log io.Reader = strings.NewReader("")
+	file, err := os.Open(logPath)
+	switch {
+	case err == nil:
+		defer file.Close()
+		log = file
+	case !os.IsNotExist(err):
+		return nil, errors.WithStackTrace(err)
+	}
+
+	records := []*Record{}
+	started := map[string]*Record{}
+	ended := map[string]bool{}
+
+	scanner := bufio.NewScanner(log)
+	scanner.Buffer(make([]byte, MAX_EVENT_LEN), MAX_EVENT_LEN)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		event := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, errors.WithStackTrace(CorruptSessionLog{Path: logPath, LineNumber: lineNumber, Cause: err})
+		}
+
+		switch event.Event {
+		case EVENT_START:
+			record := newRecord(event, event.Time)
+			records = append(records, record)
+			started[sessionKey(event.Instance, event.VpnIp, event.Time)] = record
+		case EVENT_END:
+			if event.Start == nil {
+				continue
+			}
+			key := sessionKey(event.Instance, event.VpnIp, *event.Start)
+			record, ok := started[key]
+			if !ok {
+				// The start event is in a log that was rotated away
+				record = newRecord(event, *event.Start)
+				records = append(records, record)
+			}
+			delete(started, key)
+			ended[key] = true
+
+			end := event.Time
+			record.End = &end
+			record.BytesReceived = event.BytesReceived
+			record.BytesSent = event.BytesSent
+			record.EndReason = event.Reason
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+
+	for _, session := range active {
+		key := sessionKey(session.Instance, session.VpnIp, session.Start)
+		if _, ok := started[key]; ok {
+			delete(started, key)
+		} else if !ended[key] {
+			// The start event is in a log that was rotated away
+			records = append(records, newRecord(startEvent(session), session.Start))
+		}
+	}
+	for _, record := range started {
+		record.EndReason = "the end of the session wasn't recorded"
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Start.Before(records[j].Start)
+	})
+	return records, nil
+}
+
+func newRecord(event *Event, start time.Time) *Record {
+	return &Record{
+		Username:    event.Username,
+		Serial:      event.Serial,
+		Instance:    event.Instance,
+		VpnIp:       event.VpnIp,
+		TrustedIp:   event.TrustedIp,
+		TrustedPort: event.TrustedPort,
+		Start:       start.UTC(),
+	}
+}
+
+// A session is told apart from the others by where the client connected and when
+func sessionKey(instance string, vpnIp string, start time.Time) string {
+	return fmt.Sprintf("%s/%s/%d", instance, vpnIp, start.UnixNano())
+}
+
+// Custom errors
+
+type CorruptSessionLog struct {
+	Path       string
+	LineNumber int
+	Cause      error
+}
+
+func (err CorruptSessionLog) Error() string {
+	return fmt.Sprintf("Can't read line %d of the session log %s: %s", err.LineNumber, err.Path, err.Cause)
+}
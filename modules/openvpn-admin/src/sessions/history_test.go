@@ -0,0 +1,194 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+func testTime(offset time.Duration) *time.Time {
+	at := testStart.Add(offset)
+	return &at
+}
+
+// A tracker whose log was rotated an hour before testStart, with:
+//
+// - erin, who connected before the rotation and is still connected
+// - alice, who connected before the rotation and disconnected after it
+// - bob, who connected twice
+// - carol, who was refused
+// - alice again, whose disconnect wasn't recorded
+// - dave, who is still connected
+func newTestHistory(t *testing.T) *Tracker {
+	t.Helper()
+
+	tracker := newTestTracker(t)
+
+	erin := newTestSession("erin", "0E", "172.16.0.10")
+	erin.Start = *testTime(-5 * time.Hour)
+	alice := newTestSession("alice", "0A", "172.16.0.6")
+	alice.Start = *testTime(-2 * time.Hour)
+	bob := newTestSession("bob", "0B", "172.16.0.7")
+	bob.Start = *testTime(0)
+	carol := newTestSession("carol", "0C", "172.16.0.8")
+	carol.Start = *testTime(30 * time.Minute)
+	aliceAgain := newTestSession("alice", "0A", "172.16.0.6")
+	aliceAgain.Start = *testTime(2 * time.Hour)
+	dave := newTestSession("dave", "0D", "172.16.0.9")
+	dave.Start = *testTime(3 * time.Hour)
+	bobAgain := newTestSession("bob", "0B", "172.16.0.7")
+	bobAgain.Start = *testTime(4 * time.Hour)
+
+	bobEnd := endEvent(bob, *testTime(time.Hour))
+	bobEnd.BytesReceived = 1234
+	bobEnd.BytesSent = 5678
+	refused := startEvent(carol)
+	refused.Event = EVENT_REFUSED
+	refused.Reason = "outside the allowed times"
+
+	events := []*Event{
+		endEvent(alice, *testTime(-time.Hour)),
+		startEvent(bob),
+		refused,
+		bobEnd,
+		startEvent(aliceAgain),
+		startEvent(dave),
+		startEvent(bobAgain),
+		endEvent(bobAgain, *testTime(5 * time.Hour)),
+	}
+	for _, event := range events {
+		if err := tracker.append(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	active, err := json.Marshal([]*Session{erin, dave})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tracker.ActivePath, active, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tracker
+}
+
+func describeRecords(records []*Record) string {
+	described := []string{}
+	for _, record := range records {
+		end := "active"
+		if record.End != nil {
+			end = record.End.Sub(testStart).String()
+		} else if record.EndReason != "" {
+			end = "unknown"
+		}
+		described = append(described, fmt.Sprintf("%s %s..%s", record.Username, record.Start.Sub(testStart), end))
+	}
+	return strings.Join(described, ", ")
+}
+
+func TestHistory(t *testing.T) {
+	tracker := newTestHistory(t)
+
+	testCases := []struct {
+		name     string
+		query    Query
+		expected string
+	}{
+		{
+			"everything",
+			Query{Since: *testTime(-24 * time.Hour), Until: *testTime(24 * time.Hour)},
+			"erin -5h0m0s..active, alice -2h0m0s..-1h0m0s, bob 0s..1h0m0s, alice 2h0m0s..unknown, dave 3h0m0s..active, bob 4h0m0s..5h0m0s",
+		},
+		{
+			"one user",
+			Query{Since: *testTime(-24 * time.Hour), Until: *testTime(24 * time.Hour), Username: "bob"},
+			"bob 0s..1h0m0s, bob 4h0m0s..5h0m0s",
+		},
+		{
+			"sessions connected during the window",
+			Query{Since: *testTime(time.Hour), Until: *testTime(3 * time.Hour)},
+			"erin -5h0m0s..active, bob 0s..1h0m0s, alice 2h0m0s..unknown, dave 3h0m0s..active",
+		},
+		{
+			"before the first session",
+			Query{Since: *testTime(-24 * time.Hour), Until: *testTime(-6 * time.Hour)},
+			"",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			records, err := tracker.History(testCase.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if described := describeRecords(records); described != testCase.expected {
+				t.Errorf("expected %q but got %q", testCase.expected, described)
+			}
+		})
+	}
+}
+
+func TestHistoryRecordsHowSessionsEnded(t *testing.T) {
+	tracker := newTestHistory(t)
+
+	records, err := tracker.History(Query{Since: *testTime(-24 * time.Hour), Until: *testTime(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("expected 6 sessions but got %d", len(records))
+	}
+
+	erin, alice, bob, aliceAgain := records[0], records[1], records[2], records[3]
+	if erin.Serial != "0E" || erin.Instance != "server" || erin.VpnIp != "172.16.0.10" || erin.TrustedIp != "203.0.113.7" {
+		t.Errorf("expected erin's session to be taken from the active sessions but got %+v", erin)
+	}
+	if alice.Serial != "0A" || alice.VpnIp != "172.16.0.6" || alice.EndReason != "" {
+		t.Errorf("expected alice's session to be taken from its end event but got %+v", alice)
+	}
+	if bob.BytesReceived != 1234 || bob.BytesSent != 5678 {
+		t.Errorf("expected bob's session to have 1234 bytes received and 5678 sent but got %d and %d", bob.BytesReceived, bob.BytesSent)
+	}
+	if aliceAgain.End != nil || aliceAgain.EndReason != "the end of the session wasn't recorded" {
+		t.Errorf("expected alice's second session to have ended at an unknown time but got %+v", aliceAgain)
+	}
+}
+
+func TestHistoryWithoutSessionLog(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	records, err := tracker.History(Query{Since: testStart, Until: *testTime(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no sessions but got %v", describeRecords(records))
+	}
+}
+
+func TestHistoryRefusesCorruptSessionLog(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	bob := newTestSession("bob", "0B", "172.16.0.7")
+	bob.Start = testStart
+	start, err := json.Marshal(startEvent(bob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tracker.LogPath, []byte(string(start)+"\n"+string(start)+"\n{\"Time\":\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tracker.History(Query{Since: testStart, Until: *testTime(time.Hour)})
+	corrupt, ok := errors.Unwrap(err).(CorruptSessionLog)
+	if !ok || corrupt.Path != tracker.LogPath || corrupt.LineNumber != 3 {
+		t.Fatalf("expected CorruptSessionLog for line 3 but got %v", err)
+	}
+}
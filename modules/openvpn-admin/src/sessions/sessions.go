@@ -0,0 +1,266 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/gruntwork-io/package-openvpn/modules/openvpn-admin/src/filelock"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// The kinds of event in the session log
+const EVENT_START = "start"
+const EVENT_END = "end"
+const EVENT_REFUSED = "refused"
+
+// Session is a client's connection to one of the OpenVPN server's instances, from when the client-connect hook lets it
+// in until the client-disconnect hook runs
+type Session struct {
+	Username    string
+	Serial      string
+	Instance    string
+	VpnIp       string
+	TrustedIp   string
+	TrustedPort int
+	Start       time.Time
+
+	// The OpenVPN process that the client is connected to, and when it started, so that sessions that outlived their
+	// process without a disconnect can be told apart from live ones
+	DaemonPid       int
+	DaemonStartTime int64
+}
+
+// Event is a single line in the session log: a session starting or ending, or a client that was refused
+type Event struct {
+	Time          time.Time
+	Event         string
+	Username      string
+	Serial        string        `json:",omitempty"`
+	Instance      string        `json:",omitempty"`
+	VpnIp         string        `json:",omitempty"`
+	TrustedIp     string        `json:",omitempty"`
+	TrustedPort   int           `json:",omitempty"`
+	Start         *time.Time    `json:",omitempty"`
+	Duration      time.Duration `json:",omitempty"`
+	BytesReceived int64         `json:",omitempty"`
+	BytesSent     int64         `json:",omitempty"`
+	Reason        string        `json:",omitempty"`
+}
+
+// Tracker records sessions in an append-only log of JSON events, one per line, and keeps the sessions that haven't
+// ended yet in a JSON file next to it. Each OpenVPN instance runs the hooks in a process of its own, so every change
+// happens with the active sessions file locked.
+//
+// The hooks only ever add a line to the log, and History is only run by an admin's report, so an embedded database
+// would buy nothing but a dependency: reading a year of sessions from start to end takes seconds. The log is opened for
+// each event, so it can be rotated by renaming it, and the active sessions file keeps the sessions that started in a
+// rotated log.
+type Tracker struct {
+	LogPath    string
+	ActivePath string
+
+	// Whether an active session's OpenVPN process is gone, in which case the session ended without a disconnect
+	IsStale func(session *Session) bool
+}
+
+// Start a session now, unless the user already has maxPerUser sessions, in which case a TooManySessions error is
+// returned. A maxPerUser of 0 allows any number of sessions.
+func (tracker *Tracker) Start(session *Session, maxPerUser int) error {
+	return tracker.withActiveSessions(func(active []*Session) ([]*Session, error) {
+		count := 0
+		for _, other := range active {
+			if other.Username == session.Username {
+				count++
+			}
+		}
+		if maxPerUser > 0 && count >= maxPerUser {
+			return active, errors.WithStackTrace(TooManySessions{Username: session.Username, Count: count})
+		}
+
+		session.Start = time.Now().UTC()
+		if err := tracker.append(startEvent(session)); err != nil {
+			return active, err
+		}
+		return append(active, session), nil
+	})
+}
+
+// End the session of the given client: the active session with the client's VPN address on the client's instance.
+// Returns the session, or nil if the client has no active session, e.g. because the session file was removed while it
+// was connected. If the session with that address is another user's or another certificate's, it's left alone and a
+// SessionMismatch error is returned.
+func (tracker *Tracker) End(client *Session, bytesReceived int64, bytesSent int64) (*Session, error) {
+	var ended *Session
+	err := tracker.withActiveSessions(func(active []*Session) ([]*Session, error) {
+		remaining := []*Session{}
+		for _, session := range active {
+			if ended == nil && session.Instance == client.Instance && session.VpnIp == client.VpnIp {
+				ended = session
+				continue
+			}
+			remaining = append(remaining, session)
+		}
+		if ended == nil {
+			return active, nil
+		}
+		if ended.Username != client.Username || ended.Serial != client.Serial {
+			mismatch := SessionMismatch{Active: ended, Client: client}
+			ended = nil
+			return active, errors.WithStackTrace(mismatch)
+		}
+
+		event := endEvent(ended, time.Now())
+		event.BytesReceived = bytesReceived
+		event.BytesSent = bytesSent
+		return remaining, tracker.append(event)
+	})
+	return ended, err
+}
+
+// Record that a client was refused
+func (tracker *Tracker) Refuse(session *Session, reason string) error {
+	event := startEvent(session)
+	event.Event = EVENT_REFUSED
+	event.Reason = reason
+	return tracker.withActiveSessions(func(active []*Session) ([]*Session, error) {
+		return active, tracker.append(event)
+	})
+}
+
+// The sessions that haven't ended
+func (tracker *Tracker) Active() ([]*Session, error) {
+	var sessions []*Session
+	err := tracker.withActiveSessions(func(active []*Session) ([]*Session, error) {
+		sessions = active
+		return active, nil
+	})
+	return sessions, err
+}
+
+// Run the function on the active sessions with the file locked, and write back the sessions it returns. Stale sessions
+// are ended first, with the time of the check as their end time.
+func (tracker *Tracker) withActiveSessions(change func(active []*Session) ([]*Session, error)) error {
+	file, err := os.OpenFile(tracker.ActivePath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	if err := filelock.Lock(file); err != nil {
+		return err
+	}
+	defer filelock.Unlock(file)
+
+	contents, err := ioutil.ReadAll(file)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	active := []*Session{}
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, &active); err != nil {
+			return errors.WithStackTrace(CorruptActiveSessions{Path: tracker.ActivePath, Cause: err})
+		}
+	}
+
+	live := []*Session{}
+	for _, session := range active {
+		if tracker.IsStale == nil || !tracker.IsStale(session) {
+			live = append(live, session)
+			continue
+		}
+		event := endEvent(session, time.Now())
+		event.Reason = "the OpenVPN process the client was connected to is gone"
+		if err := tracker.append(event); err != nil {
+			return err
+		}
+	}
+
+	updated, changeErr := change(live)
+
+	bytes, err := json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if err := file.Truncate(0); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if _, err := file.WriteAt(append(bytes, '\n'), 0); err != nil {
+		return errors.WithStackTrace(err)
+	}
+	return changeErr
+}
+
+func (tracker *Tracker) append(event *Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	file, err := os.OpenFile(tracker.LogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return errors.WithStackTrace(err)
+}
+
+func startEvent(session *Session) *Event {
+	return &Event{
+		Time:        session.Start,
+		Event:       EVENT_START,
+		Username:    session.Username,
+		Serial:      session.Serial,
+		Instance:    session.Instance,
+		VpnIp:       session.VpnIp,
+		TrustedIp:   session.TrustedIp,
+		TrustedPort: session.TrustedPort,
+	}
+}
+
+func endEvent(session *Session, end time.Time) *Event {
+	event := startEvent(session)
+	event.Time = end
+	event.Event = EVENT_END
+	start := session.Start.UTC()
+	event.Start = &start
+	event.Duration = end.Sub(session.Start)
+	return event
+}
+
+// Custom errors
+
+type TooManySessions struct {
+	Username string
+	Count    int
+}
+
+func (err TooManySessions) Error() string {
+	return fmt.Sprintf("%s already has %d session(s), the most a user may have at once", err.Username, err.Count)
+}
+
+type SessionMismatch struct {
+	Active *Session
+	Client *Session
+}
+
+func (err SessionMismatch) Error() string {
+	return fmt.Sprintf("The session with the address %s on %s is %s's with certificate %s, not %s's with certificate %s. Leaving it alone.", err.Active.VpnIp, err.Active.Instance, err.Active.Username, err.Active.Serial, err.Client.Username, err.Client.Serial)
+}
+
+type CorruptActiveSessions struct {
+	Path  string
+	Cause error
+}
+
+func (err CorruptActiveSessions) Error() string {
+	return fmt.Sprintf("Can't read the active sessions in %s: %s. Remove the file to start over.", err.Path, err.Cause)
+}
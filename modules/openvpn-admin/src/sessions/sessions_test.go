@@ -0,0 +1,235 @@
+package sessions
+
+import (
+	"encoding/json"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestTracker(t *testing.T) *Tracker {
+	t.Helper()
+
+	dir := t.TempDir()
+	return &Tracker{
+		LogPath:    filepath.Join(dir, "sessions.log"),
+		ActivePath: filepath.Join(dir, "sessions-active.json"),
+	}
+}
+
+func newTestSession(username string, serial string, vpnIp string) *Session {
+	return &Session{Username: username, Serial: serial, Instance: "server", VpnIp: vpnIp, TrustedIp: "203.0.113.7"}
+}
+
+func TestEndLeavesAnotherClientsSessionAlone(t *testing.T) {
+	tracker := newTestTracker(t)
+	if err := tracker.Start(newTestSession("alice", "0A", "172.16.0.6"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, client := range []*Session{newTestSession("bob", "0A", "172.16.0.6"), newTestSession("alice", "0B", "172.16.0.6")} {
+		ended, err := tracker.End(client, 0, 0)
+		if _, ok := errors.Unwrap(err).(SessionMismatch); !ok {
+			t.Errorf("expected SessionMismatch for %s with %s but got %v", client.Username, client.Serial, err)
+		}
+		if ended != nil {
+			t.Errorf("expected no session to end but %s's did", ended.Username)
+		}
+	}
+
+	active, err := tracker.Active()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Username != "alice" {
+		t.Fatalf("expected alice's session to stay active but found %v", active)
+	}
+
+	ended, err := tracker.End(newTestSession("alice", "0A", "172.16.0.6"), 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ended == nil || ended.Username != "alice" {
+		t.Errorf("expected alice's session to end but got %v", ended)
+	}
+}
+
+// The events in the tracker's session log, oldest first
+func readTestEvents(t *testing.T, tracker *Tracker) []*Event {
+	t.Helper()
+
+	contents, err := ioutil.ReadFile(tracker.LogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []*Event{}
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		event := &Event{}
+		if err := json.Unmarshal([]byte(line), event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestStartLimitsSessionsPerUser(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	for _, vpnIp := range []string{"172.16.0.6", "172.16.0.7"} {
+		if err := tracker.Start(newTestSession("alice", "0A", vpnIp), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tracker.Start(newTestSession("bob", "0B", "172.16.0.8"), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	err := tracker.Start(newTestSession("alice", "0A", "172.16.0.9"), 2)
+	tooMany, ok := errors.Unwrap(err).(TooManySessions)
+	if !ok || tooMany.Username != "alice" || tooMany.Count != 2 {
+		t.Fatalf("expected TooManySessions for alice's 2 sessions but got %v", err)
+	}
+
+	// Without a limit, or once a session ends, alice can connect again
+	if err := tracker.Start(newTestSession("alice", "0A", "172.16.0.9"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracker.End(newTestSession("alice", "0A", "172.16.0.6"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracker.End(newTestSession("alice", "0A", "172.16.0.7"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Start(newTestSession("alice", "0A", "172.16.0.6"), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := tracker.Active()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 3 {
+		t.Errorf("expected 3 active sessions but got %d", len(active))
+	}
+
+	events := readTestEvents(t, tracker)
+	if len(events) != 7 {
+		t.Fatalf("expected 5 starts and 2 ends in the log and nothing for the refused session but got %d events", len(events))
+	}
+}
+
+func TestEndRecordsTheSessionInTheLog(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	session := newTestSession("alice", "0A", "172.16.0.6")
+	session.TrustedPort = 51820
+	if err := tracker.Start(session, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ended, err := tracker.End(newTestSession("alice", "0A", "172.16.0.6"), 1234, 5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ended == nil || !ended.Start.Equal(session.Start) {
+		t.Fatalf("expected alice's session to end but got %v", ended)
+	}
+
+	events := readTestEvents(t, tracker)
+	if len(events) != 2 || events[0].Event != EVENT_START || events[1].Event != EVENT_END {
+		t.Fatalf("expected a start and an end event but got %v", events)
+	}
+	end := events[1]
+	if end.Username != "alice" || end.Serial != "0A" || end.VpnIp != "172.16.0.6" || end.TrustedPort != 51820 {
+		t.Errorf("expected the end event to describe alice's session but got %+v", end)
+	}
+	if end.Start == nil || !end.Start.Equal(session.Start) || end.Duration != end.Time.Sub(session.Start) {
+		t.Errorf("expected the end event to have the session's start and duration but got %+v", end)
+	}
+	if end.BytesReceived != 1234 || end.BytesSent != 5678 {
+		t.Errorf("expected 1234 bytes received and 5678 sent but got %d and %d", end.BytesReceived, end.BytesSent)
+	}
+
+	// Ending a session that isn't active does nothing
+	ended, err = tracker.End(newTestSession("alice", "0A", "172.16.0.6"), 0, 0)
+	if err != nil || ended != nil {
+		t.Errorf("expected no session to end but got %v, %v", ended, err)
+	}
+	if len(readTestEvents(t, tracker)) != 2 {
+		t.Error("expected nothing more in the log")
+	}
+}
+
+func TestStaleSessionsAreEndedWhenTheTrackerNextRuns(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	gone := newTestSession("alice", "0A", "172.16.0.6")
+	gone.DaemonPid = 100
+	live := newTestSession("bob", "0B", "172.16.0.7")
+	live.DaemonPid = 200
+	for _, session := range []*Session{gone, live} {
+		if err := tracker.Start(session, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker.IsStale = func(session *Session) bool { return session.DaemonPid == 100 }
+
+	// alice's stale session doesn't count towards her limit
+	if err := tracker.Start(newTestSession("alice", "0A", "172.16.0.8"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := tracker.Active()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 2 || active[0].Username != "bob" || active[1].VpnIp != "172.16.0.8" {
+		t.Fatalf("expected bob's session and alice's new one but got %v", active)
+	}
+
+	events := readTestEvents(t, tracker)
+	if len(events) != 4 || events[2].Event != EVENT_END || events[2].VpnIp != "172.16.0.6" {
+		t.Fatalf("expected alice's stale session to end before her new one started but got %v", events)
+	}
+	if events[2].Reason != "the OpenVPN process the client was connected to is gone" {
+		t.Errorf("expected the end event to say why but got %q", events[2].Reason)
+	}
+}
+
+func TestRefuseRecordsTheReason(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	if err := tracker.Refuse(newTestSession("alice", "0A", "172.16.0.6"), "outside the allowed times"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := readTestEvents(t, tracker)
+	if len(events) != 1 || events[0].Event != EVENT_REFUSED || events[0].Reason != "outside the allowed times" || events[0].Time.IsZero() {
+		t.Fatalf("expected a refused event with the reason but got %v", events)
+	}
+
+	active, err := tracker.Active()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 0 {
+		t.Errorf("expected no active sessions but got %v", active)
+	}
+}
+
+func TestActiveRefusesCorruptFile(t *testing.T) {
+	tracker := newTestTracker(t)
+	if err := ioutil.WriteFile(tracker.ActivePath, []byte("[{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := tracker.Active()
+	if _, ok := errors.Unwrap(err).(CorruptActiveSessions); !ok {
+		t.Fatalf("expected CorruptActiveSessions but got %v", err)
+	}
+}
@@ -146,6 +146,19 @@ resource "aws_security_group_rule" "allow_inbound_openvpn" {
   security_group_id = aws_security_group.openvpn.id
 }
 
+# Configure inbound VPN access to the additional OpenVPN listeners, such as TCP on port 443.
+resource "aws_security_group_rule" "allow_inbound_openvpn_additional_listeners" {
+  count = length(var.additional_vpn_listeners)
+
+  type        = "ingress"
+  from_port   = var.additional_vpn_listeners[count.index].port
+  to_port     = var.additional_vpn_listeners[count.index].port
+  protocol    = var.additional_vpn_listeners[count.index].protocol
+  cidr_blocks = var.allow_vpn_from_cidr_list
+
+  security_group_id = aws_security_group.openvpn.id
+}
+
 # ---------------------------------------------------------------------------------------------------------------------
 # CREATE THE IAM ROLE
 # This grants AWS permissions to each EC2 Instance in the cluster.
@@ -248,6 +261,20 @@ data "aws_iam_policy_document" "openvpn" {
 
     resources = ["*"]
   }
+
+  # openvpn-admin's policy files can look at who sent a request, which IAM groups they're in, or which role they used
+  statement {
+    sid    = "ReadIamUsersAndGroups"
+    effect = "Allow"
+
+    actions = [
+      "iam:ListUsers",
+      "iam:ListGroupsForUser",
+      "iam:ListRoles",
+    ]
+
+    resources = ["*"]
+  }
 }
 
 # ---------------------------------------------------------------------------------------------------------------------
@@ -357,6 +384,228 @@ resource "aws_iam_role_policy" "backup" {
   policy = data.aws_iam_policy_document.backup.json
 }
 
+# ----------------------------------------------------------------------------------------------------------------------
+# ALLOW THE EC2 INSTANCE TO SIGN WITH THE CA KMS KEY
+# Only created when the CA key is an asymmetric KMS key rather than ca.key on the instance
+# ----------------------------------------------------------------------------------------------------------------------
+
+data "aws_iam_policy_document" "ca_signing" {
+  count = var.ca_signing_kms_key_arn == null ? 0 : 1
+
+  statement {
+    sid    = "kmsSignWithCaKey"
+    effect = "Allow"
+
+    actions = [
+      "kms:Sign",
+      "kms:GetPublicKey",
+      "kms:DescribeKey",
+    ]
+
+    resources = [
+      var.ca_signing_kms_key_arn,
+    ]
+  }
+}
+
+resource "aws_iam_role_policy" "ca_signing" {
+  count  = var.ca_signing_kms_key_arn == null ? 0 : 1
+  name   = "openvpn-ca-signing"
+  role   = aws_iam_role.openvpn.id
+  policy = data.aws_iam_policy_document.ca_signing[0].json
+}
+
+# ----------------------------------------------------------------------------------------------------------------------
+# ALLOW THE EC2 INSTANCE TO NOTIFY APPROVERS OF CERTIFICATE REQUESTS
+# Only created when certificate requests need approval and approvers are notified over SNS
+# ----------------------------------------------------------------------------------------------------------------------
+
+data "aws_iam_policy_document" "approval_notifications" {
+  count = var.approval_sns_topic_arn == null ? 0 : 1
+
+  statement {
+    sid    = "snsPublishApprovalNotifications"
+    effect = "Allow"
+
+    actions = [
+      "sns:Publish",
+    ]
+
+    resources = [
+      var.approval_sns_topic_arn,
+    ]
+  }
+}
+
+resource "aws_iam_role_policy" "approval_notifications" {
+  count  = var.approval_sns_topic_arn == null ? 0 : 1
+  name   = "openvpn-approval-notifications"
+  role   = aws_iam_role.openvpn.id
+  policy = data.aws_iam_policy_document.approval_notifications[0].json
+}
+
+# ----------------------------------------------------------------------------------------------------------------------
+# ALLOW THE EC2 INSTANCE TO SEND CERTIFICATE EVENT NOTIFICATIONS OVER SNS AND SES
+# Only created when some of the notification sinks are SNS topics or SES emails. Other sinks need no IAM permissions.
+# ----------------------------------------------------------------------------------------------------------------------
+
+data "aws_iam_policy_document" "event_notifications" {
+  count = length(var.notification_sns_topic_arns) + length(var.notification_ses_identity_arns) == 0 ? 0 : 1
+
+  dynamic "statement" {
+    for_each = length(var.notification_sns_topic_arns) == 0 ? [] : [1]
+
+    content {
+      sid    = "snsPublishEventNotifications"
+      effect = "Allow"
+
+      actions = [
+        "sns:Publish",
+      ]
+
+      resources = var.notification_sns_topic_arns
+    }
+  }
+
+  dynamic "statement" {
+    for_each = length(var.notification_ses_identity_arns) == 0 ? [] : [1]
+
+    content {
+      sid    = "sesSendEventNotifications"
+      effect = "Allow"
+
+      actions = [
+        "ses:SendEmail",
+      ]
+
+      resources = var.notification_ses_identity_arns
+    }
+  }
+}
+
+resource "aws_iam_role_policy" "event_notifications" {
+  count  = length(var.notification_sns_topic_arns) + length(var.notification_ses_identity_arns) == 0 ? 0 : 1
+  name   = "openvpn-event-notifications"
+  role   = aws_iam_role.openvpn.id
+  policy = data.aws_iam_policy_document.event_notifications[0].json
+}
+
+# ----------------------------------------------------------------------------------------------------------------------
+# CREATE A LOG GROUP FOR THE AUDIT LOG AND ALLOW THE EC2 INSTANCE TO WRITE TO IT
+# Only created when audit events are shipped to CloudWatch Logs. The instance can add events, but not change or delete
+# them.
+# ----------------------------------------------------------------------------------------------------------------------
+
+resource "aws_cloudwatch_log_group" "audit" {
+  count             = var.audit_log_group_name == null ? 0 : 1
+  name              = var.audit_log_group_name
+  retention_in_days = var.audit_log_retention_in_days
+  kms_key_id        = var.kms_key_arn
+  tags              = var.tags
+}
+
+data "aws_iam_policy_document" "audit_log" {
+  count = var.audit_log_group_name == null ? 0 : 1
+
+  statement {
+    sid    = "cloudWatchLogsWriteAuditEvents"
+    effect = "Allow"
+
+    actions = [
+      "logs:CreateLogStream",
+      "logs:DescribeLogStreams",
+      "logs:PutLogEvents",
+    ]
+
+    resources = [
+      aws_cloudwatch_log_group.audit[0].arn,
+      "${aws_cloudwatch_log_group.audit[0].arn}:*",
+    ]
+  }
+}
+
+resource "aws_iam_role_policy" "audit_log" {
+  count  = var.audit_log_group_name == null ? 0 : 1
+  name   = "openvpn-audit-log"
+  role   = aws_iam_role.openvpn.id
+  policy = data.aws_iam_policy_document.audit_log[0].json
+}
+
+# ----------------------------------------------------------------------------------------------------------------------
+# CREATE A WRITE-ONCE S3 BUCKET FOR THE AUDIT LOG AND ALLOW THE EC2 INSTANCE TO ADD EVENTS TO IT
+# Only created when audit events are shipped to S3. The instance can write anywhere in the backup bucket, so events
+# shipped there can be rewritten by whoever controls the server. Object Lock in compliance mode keeps every version of
+# every object in this bucket until its retention period is over, and the instance may only put objects, so an event
+# can't be changed or removed once it's shipped, not even by the account's root user.
+# ----------------------------------------------------------------------------------------------------------------------
+
+resource "aws_s3_bucket" "audit" {
+  count  = var.audit_bucket_name == null ? 0 : 1
+  bucket = var.audit_bucket_name
+
+  versioning {
+    enabled = true
+  }
+
+  object_lock_configuration {
+    object_lock_enabled = "Enabled"
+
+    rule {
+      default_retention {
+        mode = "COMPLIANCE"
+        days = var.audit_bucket_retention_days
+      }
+    }
+  }
+
+  # If a KMS key is not provided (kms_key_arn is null), the default aws/s3 key is used
+  server_side_encryption_configuration {
+    rule {
+      apply_server_side_encryption_by_default {
+        sse_algorithm     = "aws:kms"
+        kms_master_key_id = var.kms_key_arn
+      }
+    }
+  }
+
+  tags = merge({
+    OpenVPNRole = "AuditBucket"
+  }, var.tags)
+}
+
+resource "aws_s3_bucket_public_access_block" "audit" {
+  count                   = var.audit_bucket_name == null ? 0 : 1
+  bucket                  = aws_s3_bucket.audit[0].id
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+data "aws_iam_policy_document" "audit_bucket" {
+  count = var.audit_bucket_name == null ? 0 : 1
+
+  statement {
+    sid    = "s3PutAuditEvents"
+    effect = "Allow"
+
+    actions = [
+      "s3:PutObject",
+    ]
+
+    resources = [
+      "${aws_s3_bucket.audit[0].arn}/audit/*",
+    ]
+  }
+}
+
+resource "aws_iam_role_policy" "audit_bucket" {
+  count  = var.audit_bucket_name == null ? 0 : 1
+  name   = "openvpn-audit-bucket"
+  role   = aws_iam_role.openvpn.id
+  policy = data.aws_iam_policy_document.audit_bucket[0].json
+}
+
 # ---------------------------------------------------------------------------------------------------------------------
 # CREATE THE SQS QUEUES
 # This queue is used to receive requests for new certificates
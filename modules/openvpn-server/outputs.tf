@@ -57,3 +57,11 @@ output "openvpn_users_group_name" {
 output "openvpn_admins_group_name" {
   value = aws_iam_group.openvpn-admins.name
 }
+
+output "audit_log_group_name" {
+  value = element(concat(aws_cloudwatch_log_group.audit.*.name, [""]), 0)
+}
+
+output "audit_bucket_name" {
+  value = element(concat(aws_s3_bucket.audit.*.id, [""]), 0)
+}
@@ -87,6 +87,15 @@ variable "allow_vpn_from_cidr_list" {
   default     = ["0.0.0.0/0"]
 }
 
+variable "additional_vpn_listeners" {
+  description = "The ports and protocols (tcp or udp) of OpenVPN listeners other than 1194/udp, e.g. [{ port = 443, protocol = \"tcp\" }] for users behind firewalls that block 1194/udp. VPN access to them is permitted from var.allow_vpn_from_cidr_list. The listeners themselves are set up with the listeners setting of the openvpn-admin init config file."
+  type = list(object({
+    port     = number
+    protocol = string
+  }))
+  default = []
+}
+
 variable "allow_ssh_from_cidr" {
   description = "A boolean that specifies if this server will allow SSH connections from the list of CIDR blocks specified in var.allow_ssh_from_cidr_list."
   type        = bool
@@ -111,6 +120,54 @@ variable "allow_ssh_from_security_group_id" {
   default     = null
 }
 
+variable "approval_sns_topic_arn" {
+  description = "The Amazon Resource Name (ARN) of an SNS topic that openvpn-admin notifies approvers on when a certificate request needs approval. If set, the server is granted sns:Publish on it. Pass the same ARN to run-process-requests with --approval-sns-topic-arn."
+  type        = string
+  default     = null
+}
+
+variable "notification_sns_topic_arns" {
+  description = "The Amazon Resource Names (ARNs) of SNS topics that openvpn-admin sends certificate event notifications to. The server is granted sns:Publish on each of them. List the same topics as sns sinks in the file passed to run-process-requests and run-process-revokes with --notifications-file."
+  type        = list(string)
+  default     = []
+}
+
+variable "notification_ses_identity_arns" {
+  description = "The Amazon Resource Names (ARNs) of the SES identities (verified domains or addresses) that openvpn-admin sends notification emails from, such as expiry reminders. The server is granted ses:SendEmail on each of them. Use the identities as the from address of ses sinks in the file passed to run-process-requests and run-process-revokes with --notifications-file."
+  type        = list(string)
+  default     = []
+}
+
+variable "audit_log_group_name" {
+  description = "The name of a CloudWatch Logs log group to create for openvpn-admin's audit events. If set, the server is granted permission to write to it. Pass the same name to run-process-requests and run-process-revokes with --audit-log-group."
+  type        = string
+  default     = null
+}
+
+variable "audit_log_retention_in_days" {
+  description = "How many days CloudWatch Logs keeps audit events for. Only used if audit_log_group_name is set."
+  type        = number
+  default     = 365
+}
+
+variable "audit_bucket_name" {
+  description = "The name of an S3 bucket to create for openvpn-admin's audit events, with S3 Object Lock in compliance mode so that no one, including the server, can overwrite or delete an event before audit_bucket_retention_days have passed. If set, the server is granted s3:PutObject on it, and nothing else. Pass the same name to run-process-requests and run-process-revokes with --audit-s3-bucket."
+  type        = string
+  default     = null
+}
+
+variable "audit_bucket_retention_days" {
+  description = "How many days each audit event in the audit bucket is locked for. Only used if audit_bucket_name is set."
+  type        = number
+  default     = 365
+}
+
+variable "ca_signing_kms_key_arn" {
+  description = "The Amazon Resource Name (ARN) of an asymmetric KMS key (key usage SIGN_VERIFY) to use as the CA key instead of keeping ca.key on the server. If set, the server is granted kms:Sign and kms:GetPublicKey on it, and you must also pass it to init-openvpn with --kms-signing-key-arn."
+  type        = string
+  default     = null
+}
+
 variable "backup_bucket_force_destroy" {
   description = "When a terraform destroy is run, should the backup s3 bucket be destroyed even if it contains files. Should only be set to true for testing/development"
   type        = bool
@@ -11,7 +11,9 @@ readonly script_path="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 # Move the bin files into /usr/local/bin
 sudo cp "${script_path}"/bin/run-process-requests /usr/local/bin
 sudo cp "${script_path}"/bin/run-process-revokes /usr/local/bin
+sudo cp "${script_path}"/bin/run-ocsp-responder /usr/local/bin
 
 # Change ownership and permissions
 sudo chmod +x /usr/local/bin/run-process-requests
 sudo chmod +x /usr/local/bin/run-process-revokes
+sudo chmod +x /usr/local/bin/run-ocsp-responder
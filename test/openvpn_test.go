@@ -75,12 +75,14 @@ func testOpenVpnInitializationSuite(t *testing.T, osName string, packerBuildJson
 	})
 
 	//Build the openvpn-admin binary and copy that to examples/bin where the packer build expects to find it.
+	// It's built with cgo, like the released binary, so that PKCS#11 tokens are supported.
 	test_structure.RunTestStage(t, "build_binaries", func() {
 
 		cmdDeletePrevious := shell.Command{
 			WorkingDir: "../modules/openvpn-admin/src",
 			Command:    "gox",
 			Args: []string{
+				"-cgo",
 				"-os", "linux", // runtime.GOOS,
 				"-arch", "amd64", // runtime.GOARCH,
 				"-parallel", "32",
@@ -275,6 +277,7 @@ func validateInstanceRunningOpenVPNServer(t *testing.T, osName string, workingDi
 		t.Run("running testOpenVpnAdminProcessRevokesIsRunning", wrapTestCase(testOpenVpnAdminProcessRevokesIsRunning, host))
 		t.Run("running testCrlExpirationDateUpdated", wrapTestCase(testCrlExpirationDateUpdated, host))
 		t.Run("running testCronJobExists", wrapTestCase(testCronJobExists, host))
+		t.Run("running testCrlIsHealthy", wrapTestCase(testCrlIsHealthy, host))
 	})
 }
 
@@ -334,7 +337,19 @@ func testCrlExpirationDateUpdated(t *testing.T, host ssh.Host) {
 	logger.Logf(t, "Result of running \"%s\"\n", commandToTest)
 	logger.Log(t, output)
 
-	assert.Contains(t, output, "default_crl_days= 3650")
+	// The example user-data doesn't pass --crl-expiration-days, so init-openvpn uses its default of 7 days
+	assert.Contains(t, output, "default_crl_days= 7")
+}
+
+func testCrlIsHealthy(t *testing.T, host ssh.Host) {
+	commandToTest := "sudo /usr/local/bin/openvpn-admin crl status"
+	output := ssh.CheckSshCommand(t, host, commandToTest)
+
+	// It will be convenient to see the full command output directly in logs. This will show only when there's a test failure.
+	logger.Logf(t, "Result of running \"%s\"\n", commandToTest)
+	logger.Log(t, output)
+
+	assert.Contains(t, output, "Next update:")
 }
 
 func wrapTestCase(testCase func(t *testing.T, host ssh.Host), host ssh.Host) func(t *testing.T) {